@@ -0,0 +1,135 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/gorilla/sessions"
+)
+
+// Attributes of the records written to the audit table by WithAuditLog. The
+// partition key, named like the one of the sessions table, is the session digest
+// followed by the time of the record in nanoseconds.
+const (
+	// AuditSessionField identifies the session with a digest of its id
+	AuditSessionField = "session"
+
+	// AuditUserField holds the user of the session, when known
+	AuditUserField = "user"
+
+	// AuditOperationField holds AuditPersist or AuditDelete
+	AuditOperationField = "operation"
+
+	// AuditAtField holds the time of the operation in epoch milliseconds
+	AuditAtField = "at"
+
+	// AuditChangedField holds the set of session keys added, changed or removed
+	// by a persist
+	AuditChangedField = "changed"
+)
+
+// Operations recorded by WithAuditLog
+const (
+	AuditPersist = "persist"
+	AuditDelete  = "delete"
+)
+
+// auditLog appends a record of every Persist and Delete to a companion table
+type auditLog struct {
+	table     string
+	retention time.Duration
+}
+
+// auditPersist records that session was persisted, along with the keys changed
+// since it was loaded
+func (store *Store) auditPersist(ctx context.Context, session *sessions.Session) error {
+	if store.audit == nil {
+		return nil
+	}
+
+	return store.writeAudit(ctx, AuditPersist, session.ID, store.sessionUser(session), store.changedKeys(session))
+}
+
+// auditDelete records that the session identified by id, of user if known, was
+// deleted
+func (store *Store) auditDelete(ctx context.Context, id, user string) error {
+	if store.audit == nil {
+		return nil
+	}
+
+	return store.writeAudit(ctx, AuditDelete, id, user, nil)
+}
+
+func (store *Store) writeAudit(ctx context.Context, operation, id, user string, changed []string) error {
+	now := store.now()
+	digest := redactID(id)
+
+	item := map[string]types.AttributeValue{
+		store.primaryKey:    &types.AttributeValueMemberS{Value: digest + "#" + strconv.FormatInt(now.UnixNano(), 10)},
+		AuditSessionField:   &types.AttributeValueMemberS{Value: digest},
+		AuditOperationField: &types.AttributeValueMemberS{Value: operation},
+		AuditAtField:        &types.AttributeValueMemberN{Value: strconv.FormatInt(now.UnixMilli(), 10)},
+	}
+	if user != "" {
+		item[AuditUserField] = &types.AttributeValueMemberS{Value: user}
+	}
+	if len(changed) > 0 {
+		item[AuditChangedField] = &types.AttributeValueMemberSS{Value: changed}
+	}
+	if store.audit.retention > 0 {
+		item[DefaultTTLField] = &types.AttributeValueMemberN{Value: strconv.FormatInt(now.Add(store.audit.retention).Unix(), 10)}
+	}
+
+	_, err := store.ddb.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(store.audit.table),
+		Item:      item,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write audit record: %w", err)
+	}
+
+	return nil
+}
+
+// changedKeys returns the sorted keys of the values of session that differ from
+// the ones it was loaded with. Every key of a new session counts as changed.
+func (store *Store) changedKeys(session *sessions.Session) []string {
+	previous, _ := session.Values[loadedValuesKey{}].(map[string]any)
+	current := convertToMapStringAny(session.Values)
+	delete(current, store.primaryKey)
+
+	var changed []string
+	for k, v := range current {
+		if old, ok := previous[k]; !ok || !reflect.DeepEqual(old, v) {
+			changed = append(changed, k)
+		}
+	}
+	for k := range previous {
+		if _, ok := current[k]; !ok && k != store.primaryKey {
+			changed = append(changed, k)
+		}
+	}
+
+	slices.Sort(changed)
+	return changed
+}
@@ -0,0 +1,144 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"context"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/gorilla/sessions"
+)
+
+func TestAuditLog(t *testing.T) {
+	ctx := context.TODO()
+
+	now := time.Unix(1700000000, 0)
+	ddb := newFakeDynamoDB()
+	store, _ := New(ddb, MaxAge(3600), WithClock(func() time.Time { return now }),
+		WithUserIndex("user-index", "user_id"),
+		WithAuditLog("audit", 24*time.Hour))
+
+	persistUserSessions(t, store, "bob", 1)
+
+	session := sessions.NewSession(store, "session")
+	if err := store.Load(ctx, "bob-0", session); err != nil {
+		t.Fatal(err)
+	}
+	session.Values["role"] = "admin"
+	now = now.Add(time.Second)
+	if err := store.Persist(ctx, "session", session); err != nil {
+		t.Fatal(err)
+	}
+
+	now = now.Add(time.Second)
+	if err := store.Delete(ctx, "bob-0"); err != nil {
+		t.Fatal(err)
+	}
+
+	records := ddb.others["audit"]
+	if len(records) != 3 {
+		t.Fatalf("expected one record per operation; got %v", records)
+	}
+
+	for _, record := range records {
+		if attributeString(record[AuditSessionField]) != redactID("bob-0") {
+			t.Errorf("expected the session digest to be recorded; got %v", record)
+		}
+		if attributeNumber(record[DefaultTTLField]) == "" {
+			t.Errorf("expected records to expire; got %v", record)
+		}
+	}
+
+	key := redactID("bob-0") + "#" + "1700000001000000000"
+	changed, _ := records[key][AuditChangedField].(*types.AttributeValueMemberSS)
+	if changed == nil || len(changed.Value) != 1 || changed.Value[0] != "role" || attributeString(records[key][AuditUserField]) != "bob" {
+		t.Errorf("expected the changed keys and user of the second persist; got %v", records[key])
+	}
+
+	key = redactID("bob-0") + "#" + "1700000002000000000"
+	if attributeString(records[key][AuditOperationField]) != AuditDelete {
+		t.Errorf("expected the delete to be recorded; got %v", records[key])
+	}
+}
+
+func TestAuditLogBulkOperations(t *testing.T) {
+	testCases := map[string]struct {
+		Op   func(t *testing.T, store *Store, ids []string)
+		Want map[string]int
+	}{
+		"save all": {
+			Op: func(t *testing.T, store *Store, ids []string) {
+				req := httptest.NewRequest(http.MethodGet, "/", nil)
+				for _, name := range []string{"flash", "prefs"} {
+					if _, err := store.Get(req, name); err != nil {
+						t.Fatal(err)
+					}
+				}
+				if err := store.SaveAll(req, httptest.NewRecorder()); err != nil {
+					t.Fatal(err)
+				}
+			},
+			Want: map[string]int{AuditPersist: 5},
+		},
+		"delete all for user except": {
+			Op: func(t *testing.T, store *Store, ids []string) {
+				if err := store.DeleteAllForUserExcept(context.TODO(), "bob", ids[0]); err != nil {
+					t.Fatal(err)
+				}
+			},
+			Want: map[string]int{AuditPersist: 3, AuditDelete: 2},
+		},
+		"regenerate id": {
+			Op: func(t *testing.T, store *Store, ids []string) {
+				session := sessions.NewSession(store, "session")
+				session.ID = ids[0]
+				session.Options = store.newOptions()
+				session.Values["user_id"] = "bob"
+				req := httptest.NewRequest(http.MethodGet, "/", nil)
+				if err := store.RegenerateID(context.TODO(), req, httptest.NewRecorder(), session); err != nil {
+					t.Fatal(err)
+				}
+			},
+			Want: map[string]int{AuditPersist: 4, AuditDelete: 1},
+		},
+	}
+
+	for label, tc := range testCases {
+		t.Run(label, func(t *testing.T) {
+			ddb := newFakeDynamoDB()
+			store, _ := New(ddb, MaxAge(3600),
+				WithUserIndex("user-index", "user_id"),
+				WithAuditLog("audit", 24*time.Hour))
+
+			ids := persistUserSessions(t, store, "bob", 3)
+			tc.Op(t, store, ids)
+
+			got := map[string]int{}
+			for _, record := range ddb.others["audit"] {
+				got[attributeString(record[AuditOperationField])]++
+				if operation := attributeString(record[AuditOperationField]); operation == AuditDelete && attributeString(record[AuditUserField]) != "bob" {
+					t.Errorf("expected the user of deleted sessions to be recorded; got %v", record)
+				}
+			}
+			if !maps.Equal(got, tc.Want) {
+				t.Errorf("expected %v records; got %v", tc.Want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,200 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+
+	"github.com/gorilla/sessions"
+)
+
+// trackerKey is the context key under which AutoSave installs its tracker
+type trackerKey struct{}
+
+// sessionTracker records the sessions retrieved with Get during a request,
+// along with a rendering of their values at the time, to tell which were modified
+type sessionTracker struct {
+	mu       sync.Mutex
+	sessions []*sessions.Session
+	loaded   map[*sessions.Session]string
+}
+
+func (t *sessionTracker) track(session *sessions.Session) {
+	if session == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, ok := t.loaded[session]; ok {
+		return
+	}
+
+	t.sessions = append(t.sessions, session)
+	t.loaded[session] = renderValues(session)
+}
+
+// modified returns the tracked sessions that need saving: those whose values
+// changed, new sessions that were given values, and sessions being deleted
+func (t *sessionTracker) modified() []*sessions.Session {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var out []*sessions.Session
+	for _, session := range t.sessions {
+		if deleting(session) || renderValues(session) != t.loaded[session] {
+			out = append(out, session)
+		}
+	}
+
+	return out
+}
+
+// saved records that session was saved, so it is only saved again if modified
+// further. Deleted sessions are no longer tracked.
+func (t *sessionTracker) saved(session *sessions.Session) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if deleting(session) {
+		t.sessions = slices.DeleteFunc(t.sessions, func(s *sessions.Session) bool { return s == session })
+		return
+	}
+
+	t.loaded[session] = renderValues(session)
+}
+
+func deleting(session *sessions.Session) bool {
+	return session.Options != nil && session.Options.MaxAge < 0
+}
+
+func renderValues(session *sessions.Session) string {
+	return fmt.Sprint(convertToMapStringAny(session.Values))
+}
+
+// all returns every tracked session
+func (t *sessionTracker) all() []*sessions.Session {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return slices.Clone(t.sessions)
+}
+
+// requestTracker returns the tracker of req, installing one in its context if
+// it doesn't have one yet, the way gorilla's registry is attached to requests
+func requestTracker(req *http.Request) *sessionTracker {
+	if t, ok := req.Context().Value(trackerKey{}).(*sessionTracker); ok {
+		return t
+	}
+
+	t := newSessionTracker()
+	*req = *req.WithContext(context.WithValue(req.Context(), trackerKey{}, t))
+
+	return t
+}
+
+func newSessionTracker() *sessionTracker {
+	return &sessionTracker{loaded: map[*sessions.Session]string{}}
+}
+
+// ResponseWriter wraps an http.ResponseWriter so that sessions retrieved with
+// store.Get during the request, and modified, are saved right before the
+// response headers are written. Set-Cookie headers are therefore never lost
+// because a handler wrote the body before saving.
+type ResponseWriter struct {
+	http.ResponseWriter
+
+	store   *Store
+	req     *http.Request
+	tracker *sessionTracker
+	onError func(req *http.Request, err error)
+
+	wroteHeader bool
+}
+
+// NewResponseWriter wraps w and returns it together with a copy of req that
+// tracks the sessions retrieved through it. Handlers must be given both. Errors
+// saving sessions are passed to onError, which may be nil.
+func NewResponseWriter(store *Store, w http.ResponseWriter, req *http.Request, onError func(req *http.Request, err error)) (*ResponseWriter, *http.Request) {
+	tracker := newSessionTracker()
+	req = req.WithContext(context.WithValue(req.Context(), trackerKey{}, tracker))
+
+	return &ResponseWriter{
+		ResponseWriter: w,
+		store:          store,
+		req:            req,
+		tracker:        tracker,
+		onError:        onError,
+	}, req
+}
+
+// SaveSessions saves the modified sessions that haven't been saved yet. It is
+// called before the headers are written, and should be called once more after
+// the handler returns for sessions modified after the body was written.
+func (w *ResponseWriter) SaveSessions() {
+	for _, session := range w.tracker.modified() {
+		if err := w.store.Save(w.req, w.ResponseWriter, session); err != nil && w.onError != nil {
+			w.onError(w.req, err)
+		}
+		w.tracker.saved(session)
+	}
+}
+
+// WriteHeader saves pending sessions, then writes the header
+func (w *ResponseWriter) WriteHeader(statusCode int) {
+	w.beforeHeader()
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+// Write saves pending sessions if the header hasn't been written yet, then
+// writes b
+func (w *ResponseWriter) Write(b []byte) (int, error) {
+	w.beforeHeader()
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *ResponseWriter) beforeHeader() {
+	if w.wroteHeader {
+		return
+	}
+
+	w.wroteHeader = true
+	w.SaveSessions()
+}
+
+// Unwrap returns the wrapped writer, for http.ResponseController
+func (w *ResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// AutoSave saves every session the handler retrieved with store.Get, or through
+// Middleware, and modified, using a ResponseWriter so cookies are set before the
+// handler's response is written. Sessions that were only read are not written.
+// Errors are passed to onError, which may be nil.
+func AutoSave(store *Store, onError func(req *http.Request, err error)) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			rw, req := NewResponseWriter(store, w, req, onError)
+
+			next.ServeHTTP(rw, req)
+
+			rw.SaveSessions()
+		})
+	}
+}
@@ -0,0 +1,60 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/sessions"
+)
+
+// AuthorizationHeader is the header carrying bearer tokens by default
+const AuthorizationHeader = "Authorization"
+
+// presentedValue returns the encoded session id presented by req for the session
+// named name, read from the cookie or, with WithBearerTokens, the header
+func (store *Store) presentedValue(req *http.Request, name string) (string, bool) {
+	if store.bearerHeader == "" {
+		cookie, err := req.Cookie(name)
+		if err != nil {
+			return "", false
+		}
+		return cookie.Value, true
+	}
+
+	value := strings.TrimSpace(req.Header.Get(store.bearerHeader))
+	if strings.EqualFold(store.bearerHeader, AuthorizationHeader) {
+		scheme, token, ok := strings.Cut(value, " ")
+		if !ok || !strings.EqualFold(scheme, "Bearer") {
+			return "", false
+		}
+		value = strings.TrimSpace(token)
+	}
+
+	return value, value != ""
+}
+
+// SaveToken saves the session like Save and returns the token clients present
+// to resume it. With WithBearerTokens this is how API clients obtain their
+// token, as Save doesn't set a cookie in that mode.
+func (store *Store) SaveToken(req *http.Request, w http.ResponseWriter, session *sessions.Session) (string, error) {
+	store = store.scoped(req.Context())
+
+	if err := store.Save(req, w, session); err != nil {
+		return "", err
+	}
+
+	return store.encodeCookie(req.Context(), session.Name(), session.ID)
+}
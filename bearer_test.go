@@ -0,0 +1,62 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBearerTokens(t *testing.T) {
+	testCases := map[string]struct {
+		header string
+		format func(token string) string
+	}{
+		"authorization": {header: AuthorizationHeader, format: func(token string) string { return "Bearer " + token }},
+		"custom header": {header: "X-Session-Token", format: func(token string) string { return token }},
+	}
+
+	for label, tc := range testCases {
+		t.Run(label, func(t *testing.T) {
+			store, _ := New(newFakeDynamoDB(), WithBearerTokens(tc.header), WithSigningKey([]byte("secret")))
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			session, _ := store.New(req, "session")
+			session.Values["user_id"] = "alice"
+
+			w := httptest.NewRecorder()
+			token, err := store.SaveToken(req, w, session)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(w.Result().Cookies()) != 0 {
+				t.Error("expected no cookie to be set")
+			}
+
+			req = httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set(tc.header, tc.format(token))
+			loaded, _ := store.New(req, "session")
+			if loaded.IsNew || loaded.Values["user_id"] != "alice" {
+				t.Errorf("expected the session to be resumed from the token; got %v", loaded.Values)
+			}
+
+			req = httptest.NewRequest(http.MethodGet, "/", nil)
+			req.AddCookie(&http.Cookie{Name: "session", Value: token})
+			if loaded, _ := store.New(req, "session"); !loaded.IsNew {
+				t.Error("expected cookies to be ignored")
+			}
+		})
+	}
+}
@@ -0,0 +1,347 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package bench drives a configurable mix of session reads and writes against
+// a dynastore store and reports their latency, throughput and consumed
+// capacity, so changes to the marshal path or retry logic can be measured
+// release to release. Point the store at dynamodb local, with
+// dynastoretest.Local, or at a real table.
+package bench
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ddouglas/dynastore"
+	"github.com/gorilla/sessions"
+)
+
+const (
+	// DefaultSessions is the number of sessions the benchmark reads and writes
+	DefaultSessions = 100
+
+	// DefaultConcurrency is the number of workers issuing operations
+	DefaultConcurrency = 8
+
+	// DefaultDuration is how long the benchmark runs when neither Duration nor
+	// Requests is set
+	DefaultDuration = 10 * time.Second
+
+	// DefaultValues is the number of values of each session
+	DefaultValues = 4
+
+	// DefaultValueSize is the length in bytes of each session value
+	DefaultValueSize = 64
+
+	// sessionMaxAge is the MaxAge of the sessions written, so those left behind
+	// by an interrupted run expire from a real table
+	sessionMaxAge = 3600
+)
+
+// Operations timed by Run, as named in Report.Operations
+const (
+	Load    = "Load"
+	Persist = "Persist"
+)
+
+var errNoStore = errors.New("bench: store is required")
+
+// Config describes the load generated by Run
+type Config struct {
+	// Sessions is the number of sessions written before the benchmark starts
+	// and read and rewritten during it, DefaultSessions if zero
+	Sessions int
+
+	// Concurrency is the number of workers issuing operations,
+	// DefaultConcurrency if zero
+	Concurrency int
+
+	// Duration stops the benchmark once elapsed. With Requests also set, the
+	// benchmark stops at whichever comes first; with neither, DefaultDuration
+	// is used.
+	Duration time.Duration
+
+	// Requests stops the benchmark once that many operations have been issued
+	Requests int
+
+	// Reads and Writes weigh the operations issued, for example 9 and 1 for
+	// nine loads for every persist. A read only or write only mix sets the
+	// other to zero; leaving both zero weighs them 9 to 1.
+	Reads  int
+	Writes int
+
+	// Rate caps the operations issued per second across all workers, 0 for
+	// unlimited
+	Rate int
+
+	// Values and ValueSize shape each session, DefaultValues values of
+	// DefaultValueSize bytes if zero
+	Values    int
+	ValueSize int
+}
+
+// Result is the outcome of one operation of the mix
+type Result struct {
+	Count  int64
+	Errors int64
+
+	// Throughput is the number of operations per second
+	Throughput float64
+
+	P50 time.Duration
+	P90 time.Duration
+	P99 time.Duration
+	Max time.Duration
+
+	// ConsumedCapacity is the capacity units consumed by the operation during
+	// the benchmark. It's only reported for stores created with
+	// dynastore.WithStats.
+	ConsumedCapacity float64
+}
+
+// Report is the outcome of Run
+type Report struct {
+	Elapsed    time.Duration
+	Operations map[string]Result
+}
+
+// Run writes the sessions of cfg to store then issues the configured mix of
+// Load and Persist calls against them until cfg.Duration or cfg.Requests is
+// reached, or ctx is done. The sessions are deleted once done. Failed calls are
+// counted in the report rather than stopping the benchmark; an error is
+// returned only if the sessions can't be written.
+func Run(ctx context.Context, store *dynastore.Store, cfg Config) (*Report, error) {
+	if store == nil {
+		return nil, errNoStore
+	}
+	cfg = cfg.withDefaults()
+
+	ids := make([]string, cfg.Sessions)
+	defer func() {
+		for _, id := range ids {
+			if id != "" {
+				_ = store.Delete(context.WithoutCancel(ctx), id)
+			}
+		}
+	}()
+
+	for i := range ids {
+		ids[i] = dynastore.ULID()
+		if err := store.Persist(ctx, "bench", newSession(store, ids[i], cfg)); err != nil {
+			return nil, fmt.Errorf("failed to write session %s: %w", ids[i], err)
+		}
+	}
+
+	if cfg.Duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.Duration)
+		defer cancel()
+	}
+
+	var issue <-chan time.Time
+	if cfg.Rate > 0 {
+		ticker := time.NewTicker(time.Second / time.Duration(cfg.Rate))
+		defer ticker.Stop()
+		issue = ticker.C
+	}
+
+	before := store.Stats()
+	start := time.Now()
+
+	var (
+		mu     sync.Mutex
+		issued int
+		totals = map[string]*samples{Load: {}, Persist: {}}
+	)
+
+	// next reserves the next operation, reporting false once the benchmark is over
+	next := func() bool {
+		if issue != nil {
+			select {
+			case <-issue:
+			case <-ctx.Done():
+				return false
+			}
+		}
+		if ctx.Err() != nil {
+			return false
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		if cfg.Requests > 0 && issued >= cfg.Requests {
+			return false
+		}
+		issued++
+		return true
+	}
+
+	var wg sync.WaitGroup
+	for range cfg.Concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			local := map[string]*samples{Load: {}, Persist: {}}
+			for next() {
+				id := ids[rand.N(len(ids))]
+
+				op := Persist
+				if rand.N(cfg.Reads+cfg.Writes) < cfg.Reads {
+					op = Load
+				}
+
+				var err error
+				began := time.Now()
+				switch op {
+				case Load:
+					err = store.Load(ctx, id, sessions.NewSession(store, "bench"))
+				case Persist:
+					err = store.Persist(ctx, "bench", newSession(store, id, cfg))
+				}
+				if ctx.Err() != nil {
+					// calls cut short by the end of the benchmark aren't counted
+					break
+				}
+				local[op].add(time.Since(began), err)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			for op, s := range local {
+				totals[op].merge(s)
+			}
+		}()
+	}
+	wg.Wait()
+
+	report := &Report{
+		Elapsed:    time.Since(start),
+		Operations: map[string]Result{},
+	}
+
+	after := store.Stats()
+	for op, s := range totals {
+		result := s.result(report.Elapsed)
+		result.ConsumedCapacity = after.Operations[op].ConsumedCapacity - before.Operations[op].ConsumedCapacity
+		report.Operations[op] = result
+	}
+
+	return report, nil
+}
+
+func (cfg Config) withDefaults() Config {
+	if cfg.Sessions <= 0 {
+		cfg.Sessions = DefaultSessions
+	}
+	if cfg.Concurrency <= 0 {
+		cfg.Concurrency = DefaultConcurrency
+	}
+	if cfg.Duration <= 0 && cfg.Requests <= 0 {
+		cfg.Duration = DefaultDuration
+	}
+	if cfg.Reads <= 0 && cfg.Writes <= 0 {
+		cfg.Reads, cfg.Writes = 9, 1
+	}
+	cfg.Reads, cfg.Writes = max(cfg.Reads, 0), max(cfg.Writes, 0)
+	if cfg.Values <= 0 {
+		cfg.Values = DefaultValues
+	}
+	if cfg.ValueSize <= 0 {
+		cfg.ValueSize = DefaultValueSize
+	}
+	return cfg
+}
+
+// newSession returns a session identified by id holding the values of cfg
+func newSession(store *dynastore.Store, id string, cfg Config) *sessions.Session {
+	session := sessions.NewSession(store, "bench")
+	session.ID = id
+	session.Options = &sessions.Options{MaxAge: sessionMaxAge}
+	for i := range cfg.Values {
+		session.Values[fmt.Sprintf("value-%d", i)] = strings.Repeat("x", cfg.ValueSize)
+	}
+	return session
+}
+
+// samples holds the latencies and errors of an operation
+type samples struct {
+	latencies []time.Duration
+	errors    int64
+}
+
+func (s *samples) add(latency time.Duration, err error) {
+	s.latencies = append(s.latencies, latency)
+	if err != nil {
+		s.errors++
+	}
+}
+
+func (s *samples) merge(other *samples) {
+	s.latencies = append(s.latencies, other.latencies...)
+	s.errors += other.errors
+}
+
+func (s *samples) result(elapsed time.Duration) Result {
+	result := Result{
+		Count:  int64(len(s.latencies)),
+		Errors: s.errors,
+	}
+	if len(s.latencies) == 0 {
+		return result
+	}
+
+	slices.Sort(s.latencies)
+	percentile := func(p int) time.Duration {
+		return s.latencies[(len(s.latencies)-1)*p/100]
+	}
+	result.P50 = percentile(50)
+	result.P90 = percentile(90)
+	result.P99 = percentile(99)
+	result.Max = s.latencies[len(s.latencies)-1]
+	if elapsed > 0 {
+		result.Throughput = float64(result.Count) / elapsed.Seconds()
+	}
+
+	return result
+}
+
+// Print writes the report to w as a table, one row per operation
+func (r *Report) Print(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "OPERATION\tCOUNT\tERRORS\tOPS/S\tP50\tP90\tP99\tMAX\tCAPACITY")
+
+	ops := make([]string, 0, len(r.Operations))
+	for op := range r.Operations {
+		ops = append(ops, op)
+	}
+	slices.Sort(ops)
+
+	for _, op := range ops {
+		result := r.Operations[op]
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%.1f\t%s\t%s\t%s\t%s\t%.1f\n", op, result.Count, result.Errors,
+			result.Throughput, result.P50, result.P90, result.P99, result.Max, result.ConsumedCapacity)
+	}
+	fmt.Fprintf(tw, "elapsed\t%s\n", r.Elapsed.Round(time.Millisecond))
+
+	return tw.Flush()
+}
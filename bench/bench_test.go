@@ -0,0 +1,125 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package bench
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ddouglas/dynastore"
+	"github.com/ddouglas/dynastore/dynastoretest"
+)
+
+func TestRun(t *testing.T) {
+	testCases := map[string]struct {
+		Config   Config
+		Loads    int64
+		Persists int64
+	}{
+		"read only": {
+			Config: Config{Sessions: 5, Requests: 50, Reads: 1},
+			Loads:  50,
+		},
+		"write only": {
+			Config:   Config{Sessions: 5, Requests: 50, Writes: 1},
+			Persists: 50,
+		},
+	}
+
+	for label, tc := range testCases {
+		t.Run(label, func(t *testing.T) {
+			ddb := dynastoretest.New()
+			store, _ := dynastore.New(ddb)
+
+			report, err := Run(context.TODO(), store, tc.Config)
+			if err != nil {
+				t.Fatalf("expected no error; got %v", err)
+			}
+
+			if got := report.Operations[Load]; got.Count != tc.Loads || got.Errors != 0 {
+				t.Errorf("expected %d loads; got %+v", tc.Loads, got)
+			}
+			if got := report.Operations[Persist]; got.Count != tc.Persists || got.Errors != 0 {
+				t.Errorf("expected %d persists; got %+v", tc.Persists, got)
+			}
+			if got := ddb.Len(dynastore.DefaultTableName); got != 0 {
+				t.Errorf("expected the sessions to be deleted; got %d", got)
+			}
+		})
+	}
+}
+
+func TestRunMix(t *testing.T) {
+	store, _ := dynastore.New(dynastoretest.New(), dynastore.WithStats())
+
+	report, err := Run(context.TODO(), store, Config{Sessions: 10, Concurrency: 4, Requests: 400})
+	if err != nil {
+		t.Fatalf("expected no error; got %v", err)
+	}
+
+	loads, persists := report.Operations[Load], report.Operations[Persist]
+	if loads.Count+persists.Count != 400 {
+		t.Fatalf("expected 400 operations; got %d loads and %d persists", loads.Count, persists.Count)
+	}
+	if loads.Count <= persists.Count {
+		t.Errorf("expected mostly loads; got %d loads and %d persists", loads.Count, persists.Count)
+	}
+	if loads.P50 <= 0 || loads.P99 < loads.P50 || loads.Max < loads.P99 || loads.Throughput <= 0 {
+		t.Errorf("expected load latency percentiles; got %+v", loads)
+	}
+
+	var out bytes.Buffer
+	if err := report.Print(&out); err != nil {
+		t.Fatalf("expected no error; got %v", err)
+	}
+	if !strings.Contains(out.String(), "Load") || !strings.Contains(out.String(), "Persist") {
+		t.Errorf("expected a row per operation; got %s", out.String())
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	ddb := dynastoretest.New()
+	store, _ := dynastore.New(ddb)
+
+	ddb.Fail("PutItem", errors.New("boom"))
+	if _, err := Run(context.TODO(), store, Config{Sessions: 1, Requests: 1}); err == nil {
+		t.Fatal("expected an error writing the sessions")
+	}
+
+	ddb.Fail("PutItem", nil)
+	ddb.FailNext("GetItem", 3, errors.New("boom"))
+	report, err := Run(context.TODO(), store, Config{Sessions: 1, Requests: 10, Reads: 1, Concurrency: 1})
+	if err != nil {
+		t.Fatalf("expected no error; got %v", err)
+	}
+	if got := report.Operations[Load]; got.Count != 10 || got.Errors != 3 {
+		t.Errorf("expected 3 of 10 loads to fail; got %+v", got)
+	}
+}
+
+func TestRunDuration(t *testing.T) {
+	store, _ := dynastore.New(dynastoretest.New())
+
+	report, err := Run(context.TODO(), store, Config{Sessions: 1, Duration: 50 * time.Millisecond, Rate: 100})
+	if err != nil {
+		t.Fatalf("expected no error; got %v", err)
+	}
+	if total := report.Operations[Load].Count + report.Operations[Persist].Count; total == 0 || total > 10 {
+		t.Errorf("expected a handful of rate limited operations; got %d", total)
+	}
+}
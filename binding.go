@@ -0,0 +1,252 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+
+	"github.com/gorilla/sessions"
+)
+
+const (
+	// ClientIPField contains the name of the attribute holding the address of
+	// the client a session is bound to
+	ClientIPField = "client_ip"
+
+	// FingerprintField contains the name of the attribute holding the
+	// fingerprint of the device a session is bound to
+	FingerprintField = "fingerprint"
+)
+
+var (
+	// ErrClientMismatch is passed to the MismatchHandler when a session is
+	// presented by a client other than the one it is bound to
+	ErrClientMismatch = fmt.Errorf("session presented by a different client than the one it is bound to")
+)
+
+// clientIPKey is the session.Values key under which the bound client address is
+// tracked between Load and Persist
+type clientIPKey struct{}
+
+// fingerprintKey is the session.Values key under which the bound device
+// fingerprint is tracked between Load and Persist
+type fingerprintKey struct{}
+
+// reauthKey is the session.Values key set by FlagMismatch
+type reauthKey struct{}
+
+// MismatchHandler decides what happens when a session is presented by a client
+// other than the one it is bound to. err wraps ErrClientMismatch. Returning an
+// error discards the session, so New hands out a fresh one instead; returning
+// nil keeps it.
+type MismatchHandler func(req *http.Request, session *sessions.Session, err error) error
+
+// RejectMismatch discards sessions presented by a different client
+func RejectMismatch(req *http.Request, session *sessions.Session, err error) error {
+	return err
+}
+
+// FlagMismatch keeps sessions presented by a different client, but marks them as
+// requiring re-authentication. See NeedsReauth.
+func FlagMismatch(req *http.Request, session *sessions.Session, err error) error {
+	session.Values[reauthKey{}] = true
+	return nil
+}
+
+// NeedsReauth reports whether the session was flagged by FlagMismatch during
+// the current request
+func NeedsReauth(session *sessions.Session) bool {
+	flagged, _ := session.Values[reauthKey{}].(bool)
+	return flagged
+}
+
+// ResetBinding unbinds the session from its client, typically once the user has
+// re-authenticated. The next Save binds it to the client making that request.
+func ResetBinding(session *sessions.Session) {
+	delete(session.Values, clientIPKey{})
+	delete(session.Values, fingerprintKey{})
+	delete(session.Values, reauthKey{})
+}
+
+type ipBinding struct {
+	ipv4Bits   int
+	ipv6Bits   int
+	onMismatch MismatchHandler
+}
+
+type deviceBinding struct {
+	deviceID   func(*http.Request) string
+	onMismatch MismatchHandler
+}
+
+// clientIP returns the address of the client making req
+func (store *Store) clientIP(req *http.Request) string {
+	if store.clientIPFunc != nil {
+		return store.clientIPFunc(req)
+	}
+
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+
+	return host
+}
+
+// bindClient records the address of the client making req on sessions that
+// aren't bound yet
+func (store *Store) bindClient(req *http.Request, session *sessions.Session) {
+	if store.ipBinding != nil {
+		if _, ok := session.Values[clientIPKey{}].(string); !ok {
+			if ip := store.clientIP(req); ip != "" {
+				session.Values[clientIPKey{}] = ip
+			}
+		}
+	}
+
+	if store.deviceBinding != nil {
+		if _, ok := session.Values[fingerprintKey{}].(string); !ok {
+			session.Values[fingerprintKey{}] = store.fingerprint(req)
+		}
+	}
+}
+
+// fingerprint returns a digest of the user agent and, if configured, the device
+// id of the client making req. Only the digest is stored.
+func (store *Store) fingerprint(req *http.Request) string {
+	h := sha256.New()
+	h.Write([]byte(req.UserAgent()))
+	if store.deviceBinding.deviceID != nil {
+		h.Write([]byte{0})
+		h.Write([]byte(store.deviceBinding.deviceID(req)))
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// verifyClient checks that req comes from the network and device the session is
+// bound to. Sessions written before binding was enabled are left alone until
+// their next Save binds them.
+func (store *Store) verifyClient(req *http.Request, session *sessions.Session) error {
+	if err := store.verifyIP(req, session); err != nil {
+		return err
+	}
+
+	return store.verifyDevice(req, session)
+}
+
+func (store *Store) verifyIP(req *http.Request, session *sessions.Session) error {
+	if store.ipBinding == nil {
+		return nil
+	}
+
+	bound, ok := session.Values[clientIPKey{}].(string)
+	if !ok {
+		return nil
+	}
+
+	current := store.clientIP(req)
+	if sameNetwork(bound, current, store.ipBinding.ipv4Bits, store.ipBinding.ipv6Bits) {
+		return nil
+	}
+
+	err := fmt.Errorf("%w: bound to %s, presented by %s", ErrClientMismatch, bound, current)
+	if store.ipBinding.onMismatch == nil {
+		return err
+	}
+
+	return store.ipBinding.onMismatch(req, session, err)
+}
+
+// sameNetwork reports whether a and b fall into the same ipv4Bits or ipv6Bits
+// sized prefix. Addresses that can't be parsed only match themselves.
+func sameNetwork(a, b string, ipv4Bits, ipv6Bits int) bool {
+	addrA, errA := netip.ParseAddr(a)
+	addrB, errB := netip.ParseAddr(b)
+	if errA != nil || errB != nil {
+		return a == b
+	}
+
+	addrA, addrB = addrA.Unmap(), addrB.Unmap()
+	if addrA.Is4() != addrB.Is4() {
+		return false
+	}
+
+	bits := ipv6Bits
+	if addrA.Is4() {
+		bits = ipv4Bits
+	}
+
+	prefix, err := addrA.Prefix(bits)
+	if err != nil {
+		return addrA == addrB
+	}
+
+	return prefix.Contains(addrB)
+}
+
+func (store *Store) verifyDevice(req *http.Request, session *sessions.Session) error {
+	if store.deviceBinding == nil {
+		return nil
+	}
+
+	bound, ok := session.Values[fingerprintKey{}].(string)
+	if !ok {
+		return nil
+	}
+
+	if hmac.Equal([]byte(bound), []byte(store.fingerprint(req))) {
+		return nil
+	}
+
+	err := fmt.Errorf("%w: device fingerprint changed", ErrClientMismatch)
+	if store.deviceBinding.onMismatch == nil {
+		return err
+	}
+
+	return store.deviceBinding.onMismatch(req, session, err)
+}
+
+// marshalBinding adds the client binding of the session to the values written
+// to dynamodb
+func marshalBinding(session *sessions.Session, v map[string]any) {
+	if ip, ok := session.Values[clientIPKey{}].(string); ok {
+		v[ClientIPField] = ip
+	}
+	if fingerprint, ok := session.Values[fingerprintKey{}].(string); ok {
+		v[FingerprintField] = fingerprint
+	}
+}
+
+// loadBinding extracts the client binding from an item read from dynamodb,
+// returning it keyed the way it is tracked in session.Values
+func loadBinding(item map[string]any) map[any]any {
+	binding := make(map[any]any)
+	if ip, ok := item[ClientIPField].(string); ok {
+		binding[clientIPKey{}] = ip
+	}
+	if fingerprint, ok := item[FingerprintField].(string); ok {
+		binding[fingerprintKey{}] = fingerprint
+	}
+	delete(item, ClientIPField)
+	delete(item, FingerprintField)
+
+	return binding
+}
@@ -0,0 +1,164 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/sessions"
+)
+
+func TestIPBinding(t *testing.T) {
+	ddb := newFakeDynamoDB()
+
+	request := func(remoteAddr string, cookies ...*http.Cookie) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = remoteAddr
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		return req
+	}
+
+	t.Run("reject", func(t *testing.T) {
+		store, _ := New(ddb, WithIPBinding(24, 64, RejectMismatch))
+
+		session, _ := store.New(request("10.0.0.1:1234"), "session")
+		session.Values["user_id"] = "alice"
+		w := httptest.NewRecorder()
+		if err := store.Save(request("10.0.0.1:1234"), w, session); err != nil {
+			t.Fatal(err)
+		}
+		cookie := w.Result().Cookies()[0]
+
+		loaded, _ := store.New(request("10.0.0.7:4321", cookie), "session")
+		if loaded.IsNew || loaded.Values["user_id"] != "alice" {
+			t.Error("expected the session to be accepted from the same network")
+		}
+		if _, ok := loaded.Values[ClientIPField]; ok {
+			t.Errorf("expected %v to be stripped from Values", ClientIPField)
+		}
+
+		loaded, _ = store.New(request("10.0.1.1:4321", cookie), "session")
+		if !loaded.IsNew {
+			t.Error("expected the session to be rejected from a different network")
+		}
+	})
+
+	t.Run("flag", func(t *testing.T) {
+		store, _ := New(ddb, WithIPBinding(32, 128, FlagMismatch), WithClientIPFunc(func(req *http.Request) string {
+			return req.Header.Get("X-Forwarded-For")
+		}))
+
+		req := request("192.0.2.1:1234")
+		req.Header.Set("X-Forwarded-For", "2001:db8::1")
+		session, _ := store.New(req, "session")
+		w := httptest.NewRecorder()
+		if err := store.Save(req, w, session); err != nil {
+			t.Fatal(err)
+		}
+		cookie := w.Result().Cookies()[0]
+
+		req = request("192.0.2.1:1234", cookie)
+		req.Header.Set("X-Forwarded-For", "2001:db8::2")
+		loaded, _ := store.New(req, "session")
+		if loaded.IsNew {
+			t.Fatal("expected the session to be kept")
+		}
+		if !NeedsReauth(loaded) {
+			t.Error("expected the session to be flagged for re-authentication")
+		}
+
+		ResetBinding(loaded)
+		if err := store.Save(req, httptest.NewRecorder(), loaded); err != nil {
+			t.Fatal(err)
+		}
+		loaded, _ = store.New(req, "session")
+		if loaded.IsNew || NeedsReauth(loaded) {
+			t.Error("expected the session to be re-bound to the new address")
+		}
+	})
+}
+
+func TestDeviceBinding(t *testing.T) {
+	var challenged error
+	ddb := newFakeDynamoDB()
+	store, _ := New(ddb, WithDeviceBinding(
+		func(req *http.Request) string { return req.Header.Get("X-Device-Id") },
+		func(req *http.Request, session *sessions.Session, err error) error {
+			challenged = err
+			return nil
+		},
+	))
+
+	request := func(userAgent, deviceID string, cookies ...*http.Cookie) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("User-Agent", userAgent)
+		req.Header.Set("X-Device-Id", deviceID)
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		return req
+	}
+
+	req := request("browser/1.0", "device-a")
+	session, _ := store.New(req, "session")
+	w := httptest.NewRecorder()
+	if err := store.Save(req, w, session); err != nil {
+		t.Fatal(err)
+	}
+	cookie := w.Result().Cookies()[0]
+
+	if _, err := store.New(request("browser/1.0", "device-a", cookie), "session"); err != nil || challenged != nil {
+		t.Fatalf("expected the same device to be accepted; got %v", challenged)
+	}
+	if _, ok := ddb.items[session.ID][FingerprintField]; !ok {
+		t.Errorf("expected %v to be written", FingerprintField)
+	}
+
+	loaded, _ := store.New(request("browser/1.0", "device-b", cookie), "session")
+	if !errors.Is(challenged, ErrClientMismatch) {
+		t.Errorf("expected %v; got %v", ErrClientMismatch, challenged)
+	}
+	if loaded.IsNew {
+		t.Error("expected the callback to keep the session")
+	}
+}
+
+func TestSameNetwork(t *testing.T) {
+	testCases := map[string]struct {
+		a, b string
+		want bool
+	}{
+		"exact":        {a: "10.0.0.1", b: "10.0.0.1", want: true},
+		"same /24":     {a: "10.0.0.1", b: "10.0.0.200", want: true},
+		"other /24":    {a: "10.0.0.1", b: "10.0.1.1", want: false},
+		"same /64":     {a: "2001:db8::1", b: "2001:db8::ffff", want: true},
+		"other /64":    {a: "2001:db8::1", b: "2001:db8:0:1::1", want: false},
+		"mapped ipv4":  {a: "::ffff:10.0.0.1", b: "10.0.0.2", want: true},
+		"mixed family": {a: "10.0.0.1", b: "2001:db8::1", want: false},
+		"unparsable":   {a: "unix", b: "unix", want: true},
+	}
+
+	for label, tc := range testCases {
+		t.Run(label, func(t *testing.T) {
+			if got := sameNetwork(tc.a, tc.b, 24, 64); got != tc.want {
+				t.Errorf("expected %v; got %v", tc.want, got)
+			}
+		})
+	}
+}
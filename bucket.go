@@ -0,0 +1,89 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"strings"
+	"time"
+
+	"github.com/gorilla/sessions"
+)
+
+// bucketSeparator separates the name of a bucket from the keys it holds
+const bucketSeparator = "."
+
+// Bucket scopes the values of a session under a name, e.g. "auth" or "cart", so
+// features sharing a session can't trample each other's keys. A value set under
+// key in the bucket named cart is stored as "cart.key".
+type Bucket struct {
+	session *sessions.Session
+	prefix  string
+}
+
+// SessionBucket returns the bucket of session named name
+func SessionBucket(session *sessions.Session, name string) Bucket {
+	return Bucket{session: session, prefix: name + bucketSeparator}
+}
+
+// Get returns the value stored under key in the bucket
+func (b Bucket) Get(key string) (any, bool) {
+	v, ok := b.session.Values[b.prefix+key]
+	return v, ok
+}
+
+// Set stores value under key in the bucket
+func (b Bucket) Set(key string, value any) {
+	b.session.Values[b.prefix+key] = value
+}
+
+// Delete removes the value stored under key in the bucket, with its deadline
+func (b Bucket) Delete(key string) {
+	delete(b.session.Values, b.prefix+key)
+	delete(valueExpiries(b.session), b.prefix+key)
+}
+
+// Keys returns the keys of the values held by the bucket, without the bucket name
+func (b Bucket) Keys() []string {
+	var keys []string
+	for k := range b.session.Values {
+		if k, ok := k.(string); ok && strings.HasPrefix(k, b.prefix) {
+			keys = append(keys, strings.TrimPrefix(k, b.prefix))
+		}
+	}
+
+	return keys
+}
+
+// Clear removes every value of the bucket, leaving other buckets and values
+// untouched
+func (b Bucket) Clear() {
+	for _, key := range b.Keys() {
+		b.Delete(key)
+	}
+}
+
+// SetExpiry attaches a deadline to the value stored under key in the bucket (see
+// SetValueExpiry)
+func (b Bucket) SetExpiry(key string, deadline time.Time) {
+	SetValueExpiry(b.session, b.prefix+key, deadline)
+}
+
+// Expire attaches deadline to every value currently held by the bucket, so the
+// bucket empties itself at deadline while the session lives on. Values set
+// afterwards get no deadline of their own.
+func (b Bucket) Expire(deadline time.Time) {
+	for _, key := range b.Keys() {
+		b.SetExpiry(key, deadline)
+	}
+}
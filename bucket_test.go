@@ -0,0 +1,68 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+)
+
+func TestBucket(t *testing.T) {
+	now := time.Now()
+	store, _ := New(newFakeDynamoDB(), MaxAge(3600))
+	store.now = func() time.Time { return now }
+
+	session := sessions.NewSession(store, "session")
+	session.ID = "abc"
+	session.Options = store.newOptions()
+
+	auth := SessionBucket(session, "auth")
+	cart := SessionBucket(session, "cart")
+	auth.Set("user", "bob")
+	cart.Set("items", 3)
+	cart.Set("user", "guest")
+
+	if v, _ := auth.Get("user"); v != "bob" {
+		t.Errorf("expected buckets to hold separate values; got %v", v)
+	}
+	if session.Values["cart.items"] != 3 {
+		t.Errorf("expected the value to be stored under the bucket name; got %v", session.Values)
+	}
+
+	cart.Expire(now.Add(time.Minute))
+	if err := store.Persist(context.TODO(), "session", session); err != nil {
+		t.Fatal(err)
+	}
+
+	now = now.Add(2 * time.Minute)
+	loaded := sessions.NewSession(store, "session")
+	if err := store.Load(context.TODO(), "abc", loaded); err != nil {
+		t.Fatal(err)
+	}
+	if keys := SessionBucket(loaded, "cart").Keys(); len(keys) != 0 {
+		t.Errorf("expected the cart bucket to have expired; got %v", keys)
+	}
+
+	auth = SessionBucket(loaded, "auth")
+	if v, _ := auth.Get("user"); v != "bob" {
+		t.Errorf("expected the auth bucket to survive; got %v", v)
+	}
+	auth.Clear()
+	if len(auth.Keys()) != 0 {
+		t.Error("expected the bucket to be empty once cleared")
+	}
+}
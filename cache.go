@@ -0,0 +1,193 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// itemCache is a bounded LRU cache of session items. Entries are fresh for ttl
+// after being stored and are kept until evicted. All methods are safe to call on
+// a nil cache, which caches nothing.
+type itemCache struct {
+	mu      sync.Mutex
+	now     func() time.Time
+	size    int
+	ttl     time.Duration
+	lru     *list.List
+	entries map[string]*list.Element
+}
+
+type cachedItem struct {
+	key    string
+	item   map[string]types.AttributeValue
+	stored time.Time
+}
+
+func newItemCache(size int, ttl time.Duration) *itemCache {
+	return &itemCache{
+		now:     time.Now,
+		size:    max(size, 1),
+		ttl:     ttl,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// get returns the item cached for key in table unless it is older than the ttl
+func (c *itemCache) get(table, key string) (map[string]types.AttributeValue, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[bufferKey(table, key)]
+	if !ok {
+		return nil, false
+	}
+
+	entry := element.Value.(*cachedItem)
+	if c.ttl > 0 && c.now().Sub(entry.stored) >= c.ttl {
+		return nil, false
+	}
+
+	c.lru.MoveToFront(element)
+	return entry.item, true
+}
+
+// put caches item under key in table, evicting the least recently used entry
+// when full
+func (c *itemCache) put(table, key string, item map[string]types.AttributeValue) {
+	if c == nil || item == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	k := bufferKey(table, key)
+	if element, ok := c.entries[k]; ok {
+		element.Value = &cachedItem{key: k, item: item, stored: c.now()}
+		c.lru.MoveToFront(element)
+		return
+	}
+
+	if c.lru.Len() >= c.size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cachedItem).key)
+	}
+
+	c.entries[k] = c.lru.PushFront(&cachedItem{key: k, item: item, stored: c.now()})
+}
+
+// invalidate drops the entry of key in table
+func (c *itemCache) invalidate(table, key string) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	k := bufferKey(table, key)
+	if element, ok := c.entries[k]; ok {
+		c.lru.Remove(element)
+		delete(c.entries, k)
+	}
+}
+
+// cachingClient keeps the item cache of a store in line with its own writes: it
+// caches the items it puts and invalidates the ones it updates or deletes,
+// whatever the feature issuing the write
+type cachingClient struct {
+	DynamoDBClient
+	cache      *itemCache
+	primaryKey string
+}
+
+// keyOf returns the partition key value of an item or key
+func (c cachingClient) keyOf(item map[string]types.AttributeValue) string {
+	if v, ok := item[c.primaryKey].(*types.AttributeValueMemberS); ok {
+		return v.Value
+	}
+
+	return ""
+}
+
+func (c cachingClient) PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	table, key := aws.ToString(params.TableName), c.keyOf(params.Item)
+
+	out, err := c.DynamoDBClient.PutItem(ctx, params, optFns...)
+	if err != nil {
+		c.cache.invalidate(table, key)
+		return out, err
+	}
+
+	c.cache.put(table, key, params.Item)
+	return out, nil
+}
+
+func (c cachingClient) UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error) {
+	defer c.cache.invalidate(aws.ToString(params.TableName), c.keyOf(params.Key))
+	return c.DynamoDBClient.UpdateItem(ctx, params, optFns...)
+}
+
+func (c cachingClient) DeleteItem(ctx context.Context, params *dynamodb.DeleteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error) {
+	defer c.cache.invalidate(aws.ToString(params.TableName), c.keyOf(params.Key))
+	return c.DynamoDBClient.DeleteItem(ctx, params, optFns...)
+}
+
+func (c cachingClient) BatchWriteItem(ctx context.Context, params *dynamodb.BatchWriteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchWriteItemOutput, error) {
+	defer func() {
+		for table, requests := range params.RequestItems {
+			for _, request := range requests {
+				switch {
+				case request.PutRequest != nil:
+					c.cache.invalidate(table, c.keyOf(request.PutRequest.Item))
+				case request.DeleteRequest != nil:
+					c.cache.invalidate(table, c.keyOf(request.DeleteRequest.Key))
+				}
+			}
+		}
+	}()
+
+	return c.DynamoDBClient.BatchWriteItem(ctx, params, optFns...)
+}
+
+func (c cachingClient) TransactWriteItems(ctx context.Context, params *dynamodb.TransactWriteItemsInput, optFns ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error) {
+	defer func() {
+		for _, item := range params.TransactItems {
+			switch {
+			case item.Put != nil:
+				c.cache.invalidate(aws.ToString(item.Put.TableName), c.keyOf(item.Put.Item))
+			case item.Update != nil:
+				c.cache.invalidate(aws.ToString(item.Update.TableName), c.keyOf(item.Update.Key))
+			case item.Delete != nil:
+				c.cache.invalidate(aws.ToString(item.Delete.TableName), c.keyOf(item.Delete.Key))
+			}
+		}
+	}()
+
+	return c.DynamoDBClient.TransactWriteItems(ctx, params, optFns...)
+}
@@ -0,0 +1,65 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+)
+
+func TestCache(t *testing.T) {
+	ctx := context.TODO()
+
+	ddb := newFakeDynamoDB()
+	store, _ := New(ddb, MaxAge(3600), WithCache(2, time.Minute))
+	now := time.Now()
+	store.now = func() time.Time { return now }
+
+	persistUserSessions(t, store, "bob", 3)
+
+	load := func(id string) *sessions.Session {
+		session := sessions.NewSession(store, "session")
+		if err := store.Load(ctx, id, session); err != nil {
+			t.Fatalf("failed to load %v: %v", id, err)
+		}
+		return session
+	}
+
+	load("bob-1")
+	load("bob-2")
+	if ddb.gets != 0 {
+		t.Errorf("expected saved sessions to be cached; got %v reads", ddb.gets)
+	}
+
+	load("bob-0")
+	if ddb.gets != 1 {
+		t.Errorf("expected the least recently used session to be evicted; got %v reads", ddb.gets)
+	}
+
+	now = now.Add(time.Minute)
+	load("bob-0")
+	if ddb.gets != 2 {
+		t.Errorf("expected expired entries to be read again; got %v reads", ddb.gets)
+	}
+
+	if err := store.Delete(ctx, "bob-0"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Load(ctx, "bob-0", sessions.NewSession(store, "session")); err == nil {
+		t.Error("expected deleted session not to be served from the cache")
+	}
+}
@@ -0,0 +1,60 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// lifecycle holds the functions stopping the background work of a store, such as
+// asynchronous writers, which Close runs
+type lifecycle struct {
+	mu      sync.Mutex
+	closers []func(ctx context.Context) error
+	closed  bool
+}
+
+// onClose registers fn to be run by Close. Closers run in the reverse order of
+// their registration, so work started last is stopped first.
+func (store *Store) onClose(fn func(ctx context.Context) error) {
+	store.lifecycle.mu.Lock()
+	defer store.lifecycle.mu.Unlock()
+
+	store.lifecycle.closers = append(store.lifecycle.closers, fn)
+}
+
+// Close flushes pending asynchronous writes and stops the background work of the
+// store, for graceful shutdown. Writes still pending when ctx is done may be
+// lost. Calling Close more than once is a no-op.
+func (store *Store) Close(ctx context.Context) error {
+	store.lifecycle.mu.Lock()
+	if store.lifecycle.closed {
+		store.lifecycle.mu.Unlock()
+		return nil
+	}
+	store.lifecycle.closed = true
+	closers := store.lifecycle.closers
+	store.lifecycle.mu.Unlock()
+
+	var errs []error
+	for i := len(closers) - 1; i >= 0; i-- {
+		if err := closers[i](ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,45 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+func TestClose(t *testing.T) {
+	store, _ := New(newFakeDynamoDB())
+
+	var order []int
+	store.onClose(func(ctx context.Context) error {
+		order = append(order, 1)
+		return nil
+	})
+	store.onClose(func(ctx context.Context) error {
+		order = append(order, 2)
+		return fmt.Errorf("flush failed")
+	})
+
+	if err := store.Close(context.TODO()); err == nil {
+		t.Error("expected the error of a closer to be returned")
+	}
+	if len(order) != 2 || order[0] != 2 || order[1] != 1 {
+		t.Errorf("expected closers to run in reverse order; got %v", order)
+	}
+
+	if err := store.Close(context.TODO()); err != nil || len(order) != 2 {
+		t.Errorf("expected a second Close to be a no-op; got %v, %v", err, order)
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// cfnTable is the AWS::DynamoDB::Table resource emitted by CloudFormationResource
+type cfnTable struct {
+	Type       string             `json:"Type"`
+	Properties cfnTableProperties `json:"Properties"`
+}
+
+type cfnTableProperties struct {
+	TableName               string            `json:"TableName"`
+	BillingMode             string            `json:"BillingMode"`
+	AttributeDefinitions    []cfnAttributeDef `json:"AttributeDefinitions"`
+	KeySchema               []cfnKeySchema    `json:"KeySchema"`
+	GlobalSecondaryIndexes  []cfnGlobalIndex  `json:"GlobalSecondaryIndexes,omitempty"`
+	TimeToLiveSpecification cfnTimeToLive     `json:"TimeToLiveSpecification"`
+}
+
+type cfnAttributeDef struct {
+	AttributeName string `json:"AttributeName"`
+	AttributeType string `json:"AttributeType"`
+}
+
+type cfnKeySchema struct {
+	AttributeName string `json:"AttributeName"`
+	KeyType       string `json:"KeyType"`
+}
+
+type cfnGlobalIndex struct {
+	IndexName  string         `json:"IndexName"`
+	KeySchema  []cfnKeySchema `json:"KeySchema"`
+	Projection cfnProjection  `json:"Projection"`
+}
+
+type cfnProjection struct {
+	ProjectionType string `json:"ProjectionType"`
+}
+
+type cfnTimeToLive struct {
+	AttributeName string `json:"AttributeName"`
+	Enabled       bool   `json:"Enabled"`
+}
+
+// CloudFormationResource returns an AWS::DynamoDB::Table resource, as indented
+// JSON, declaring the table of CreateTableInput with ttl enabled, for placing
+// under the Resources of a CloudFormation template or importing into a CDK
+// stack, so infrastructure as code stays in step with the store configuration.
+func (store *Store) CloudFormationResource() ([]byte, error) {
+	input := store.CreateTableInput()
+
+	props := cfnTableProperties{
+		TableName:   aws.ToString(input.TableName),
+		BillingMode: string(input.BillingMode),
+		TimeToLiveSpecification: cfnTimeToLive{
+			AttributeName: DefaultTTLField,
+			Enabled:       true,
+		},
+	}
+	for _, def := range input.AttributeDefinitions {
+		props.AttributeDefinitions = append(props.AttributeDefinitions, cfnAttributeDef{
+			AttributeName: aws.ToString(def.AttributeName),
+			AttributeType: string(def.AttributeType),
+		})
+	}
+	for _, key := range input.KeySchema {
+		props.KeySchema = append(props.KeySchema, cfnKeySchema{
+			AttributeName: aws.ToString(key.AttributeName),
+			KeyType:       string(key.KeyType),
+		})
+	}
+	for _, gsi := range input.GlobalSecondaryIndexes {
+		index := cfnGlobalIndex{
+			IndexName:  aws.ToString(gsi.IndexName),
+			Projection: cfnProjection{ProjectionType: string(gsi.Projection.ProjectionType)},
+		}
+		for _, key := range gsi.KeySchema {
+			index.KeySchema = append(index.KeySchema, cfnKeySchema{
+				AttributeName: aws.ToString(key.AttributeName),
+				KeyType:       string(key.KeyType),
+			})
+		}
+		props.GlobalSecondaryIndexes = append(props.GlobalSecondaryIndexes, index)
+	}
+
+	data, err := json.MarshalIndent(cfnTable{Type: "AWS::DynamoDB::Table", Properties: props}, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal cloudformation resource: %w", err)
+	}
+
+	return data, nil
+}
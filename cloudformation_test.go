@@ -0,0 +1,50 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestCloudFormationResource(t *testing.T) {
+	store, _ := New(newFakeDynamoDB(), TableName("sessions"), PrimaryKey("session_id"), WithUserIndex("user-index", "user_id"))
+
+	data, err := store.CloudFormationResource()
+	if err != nil {
+		t.Fatalf("expected a resource; got %v", err)
+	}
+
+	var resource cfnTable
+	if err := json.Unmarshal(data, &resource); err != nil {
+		t.Fatalf("expected valid json; got %v", err)
+	}
+
+	props := resource.Properties
+	if resource.Type != "AWS::DynamoDB::Table" || props.TableName != "sessions" || props.BillingMode != "PAY_PER_REQUEST" {
+		t.Errorf("unexpected table; got %s", data)
+	}
+	if len(props.KeySchema) != 1 || props.KeySchema[0] != (cfnKeySchema{AttributeName: "session_id", KeyType: "HASH"}) {
+		t.Errorf("expected a session_id partition key; got %+v", props.KeySchema)
+	}
+	if len(props.GlobalSecondaryIndexes) != 1 || props.GlobalSecondaryIndexes[0].KeySchema[0].AttributeName != "user_id" {
+		t.Errorf("expected the user index; got %+v", props.GlobalSecondaryIndexes)
+	}
+	if len(props.AttributeDefinitions) != 2 {
+		t.Errorf("expected both keys to be defined; got %+v", props.AttributeDefinitions)
+	}
+	if props.TimeToLiveSpecification != (cfnTimeToLive{AttributeName: DefaultTTLField, Enabled: true}) {
+		t.Errorf("expected ttl to be enabled; got %+v", props.TimeToLiveSpecification)
+	}
+}
@@ -0,0 +1,385 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Command dynastore manages the sessions of a dynastore table from the command
+// line: listing, inspecting and deleting sessions, deleting the sessions of a
+// user, purging expired sessions, creating the table, summarising it and
+// benchmarking it.
+//
+//	dynastore [flags] <command> [args]
+//
+// Credentials and region are read from the environment, as by the aws cli.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/ddouglas/dynastore"
+	"github.com/ddouglas/dynastore/bench"
+	"github.com/gorilla/sessions"
+)
+
+const usage = `usage: dynastore [flags] <command> [args]
+
+commands:
+  list [user]          list the sessions of the table, or of user
+  inspect <id>         print the values of a session as json
+  delete <id>...       delete sessions
+  delete-user <user>   delete every session of user
+  purge-expired        delete the sessions whose ttl has passed
+  create-table         create the table if it does not exist
+  stats                count the sessions, expired sessions and users of the table
+  bench                drive a mix of session reads and writes against the table and report their latency
+
+flags:
+`
+
+// errUsage is returned for invalid command lines, after printing the usage
+var errUsage = errors.New("invalid usage")
+
+// cli holds the flags shared by all commands
+type cli struct {
+	table     string
+	key       string
+	userIndex string
+	userKey   string
+	region    string
+	endpoint  string
+	dryRun    bool
+	cfn       bool
+	segments  int
+	rate      int
+	bench     bench.Config
+	stdout    io.Writer
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if err := run(ctx, os.Args[1:], os.Stdout, os.Stderr); err != nil {
+		if !errors.Is(err, errUsage) {
+			fmt.Fprintln(os.Stderr, "dynastore:", err)
+		}
+		os.Exit(1)
+	}
+}
+
+// run parses args and executes the command they name
+func run(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	c := cli{stdout: stdout}
+
+	fs := flag.NewFlagSet("dynastore", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprint(stderr, usage)
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&c.table, "table", dynastore.DefaultTableName, "name of the sessions table")
+	fs.StringVar(&c.key, "key", dynastore.DefaultPrimaryKey, "name of the primary key of the table")
+	fs.StringVar(&c.userIndex, "user-index", "", "global secondary index keyed by user, required by list <user> and delete-user")
+	fs.StringVar(&c.userKey, "user-key", "user_id", "session value backing the user index")
+	fs.StringVar(&c.region, "region", "", "aws region, defaults to the environment")
+	fs.StringVar(&c.endpoint, "endpoint", "", "dynamodb endpoint, e.g. http://localhost:8000 for dynamodb local")
+	fs.BoolVar(&c.dryRun, "dry-run", false, "report what purge-expired would delete without deleting")
+	fs.BoolVar(&c.cfn, "cloudformation", false, "print the cloudformation resource of create-table instead of creating the table")
+	fs.IntVar(&c.segments, "segments", 1, "parallel scan segments of list, purge-expired and stats")
+	fs.IntVar(&c.rate, "rate", 0, "maximum scan pages per second of list, purge-expired and stats, 0 for unlimited")
+	fs.DurationVar(&c.bench.Duration, "duration", bench.DefaultDuration, "how long bench runs")
+	fs.IntVar(&c.bench.Requests, "requests", 0, "operations after which bench stops, 0 for no limit")
+	fs.IntVar(&c.bench.Concurrency, "concurrency", bench.DefaultConcurrency, "workers issuing the operations of bench")
+	fs.IntVar(&c.bench.Sessions, "sessions", bench.DefaultSessions, "sessions written and read by bench")
+	fs.IntVar(&c.bench.Reads, "reads", 9, "weight of loads in the operations of bench")
+	fs.IntVar(&c.bench.Writes, "writes", 1, "weight of persists in the operations of bench")
+	fs.IntVar(&c.bench.Rate, "ops", 0, "maximum operations per second of bench, 0 for unlimited")
+	fs.IntVar(&c.bench.ValueSize, "value-size", bench.DefaultValueSize, "length in bytes of the session values written by bench")
+
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return errUsage
+	}
+
+	command, ok := commands[fs.Arg(0)]
+	if !ok || fs.NArg()-1 < command.minArgs || (command.maxArgs >= 0 && fs.NArg()-1 > command.maxArgs) {
+		fs.Usage()
+		return errUsage
+	}
+
+	store, err := c.store(ctx)
+	if err != nil {
+		return err
+	}
+	defer store.Close(context.WithoutCancel(ctx))
+
+	return command.run(ctx, c, store, fs.Args()[1:])
+}
+
+// command is a subcommand taking between minArgs and maxArgs arguments, with a
+// negative maxArgs meaning unbounded
+type command struct {
+	minArgs int
+	maxArgs int
+	run     func(ctx context.Context, c cli, store *dynastore.Store, args []string) error
+}
+
+var commands = map[string]command{
+	"list":          {minArgs: 0, maxArgs: 1, run: list},
+	"inspect":       {minArgs: 1, maxArgs: 1, run: inspect},
+	"delete":        {minArgs: 1, maxArgs: -1, run: deleteSessions},
+	"delete-user":   {minArgs: 1, maxArgs: 1, run: deleteUser},
+	"purge-expired": {minArgs: 0, maxArgs: 0, run: purgeExpired},
+	"create-table":  {minArgs: 0, maxArgs: 0, run: createTable},
+	"stats":         {minArgs: 0, maxArgs: 0, run: stats},
+	"bench":         {minArgs: 0, maxArgs: 0, run: runBench},
+}
+
+// store connects to dynamodb and returns a store configured by the flags
+func (c cli) store(ctx context.Context) (*dynastore.Store, error) {
+	var opts []func(*config.LoadOptions) error
+	if c.region != "" {
+		opts = append(opts, config.WithRegion(c.region))
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load aws config: %w", err)
+	}
+
+	client := dynamodb.NewFromConfig(cfg, func(o *dynamodb.Options) {
+		if c.endpoint != "" {
+			o.BaseEndpoint = aws.String(c.endpoint)
+		}
+	})
+
+	storeOpts := []dynastore.Option{
+		dynastore.TableName(c.table),
+		dynastore.PrimaryKey(c.key),
+		dynastore.TTLEnabled(),
+		dynastore.WithStats(),
+	}
+	if c.userIndex != "" {
+		storeOpts = append(storeOpts, dynastore.WithUserIndex(c.userIndex, c.userKey))
+	}
+
+	return dynastore.New(client, storeOpts...)
+}
+
+func (c cli) iterateOptions() []dynastore.IterateOption {
+	return []dynastore.IterateOption{
+		dynastore.IterateSegments(c.segments),
+		dynastore.IterateRateLimit(c.rate),
+	}
+}
+
+func list(ctx context.Context, c cli, store *dynastore.Store, args []string) error {
+	w := tabwriter.NewWriter(c.stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tUSER\tCREATED\tLAST SEEN\tEXPIRES\tIP")
+
+	row := func(summary dynastore.SessionSummary, user string) {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", summary.ID, user,
+			formatTime(summary.CreatedAt), formatTime(summary.LastSeen), formatTime(summary.ExpiresAt), summary.IP)
+	}
+
+	if len(args) == 1 {
+		var cursor string
+		for {
+			summaries, next, err := store.ListSessions(ctx, args[0], cursor)
+			if err != nil {
+				return err
+			}
+			for _, summary := range summaries {
+				row(summary, args[0])
+			}
+			if cursor = next; cursor == "" {
+				break
+			}
+		}
+		return w.Flush()
+	}
+
+	err := store.Iterate(ctx, func(item dynastore.SessionItem) error {
+		row(item.SessionSummary, item.User)
+		return nil
+	}, c.iterateOptions()...)
+	if err != nil {
+		return err
+	}
+
+	return w.Flush()
+}
+
+func inspect(ctx context.Context, c cli, store *dynastore.Store, args []string) error {
+	session := sessions.NewSession(store, "")
+	if err := store.Load(ctx, args[0], session); err != nil {
+		return err
+	}
+
+	values := make(map[string]any, len(session.Values))
+	for key, value := range session.Values {
+		values[fmt.Sprint(key)] = value
+	}
+
+	enc := json.NewEncoder(c.stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(values)
+}
+
+func deleteSessions(ctx context.Context, c cli, store *dynastore.Store, args []string) error {
+	for _, id := range args {
+		if err := store.Delete(ctx, id); err != nil {
+			return err
+		}
+		fmt.Fprintln(c.stdout, "deleted", id)
+	}
+
+	return nil
+}
+
+func deleteUser(ctx context.Context, c cli, store *dynastore.Store, args []string) error {
+	if err := store.DeleteAllForUser(ctx, args[0]); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(c.stdout, "deleted the sessions of", args[0])
+	return nil
+}
+
+func purgeExpired(ctx context.Context, c cli, store *dynastore.Store, args []string) error {
+	now := time.Now()
+
+	var expired []string
+	err := store.Iterate(ctx, func(item dynastore.SessionItem) error {
+		if !item.ExpiresAt.IsZero() && !now.Before(item.ExpiresAt) {
+			expired = append(expired, item.ID)
+		}
+		return nil
+	}, c.iterateOptions()...)
+	if err != nil {
+		return err
+	}
+
+	if c.dryRun {
+		fmt.Fprintf(c.stdout, "would delete %d expired sessions\n", len(expired))
+		return nil
+	}
+
+	for _, id := range expired {
+		if err := store.Delete(ctx, id); err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintf(c.stdout, "deleted %d expired sessions\n", len(expired))
+	return nil
+}
+
+func createTable(ctx context.Context, c cli, store *dynastore.Store, args []string) error {
+	if c.cfn {
+		resource, err := store.CloudFormationResource()
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(c.stdout, "%s\n", resource)
+		return err
+	}
+
+	if err := store.EnsureTable(ctx, dynastore.TableOptions{}); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(c.stdout, "table", c.table, "is active")
+	return nil
+}
+
+func stats(ctx context.Context, c cli, store *dynastore.Store, args []string) error {
+	now := time.Now()
+
+	var sessionCount, expired int
+	users := map[string]int{}
+	err := store.Iterate(ctx, func(item dynastore.SessionItem) error {
+		sessionCount++
+		if !item.ExpiresAt.IsZero() && !now.Before(item.ExpiresAt) {
+			expired++
+		}
+		if item.User != "" {
+			users[item.User]++
+		}
+		return nil
+	}, c.iterateOptions()...)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(c.stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "sessions\t%d\n", sessionCount)
+	fmt.Fprintf(w, "expired\t%d\n", expired)
+	if c.userIndex != "" {
+		fmt.Fprintf(w, "users\t%d\n", len(users))
+		if top := topUser(users); top != "" {
+			fmt.Fprintf(w, "most sessions\t%s (%d)\n", top, users[top])
+		}
+	}
+
+	return w.Flush()
+}
+
+func runBench(ctx context.Context, c cli, store *dynastore.Store, args []string) error {
+	report, err := bench.Run(ctx, store, c.bench)
+	if err != nil {
+		return err
+	}
+
+	return report.Print(c.stdout)
+}
+
+// topUser returns the user with the most sessions, the first by name on ties
+func topUser(users map[string]int) string {
+	names := make([]string, 0, len(users))
+	for name := range users {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var top string
+	for _, name := range names {
+		if top == "" || users[name] > users[top] {
+			top = name
+		}
+	}
+
+	return top
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+
+	return t.UTC().Format(time.RFC3339)
+}
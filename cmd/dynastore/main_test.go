@@ -0,0 +1,58 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestUsage(t *testing.T) {
+	testCases := map[string]struct {
+		args []string
+		err  error
+	}{
+		"help":            {args: []string{"-h"}},
+		"no command":      {args: nil, err: errUsage},
+		"unknown command": {args: []string{"bogus"}, err: errUsage},
+		"missing id":      {args: []string{"inspect"}, err: errUsage},
+		"extra argument":  {args: []string{"purge-expired", "now"}, err: errUsage},
+		"bench argument":  {args: []string{"bench", "now"}, err: errUsage},
+		"unknown flag":    {args: []string{"-bogus", "stats"}, err: errUsage},
+	}
+
+	for label, tc := range testCases {
+		t.Run(label, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			if err := run(context.TODO(), tc.args, &stdout, &stderr); !errors.Is(err, tc.err) {
+				t.Errorf("expected %v; got %v", tc.err, err)
+			}
+			if !strings.Contains(stderr.String(), "usage: dynastore") {
+				t.Errorf("expected the usage to be printed; got %q", stderr.String())
+			}
+		})
+	}
+}
+
+func TestTopUser(t *testing.T) {
+	if got := topUser(map[string]int{"bob": 2, "alice": 2, "carol": 1}); got != "alice" {
+		t.Errorf("expected alice; got %v", got)
+	}
+	if got := topUser(nil); got != "" {
+		t.Errorf("expected no user; got %v", got)
+	}
+}
@@ -0,0 +1,220 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// Config is the effective configuration of a store, as reported by Config. It
+// never holds key material, so it's safe to log or attach to a support ticket.
+type Config struct {
+	Table      string `json:"table"`
+	PrimaryKey string `json:"primaryKey"`
+	UserIndex  string `json:"userIndex,omitempty"`
+	UserKey    string `json:"userKey,omitempty"`
+	Namespace  string `json:"namespace,omitempty"`
+	ReadOnly   bool   `json:"readOnly"`
+	InMemory   bool   `json:"inMemory"`
+
+	// Serializer is how session values are written to the table, either
+	// "attributes" for native dynamodb attributes or "json" when they're sealed
+	// into a single encrypted attribute
+	Serializer string `json:"serializer"`
+
+	TTL        TTLConfig        `json:"ttl"`
+	Encryption EncryptionConfig `json:"encryption"`
+	Cache      CacheConfig      `json:"cache"`
+	Cookie     CookieConfig     `json:"cookie"`
+}
+
+// TTLConfig describes how the ttl of an item is computed
+type TTLConfig struct {
+	// Mode is "disabled", "max-age" when the ttl follows the cookie MaxAge, or
+	// "server" when it's set by WithServerTTL
+	Mode           string        `json:"mode"`
+	Field          string        `json:"field,omitempty"`
+	ServerTTL      time.Duration `json:"serverTTL,omitempty"`
+	Grace          time.Duration `json:"grace,omitempty"`
+	Jitter         time.Duration `json:"jitter,omitempty"`
+	Min            time.Duration `json:"min,omitempty"`
+	Max            time.Duration `json:"max,omitempty"`
+	WriteExpiresAt bool          `json:"writeExpiresAt"`
+	AutoEnable     bool          `json:"autoEnable"`
+}
+
+// EncryptionConfig describes how session ids are signed and values encrypted
+type EncryptionConfig struct {
+	Enabled bool `json:"enabled"`
+
+	// Mode is "aes-gcm" for keys held by the store or its KeyProvider, "kms" for
+	// envelope encryption, and empty when encryption is disabled
+	Mode        string   `json:"mode,omitempty"`
+	KMSKeyID    string   `json:"kmsKeyID,omitempty"`
+	Fields      []string `json:"fields,omitempty"`
+	SignedIDs   bool     `json:"signedIDs"`
+	HashedIDs   bool     `json:"hashedIDs"`
+	KeyProvider bool     `json:"keyProvider"`
+}
+
+// CacheConfig describes the item cache set up by WithCache
+type CacheConfig struct {
+	Enabled    bool          `json:"enabled"`
+	Size       int           `json:"size,omitempty"`
+	TTL        time.Duration `json:"ttl,omitempty"`
+	ServeStale time.Duration `json:"serveStale,omitempty"`
+}
+
+// CookieConfig holds the sessions.Options of the cookies set by the store
+type CookieConfig struct {
+	Path     string `json:"path,omitempty"`
+	Domain   string `json:"domain,omitempty"`
+	MaxAge   int    `json:"maxAge"`
+	Secure   bool   `json:"secure"`
+	HttpOnly bool   `json:"httpOnly"`
+	SameSite string `json:"sameSite,omitempty"`
+}
+
+// Config returns the effective configuration of the store, after defaults and
+// options have been applied. Keys are reported only as whether they're set.
+func (store *Store) Config() Config {
+	config := Config{
+		Table:      store.tableName,
+		PrimaryKey: store.primaryKey,
+		UserIndex:  store.userIndex,
+		UserKey:    store.userKey,
+		Namespace:  store.namespace,
+		ReadOnly:   store.readOnly,
+		InMemory:   store.inMemory,
+		Serializer: "attributes",
+		TTL: TTLConfig{
+			Mode:           "disabled",
+			ServerTTL:      store.serverTTL,
+			Grace:          store.ttlGrace,
+			Jitter:         store.ttlJitter,
+			Min:            store.minTTL,
+			Max:            store.maxTTL,
+			WriteExpiresAt: store.writeExpiresAt,
+			AutoEnable:     store.autoEnableTTL,
+		},
+		Encryption: EncryptionConfig{
+			Enabled:   store.sealer != nil,
+			SignedIDs: store.signIDs,
+			HashedIDs: store.hashIDs,
+		},
+		Cookie: CookieConfig{
+			Path:     store.options.Path,
+			Domain:   store.options.Domain,
+			MaxAge:   store.options.MaxAge,
+			Secure:   store.options.Secure,
+			HttpOnly: store.options.HttpOnly,
+			SameSite: sameSiteName(store.options.SameSite),
+		},
+	}
+
+	if store.enableTTL {
+		config.TTL.Mode = "max-age"
+		config.TTL.Field = DefaultTTLField
+		if store.serverTTL > 0 {
+			config.TTL.Mode = "server"
+		}
+	}
+
+	if _, ok := store.keys.(staticKeys); !ok && store.keys != nil {
+		config.Encryption.KeyProvider = true
+	}
+
+	if store.sealer != nil {
+		config.Encryption.Mode = "aes-gcm"
+		config.Encryption.Fields = store.encryptedFields
+		if len(store.encryptedFields) == 0 {
+			config.Serializer = "json"
+		}
+		if store.kms != nil {
+			config.Encryption.Mode = "kms"
+			config.Encryption.KMSKeyID = store.kms.keyID
+		}
+	}
+
+	if store.cache != nil {
+		config.Cache = CacheConfig{
+			Enabled: true,
+			Size:    store.cache.size,
+			TTL:     store.cache.ttl,
+		}
+		if store.serveStale != nil {
+			config.Cache.ServeStale = *store.serveStale
+		}
+	}
+
+	return config
+}
+
+// MarshalJSON writes the durations of the ttl as strings such as "1h0m0s"
+func (c TTLConfig) MarshalJSON() ([]byte, error) {
+	type plain TTLConfig
+	return json.Marshal(struct {
+		plain
+		ServerTTL string `json:"serverTTL,omitempty"`
+		Grace     string `json:"grace,omitempty"`
+		Jitter    string `json:"jitter,omitempty"`
+		Min       string `json:"min,omitempty"`
+		Max       string `json:"max,omitempty"`
+	}{
+		plain:     plain(c),
+		ServerTTL: durationString(c.ServerTTL),
+		Grace:     durationString(c.Grace),
+		Jitter:    durationString(c.Jitter),
+		Min:       durationString(c.Min),
+		Max:       durationString(c.Max),
+	})
+}
+
+// MarshalJSON writes the durations of the cache as strings such as "1m0s"
+func (c CacheConfig) MarshalJSON() ([]byte, error) {
+	type plain CacheConfig
+	return json.Marshal(struct {
+		plain
+		TTL        string `json:"ttl,omitempty"`
+		ServeStale string `json:"serveStale,omitempty"`
+	}{
+		plain:      plain(c),
+		TTL:        durationString(c.TTL),
+		ServeStale: durationString(c.ServeStale),
+	})
+}
+
+func durationString(d time.Duration) string {
+	if d == 0 {
+		return ""
+	}
+	return d.String()
+}
+
+func sameSiteName(mode http.SameSite) string {
+	switch mode {
+	case http.SameSiteLaxMode:
+		return "lax"
+	case http.SameSiteStrictMode:
+		return "strict"
+	case http.SameSiteNoneMode:
+		return "none"
+	case http.SameSiteDefaultMode:
+		return "default"
+	default:
+		return ""
+	}
+}
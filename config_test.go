@@ -0,0 +1,99 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestConfig(t *testing.T) {
+	signingKey := bytes.Repeat([]byte("s"), 32)
+	encryptionKey := bytes.Repeat([]byte("e"), 32)
+
+	testCases := map[string]struct {
+		Opts     []Option
+		Expected func(Config) bool
+	}{
+		"defaults": {
+			Expected: func(c Config) bool {
+				return c.Table == DefaultTableName && c.TTL.Mode == "disabled" && c.Serializer == "attributes" && !c.Encryption.Enabled && !c.Cache.Enabled
+			},
+		},
+		"server ttl": {
+			Opts: []Option{TTLEnabled(), WithServerTTL(time.Hour), WithTTLGrace(time.Minute)},
+			Expected: func(c Config) bool {
+				return c.TTL.Mode == "server" && c.TTL.ServerTTL == time.Hour && c.TTL.Grace == time.Minute
+			},
+		},
+		"encryption": {
+			Opts: []Option{WithSigningKey(signingKey), WithEncryption(encryptionKey)},
+			Expected: func(c Config) bool {
+				return c.Encryption.Enabled && c.Encryption.Mode == "aes-gcm" && c.Encryption.SignedIDs && c.Serializer == "json"
+			},
+		},
+		"cache": {
+			Opts: []Option{WithCache(10, time.Minute), WithServeStale(time.Hour)},
+			Expected: func(c Config) bool {
+				return c.Cache.Enabled && c.Cache.Size == 10 && c.Cache.TTL == time.Minute && c.Cache.ServeStale == time.Hour
+			},
+		},
+	}
+
+	for label, tc := range testCases {
+		t.Run(label, func(t *testing.T) {
+			store, err := New(newFakeDynamoDB(), tc.Opts...)
+			if err != nil {
+				t.Fatalf("expected no error; got %v", err)
+			}
+
+			config := store.Config()
+			if !tc.Expected(config) {
+				t.Errorf("unexpected config %+v", config)
+			}
+
+			data, err := json.Marshal(config)
+			if err != nil {
+				t.Fatalf("expected no error; got %v", err)
+			}
+			if bytes.Contains(data, signingKey) || bytes.Contains(data, encryptionKey) {
+				t.Errorf("expected keys to be redacted; got %s", data)
+			}
+		})
+	}
+}
+
+func TestConfigJSON(t *testing.T) {
+	store, _ := New(newFakeDynamoDB(), TTLEnabled(), WithServerTTL(time.Hour))
+
+	data, err := json.Marshal(store.Config())
+	if err != nil {
+		t.Fatalf("expected no error; got %v", err)
+	}
+
+	var got struct {
+		TTL struct {
+			Mode      string `json:"mode"`
+			ServerTTL string `json:"serverTTL"`
+		} `json:"ttl"`
+	}
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("expected no error; got %v", err)
+	}
+	if got.TTL.Mode != "server" || got.TTL.ServerTTL != "1h0m0s" {
+		t.Errorf("expected server ttl of 1h0m0s; got %s", data)
+	}
+}
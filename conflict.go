@@ -0,0 +1,173 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/gorilla/sessions"
+)
+
+// UpdatedAtField contains the name of the attribute holding the time, in epoch
+// milliseconds, a session was last written with WithLastWriterWins
+const UpdatedAtField = "updated_at"
+
+// ErrSessionConflict is returned by Persist with WithLastWriterWins when the
+// stored session was written more recently than the write being attempted
+var ErrSessionConflict = fmt.Errorf("session was updated more recently elsewhere")
+
+// ConflictHandler is called by Persist when the stored session has been written
+// since session was loaded, e.g. by another region, with the decoded attributes
+// of the stored item. It may merge them into session before session overwrites
+// them; returning an error aborts the write.
+type ConflictHandler func(ctx context.Context, session *sessions.Session, current map[string]any) error
+
+// updatedAtKey is the session.Values key under which the updated_at of the
+// session, as loaded or last written, is tracked
+type updatedAtKey struct{}
+
+// lastWriterWins holds the configuration of WithLastWriterWins
+type lastWriterWins struct {
+	onConflict ConflictHandler
+}
+
+// updatedAt returns the updated_at to write for session: the current time, or
+// just after the updated_at it was loaded with should the clock lag behind it
+func (store *Store) updatedAt(session *sessions.Session) int64 {
+	loaded, _ := session.Values[updatedAtKey{}].(int64)
+	return max(store.now().UnixMilli(), loaded+1)
+}
+
+// loadUpdatedAt removes UpdatedAtField from item, returning its value
+func loadUpdatedAt(item map[string]any) int64 {
+	v, _ := item[UpdatedAtField].(float64)
+	delete(item, UpdatedAtField)
+	return int64(v)
+}
+
+// putLastWriterWins writes items, the marshaled session, unless the stored item
+// changed since the session was loaded, in which case the conflict handler is
+// consulted and the session written only if it is newer than the stored one. The
+// item actually written is returned.
+func (store *Store) putLastWriterWins(ctx context.Context, session *sessions.Session, items map[string]types.AttributeValue) (map[string]types.AttributeValue, error) {
+	loaded, _ := session.Values[updatedAtKey{}].(int64)
+
+	cond := "attribute_not_exists(#pk) OR attribute_not_exists(#updated)"
+	values := map[string]types.AttributeValue{}
+	if loaded > 0 {
+		cond = "attribute_not_exists(#pk) OR #updated = :loaded"
+		values[":loaded"] = &types.AttributeValueMemberN{Value: strconv.FormatInt(loaded, 10)}
+	}
+
+	err := store.putConditional(ctx, items, cond, values)
+
+	var ccf *types.ConditionalCheckFailedException
+	if !errors.As(err, &ccf) {
+		if err != nil {
+			return nil, err
+		}
+		store.markWritten(session, items)
+		return items, nil
+	}
+
+	if err := store.checkNotRevoked(ctx, session.ID); err != nil {
+		return nil, err
+	}
+
+	if handler := store.lastWriterWins.onConflict; handler != nil {
+		current, err := store.currentValues(ctx, session.ID)
+		if err != nil {
+			return nil, err
+		}
+		if err := handler(ctx, session, current); err != nil {
+			return nil, err
+		}
+
+		if items, err = store.marshalSession(ctx, session); err != nil {
+			return nil, err
+		}
+	}
+
+	mine := items[UpdatedAtField]
+	err = store.putConditional(ctx, items, "attribute_not_exists(#pk) OR #updated < :mine", map[string]types.AttributeValue{":mine": mine})
+	if errors.As(err, &ccf) {
+		if err := store.checkNotRevoked(ctx, session.ID); err != nil {
+			return nil, err
+		}
+		return nil, ErrSessionConflict
+	}
+	if err != nil {
+		return nil, err
+	}
+	store.markWritten(session, items)
+
+	return items, nil
+}
+
+func (store *Store) putConditional(ctx context.Context, items map[string]types.AttributeValue, cond string, values map[string]types.AttributeValue) error {
+	input := &dynamodb.PutItemInput{
+		TableName:                aws.String(store.tableName),
+		Item:                     items,
+		ConditionExpression:      aws.String("(" + cond + ") AND " + notRevokedCondition),
+		ExpressionAttributeNames: map[string]string{"#pk": store.primaryKey, "#updated": UpdatedAtField, "#revoked": RevokedField},
+	}
+	if len(values) > 0 {
+		input.ExpressionAttributeValues = values
+	}
+
+	_, err := store.ddb.PutItem(ctx, input)
+	return err
+}
+
+// markWritten records the updated_at written for session, so its next write is
+// checked against it
+func (store *Store) markWritten(session *sessions.Session, items map[string]types.AttributeValue) {
+	if n, ok := items[UpdatedAtField].(*types.AttributeValueMemberN); ok {
+		updated, _ := strconv.ParseInt(n.Value, 10, 64)
+		session.Values[updatedAtKey{}] = updated
+	}
+}
+
+// currentValues returns the decoded attributes of the stored session identified
+// by id, or nil if it doesn't exist
+func (store *Store) currentValues(ctx context.Context, id string) (map[string]any, error) {
+	result, err := store.ddb.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(store.tableName),
+		Key:            store.key(id),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read conflicting session: %w", err)
+	}
+	if result.Item == nil {
+		return nil, nil
+	}
+
+	current := map[string]any{}
+	if err := attributevalue.UnmarshalMap(result.Item, &current); err != nil {
+		return nil, fmt.Errorf("failed to decode conflicting session: %w", err)
+	}
+	if err := store.openValues(ctx, store.itemKey(id), current); err != nil {
+		return nil, err
+	}
+
+	return current, nil
+}
@@ -0,0 +1,83 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+)
+
+func TestLastWriterWins(t *testing.T) {
+	ctx := context.TODO()
+	now := time.Now()
+
+	var conflicts []map[string]any
+	ddb := newFakeDynamoDB()
+	store, _ := New(ddb, MaxAge(3600), WithLastWriterWins(func(ctx context.Context, session *sessions.Session, current map[string]any) error {
+		conflicts = append(conflicts, current)
+		session.Values["cart"] = current["cart"]
+		return nil
+	}))
+	store.now = func() time.Time { return now }
+
+	session := sessions.NewSession(store, "session")
+	session.ID = "abc"
+	session.Options = store.newOptions()
+	if err := store.Persist(ctx, "session", session); err != nil {
+		t.Fatal(err)
+	}
+
+	load := func() *sessions.Session {
+		s := sessions.NewSession(store, "session")
+		if err := store.Load(ctx, "abc", s); err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	east, west := load(), load()
+
+	now = now.Add(time.Second)
+	east.Values["cart"] = "book"
+	if err := store.Persist(ctx, "session", east); err != nil {
+		t.Fatal(err)
+	}
+
+	now = now.Add(time.Second)
+	west.Values["theme"] = "dark"
+	if err := store.Persist(ctx, "session", west); err != nil {
+		t.Fatal(err)
+	}
+	if len(conflicts) != 1 || conflicts[0]["cart"] != "book" {
+		t.Fatalf("expected the conflict handler to see the stored values; got %v", conflicts)
+	}
+
+	merged := load()
+	if merged.Values["cart"] != "book" || merged.Values["theme"] != "dark" {
+		t.Errorf("expected the resolved values to be written; got %v", merged.Values)
+	}
+
+	// a writer whose clock lags behind loses
+	stale := sessions.NewSession(store, "session")
+	stale.ID = "abc"
+	stale.Options = store.newOptions()
+	stale.Values[updatedAtKey{}] = int64(1)
+	now = now.Add(-time.Hour)
+	if err := store.Persist(ctx, "session", stale); err != ErrSessionConflict {
+		t.Errorf("expected ErrSessionConflict; got %v", err)
+	}
+}
@@ -0,0 +1,209 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+)
+
+const (
+	// maxCookieValueLength bounds the cookie values decodeCookie looks at, well
+	// above anything encodeCookie produces
+	maxCookieValueLength = 4096
+
+	// hostPrefix marks cookies that must be Secure, have Path=/ and no Domain
+	hostPrefix = "__Host-"
+
+	// securePrefix marks cookies that must be Secure
+	securePrefix = "__Secure-"
+)
+
+var (
+	errInvalidSignature = fmt.Errorf("session id signature is missing or invalid")
+
+	// ErrInvalidSessionID is returned when a cookie carries a value that can't be
+	// a session id issued by the store, e.g. because it is oversized or uses
+	// characters outside the id alphabet
+	ErrInvalidSessionID = fmt.Errorf("malformed session id")
+
+	errSigningKeyRequired = fmt.Errorf("WithSecureDefaults requires a signing key, see WithSigningKey or WithKeyProvider")
+
+	// ErrInvalidCookiePrefix is returned when a cookie name uses the __Host- or
+	// __Secure- prefix but the options don't make it Secure, or, for __Host-,
+	// give it a Domain or a Path other than /
+	ErrInvalidCookiePrefix = fmt.Errorf("cookie options conflict with the __Host- prefix")
+
+	// ErrInsecureSameSiteNone is returned when a cookie is set with SameSite=None
+	// but without Secure, which browsers reject
+	ErrInsecureSameSiteNone = fmt.Errorf("cookie with SameSite=None must be Secure")
+
+	// ErrInsecurePartitioned is returned when a cookie is set Partitioned but
+	// without Secure, which browsers reject
+	ErrInsecurePartitioned = fmt.Errorf("partitioned cookie must be Secure")
+)
+
+// validateCookie checks that the options of a cookie named name are accepted by
+// browsers. Options contradicting the prefix of the name are reported rather
+// than overridden; only an unset Path is defaulted to / by applyCookiePrefix.
+func validateCookie(name string, opts *sessions.Options) error {
+	if opts == nil {
+		return nil
+	}
+
+	prefixed := strings.HasPrefix(name, hostPrefix) || strings.HasPrefix(name, securePrefix)
+	if prefixed && !opts.Secure {
+		return fmt.Errorf("%w: %s is not Secure", ErrInvalidCookiePrefix, name)
+	}
+
+	if opts.SameSite == http.SameSiteNoneMode && !opts.Secure {
+		return fmt.Errorf("%w: %s", ErrInsecureSameSiteNone, name)
+	}
+
+	if opts.Partitioned && !opts.Secure {
+		return fmt.Errorf("%w: %s", ErrInsecurePartitioned, name)
+	}
+
+	if !strings.HasPrefix(name, hostPrefix) {
+		return nil
+	}
+
+	if opts.Domain != "" {
+		return fmt.Errorf("%w: %s has Domain %q", ErrInvalidCookiePrefix, name, opts.Domain)
+	}
+
+	if opts.Path != "" && opts.Path != "/" {
+		return fmt.Errorf("%w: %s has Path %q", ErrInvalidCookiePrefix, name, opts.Path)
+	}
+
+	return nil
+}
+
+// applyCookiePrefix defaults an unset Path to / for cookies named with the
+// __Host- prefix, which browsers reject without it. Options contradicting the
+// prefix are reported by validateCookie instead.
+func applyCookiePrefix(cookie *http.Cookie) {
+	if strings.HasPrefix(cookie.Name, hostPrefix) && cookie.Path == "" {
+		cookie.Path = "/"
+	}
+}
+
+// encodeCookie converts a session id into the value placed in the cookie
+func (store *Store) encodeCookie(ctx context.Context, name, id string) (string, error) {
+	if store.signIDs {
+		keys, err := store.keys.Keys(ctx)
+		if err != nil {
+			return "", fmt.Errorf("failed to retrieve signing key: %w", err)
+		}
+		id = id + "." + signID(keys.SigningKey, id)
+	}
+
+	if len(store.codecs) == 0 {
+		return id, nil
+	}
+
+	value, err := securecookie.EncodeMulti(name, id, store.codecs...)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode session cookie: %w", err)
+	}
+
+	return value, nil
+}
+
+// decodeCookie extracts the session id from a cookie value
+func (store *Store) decodeCookie(ctx context.Context, name, value string) (string, error) {
+	id := value
+	if len(store.codecs) > 0 {
+		if err := securecookie.DecodeMulti(name, value, &id, store.codecs...); err != nil {
+			return "", fmt.Errorf("failed to decode session cookie: %w", err)
+		}
+	}
+
+	if store.signIDs {
+		keys, err := store.keys.Keys(ctx)
+		if err != nil {
+			return "", fmt.Errorf("failed to retrieve signing key: %w", err)
+		}
+		return verifyID(id, keys.SigningKey, keys.PreviousSigningKeys...)
+	}
+
+	return id, nil
+}
+
+// cookieID extracts the session id from a cookie value presented by a client,
+// rejecting values that can't hold an id issued by the store before decoding
+// them and ids that don't look like one afterwards
+func (store *Store) cookieID(ctx context.Context, name, value string) (string, error) {
+	if len(value) > maxCookieValueLength {
+		return "", fmt.Errorf("%w: cookie value of %d bytes", ErrInvalidSessionID, len(value))
+	}
+
+	id, err := store.decodeCookie(ctx, name, value)
+	if err != nil {
+		return "", err
+	}
+
+	if !store.validID(id) {
+		return "", fmt.Errorf("%w: %.64q", ErrInvalidSessionID, id)
+	}
+
+	return id, nil
+}
+
+// reportInvalidID passes cookies rejected as malformed or forged to the handler
+// set with WithInvalidIDHandler
+func (store *Store) reportInvalidID(req *http.Request, value string, err error) {
+	if store.invalidIDHandler == nil {
+		return
+	}
+
+	if errors.Is(err, ErrInvalidSessionID) || errors.Is(err, errInvalidSignature) {
+		store.invalidIDHandler(req, value, err)
+	}
+}
+
+// signID returns the base64 encoded HMAC-SHA256 of id
+func signID(key []byte, id string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(id))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verifyID checks a signed value produced by encodeCookie against the primary key
+// and then any previous keys, returning the id
+func verifyID(value string, key []byte, previous ...[]byte) (string, error) {
+	// ids from WithIDGenerator may contain '.', signatures never do
+	i := strings.LastIndex(value, ".")
+	if i < 0 {
+		return "", errInvalidSignature
+	}
+	id, signature := value[:i], value[i+1:]
+
+	for _, k := range append([][]byte{key}, previous...) {
+		if hmac.Equal([]byte(signature), []byte(signID(k, id))) {
+			return id, nil
+		}
+	}
+
+	return "", errInvalidSignature
+}
@@ -0,0 +1,270 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/securecookie"
+)
+
+func TestCodecs(t *testing.T) {
+	oldCodec := securecookie.New(securecookie.GenerateRandomKey(64), securecookie.GenerateRandomKey(32))
+	newCodec := securecookie.New(securecookie.GenerateRandomKey(64), securecookie.GenerateRandomKey(32))
+
+	ddb := newFakeDynamoDB()
+	store, _ := New(ddb, WithCodecs(oldCodec))
+
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost", nil)
+	session, _ := store.New(req, "session")
+
+	w := httptest.NewRecorder()
+	if err := store.Save(req, w, session); err != nil {
+		t.Fatal(err)
+	}
+
+	cookie := w.Result().Cookies()[0]
+	if cookie.Value == session.ID {
+		t.Fatal("expected cookie value to be encoded")
+	}
+
+	rotated, _ := New(ddb, WithCodecs(newCodec, oldCodec))
+	req.AddCookie(cookie)
+	found, _ := rotated.New(req, "session")
+	if found.IsNew || found.ID != session.ID {
+		t.Errorf("expected session %v to be decoded with the previous codec; got %v", session.ID, found.ID)
+	}
+
+	forged, _ := http.NewRequest(http.MethodGet, "http://localhost", nil)
+	forged.AddCookie(&http.Cookie{Name: "session", Value: session.ID})
+	found, _ = rotated.New(forged, "session")
+	if !found.IsNew {
+		t.Error("expected an unsigned cookie to be rejected")
+	}
+}
+
+func TestSigningKey(t *testing.T) {
+	store, _ := New(newFakeDynamoDB(), WithSigningKey([]byte("secret")))
+
+	value, err := store.encodeCookie(context.TODO(), "session", "abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if id, err := store.decodeCookie(context.TODO(), "session", value); err != nil || id != "abc" {
+		t.Errorf("expected abc; got %v, %v", id, err)
+	}
+
+	for _, forged := range []string{"abc", "abc.", "abd" + value[3:], value + "x"} {
+		if _, err := store.decodeCookie(context.TODO(), "session", forged); err != errInvalidSignature {
+			t.Errorf("expected %q to be rejected; got %v", forged, err)
+		}
+	}
+}
+
+func TestSigningKeyDottedID(t *testing.T) {
+	store, _ := New(newFakeDynamoDB(), WithSigningKey([]byte("secret")), WithIDGenerator(func() string {
+		return "order.42.abc"
+	}))
+
+	value, err := store.encodeCookie(context.TODO(), "session", store.newID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, err := store.decodeCookie(context.TODO(), "session", value); err != nil || id != "order.42.abc" {
+		t.Errorf("expected an id containing dots to verify; got %v, %v", id, err)
+	}
+}
+
+func TestCookiePrefixes(t *testing.T) {
+	ddb := newFakeDynamoDB()
+
+	testCases := map[string]struct {
+		name   string
+		opts   []Option
+		err    error
+		path   string
+		domain string
+	}{
+		"host":            {name: "__Host-session", opts: []Option{Secure()}, path: "/"},
+		"host insecure":   {name: "__Host-session", err: ErrInvalidCookiePrefix},
+		"host domain":     {name: "__Host-session", opts: []Option{Secure(), Domain("example.com")}, err: ErrInvalidCookiePrefix},
+		"host path":       {name: "__Host-session", opts: []Option{Secure(), Path("/app")}, err: ErrInvalidCookiePrefix},
+		"secure":          {name: "__Secure-session", opts: []Option{Secure(), Domain("example.com"), Path("/app")}, path: "/app", domain: "example.com"},
+		"secure insecure": {name: "__Secure-session", err: ErrInvalidCookiePrefix},
+		"unprefixed":      {name: "session"},
+	}
+
+	for label, tc := range testCases {
+		t.Run(label, func(t *testing.T) {
+			store, _ := New(ddb, tc.opts...)
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			session, _ := store.New(req, tc.name)
+
+			w := httptest.NewRecorder()
+			err := store.Save(req, w, session)
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("expected %v; got %v", tc.err, err)
+			}
+			if err != nil {
+				return
+			}
+
+			cookie := w.Result().Cookies()[0]
+			if secure := tc.name != "session"; cookie.Secure != secure {
+				t.Errorf("expected Secure %v; got %v", secure, cookie.Secure)
+			}
+			if cookie.Path != tc.path || cookie.Domain != tc.domain {
+				t.Errorf("expected Path %q and Domain %q; got %q and %q", tc.path, tc.domain, cookie.Path, cookie.Domain)
+			}
+		})
+	}
+
+	if _, err := New(ddb, Secure(), Domain("example.com"), WithRememberMe("__Host-remember", time.Hour, "user_id")); !errors.Is(err, ErrInvalidCookiePrefix) {
+		t.Errorf("expected %v; got %v", ErrInvalidCookiePrefix, err)
+	}
+	if _, err := New(ddb, WithRememberMe("__Secure-remember", time.Hour, "user_id")); !errors.Is(err, ErrInvalidCookiePrefix) {
+		t.Errorf("expected %v for an insecure prefixed cookie; got %v", ErrInvalidCookiePrefix, err)
+	}
+}
+
+func TestSameSite(t *testing.T) {
+	ddb := newFakeDynamoDB()
+
+	store, _ := New(ddb, SameSite(http.SameSiteStrictMode))
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	session, _ := store.New(req, "session")
+	w := httptest.NewRecorder()
+	if err := store.Save(req, w, session); err != nil {
+		t.Fatal(err)
+	}
+	if got := w.Result().Cookies()[0].SameSite; got != http.SameSiteStrictMode {
+		t.Errorf("expected %v; got %v", http.SameSiteStrictMode, got)
+	}
+
+	// Per-session options take precedence over the store default
+	session, _ = store.New(req, "session")
+	session.Options.SameSite = http.SameSiteNoneMode
+	if err := store.Save(req, httptest.NewRecorder(), session); !errors.Is(err, ErrInsecureSameSiteNone) {
+		t.Errorf("expected %v; got %v", ErrInsecureSameSiteNone, err)
+	}
+
+	session.Options.Secure = true
+	w = httptest.NewRecorder()
+	if err := store.Save(req, w, session); err != nil {
+		t.Fatal(err)
+	}
+	if got := w.Result().Cookies()[0].SameSite; got != http.SameSiteNoneMode {
+		t.Errorf("expected %v; got %v", http.SameSiteNoneMode, got)
+	}
+}
+
+func TestPartitioned(t *testing.T) {
+	ddb := newFakeDynamoDB()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	store, _ := New(ddb, Partitioned())
+	session, _ := store.New(req, "session")
+	if err := store.Save(req, httptest.NewRecorder(), session); !errors.Is(err, ErrInsecurePartitioned) {
+		t.Errorf("expected %v; got %v", ErrInsecurePartitioned, err)
+	}
+
+	store, _ = New(ddb, Partitioned(), Secure(), SameSite(http.SameSiteNoneMode))
+	session, _ = store.New(req, "session")
+	w := httptest.NewRecorder()
+	if err := store.Save(req, w, session); err != nil {
+		t.Fatal(err)
+	}
+	if cookie := w.Result().Cookies()[0]; !cookie.Partitioned {
+		t.Error("expected the cookie to be partitioned")
+	}
+	if header := w.Header().Get("Set-Cookie"); !strings.Contains(header, "Partitioned") {
+		t.Errorf("expected the Partitioned attribute to be emitted; got %v", header)
+	}
+}
+
+func TestSecureDefaults(t *testing.T) {
+	ddb := newFakeDynamoDB()
+
+	if _, err := New(ddb, WithSecureDefaults()); err == nil {
+		t.Fatal("expected a signing key to be required")
+	}
+
+	store, err := New(ddb, WithSecureDefaults(), WithSigningKey([]byte("secret")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	session, _ := store.New(req, "session")
+	w := httptest.NewRecorder()
+	if err := store.Save(req, w, session); err != nil {
+		t.Fatal(err)
+	}
+
+	cookie := w.Result().Cookies()[0]
+	if !cookie.HttpOnly || !cookie.Secure || cookie.SameSite != http.SameSiteLaxMode || cookie.MaxAge != SecureDefaultMaxAge {
+		t.Errorf("expected a hardened cookie; got %v", cookie)
+	}
+	if !strings.Contains(cookie.Value, ".") {
+		t.Errorf("expected a signed id; got %v", cookie.Value)
+	}
+	if _, ok := ddb.items[session.ID][DefaultTTLField]; !ok {
+		t.Error("expected a ttl to be written")
+	}
+}
+
+func TestInvalidSessionID(t *testing.T) {
+	var rejected []error
+	ddb := newFakeDynamoDB()
+	store, _ := New(ddb, WithSigningKey([]byte("secret")), WithInvalidIDHandler(func(req *http.Request, value string, err error) {
+		rejected = append(rejected, err)
+	}))
+
+	testCases := map[string]struct {
+		value string
+		err   error
+	}{
+		"oversized": {value: strings.Repeat("A", maxCookieValueLength+1), err: ErrInvalidSessionID},
+		"alphabet":  {value: "abc." + signID([]byte("secret"), "abc"), err: ErrInvalidSessionID},
+		"forged":    {value: store.newID() + ".forged", err: errInvalidSignature},
+	}
+
+	for label, tc := range testCases {
+		t.Run(label, func(t *testing.T) {
+			rejected = nil
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.AddCookie(&http.Cookie{Name: "session", Value: tc.value})
+			session, err := store.New(req, "session")
+			if !errors.Is(err, ErrSessionNotFound) || !session.IsNew {
+				t.Fatalf("expected a new session and ErrSessionNotFound; got %v", err)
+			}
+			if len(rejected) != 1 || !errors.Is(rejected[0], tc.err) {
+				t.Errorf("expected %v to be reported; got %v", tc.err, rejected)
+			}
+		})
+	}
+
+	if !store.validID(store.newID()) {
+		t.Error("expected generated ids to be valid")
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+)
+
+const (
+	// CSRFTokenKey is the session value holding the CSRF token of the session
+	CSRFTokenKey = "csrf_token"
+
+	// CSRFHeader is the request header CSRFMiddleware reads the token from
+	CSRFHeader = "X-CSRF-Token"
+
+	// CSRFFormField is the form field CSRFMiddleware reads the token from when
+	// the header is absent
+	CSRFFormField = "csrf_token"
+)
+
+// CSRFToken returns the CSRF token of the session, generating one if the session
+// doesn't have one yet. The token is stored in the session, so it lives and dies
+// with it; the session must be saved for a newly generated token to stick.
+func CSRFToken(session *sessions.Session) string {
+	if token, ok := session.Values[CSRFTokenKey].(string); ok && token != "" {
+		return token
+	}
+
+	token := base64.RawURLEncoding.EncodeToString(securecookie.GenerateRandomKey(32))
+	session.Values[CSRFTokenKey] = token
+
+	return token
+}
+
+// FormCSRFToken returns a token only valid for the named form, derived from the
+// CSRF token of the session, so tokens leaking from one form can't be replayed
+// against another. Nothing beyond the session token is stored. Requests posting
+// the form are checked with FormCSRFMiddleware.
+func FormCSRFToken(session *sessions.Session, form string) string {
+	mac := hmac.New(sha256.New, []byte(CSRFToken(session)))
+	mac.Write([]byte(form))
+
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// ValidCSRFToken reports, in constant time, whether token is the CSRF token of
+// the session or, when form is not empty, the token issued for that form
+func ValidCSRFToken(session *sessions.Session, token, form string) bool {
+	expected, ok := session.Values[CSRFTokenKey].(string)
+	if !ok || expected == "" || token == "" {
+		return false
+	}
+
+	if form != "" {
+		expected = FormCSRFToken(session, form)
+	}
+
+	return subtle.ConstantTimeCompare([]byte(token), []byte(expected)) == 1
+}
+
+// CSRFMiddleware rejects requests with unsafe methods whose CSRF token, read
+// from CSRFHeader or the CSRFFormField form field, doesn't match the session
+// named name. Requests with safe methods pass through untouched; handlers
+// rendering forms call CSRFToken and save the session.
+func (store *Store) CSRFMiddleware(name string, next http.Handler) http.Handler {
+	return store.csrfMiddleware(name, "", next)
+}
+
+// FormCSRFMiddleware is like CSRFMiddleware but only accepts the token issued
+// for form by FormCSRFToken, for the route handling that form. The form is
+// fixed here rather than read from the request, so a token issued for another
+// form is refused.
+func (store *Store) FormCSRFMiddleware(name, form string, next http.Handler) http.Handler {
+	return store.csrfMiddleware(name, form, next)
+}
+
+func (store *Store) csrfMiddleware(name, form string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		session, err := store.Get(req, name)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+
+		token := req.Header.Get(CSRFHeader)
+		if token == "" {
+			token = req.PostFormValue(CSRFFormField)
+		}
+
+		if !ValidCSRFToken(session, token, form) {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, req)
+	})
+}
@@ -0,0 +1,81 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestCSRFMiddleware(t *testing.T) {
+	store, _ := New(newFakeDynamoDB())
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	session, _ := store.Get(req, "session")
+	token := CSRFToken(session)
+	if CSRFToken(session) != token {
+		t.Fatal("expected the token to be stable for the session")
+	}
+	formToken := FormCSRFToken(session, "transfer")
+	w := httptest.NewRecorder()
+	if err := store.Save(req, w, session); err != nil {
+		t.Fatal(err)
+	}
+	cookie := w.Result().Cookies()[0]
+
+	noContent := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	handler := store.CSRFMiddleware("session", noContent)
+	transfer := store.FormCSRFMiddleware("session", "transfer", noContent)
+	remove := store.FormCSRFMiddleware("session", "delete", noContent)
+
+	testCases := map[string]struct {
+		handler http.Handler
+		method  string
+		header  string
+		form    url.Values
+		want    int
+	}{
+		"safe method":            {handler: handler, method: http.MethodGet, want: http.StatusNoContent},
+		"missing token":          {handler: handler, method: http.MethodPost, want: http.StatusForbidden},
+		"header token":           {handler: handler, method: http.MethodPost, header: token, want: http.StatusNoContent},
+		"wrong header token":     {handler: handler, method: http.MethodPost, header: "nope", want: http.StatusForbidden},
+		"form token":             {handler: handler, method: http.MethodPost, form: url.Values{CSRFFormField: {token}}, want: http.StatusNoContent},
+		"per-form token":         {handler: transfer, method: http.MethodPost, form: url.Values{CSRFFormField: {formToken}}, want: http.StatusNoContent},
+		"per-form token reuse":   {handler: remove, method: http.MethodPost, form: url.Values{CSRFFormField: {formToken}}, want: http.StatusForbidden},
+		"session token for form": {handler: transfer, method: http.MethodPost, form: url.Values{CSRFFormField: {token}}, want: http.StatusForbidden},
+		"form name from request": {handler: remove, method: http.MethodPost, form: url.Values{CSRFFormField: {formToken}, "csrf_form": {"transfer"}}, want: http.StatusForbidden},
+	}
+
+	for label, tc := range testCases {
+		t.Run(label, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, "/", strings.NewReader(tc.form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req.AddCookie(cookie)
+			if tc.header != "" {
+				req.Header.Set(CSRFHeader, tc.header)
+			}
+
+			w := httptest.NewRecorder()
+			tc.handler.ServeHTTP(w, req)
+			if w.Code != tc.want {
+				t.Errorf("expected %v; got %v", tc.want, w.Code)
+			}
+		})
+	}
+}
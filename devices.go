@@ -0,0 +1,110 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"context"
+	"slices"
+	"time"
+)
+
+// Device groups the sessions a user holds on one device, as identified by the
+// fingerprint recorded by WithDeviceBinding or, failing that, the user agent
+type Device struct {
+	// Fingerprint is the device fingerprint, empty without WithDeviceBinding
+	Fingerprint string
+
+	// UserAgent is the user agent of the most recently seen session
+	UserAgent string
+
+	// IP is the origin address of the most recently seen session
+	IP string
+
+	// Location is a hint derived from IP by the resolver set with
+	// WithLocationResolver, e.g. "Berlin, DE"
+	Location string
+
+	// LastSeen is the most recent LastSeen of the device's sessions
+	LastSeen time.Time
+
+	// Current is set if the device holds the session the request was made with
+	Current bool
+
+	// Sessions lists the sessions held by the device, most recently seen first
+	Sessions []SessionSummary
+}
+
+// Devices lists the devices userID is signed in on, most recently seen first,
+// ready to render a "your devices" page. currentSessionID, which may be empty,
+// marks the device making the request. It requires WithUserIndex.
+func (store *Store) Devices(ctx context.Context, userID, currentSessionID string) ([]Device, error) {
+	store = store.scoped(ctx)
+
+	current := ""
+	if currentSessionID != "" {
+		current = store.itemKey(currentSessionID)
+	}
+
+	var devices []*Device
+	byKey := map[string]*Device{}
+
+	cursor := ""
+	for {
+		page, next, err := store.ListSessions(ctx, userID, cursor)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, summary := range page {
+			key := summary.Device
+			if key == "" {
+				key = "ua:" + summary.UserAgent
+			}
+
+			device, ok := byKey[key]
+			if !ok {
+				device = &Device{Fingerprint: summary.Device}
+				byKey[key] = device
+				devices = append(devices, device)
+			}
+
+			device.Sessions = append(device.Sessions, summary)
+			device.Current = device.Current || (current != "" && summary.ID == current)
+		}
+
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+
+	out := make([]Device, 0, len(devices))
+	for _, device := range devices {
+		slices.SortFunc(device.Sessions, func(a, b SessionSummary) int { return b.LastSeen.Compare(a.LastSeen) })
+
+		latest := device.Sessions[0]
+		device.LastSeen = latest.LastSeen
+		device.UserAgent = latest.UserAgent
+		device.IP = latest.IP
+		if store.locationResolver != nil && latest.IP != "" {
+			device.Location = store.locationResolver(latest.IP)
+		}
+
+		out = append(out, *device)
+	}
+
+	slices.SortStableFunc(out, func(a, b Device) int { return b.LastSeen.Compare(a.LastSeen) })
+
+	return out, nil
+}
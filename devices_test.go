@@ -0,0 +1,71 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDevices(t *testing.T) {
+	ddb := newFakeDynamoDB()
+	now := time.Unix(1700000000, 0)
+	store, _ := New(ddb,
+		WithUserIndex("user-index", "user_id"),
+		WithDeviceBinding(nil, RejectMismatch),
+		WithClock(func() time.Time { return now }),
+		WithLocationResolver(func(ip string) string { return "somewhere near " + ip }),
+	)
+
+	login := func(userAgent, ip string) string {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("User-Agent", userAgent)
+		req.RemoteAddr = ip + ":1234"
+
+		session, _ := store.New(req, "session")
+		session.Values["user_id"] = "alice"
+		if err := store.Save(req, httptest.NewRecorder(), session); err != nil {
+			t.Fatal(err)
+		}
+		now = now.Add(time.Minute)
+		return session.ID
+	}
+
+	login("laptop", "192.0.2.1")
+	phone := login("phone", "198.51.100.1")
+	login("laptop", "192.0.2.2")
+
+	devices, err := store.Devices(context.TODO(), "alice", phone)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(devices) != 2 {
+		t.Fatalf("expected 2 devices; got %v", len(devices))
+	}
+
+	laptop := devices[0]
+	if laptop.UserAgent != "laptop" || len(laptop.Sessions) != 2 || laptop.Current {
+		t.Errorf("expected the laptop first with both its sessions; got %+v", laptop)
+	}
+	if laptop.IP != "192.0.2.2" || laptop.Location != "somewhere near 192.0.2.2" {
+		t.Errorf("expected the latest origin to be used; got %v, %v", laptop.IP, laptop.Location)
+	}
+	if !devices[1].Current || devices[1].UserAgent != "phone" {
+		t.Errorf("expected the phone to be marked current; got %+v", devices[1])
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package dynastoretest provides an in-memory implementation of the dynamodb
+// client used by dynastore.Store, so applications can unit test session flows
+// without dynamodb local or AWS credentials:
+//
+//	ddb := dynastoretest.New()
+//	store, err := dynastore.New(ddb, dynastore.TTLEnabled())
+//
+// It understands the expressions the store issues, simulates time to live with
+// Expire and injects failures with Fail and FailNext. Integration tests that need
+// the real thing use Local, which returns a store backed by dynamodb local.
+package dynastoretest
+
+import (
+	"time"
+
+	"github.com/ddouglas/dynastore"
+	"github.com/ddouglas/dynastore/internal/memdb"
+)
+
+var _ dynastore.DynamoDBClient = (*DynamoDB)(nil)
+
+// DynamoDB is an in-memory dynastore.DynamoDBClient holding any number of tables
+// keyed on a single string partition key. Tables spring into existence on first
+// write, or are created with CreateTable. It is safe for concurrent use.
+//
+// Fail makes every call of an operation, a DynamoDBClient method name such as
+// "GetItem", return an error until cleared with a nil error; FailNext fails the
+// next n calls only. Expire deletes the items whose ttl has passed, as dynamodb
+// does some time after expiry. Calls, Item and Len support assertions.
+type DynamoDB = memdb.DB
+
+// Option configures a DynamoDB
+type Option = memdb.Option
+
+// New returns an empty in-memory DynamoDB
+func New(opts ...Option) *DynamoDB {
+	return memdb.New(append([]Option{WithPrimaryKey(dynastore.DefaultPrimaryKey)}, opts...)...)
+}
+
+// WithClock sets the clock Expire compares ttl attributes with. Defaults to
+// time.Now.
+func WithClock(now func() time.Time) Option {
+	return memdb.WithClock(now)
+}
+
+// WithPrimaryKey sets the partition key of tables that are written to without
+// being created with CreateTable. Defaults to dynastore.DefaultPrimaryKey.
+func WithPrimaryKey(key string) Option {
+	return memdb.WithPrimaryKey(key)
+}
+
+// WithTTL enables time to live on attribute for every table, as if
+// UpdateTimeToLive had been called on each
+func WithTTL(attribute string) Option {
+	return memdb.WithTTL(attribute)
+}
@@ -0,0 +1,148 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastoretest
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/ddouglas/dynastore"
+	"github.com/gorilla/sessions"
+)
+
+func TestSessionFlow(t *testing.T) {
+	ddb := New()
+	store, err := dynastore.New(ddb, dynastore.TTLEnabled(), dynastore.MaxAge(3600), dynastore.WithUserIndex("user-index", "user_id"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	session, _ := store.New(req, "session")
+	session.Values["user_id"] = "bob"
+	w := httptest.NewRecorder()
+	if err := store.Save(req, w, session); err != nil {
+		t.Fatalf("expected the session to be saved; got %v", err)
+	}
+	if ddb.Len(dynastore.DefaultTableName) != 1 {
+		t.Fatalf("expected 1 item; got %v", ddb.Len(dynastore.DefaultTableName))
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(w.Result().Cookies()[0])
+	loaded, err := store.New(req, "session")
+	if err != nil || loaded.IsNew || loaded.Values["user_id"] != "bob" {
+		t.Fatalf("expected the saved session; got %v, %v", loaded.Values, err)
+	}
+
+	summaries, _, err := store.ListSessions(context.TODO(), "bob", "")
+	if err != nil || len(summaries) != 1 {
+		t.Errorf("expected 1 session of bob; got %v, %v", summaries, err)
+	}
+
+	if err := store.DeleteAllForUser(context.TODO(), "bob"); err != nil {
+		t.Fatal(err)
+	}
+	if ddb.Len(dynastore.DefaultTableName) != 0 {
+		t.Errorf("expected the session to be deleted; got %v items", ddb.Len(dynastore.DefaultTableName))
+	}
+}
+
+func TestExpire(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	clock := func() time.Time { return now }
+
+	ddb := New(WithClock(clock), WithTTL(dynastore.DefaultTTLField))
+	store, _ := dynastore.New(ddb, dynastore.TTLEnabled(), dynastore.MaxAge(60), dynastore.WithClock(clock))
+
+	session := sessions.NewSession(store, "session")
+	session.ID = "abc"
+	session.Options = &sessions.Options{MaxAge: 60}
+	if err := store.Persist(context.TODO(), "session", session); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := ddb.Expire(); n != 0 {
+		t.Errorf("expected nothing to expire yet; got %v", n)
+	}
+
+	now = now.Add(2 * time.Minute)
+	if err := store.Load(context.TODO(), "abc", sessions.NewSession(store, "session")); !errors.Is(err, dynastore.ErrSessionExpired) {
+		t.Errorf("expected an expired session pending deletion; got %v", err)
+	}
+	if n := ddb.Expire(); n != 1 {
+		t.Errorf("expected the session to expire; got %v", n)
+	}
+	if _, ok := ddb.Item(dynastore.DefaultTableName, "abc"); ok {
+		t.Error("expected the expired item to be deleted")
+	}
+}
+
+func TestFail(t *testing.T) {
+	ctx := context.TODO()
+
+	ddb := New()
+	store, _ := dynastore.New(ddb, dynastore.MaxAge(3600))
+
+	session := sessions.NewSession(store, "session")
+	session.ID = "abc"
+	session.Options = &sessions.Options{MaxAge: 3600}
+
+	ddb.Fail("PutItem", &types.InternalServerError{Message: aws.String("boom")})
+	if err := store.Persist(ctx, "session", session); !errors.Is(err, dynastore.ErrBackendUnavailable) {
+		t.Errorf("expected %v; got %v", dynastore.ErrBackendUnavailable, err)
+	}
+	ddb.Fail("PutItem", nil)
+	if err := store.Persist(ctx, "session", session); err != nil {
+		t.Fatalf("expected the failure to be cleared; got %v", err)
+	}
+
+	ddb.FailNext("GetItem", 1, &types.ProvisionedThroughputExceededException{Message: aws.String("slow down")})
+	if err := store.Load(ctx, "abc", sessions.NewSession(store, "session")); !errors.Is(err, dynastore.ErrThrottled) {
+		t.Errorf("expected %v; got %v", dynastore.ErrThrottled, err)
+	}
+	if err := store.Load(ctx, "abc", sessions.NewSession(store, "session")); err != nil {
+		t.Errorf("expected only the next call to fail; got %v", err)
+	}
+	if calls := ddb.Calls("GetItem"); calls != 2 {
+		t.Errorf("expected 2 calls; got %v", calls)
+	}
+}
+
+func TestEnsureTable(t *testing.T) {
+	ctx := context.TODO()
+
+	ddb := New()
+	store, _ := dynastore.New(ddb, dynastore.TableName("sessions"), dynastore.PrimaryKey("session_id"), dynastore.TTLEnabled())
+
+	if err := store.Ping(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Validate(ctx); err == nil {
+		t.Error("expected the table not to exist yet")
+	}
+
+	if err := store.EnsureTable(ctx, dynastore.TableOptions{}); err != nil {
+		t.Fatalf("expected the table to be created; got %v", err)
+	}
+	if err := store.Validate(ctx); err != nil {
+		t.Errorf("expected the table to match the store; got %v", err)
+	}
+}
@@ -0,0 +1,167 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastoretest
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/ddouglas/dynastore"
+)
+
+const (
+	// LocalEndpointEnv names the environment variable holding the endpoint of a
+	// running dynamodb local, e.g. http://localhost:8000
+	LocalEndpointEnv = "DYNAMODB_LOCAL_ENDPOINT"
+
+	// LocalImage is the container image Local starts when no dynamodb local is
+	// reachable
+	LocalImage = "amazon/dynamodb-local"
+
+	defaultLocalEndpoint = "http://localhost:8000"
+	localStartTimeout    = 30 * time.Second
+)
+
+// Local returns a store backed by a fresh table in dynamodb local, for
+// integration tests. It connects to the endpoint in LocalEndpointEnv, or
+// localhost:8000, and failing that starts a LocalImage container with docker.
+// The test is skipped when neither works. The table, named after the test, is
+// created with EnsureTable and deleted, along with any container started, when
+// the test completes. opts are applied after the table name and TTLEnabled.
+func Local(tb testing.TB, opts ...dynastore.Option) *dynastore.Store {
+	tb.Helper()
+
+	ctx := context.Background()
+
+	endpoint := os.Getenv(LocalEndpointEnv)
+	if endpoint == "" {
+		endpoint = defaultLocalEndpoint
+	}
+	if !reachable(endpoint, time.Second) {
+		started, err := startLocal(tb)
+		if err != nil {
+			tb.Skipf("dynamodb local is not reachable at %s and could not be started: %v", endpoint, err)
+		}
+		endpoint = started
+	}
+
+	client := dynamodb.New(dynamodb.Options{
+		Region:       "us-east-1",
+		BaseEndpoint: aws.String(endpoint),
+		Credentials: aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+			return aws.Credentials{AccessKeyID: "local", SecretAccessKey: "local", Source: "dynastoretest"}, nil
+		}),
+	})
+
+	opts = append([]dynastore.Option{
+		dynastore.TableName(localTableName(tb.Name())),
+		dynastore.TTLEnabled(),
+	}, opts...)
+
+	store, err := dynastore.New(client, opts...)
+	if err != nil {
+		tb.Fatalf("failed to create store: %v", err)
+	}
+	tableName := store.CreateTableInput().TableName
+
+	if err := store.EnsureTable(ctx, dynastore.TableOptions{MaxWait: localStartTimeout}); err != nil {
+		tb.Fatalf("failed to create table %s: %v", aws.ToString(tableName), err)
+	}
+
+	tb.Cleanup(func() {
+		if err := store.Close(ctx); err != nil {
+			tb.Errorf("failed to close store: %v", err)
+		}
+		if _, err := client.DeleteTable(ctx, &dynamodb.DeleteTableInput{TableName: tableName}); err != nil {
+			tb.Errorf("failed to delete table %s: %v", aws.ToString(tableName), err)
+		}
+	})
+
+	return store
+}
+
+// startLocal runs a dynamodb local container, removed when tb completes, and
+// returns its endpoint once it accepts connections
+func startLocal(tb testing.TB) (string, error) {
+	if _, err := exec.LookPath("docker"); err != nil {
+		return "", err
+	}
+
+	out, err := exec.Command("docker", "run", "--detach", "--rm", "--publish", "127.0.0.1::8000", LocalImage, "-jar", "DynamoDBLocal.jar", "-inMemory").Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to start %s: %w", LocalImage, err)
+	}
+	container := strings.TrimSpace(string(out))
+	tb.Cleanup(func() {
+		_ = exec.Command("docker", "rm", "--force", container).Run()
+	})
+
+	out, err = exec.Command("docker", "port", container, "8000/tcp").Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to find the port of container %s: %w", container, err)
+	}
+	address, _, _ := strings.Cut(strings.TrimSpace(string(out)), "\n")
+	endpoint := "http://" + address
+
+	for deadline := time.Now().Add(localStartTimeout); time.Now().Before(deadline); time.Sleep(100 * time.Millisecond) {
+		if reachable(endpoint, time.Second) {
+			return endpoint, nil
+		}
+	}
+
+	return "", fmt.Errorf("container %s did not accept connections within %v", container, localStartTimeout)
+}
+
+// reachable reports whether a tcp connection can be made to the host of endpoint
+func reachable(endpoint string, timeout time.Duration) bool {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" {
+		return false
+	}
+
+	conn, err := net.DialTimeout("tcp", u.Host, timeout)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+
+	return true
+}
+
+// localTableName returns a table name unique to the test named name, made of the
+// characters dynamodb allows and within its length limit
+func localTableName(name string) string {
+	sanitized := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			return r
+		default:
+			return '-'
+		}
+	}, name)
+	if len(sanitized) > 200 {
+		sanitized = sanitized[:200]
+	}
+
+	return fmt.Sprintf("dynastore-%s-%d", sanitized, time.Now().UnixNano())
+}
@@ -0,0 +1,51 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastoretest
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestLocal(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping dynamodb local in short mode")
+	}
+
+	store := Local(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	session, _ := store.New(req, "session")
+	session.Values["hello"] = "world"
+	if err := store.Persist(context.TODO(), "session", session); err != nil {
+		t.Fatalf("expected the session to be saved; got %v", err)
+	}
+	if err := store.Validate(context.TODO()); err != nil {
+		t.Errorf("expected the table to match the store; got %v", err)
+	}
+}
+
+func TestLocalTableName(t *testing.T) {
+	valid := regexp.MustCompile(`^[a-zA-Z0-9_.-]{3,255}$`)
+
+	for _, name := range []string{"TestLocal", "TestLocal/sub test#1", strings.Repeat("x", 300)} {
+		if got := localTableName(name); !valid.MatchString(got) {
+			t.Errorf("expected a valid table name for %q; got %q", name, got)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// EMFRecorder is a MetricsRecorder writing CloudWatch Embedded Metric Format
+// records, one JSON object per line, to a writer; CloudWatch Logs turns them into
+// metrics when the writer is the standard output of a Lambda function or of an
+// ECS task using the awslogs driver. Operations are recorded under the Operation
+// and Table dimensions, cache lookups under the Cache dimension.
+type EMFRecorder struct {
+	namespace string
+	now       func() time.Time
+
+	mu  sync.Mutex
+	enc *json.Encoder
+	err error
+}
+
+// NewEMFRecorder returns a recorder writing records for the CloudWatch metric
+// namespace to w
+func NewEMFRecorder(w io.Writer, namespace string) *EMFRecorder {
+	return &EMFRecorder{
+		namespace: namespace,
+		now:       time.Now,
+		enc:       json.NewEncoder(w),
+	}
+}
+
+type emfMetric struct {
+	Name string `json:"Name"`
+	Unit string `json:"Unit"`
+}
+
+type emfDirective struct {
+	Namespace  string      `json:"Namespace"`
+	Dimensions [][]string  `json:"Dimensions"`
+	Metrics    []emfMetric `json:"Metrics"`
+}
+
+type emfMetadata struct {
+	Timestamp         int64          `json:"Timestamp"`
+	CloudWatchMetrics []emfDirective `json:"CloudWatchMetrics"`
+}
+
+// ObserveOperation implements MetricsRecorder
+func (r *EMFRecorder) ObserveOperation(m OperationMetrics) {
+	failed := 0
+	if m.Err != nil {
+		failed = 1
+	}
+
+	r.write([]string{"Operation", "Table"}, []emfMetric{
+		{Name: "Latency", Unit: "Milliseconds"},
+		{Name: "Errors", Unit: "Count"},
+		{Name: "ItemSize", Unit: "Bytes"},
+		{Name: "ConsumedCapacity", Unit: "Count"},
+	}, map[string]any{
+		"Operation":        m.Operation,
+		"Table":            m.Table,
+		"ErrorClass":       m.ErrorClass,
+		"Latency":          float64(m.Duration) / float64(time.Millisecond),
+		"Errors":           failed,
+		"ItemSize":         m.ItemSize,
+		"ConsumedCapacity": m.ConsumedCapacity,
+	})
+}
+
+// ObserveCache implements MetricsRecorder
+func (r *EMFRecorder) ObserveCache(cache string, hit bool) {
+	hits, misses := 0, 1
+	if hit {
+		hits, misses = 1, 0
+	}
+
+	r.write([]string{"Cache"}, []emfMetric{
+		{Name: "CacheHits", Unit: "Count"},
+		{Name: "CacheMisses", Unit: "Count"},
+	}, map[string]any{
+		"Cache":       cache,
+		"CacheHits":   hits,
+		"CacheMisses": misses,
+	})
+}
+
+// Err returns the first error writing a record, after which records are dropped
+func (r *EMFRecorder) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.err
+}
+
+func (r *EMFRecorder) write(dimensions []string, metrics []emfMetric, record map[string]any) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.err != nil {
+		return
+	}
+
+	record["_aws"] = emfMetadata{
+		Timestamp: r.now().UnixMilli(),
+		CloudWatchMetrics: []emfDirective{{
+			Namespace:  r.namespace,
+			Dimensions: [][]string{dimensions},
+			Metrics:    metrics,
+		}},
+	}
+
+	r.err = r.enc.Encode(record)
+}
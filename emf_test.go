@@ -0,0 +1,59 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+func TestEMFRecorder(t *testing.T) {
+	var buf bytes.Buffer
+	recorder := NewEMFRecorder(&buf, "Sessions")
+	store, _ := New(newFakeDynamoDB(), MaxAge(3600), WithMetrics(recorder))
+
+	persistUserSessions(t, store, "bob", 1)
+	_ = store.Delete(context.TODO(), "bob-0")
+	if err := recorder.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	var records []map[string]any
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		var record map[string]any
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, record)
+	}
+
+	if len(records) != 2 {
+		t.Fatalf("expected one record per operation; got %v", records)
+	}
+
+	record := records[0]
+	if record["Operation"] != "Persist" || record["Table"] != DefaultTableName || record["Errors"] != 0.0 {
+		t.Errorf("expected the Persist record; got %v", record)
+	}
+
+	meta, _ := record["_aws"].(map[string]any)
+	directives, _ := meta["CloudWatchMetrics"].([]any)
+	if len(directives) != 1 || directives[0].(map[string]any)["Namespace"] != "Sessions" {
+		t.Errorf("expected the metric directive to carry the namespace; got %v", meta)
+	}
+}
@@ -0,0 +1,209 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+)
+
+// DataField contains the name of the attribute holding the encrypted session values
+const DataField = "data"
+
+var (
+	errNoEncryptionKey = fmt.Errorf("session is encrypted but no encryption key is configured")
+
+	errEncryptedFieldsWithoutKey = fmt.Errorf("WithEncryptedFields requires an encryption key, see WithEncryption, WithKMSEncryption or WithKeyProvider")
+)
+
+// sealer encrypts and decrypts serialized session values. additionalData binds
+// the ciphertext to the item it was written to.
+type sealer interface {
+	seal(ctx context.Context, plaintext, additionalData []byte) ([]byte, error)
+	open(ctx context.Context, ciphertext, additionalData []byte) ([]byte, error)
+}
+
+// aesGCM seals values with AES-GCM, prefixing the ciphertext with a random nonce
+type aesGCM struct {
+	aead cipher.AEAD
+}
+
+func newAESGCM(key []byte) (*aesGCM, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption key: %w", err)
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption key: %w", err)
+	}
+
+	return &aesGCM{aead: aead}, nil
+}
+
+func (a *aesGCM) seal(ctx context.Context, plaintext, additionalData []byte) ([]byte, error) {
+	nonce := make([]byte, a.aead.NonceSize(), a.aead.NonceSize()+len(plaintext)+a.aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	return a.aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func (a *aesGCM) open(ctx context.Context, ciphertext, additionalData []byte) ([]byte, error) {
+	if len(ciphertext) < a.aead.NonceSize() {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+
+	nonce, ciphertext := ciphertext[:a.aead.NonceSize()], ciphertext[a.aead.NonceSize():]
+	return a.aead.Open(nil, nonce, ciphertext, additionalData)
+}
+
+// sealValues replaces the session values with a single encrypted DataField when
+// encryption is enabled. The user index key is left in the clear so the index
+// keeps working. When WithEncryptedFields is set only those values are encrypted.
+func (store *Store) sealValues(ctx context.Context, id string, values map[string]any) (map[string]any, error) {
+	if store.sealer == nil {
+		return values, nil
+	}
+
+	if len(store.encryptedFields) > 0 {
+		return store.sealFields(ctx, id, values)
+	}
+
+	out := make(map[string]any)
+	if v, ok := values[store.userKey]; ok && store.userKey != "" {
+		out[store.userKey] = v
+	}
+
+	payload := make(map[string]any, len(values))
+	for k, v := range values {
+		if k == store.primaryKey || k == store.userKey {
+			continue
+		}
+		payload[k] = v
+	}
+
+	plaintext, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize session values: %w", err)
+	}
+
+	ciphertext, err := store.sealer.seal(ctx, plaintext, []byte(store.itemKey(id)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt session values: %w", err)
+	}
+
+	out[DataField] = ciphertext
+
+	return out, nil
+}
+
+// openValues decrypts DataField, if present, merging the session values back into
+// item. key is the item key of the session, as returned by itemKey.
+func (store *Store) openValues(ctx context.Context, key string, item map[string]any) error {
+	if err := store.openFields(ctx, key, item); err != nil {
+		return err
+	}
+
+	ciphertext, ok := item[DataField].([]byte)
+	if !ok {
+		return nil
+	}
+
+	if store.sealer == nil {
+		return errNoEncryptionKey
+	}
+
+	plaintext, err := store.sealer.open(ctx, ciphertext, []byte(key))
+	if err != nil {
+		return fmt.Errorf("failed to decrypt session values: %w", err)
+	}
+
+	payload := make(map[string]any)
+	if err := json.Unmarshal(plaintext, &payload); err != nil {
+		return fmt.Errorf("failed to deserialize session values: %w", err)
+	}
+
+	delete(item, DataField)
+	for k, v := range payload {
+		item[k] = v
+	}
+
+	return nil
+}
+
+// fieldAdditionalData binds an encrypted field to both its item and its name, so
+// ciphertext can't be moved between items or between fields
+func (store *Store) fieldAdditionalData(key, field string) []byte {
+	return []byte(key + "\x00" + field)
+}
+
+// sealFields encrypts the values named by WithEncryptedFields individually,
+// leaving all other values in the clear
+func (store *Store) sealFields(ctx context.Context, id string, values map[string]any) (map[string]any, error) {
+	for _, field := range store.encryptedFields {
+		v, ok := values[field]
+		if !ok {
+			continue
+		}
+
+		plaintext, err := json.Marshal(v)
+		if err != nil {
+			return nil, fmt.Errorf("failed to serialize session value %s: %w", field, err)
+		}
+
+		ciphertext, err := store.sealer.seal(ctx, plaintext, store.fieldAdditionalData(store.itemKey(id), field))
+		if err != nil {
+			return nil, fmt.Errorf("failed to encrypt session value %s: %w", field, err)
+		}
+
+		values[field] = ciphertext
+	}
+
+	return values, nil
+}
+
+// openFields decrypts the values named by WithEncryptedFields in place
+func (store *Store) openFields(ctx context.Context, key string, item map[string]any) error {
+	for _, field := range store.encryptedFields {
+		ciphertext, ok := item[field].([]byte)
+		if !ok {
+			continue
+		}
+
+		if store.sealer == nil {
+			return errNoEncryptionKey
+		}
+
+		plaintext, err := store.sealer.open(ctx, ciphertext, store.fieldAdditionalData(key, field))
+		if err != nil {
+			return fmt.Errorf("failed to decrypt session value %s: %w", field, err)
+		}
+
+		var v any
+		if err := json.Unmarshal(plaintext, &v); err != nil {
+			return fmt.Errorf("failed to deserialize session value %s: %w", field, err)
+		}
+
+		item[field] = v
+	}
+
+	return nil
+}
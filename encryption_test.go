@@ -0,0 +1,179 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+)
+
+func TestEncryption(t *testing.T) {
+	ctx := context.TODO()
+
+	ddb := newFakeDynamoDB()
+	store, err := New(ddb, WithEncryption(securecookie.GenerateRandomKey(32)), WithUserIndex("user-index", "user_id"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session := sessions.NewSession(store, "session")
+	session.ID = "abc"
+	session.Values["user_id"] = "bob"
+	session.Values["email"] = "bob@example.com"
+
+	if err := store.Persist(ctx, session.Name(), session); err != nil {
+		t.Fatal(err)
+	}
+
+	item := ddb.items["abc"]
+	if _, ok := item["email"]; ok {
+		t.Error("expected values to be encrypted")
+	}
+	if _, ok := item["user_id"]; !ok {
+		t.Error("expected the user index key to be stored in the clear")
+	}
+
+	found := sessions.NewSession(store, "session")
+	if err := store.Load(ctx, "abc", found); err != nil {
+		t.Fatal(err)
+	}
+	if found.Values["email"] != "bob@example.com" || found.Values["user_id"] != "bob" {
+		t.Errorf("expected values to be decrypted; got %v", found.Values)
+	}
+
+	ddb.items["xyz"] = item
+	if err := store.Load(ctx, "xyz", sessions.NewSession(store, "session")); err == nil {
+		t.Error("expected ciphertext moved to another item to be rejected")
+	}
+
+	if _, err := New(ddb, WithEncryption([]byte("short"))); err == nil {
+		t.Error("expected an invalid key to be rejected")
+	}
+}
+
+func TestEncryptedFields(t *testing.T) {
+	ctx := context.TODO()
+
+	ddb := newFakeDynamoDB()
+	store, err := New(ddb, WithEncryption(securecookie.GenerateRandomKey(32)), WithEncryptedFields("email", "ssn_last4"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session := sessions.NewSession(store, "session")
+	session.ID = "abc"
+	session.Values["email"] = "bob@example.com"
+	session.Values["ssn_last4"] = "1234"
+	session.Values["theme"] = "dark"
+
+	if err := store.Persist(ctx, session.Name(), session); err != nil {
+		t.Fatal(err)
+	}
+
+	item := ddb.items["abc"]
+	if _, ok := item["email"].(*types.AttributeValueMemberB); !ok {
+		t.Errorf("expected email to be encrypted; got %#v", item["email"])
+	}
+	if v, ok := item["theme"].(*types.AttributeValueMemberS); !ok || v.Value != "dark" {
+		t.Errorf("expected theme to be stored in the clear; got %#v", item["theme"])
+	}
+
+	found := sessions.NewSession(store, "session")
+	if err := store.Load(ctx, "abc", found); err != nil {
+		t.Fatal(err)
+	}
+	if found.Values["email"] != "bob@example.com" || found.Values["ssn_last4"] != "1234" {
+		t.Errorf("expected fields to be decrypted; got %v", found.Values)
+	}
+
+	item["email"], item["ssn_last4"] = item["ssn_last4"], item["email"]
+	if err := store.Load(ctx, "abc", sessions.NewSession(store, "session")); err == nil {
+		t.Error("expected ciphertext swapped between fields to be rejected")
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	ctx := context.TODO()
+
+	oldKey, newKey := securecookie.GenerateRandomKey(32), securecookie.GenerateRandomKey(32)
+
+	ddb := newFakeDynamoDB()
+	store, _ := New(ddb, WithEncryption(oldKey), WithSigningKey(oldKey))
+
+	session := sessions.NewSession(store, "session")
+	session.ID = "abc"
+	session.Values["email"] = "bob@example.com"
+	if err := store.Persist(ctx, session.Name(), session); err != nil {
+		t.Fatal(err)
+	}
+
+	value, _ := store.encodeCookie(ctx, "session", "abc")
+
+	rotated, err := New(ddb,
+		WithEncryption(newKey), WithPreviousEncryptionKeys(oldKey),
+		WithSigningKey(newKey), WithPreviousSigningKeys(oldKey),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if id, err := rotated.decodeCookie(ctx, "session", value); err != nil || id != "abc" {
+		t.Errorf("expected id signed with the previous key to verify; got %v, %v", id, err)
+	}
+
+	found := sessions.NewSession(rotated, "session")
+	if err := rotated.Load(ctx, "abc", found); err != nil {
+		t.Fatal(err)
+	}
+	if found.Values["email"] != "bob@example.com" {
+		t.Errorf("expected values encrypted with the previous key to decrypt; got %v", found.Values)
+	}
+
+	if err := rotated.Persist(ctx, found.Name(), found); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Load(ctx, "abc", sessions.NewSession(store, "session")); err == nil {
+		t.Error("expected the session to be re-encrypted with the new key")
+	}
+}
+
+func TestEncryptedFieldsRequireKey(t *testing.T) {
+	testCases := map[string]struct {
+		Opts     []Option
+		Expected error
+	}{
+		"no key": {
+			Expected: errEncryptedFieldsWithoutKey,
+		},
+		"key provider without encryption key": {
+			Opts:     []Option{WithKeyProvider(staticKeys{SigningKey: securecookie.GenerateRandomKey(32)})},
+			Expected: errEncryptedFieldsWithoutKey,
+		},
+		"encryption key": {
+			Opts: []Option{WithEncryption(securecookie.GenerateRandomKey(32))},
+		},
+	}
+
+	for label, tc := range testCases {
+		t.Run(label, func(t *testing.T) {
+			if _, err := New(newFakeDynamoDB(), append(tc.Opts, WithEncryptedFields("email"))...); err != tc.Expected {
+				t.Errorf("expected %v; got %v", tc.Expected, err)
+			}
+		})
+	}
+}
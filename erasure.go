@@ -0,0 +1,160 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// erasurePrefix prefixes the primary key of erasure attestations, which share the
+// sessions table but can never be loaded as sessions
+const erasurePrefix = "erasure#"
+
+const (
+	// ErasureSubjectField holds the digest of the user an attestation is about
+	ErasureSubjectField = "subject"
+
+	// ErasureSessionsField holds the number of sessions an erasure deleted
+	ErasureSessionsField = "sessions"
+
+	// ErasureShreddedField is true when encrypted values were shredded before
+	// the sessions were deleted
+	ErasureShreddedField = "shredded"
+
+	// ErasedAtField holds the time of an erasure in epoch seconds
+	ErasedAtField = "erased_at"
+)
+
+// Erasure attests to the erasure of the sessions of a user by EraseUser
+type Erasure struct {
+	// ID identifies the attestation; it is stored under erasure#<ID>
+	ID string
+
+	// Subject is a digest of the user id, so the attestation doesn't itself
+	// retain the personal data it attests the erasure of. It is keyed with the
+	// key passed to WithHashedIDs, if any.
+	Subject string
+
+	// Sessions is the number of sessions deleted
+	Sessions int
+
+	// Shredded reports whether the encrypted values of the sessions were
+	// removed before the sessions were deleted
+	Shredded bool
+
+	// ErasedAt is the time the erasure completed
+	ErasedAt time.Time
+}
+
+// EraseUser deletes every session of userID and records an attestation of the
+// erasure in the table, for data deletion requests. When encryption is enabled,
+// the encrypted attributes of each session are removed first, so the data is
+// unrecoverable even if a delete fails part way. The remember-me series of the
+// user are deleted too. It requires WithUserIndex.
+func (store *Store) EraseUser(ctx context.Context, userID string) (*Erasure, error) {
+	store = store.scoped(ctx)
+
+	sessions, err := store.userSessions(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	shredded := store.sealer != nil
+	if shredded {
+		for _, s := range sessions {
+			if err := store.shred(ctx, s.key); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := store.DeleteAllForUser(ctx, userID); err != nil {
+		return nil, fmt.Errorf("failed to delete sessions of user: %w", err)
+	}
+
+	erasure := &Erasure{
+		ID:       store.newID(),
+		Subject:  store.subjectDigest(userID),
+		Sessions: len(sessions),
+		Shredded: shredded,
+		ErasedAt: store.now(),
+	}
+
+	item := store.rawKey(erasurePrefix + erasure.ID)
+	item[ErasureSubjectField] = &types.AttributeValueMemberS{Value: erasure.Subject}
+	item[ErasureSessionsField] = &types.AttributeValueMemberN{Value: strconv.Itoa(erasure.Sessions)}
+	item[ErasureShreddedField] = &types.AttributeValueMemberBOOL{Value: erasure.Shredded}
+	item[ErasedAtField] = &types.AttributeValueMemberN{Value: strconv.FormatInt(erasure.ErasedAt.Unix(), 10)}
+
+	if _, err := store.ddb.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(store.tableName),
+		Item:      item,
+	}); err != nil {
+		return nil, fmt.Errorf("failed to record erasure: %w", err)
+	}
+
+	return erasure, nil
+}
+
+// shred removes the encrypted attributes of the session stored under key
+func (store *Store) shred(ctx context.Context, key string) error {
+	names := map[string]string{"#pk": store.primaryKey, "#data": DataField}
+	removed := []string{"#data"}
+	for i, field := range store.encryptedFields {
+		name := "#f" + strconv.Itoa(i)
+		names[name] = field
+		removed = append(removed, name)
+	}
+
+	_, err := store.ddb.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:                aws.String(store.tableName),
+		Key:                      map[string]types.AttributeValue{store.primaryKey: &types.AttributeValueMemberS{Value: key}},
+		UpdateExpression:         aws.String("REMOVE " + strings.Join(removed, ", ")),
+		ConditionExpression:      aws.String("attribute_exists(#pk)"),
+		ExpressionAttributeNames: names,
+	})
+
+	var ccf *types.ConditionalCheckFailedException
+	if err != nil && !errors.As(err, &ccf) {
+		return fmt.Errorf("failed to shred session of user: %w", err)
+	}
+
+	return nil
+}
+
+// subjectDigest returns the digest of userID recorded by erasure attestations
+func (store *Store) subjectDigest(userID string) string {
+	var mac hash.Hash
+	if store.hashKey != nil {
+		mac = hmac.New(sha256.New, store.hashKey)
+	} else {
+		mac = sha256.New()
+	}
+	mac.Write([]byte(userID))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,61 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+)
+
+func TestEraseUser(t *testing.T) {
+	ddb := newFakeDynamoDB()
+	store, _ := New(ddb,
+		WithUserIndex("user-index", "user_id"),
+		WithEncryption(securecookie.GenerateRandomKey(32)),
+		MaxAge(3600),
+	)
+
+	persistUserSessions(t, store, "bob", 3)
+	alice := persistUserSessions(t, store, "alice", 1)
+
+	erasure, err := store.EraseUser(context.TODO(), "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if erasure.Sessions != 3 || !erasure.Shredded {
+		t.Errorf("expected 3 sessions to be shredded; got %+v", erasure)
+	}
+	if ddb.updates != 3 {
+		t.Errorf("expected the encrypted values of each session to be removed; got %v updates", ddb.updates)
+	}
+
+	if _, ok := ddb.items[alice[0]]; !ok || len(ddb.items) != 2 {
+		t.Errorf("expected only the session of alice and the attestation to remain; got %v", ddb.items)
+	}
+
+	item, ok := ddb.items[erasurePrefix+erasure.ID]
+	if !ok {
+		t.Fatal("expected an attestation to be recorded")
+	}
+	if subject := attributeString(item[ErasureSubjectField]); subject == "bob" || subject != erasure.Subject {
+		t.Errorf("expected the attestation to hold a digest of the user; got %v", subject)
+	}
+
+	if err := store.Load(context.TODO(), erasurePrefix+erasure.ID, sessions.NewSession(store, "session")); err != ErrSessionNotFound {
+		t.Errorf("expected an attestation not to load as a session; got %v", err)
+	}
+}
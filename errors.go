@@ -0,0 +1,179 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// maxItemSize is the largest item dynamodb accepts
+const maxItemSize = 400 * 1024
+
+var (
+	// ErrSessionNotFound is returned when no session is stored under an id. New
+	// also wraps it when the presented session could not be used because it
+	// expired, was revoked, is malformed or is bound to another client
+	ErrSessionNotFound = fmt.Errorf("session not found")
+
+	// ErrThrottled wraps the errors of dynamodb calls that were throttled
+	ErrThrottled = fmt.Errorf("dynamodb throttled the request")
+
+	// ErrConditionFailed wraps the errors of dynamodb writes whose condition
+	// failed, such as concurrent writes to the same session
+	ErrConditionFailed = fmt.Errorf("dynamodb write condition failed")
+
+	// ErrSessionTooLarge is returned when a session item exceeds the 400KB
+	// dynamodb item limit
+	ErrSessionTooLarge = fmt.Errorf("session exceeds the dynamodb item size limit")
+
+	// ErrBackendUnavailable wraps the errors of dynamodb calls that failed for
+	// server side or network reasons and may succeed if retried
+	ErrBackendUnavailable = fmt.Errorf("dynamodb unavailable")
+)
+
+// classifiedError ties an error of a dynamodb call to its class, so callers can
+// branch with errors.Is on the class and still reach the aws error with errors.As
+type classifiedError struct {
+	class error
+	err   error
+}
+
+func (e *classifiedError) Error() string {
+	return e.class.Error() + ": " + e.err.Error()
+}
+
+func (e *classifiedError) Unwrap() []error {
+	return []error{e.class, e.err}
+}
+
+// classify wraps err, returned by a dynamodb call, into its class when it has one
+func classify(err error) error {
+	var class error
+
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, ErrThrottled),
+		errors.Is(err, ErrConditionFailed),
+		errors.Is(err, ErrSessionTooLarge),
+		errors.Is(err, ErrBackendUnavailable):
+		return err
+	case conditionFailed(err):
+		class = ErrConditionFailed
+	case throttlingError(err):
+		class = ErrThrottled
+	case itemTooLarge(err):
+		class = ErrSessionTooLarge
+	case transientError(err):
+		class = ErrBackendUnavailable
+	default:
+		return err
+	}
+
+	return &classifiedError{class: class, err: err}
+}
+
+// conditionFailed reports whether err is a failed write condition, on its own or
+// within a transaction
+func conditionFailed(err error) bool {
+	var ccf *types.ConditionalCheckFailedException
+	if errors.As(err, &ccf) {
+		return true
+	}
+
+	var canceled *types.TransactionCanceledException
+	if errors.As(err, &canceled) {
+		for _, reason := range canceled.CancellationReasons {
+			if aws.ToString(reason.Code) == "ConditionalCheckFailed" {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// itemTooLarge reports whether err is dynamodb refusing an item over the size limit
+func itemTooLarge(err error) bool {
+	var coded interface {
+		ErrorCode() string
+		ErrorMessage() string
+	}
+
+	return errors.As(err, &coded) && coded.ErrorCode() == "ValidationException" && strings.Contains(coded.ErrorMessage(), "size has exceeded")
+}
+
+// classifyingClient classifies the errors of every call, see classify
+type classifyingClient struct {
+	DynamoDBClient
+}
+
+func classified[O any](out O, err error) (O, error) {
+	return out, classify(err)
+}
+
+func (c classifyingClient) GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	return classified(c.DynamoDBClient.GetItem(ctx, params, optFns...))
+}
+
+func (c classifyingClient) PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	return classified(c.DynamoDBClient.PutItem(ctx, params, optFns...))
+}
+
+func (c classifyingClient) UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error) {
+	return classified(c.DynamoDBClient.UpdateItem(ctx, params, optFns...))
+}
+
+func (c classifyingClient) DeleteItem(ctx context.Context, params *dynamodb.DeleteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error) {
+	return classified(c.DynamoDBClient.DeleteItem(ctx, params, optFns...))
+}
+
+func (c classifyingClient) BatchWriteItem(ctx context.Context, params *dynamodb.BatchWriteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchWriteItemOutput, error) {
+	return classified(c.DynamoDBClient.BatchWriteItem(ctx, params, optFns...))
+}
+
+func (c classifyingClient) Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
+	return classified(c.DynamoDBClient.Query(ctx, params, optFns...))
+}
+
+func (c classifyingClient) Scan(ctx context.Context, params *dynamodb.ScanInput, optFns ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error) {
+	return classified(c.DynamoDBClient.Scan(ctx, params, optFns...))
+}
+
+func (c classifyingClient) TransactWriteItems(ctx context.Context, params *dynamodb.TransactWriteItemsInput, optFns ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error) {
+	return classified(c.DynamoDBClient.TransactWriteItems(ctx, params, optFns...))
+}
+
+func (c classifyingClient) CreateTable(ctx context.Context, params *dynamodb.CreateTableInput, optFns ...func(*dynamodb.Options)) (*dynamodb.CreateTableOutput, error) {
+	return classified(c.DynamoDBClient.CreateTable(ctx, params, optFns...))
+}
+
+func (c classifyingClient) DescribeTable(ctx context.Context, params *dynamodb.DescribeTableInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DescribeTableOutput, error) {
+	return classified(c.DynamoDBClient.DescribeTable(ctx, params, optFns...))
+}
+
+func (c classifyingClient) DescribeTimeToLive(ctx context.Context, params *dynamodb.DescribeTimeToLiveInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DescribeTimeToLiveOutput, error) {
+	return classified(c.DynamoDBClient.DescribeTimeToLive(ctx, params, optFns...))
+}
+
+func (c classifyingClient) UpdateTimeToLive(ctx context.Context, params *dynamodb.UpdateTimeToLiveInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateTimeToLiveOutput, error) {
+	return classified(c.DynamoDBClient.UpdateTimeToLive(ctx, params, optFns...))
+}
@@ -0,0 +1,101 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/gorilla/sessions"
+)
+
+func TestClassify(t *testing.T) {
+	testCases := map[string]struct {
+		err   error
+		class error
+	}{
+		"throttled":   {err: &types.ProvisionedThroughputExceededException{Message: aws.String("slow down")}, class: ErrThrottled},
+		"condition":   {err: &types.ConditionalCheckFailedException{}, class: ErrConditionFailed},
+		"transaction": {err: &types.TransactionCanceledException{CancellationReasons: []types.CancellationReason{{Code: aws.String("ConditionalCheckFailed")}}}, class: ErrConditionFailed},
+		"server":      {err: &types.InternalServerError{}, class: ErrBackendUnavailable},
+	}
+
+	for label, tc := range testCases {
+		t.Run(label, func(t *testing.T) {
+			err := classify(fmt.Errorf("failed: %w", tc.err))
+			if !errors.Is(err, tc.class) {
+				t.Errorf("expected %v; got %v", tc.class, err)
+			}
+			if !errors.Is(err, tc.err) {
+				t.Errorf("expected the aws error to remain reachable; got %v", err)
+			}
+		})
+	}
+
+	if err := fmt.Errorf("boom"); classify(err) != err {
+		t.Error("expected unclassified errors to be returned as is")
+	}
+}
+
+func TestSessionTooLarge(t *testing.T) {
+	store, _ := New(newFakeDynamoDB(), MaxAge(3600))
+
+	session := sessions.NewSession(store, "session")
+	session.ID = "abc"
+	session.Options = store.newOptions()
+	session.Values["blob"] = strings.Repeat("x", maxItemSize)
+
+	if err := store.Persist(context.TODO(), "session", session); !errors.Is(err, ErrSessionTooLarge) {
+		t.Errorf("expected %v; got %v", ErrSessionTooLarge, err)
+	}
+}
+
+func TestNewLoadErrors(t *testing.T) {
+	ddb := &throttledDynamoDB{fakeDynamoDB: newFakeDynamoDB()}
+	store, _ := New(ddb, MaxAge(3600))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	session, _ := store.New(req, "session")
+	w := httptest.NewRecorder()
+	if err := store.Save(req, w, session); err != nil {
+		t.Fatalf("expected the session to be saved; got %v", err)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(w.Result().Cookies()[0])
+
+	ddb.err = &types.InternalServerError{}
+	loaded, err := store.New(req, "session")
+	if !errors.Is(err, ErrBackendUnavailable) || errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("expected a backend error; got %v", err)
+	}
+	if loaded == nil || !loaded.IsNew {
+		t.Error("expected a new session alongside the error")
+	}
+
+	ddb.err = nil
+	if err := store.Delete(context.TODO(), session.ID); err != nil {
+		t.Fatalf("expected the session to be deleted; got %v", err)
+	}
+	if _, err := store.New(req, "session"); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("expected %v; got %v", ErrSessionNotFound, err)
+	}
+}
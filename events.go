@@ -0,0 +1,169 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gorilla/sessions"
+)
+
+// EventType is the kind of session lifecycle event
+type EventType string
+
+const (
+	// SessionCreated is published when a new session is first saved, or saved
+	// under its new id by RegenerateID
+	SessionCreated EventType = "session.created"
+
+	// SessionRenewed is published when an existing session is saved again or touched
+	SessionRenewed EventType = "session.renewed"
+
+	// SessionDestroyed is published when a session is deleted, alone or in bulk,
+	// or its old id is given up by RegenerateID
+	SessionDestroyed EventType = "session.destroyed"
+
+	// SessionExpired is published when Load finds a session past its ttl
+	SessionExpired EventType = "session.expired"
+)
+
+// Event describes a session lifecycle event
+type Event struct {
+	Type EventType `json:"type"`
+
+	// Session identifies the session with a digest of its id, which is a bearer
+	// credential and never published as is
+	Session string `json:"session"`
+
+	// UserID is the user of the session, when known, see WithUserIndex
+	UserID string `json:"user_id,omitempty"`
+
+	Table string    `json:"table"`
+	Time  time.Time `json:"time"`
+}
+
+// EventPublisher publishes session lifecycle events, typically to EventBridge
+// with PutEvents or to SNS with Publish, the event encoded as JSON as the detail
+// or message:
+//
+//	type eventBridge struct{ client *eventbridge.Client }
+//
+//	func (p eventBridge) Publish(ctx context.Context, event dynastore.Event) error {
+//		detail, _ := json.Marshal(event)
+//		_, err := p.client.PutEvents(ctx, &eventbridge.PutEventsInput{
+//			Entries: []types.PutEventsRequestEntry{{
+//				Source:     aws.String("sessions"),
+//				DetailType: aws.String(string(event.Type)),
+//				Detail:     aws.String(string(detail)),
+//			}},
+//		})
+//		return err
+//	}
+type EventPublisher interface {
+	Publish(ctx context.Context, event Event) error
+}
+
+// ErrEventQueueFull is passed to the error handler of WithEvents when an event is
+// dropped because the publisher can't keep up
+var ErrEventQueueFull = fmt.Errorf("event queue is full")
+
+// eventQueue publishes events in the background so requests don't wait for the
+// publisher
+type eventQueue struct {
+	publisher EventPublisher
+	onError   func(error)
+	queue     chan Event
+	done      chan struct{}
+	mu        sync.RWMutex
+	closed    bool
+}
+
+func (q *eventQueue) start() {
+	q.done = make(chan struct{})
+	go func() {
+		defer close(q.done)
+		for event := range q.queue {
+			if err := q.publisher.Publish(context.Background(), event); err != nil {
+				q.fail(fmt.Errorf("failed to publish %s event: %w", event.Type, err))
+			}
+		}
+	}()
+}
+
+func (q *eventQueue) fail(err error) {
+	if q.onError != nil {
+		q.onError(err)
+	}
+}
+
+// close stops accepting events and waits for the queued ones to be published, or
+// for ctx to be done
+func (q *eventQueue) close(ctx context.Context) error {
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.queue)
+	}
+	q.mu.Unlock()
+
+	select {
+	case <-q.done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("events still pending: %w", ctx.Err())
+	}
+}
+
+// publish queues an event about the session identified by id when WithEvents is
+// set, dropping it if the queue is full
+func (store *Store) publish(eventType EventType, id, user string) {
+	q := store.events
+	if q == nil {
+		return
+	}
+
+	event := Event{
+		Type:    eventType,
+		Session: redactID(id),
+		UserID:  user,
+		Table:   store.tableName,
+		Time:    store.now(),
+	}
+
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	if q.closed {
+		return
+	}
+
+	select {
+	case q.queue <- event:
+	default:
+		q.fail(ErrEventQueueFull)
+	}
+}
+
+// publishSaved publishes the event of a session having been persisted
+func (store *Store) publishSaved(session *sessions.Session) {
+	eventType := SessionRenewed
+	if session.IsNew {
+		eventType = SessionCreated
+	}
+
+	store.publish(eventType, session.ID, store.sessionUser(session))
+}
@@ -0,0 +1,156 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+)
+
+type recordingPublisher struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (p *recordingPublisher) Publish(ctx context.Context, event Event) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.events = append(p.events, event)
+	return nil
+}
+
+func TestEvents(t *testing.T) {
+	ctx := context.TODO()
+
+	now := time.Unix(1700000000, 0)
+	publisher := &recordingPublisher{}
+	store, _ := New(newFakeDynamoDB(), TTLEnabled(), MaxAge(60), WithClock(func() time.Time { return now }),
+		WithUserIndex("user-index", "user_id"),
+		WithEvents(publisher, 10, func(err error) {
+			t.Errorf("unexpected event error: %v", err)
+		}))
+
+	session := sessions.NewSession(store, "session")
+	session.ID = "abc"
+	session.IsNew = true
+	session.Options = store.newOptions()
+	session.Values["user_id"] = "bob"
+	if err := store.Persist(ctx, "session", session); err != nil {
+		t.Fatal(err)
+	}
+	session.IsNew = false
+	if err := store.Persist(ctx, "session", session); err != nil {
+		t.Fatal(err)
+	}
+
+	now = now.Add(time.Hour)
+	if err := store.Load(ctx, "abc", sessions.NewSession(store, "session")); err != ErrSessionExpired {
+		t.Fatalf("expected %v; got %v", ErrSessionExpired, err)
+	}
+	if err := store.Delete(ctx, "abc"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []EventType{SessionCreated, SessionRenewed, SessionExpired, SessionDestroyed}
+	if len(publisher.events) != len(want) {
+		t.Fatalf("expected %v; got %v", want, publisher.events)
+	}
+	for i, event := range publisher.events {
+		if event.Type != want[i] || event.Session != redactID("abc") || event.Table != DefaultTableName {
+			t.Errorf("expected a %v event; got %+v", want[i], event)
+		}
+	}
+	if publisher.events[0].UserID != "bob" {
+		t.Errorf("expected the user to be published; got %+v", publisher.events[0])
+	}
+}
+
+func TestEventsBulkOperations(t *testing.T) {
+	testCases := map[string]struct {
+		Op   func(t *testing.T, store *Store, ids []string)
+		Want []EventType
+	}{
+		"save all": {
+			Op: func(t *testing.T, store *Store, ids []string) {
+				req := httptest.NewRequest(http.MethodGet, "/", nil)
+				for _, name := range []string{"flash", "prefs"} {
+					if _, err := store.Get(req, name); err != nil {
+						t.Fatal(err)
+					}
+				}
+				if err := store.SaveAll(req, httptest.NewRecorder()); err != nil {
+					t.Fatal(err)
+				}
+			},
+			Want: []EventType{SessionCreated, SessionCreated},
+		},
+		"delete all for user except": {
+			Op: func(t *testing.T, store *Store, ids []string) {
+				if err := store.DeleteAllForUserExcept(context.TODO(), "bob", ids[0]); err != nil {
+					t.Fatal(err)
+				}
+			},
+			Want: []EventType{SessionDestroyed, SessionDestroyed},
+		},
+		"regenerate id": {
+			Op: func(t *testing.T, store *Store, ids []string) {
+				session := sessions.NewSession(store, "session")
+				session.ID = ids[0]
+				session.Options = store.newOptions()
+				session.Values["user_id"] = "bob"
+				req := httptest.NewRequest(http.MethodGet, "/", nil)
+				if err := store.RegenerateID(context.TODO(), req, httptest.NewRecorder(), session); err != nil {
+					t.Fatal(err)
+				}
+			},
+			Want: []EventType{SessionDestroyed, SessionCreated},
+		},
+	}
+
+	for label, tc := range testCases {
+		t.Run(label, func(t *testing.T) {
+			publisher := &recordingPublisher{}
+			store, _ := New(newFakeDynamoDB(), WithUserIndex("user-index", "user_id"),
+				WithEvents(publisher, 10, func(err error) {
+					t.Errorf("unexpected event error: %v", err)
+				}))
+			ids := persistUserSessions(t, store, "bob", 3)
+
+			tc.Op(t, store, ids)
+			if err := store.Close(context.TODO()); err != nil {
+				t.Fatal(err)
+			}
+
+			// the first events are those of the sessions being created
+			var got []EventType
+			for _, event := range publisher.events[len(ids):] {
+				got = append(got, event.Type)
+			}
+			if !slices.Equal(got, tc.Want) {
+				t.Errorf("expected %v; got %v", tc.Want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	av "github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// ExportUser writes every session of userID to w as JSON lines, one object per
+// session holding its decoded attributes, to answer subject access requests.
+// Encrypted values are decrypted. The primary key is left out, as it is the
+// session id itself unless WithHashedIDs is set. It requires WithUserIndex.
+func (store *Store) ExportUser(ctx context.Context, userID string, w io.Writer) error {
+	store = store.scoped(ctx)
+
+	sessions, err := store.userSessions(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(w)
+	for _, s := range sessions {
+		result, err := store.ddb.GetItem(ctx, &dynamodb.GetItemInput{
+			TableName:      aws.String(store.tableName),
+			Key:            map[string]types.AttributeValue{store.primaryKey: &types.AttributeValueMemberS{Value: s.key}},
+			ConsistentRead: aws.Bool(true),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to read session of user: %w", err)
+		}
+		if result.Item == nil {
+			continue
+		}
+
+		out := map[string]any{}
+		if err := av.UnmarshalMap(result.Item, &out); err != nil {
+			return fmt.Errorf("failed to decode session of user: %w", err)
+		}
+		if err := store.openValues(ctx, s.key, out); err != nil {
+			return err
+		}
+		delete(out, store.primaryKey)
+
+		if err := enc.Encode(out); err != nil {
+			return fmt.Errorf("failed to write session of user: %w", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,66 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/gorilla/securecookie"
+)
+
+func TestExportUser(t *testing.T) {
+	ddb := newFakeDynamoDB()
+	store, _ := New(ddb,
+		WithUserIndex("user-index", "user_id"),
+		WithHashedIDs(nil),
+		WithEncryption(securecookie.GenerateRandomKey(32)),
+		MaxAge(3600),
+	)
+
+	persistUserSessions(t, store, "bob", 3)
+	persistUserSessions(t, store, "alice", 1)
+
+	var buf bytes.Buffer
+	if err := store.ExportUser(context.TODO(), "bob", &buf); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := 0
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		lines++
+
+		var record map[string]any
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatal(err)
+		}
+		if record["user_id"] != "bob" {
+			t.Errorf("expected only sessions of bob; got %v", record)
+		}
+		if _, ok := record[DataField]; ok {
+			t.Errorf("expected values to be decrypted; got %v", record)
+		}
+		if _, ok := record[DefaultPrimaryKey]; ok {
+			t.Errorf("expected the primary key to be left out; got %v", record)
+		}
+	}
+
+	if lines != 3 {
+		t.Errorf("expected 3 sessions; got %v", lines)
+	}
+}
@@ -0,0 +1,259 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// failoverClient sends requests to a secondary table once the primary has failed
+// threshold times in a row. After cooldown the primary is tried again, and it
+// takes the traffic back as soon as a request to it succeeds.
+type failoverClient struct {
+	primary        DynamoDBClient
+	secondary      DynamoDBClient
+	primaryTable   string
+	secondaryTable string
+	threshold      int
+	cooldown       time.Duration
+	now            func() time.Time
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+}
+
+// usePrimary reports whether the next request goes to the primary table
+func (c *failoverClient) usePrimary() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.failures < c.threshold || !c.now().Before(c.openUntil)
+}
+
+// record updates the health of the primary after a request to it
+func (c *failoverClient) record(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !storeOutage(err) {
+		c.failures = 0
+		return
+	}
+
+	c.failures++
+	if c.failures >= c.threshold {
+		c.openUntil = c.now().Add(c.cooldown)
+	}
+}
+
+// failedOver reports whether requests currently go to the secondary table
+func (c *failoverClient) failedOver() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.failures >= c.threshold && c.now().Before(c.openUntil)
+}
+
+// table returns the name of the secondary table standing in for table. Tables
+// other than the primary sessions table, such as the revocation table, keep
+// their names.
+func (c *failoverClient) table(table *string) *string {
+	if c.secondaryTable == "" || aws.ToString(table) != c.primaryTable {
+		return table
+	}
+	return aws.String(c.secondaryTable)
+}
+
+// invoke sends a request to the primary or, when failed over, to the secondary
+func invoke[O any](c *failoverClient, primary func() (O, error), secondary func() (O, error)) (O, error) {
+	if !c.usePrimary() {
+		return secondary()
+	}
+
+	out, err := primary()
+	c.record(err)
+
+	return out, err
+}
+
+func (c *failoverClient) GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	return invoke(c, func() (*dynamodb.GetItemOutput, error) {
+		return c.primary.GetItem(ctx, params, optFns...)
+	}, func() (*dynamodb.GetItemOutput, error) {
+		in := *params
+		in.TableName = c.table(params.TableName)
+		return c.secondary.GetItem(ctx, &in, optFns...)
+	})
+}
+
+func (c *failoverClient) PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	return invoke(c, func() (*dynamodb.PutItemOutput, error) {
+		return c.primary.PutItem(ctx, params, optFns...)
+	}, func() (*dynamodb.PutItemOutput, error) {
+		in := *params
+		in.TableName = c.table(params.TableName)
+		return c.secondary.PutItem(ctx, &in, optFns...)
+	})
+}
+
+func (c *failoverClient) UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error) {
+	return invoke(c, func() (*dynamodb.UpdateItemOutput, error) {
+		return c.primary.UpdateItem(ctx, params, optFns...)
+	}, func() (*dynamodb.UpdateItemOutput, error) {
+		in := *params
+		in.TableName = c.table(params.TableName)
+		return c.secondary.UpdateItem(ctx, &in, optFns...)
+	})
+}
+
+func (c *failoverClient) DeleteItem(ctx context.Context, params *dynamodb.DeleteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error) {
+	return invoke(c, func() (*dynamodb.DeleteItemOutput, error) {
+		return c.primary.DeleteItem(ctx, params, optFns...)
+	}, func() (*dynamodb.DeleteItemOutput, error) {
+		in := *params
+		in.TableName = c.table(params.TableName)
+		return c.secondary.DeleteItem(ctx, &in, optFns...)
+	})
+}
+
+func (c *failoverClient) BatchWriteItem(ctx context.Context, params *dynamodb.BatchWriteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchWriteItemOutput, error) {
+	return invoke(c, func() (*dynamodb.BatchWriteItemOutput, error) {
+		return c.primary.BatchWriteItem(ctx, params, optFns...)
+	}, func() (*dynamodb.BatchWriteItemOutput, error) {
+		in := *params
+		in.RequestItems = make(map[string][]types.WriteRequest, len(params.RequestItems))
+		for table, requests := range params.RequestItems {
+			in.RequestItems[aws.ToString(c.table(aws.String(table)))] = requests
+		}
+
+		out, err := c.secondary.BatchWriteItem(ctx, &in, optFns...)
+		if err != nil || c.secondaryTable == "" {
+			return out, err
+		}
+
+		// Report unprocessed items under the name the caller used
+		if unprocessed, ok := out.UnprocessedItems[c.secondaryTable]; ok {
+			delete(out.UnprocessedItems, c.secondaryTable)
+			out.UnprocessedItems[c.primaryTable] = unprocessed
+		}
+
+		return out, nil
+	})
+}
+
+func (c *failoverClient) Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
+	return invoke(c, func() (*dynamodb.QueryOutput, error) {
+		return c.primary.Query(ctx, params, optFns...)
+	}, func() (*dynamodb.QueryOutput, error) {
+		in := *params
+		in.TableName = c.table(params.TableName)
+		return c.secondary.Query(ctx, &in, optFns...)
+	})
+}
+
+func (c *failoverClient) Scan(ctx context.Context, params *dynamodb.ScanInput, optFns ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error) {
+	return invoke(c, func() (*dynamodb.ScanOutput, error) {
+		return c.primary.Scan(ctx, params, optFns...)
+	}, func() (*dynamodb.ScanOutput, error) {
+		in := *params
+		in.TableName = c.table(params.TableName)
+		return c.secondary.Scan(ctx, &in, optFns...)
+	})
+}
+
+func (c *failoverClient) TransactWriteItems(ctx context.Context, params *dynamodb.TransactWriteItemsInput, optFns ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error) {
+	return invoke(c, func() (*dynamodb.TransactWriteItemsOutput, error) {
+		return c.primary.TransactWriteItems(ctx, params, optFns...)
+	}, func() (*dynamodb.TransactWriteItemsOutput, error) {
+		in := *params
+		in.TransactItems = make([]types.TransactWriteItem, len(params.TransactItems))
+		for i, item := range params.TransactItems {
+			if item.Put != nil {
+				put := *item.Put
+				put.TableName = c.table(put.TableName)
+				item.Put = &put
+			}
+			if item.Update != nil {
+				update := *item.Update
+				update.TableName = c.table(update.TableName)
+				item.Update = &update
+			}
+			if item.Delete != nil {
+				del := *item.Delete
+				del.TableName = c.table(del.TableName)
+				item.Delete = &del
+			}
+			if item.ConditionCheck != nil {
+				check := *item.ConditionCheck
+				check.TableName = c.table(check.TableName)
+				item.ConditionCheck = &check
+			}
+			in.TransactItems[i] = item
+		}
+		return c.secondary.TransactWriteItems(ctx, &in, optFns...)
+	})
+}
+
+func (c *failoverClient) CreateTable(ctx context.Context, params *dynamodb.CreateTableInput, optFns ...func(*dynamodb.Options)) (*dynamodb.CreateTableOutput, error) {
+	return invoke(c, func() (*dynamodb.CreateTableOutput, error) {
+		return c.primary.CreateTable(ctx, params, optFns...)
+	}, func() (*dynamodb.CreateTableOutput, error) {
+		in := *params
+		in.TableName = c.table(params.TableName)
+		return c.secondary.CreateTable(ctx, &in, optFns...)
+	})
+}
+
+func (c *failoverClient) DescribeTable(ctx context.Context, params *dynamodb.DescribeTableInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DescribeTableOutput, error) {
+	return invoke(c, func() (*dynamodb.DescribeTableOutput, error) {
+		return c.primary.DescribeTable(ctx, params, optFns...)
+	}, func() (*dynamodb.DescribeTableOutput, error) {
+		in := *params
+		in.TableName = c.table(params.TableName)
+		return c.secondary.DescribeTable(ctx, &in, optFns...)
+	})
+}
+
+func (c *failoverClient) DescribeTimeToLive(ctx context.Context, params *dynamodb.DescribeTimeToLiveInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DescribeTimeToLiveOutput, error) {
+	return invoke(c, func() (*dynamodb.DescribeTimeToLiveOutput, error) {
+		return c.primary.DescribeTimeToLive(ctx, params, optFns...)
+	}, func() (*dynamodb.DescribeTimeToLiveOutput, error) {
+		in := *params
+		in.TableName = c.table(params.TableName)
+		return c.secondary.DescribeTimeToLive(ctx, &in, optFns...)
+	})
+}
+
+func (c *failoverClient) UpdateTimeToLive(ctx context.Context, params *dynamodb.UpdateTimeToLiveInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateTimeToLiveOutput, error) {
+	return invoke(c, func() (*dynamodb.UpdateTimeToLiveOutput, error) {
+		return c.primary.UpdateTimeToLive(ctx, params, optFns...)
+	}, func() (*dynamodb.UpdateTimeToLiveOutput, error) {
+		in := *params
+		in.TableName = c.table(params.TableName)
+		return c.secondary.UpdateTimeToLive(ctx, &in, optFns...)
+	})
+}
+
+// FailedOver reports whether the store is currently using the secondary table
+// configured with WithFailover, e.g. for health endpoints and alerting
+func (store *Store) FailedOver() bool {
+	return store.failover != nil && store.failover.failedOver()
+}
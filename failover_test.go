@@ -0,0 +1,63 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+)
+
+func TestFailover(t *testing.T) {
+	now := time.Now()
+	primary := &outageDynamoDB{fakeDynamoDB: newFakeDynamoDB(), down: true}
+	secondary := newFakeDynamoDB()
+
+	store, _ := New(primary, MaxAge(3600), WithFailover(secondary, "sessions-dr", 2, time.Minute))
+	store.now = func() time.Time { return now }
+
+	persist := func(id string) error {
+		session := sessions.NewSession(store, "session")
+		session.ID = id
+		session.Options = store.newOptions()
+		return store.Persist(context.TODO(), "session", session)
+	}
+
+	for _, id := range []string{"a", "b"} {
+		if err := persist(id); err == nil {
+			t.Fatal("expected the primary to fail")
+		}
+	}
+	if !store.FailedOver() {
+		t.Fatal("expected the store to fail over after 2 failures")
+	}
+
+	if err := persist("c"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := secondary.others["sessions-dr"]["c"]; !ok {
+		t.Errorf("expected the session to be written to the secondary table; got %v", secondary.others)
+	}
+
+	primary.down = false
+	now = now.Add(2 * time.Minute)
+	if err := persist("d"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := primary.items["d"]; !ok || store.FailedOver() {
+		t.Error("expected the primary to take the traffic back once it recovers")
+	}
+}
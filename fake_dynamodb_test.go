@@ -0,0 +1,716 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"hash/fnv"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// fakeDynamoDB is a minimal in-memory DynamoDBClient keyed on a single string
+// partition key. It understands just enough of the expression syntax used by
+// Store to exercise it in unit tests.
+type fakeDynamoDB struct {
+	mu           sync.Mutex
+	primaryKey   string
+	items        map[string]map[string]types.AttributeValue
+	others       map[string]map[string]map[string]types.AttributeValue
+	ttl          *types.TimeToLiveDescription
+	table        *types.TableDescription
+	gets         int
+	transactions int
+	updates      int
+	puts         int
+	batches      int
+}
+
+func newFakeDynamoDB() *fakeDynamoDB {
+	return &fakeDynamoDB{
+		primaryKey: DefaultPrimaryKey,
+		items:      make(map[string]map[string]types.AttributeValue),
+		others:     make(map[string]map[string]map[string]types.AttributeValue),
+	}
+}
+
+// tableItems returns the items of the named table; items holds the default table
+func (f *fakeDynamoDB) tableItems(name *string) map[string]map[string]types.AttributeValue {
+	if n := aws.ToString(name); n != "" && n != DefaultTableName {
+		if _, ok := f.others[n]; !ok {
+			f.others[n] = make(map[string]map[string]types.AttributeValue)
+		}
+		return f.others[n]
+	}
+
+	return f.items
+}
+
+func (f *fakeDynamoDB) key(key map[string]types.AttributeValue) string {
+	return key[f.primaryKey].(*types.AttributeValueMemberS).Value
+}
+
+func (f *fakeDynamoDB) GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.gets++
+
+	return &dynamodb.GetItemOutput{Item: f.tableItems(params.TableName)[f.key(params.Key)]}, nil
+}
+
+func (f *fakeDynamoDB) PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.puts++
+
+	items := f.tableItems(params.TableName)
+	if err := f.checkCondition(params.ConditionExpression, params.ExpressionAttributeNames, params.ExpressionAttributeValues, items, f.key(params.Item)); err != nil {
+		return nil, err
+	}
+
+	items[f.key(params.Item)] = params.Item
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func (f *fakeDynamoDB) DeleteItem(ctx context.Context, params *dynamodb.DeleteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	items := f.tableItems(params.TableName)
+	if err := f.checkCondition(params.ConditionExpression, params.ExpressionAttributeNames, params.ExpressionAttributeValues, items, f.key(params.Key)); err != nil {
+		return nil, err
+	}
+
+	out := &dynamodb.DeleteItemOutput{}
+	if params.ReturnValues == types.ReturnValueAllOld {
+		out.Attributes = items[f.key(params.Key)]
+	}
+	delete(items, f.key(params.Key))
+	return out, nil
+}
+
+// checkCondition evaluates the condition expression of a write against the item
+// currently stored under id
+func (f *fakeDynamoDB) checkCondition(cond *string, names map[string]string, values map[string]types.AttributeValue, items map[string]map[string]types.AttributeValue, id string) error {
+	if aws.ToString(cond) == "" {
+		return nil
+	}
+
+	expr := expression{names: names, values: values}
+	item, ok := items[id]
+	matched, err := expr.condition(aws.ToString(cond), item, ok)
+	if err != nil {
+		return err
+	}
+	if !matched {
+		return &types.ConditionalCheckFailedException{Message: aws.String("The conditional request failed")}
+	}
+
+	return nil
+}
+
+func (f *fakeDynamoDB) UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.updates++
+
+	expr := expression{names: params.ExpressionAttributeNames, values: params.ExpressionAttributeValues}
+
+	items := f.tableItems(params.TableName)
+	id := f.key(params.Key)
+	item, ok := items[id]
+	if !ok {
+		item = map[string]types.AttributeValue{f.primaryKey: params.Key[f.primaryKey]}
+	}
+
+	if cond := aws.ToString(params.ConditionExpression); cond != "" {
+		matched, err := expr.condition(cond, item, ok)
+		if err != nil {
+			return nil, err
+		}
+		if !matched {
+			return nil, &types.ConditionalCheckFailedException{Message: aws.String("The conditional request failed")}
+		}
+	}
+
+	updated, err := expr.update(aws.ToString(params.UpdateExpression), maps.Clone(item))
+	if err != nil {
+		return nil, err
+	}
+	items[id] = updated
+
+	return &dynamodb.UpdateItemOutput{Attributes: updated}, nil
+}
+
+// expression evaluates the small subset of the dynamodb expression syntax used by Store
+type expression struct {
+	names  map[string]string
+	values map[string]types.AttributeValue
+}
+
+func (e expression) name(token string) string {
+	token = strings.TrimSpace(token)
+	if n, ok := e.names[token]; ok {
+		return n
+	}
+	return token
+}
+
+// operand resolves an attribute name, value placeholder or if_not_exists call
+func (e expression) operand(token string, item map[string]types.AttributeValue) types.AttributeValue {
+	token = strings.TrimSpace(token)
+	switch {
+	case strings.HasPrefix(token, ":"):
+		return e.values[token]
+	case strings.HasPrefix(token, "if_not_exists("):
+		args := splitTopLevel(strings.TrimSuffix(strings.TrimPrefix(token, "if_not_exists("), ")"), ',')
+		if v, ok := item[e.name(args[0])]; ok {
+			return v
+		}
+		return e.operand(args[1], item)
+	default:
+		return item[e.name(token)]
+	}
+}
+
+func (e expression) update(update string, item map[string]types.AttributeValue) (map[string]types.AttributeValue, error) {
+	keywords := []string{"SET", "ADD", "DELETE", "REMOVE"}
+
+	var sections [][2]string
+	fields := strings.Fields(update)
+	for _, field := range fields {
+		if slices.Contains(keywords, field) {
+			sections = append(sections, [2]string{field, ""})
+			continue
+		}
+		if len(sections) == 0 {
+			return nil, fmt.Errorf("fake: unsupported update expression %q", update)
+		}
+		sections[len(sections)-1][1] += " " + field
+	}
+
+	for _, section := range sections {
+		for _, clause := range splitTopLevel(section[1], ',') {
+			clause = strings.TrimSpace(clause)
+			switch section[0] {
+			case "SET":
+				target, value, ok := strings.Cut(clause, "=")
+				if !ok {
+					return nil, fmt.Errorf("fake: unsupported SET clause %q", clause)
+				}
+
+				if left, right, ok := strings.Cut(value, " + "); ok {
+					item[e.name(target)] = addNumbers(e.operand(left, item), e.operand(right, item), 1)
+				} else if left, right, ok := strings.Cut(value, " - "); ok {
+					item[e.name(target)] = addNumbers(e.operand(left, item), e.operand(right, item), -1)
+				} else {
+					item[e.name(target)] = e.operand(value, item)
+				}
+			case "ADD", "DELETE":
+				target, value, _ := strings.Cut(clause, " ")
+				name := e.name(target)
+				operand := e.operand(value, item)
+				switch v := operand.(type) {
+				case *types.AttributeValueMemberN:
+					if section[0] == "DELETE" {
+						return nil, fmt.Errorf("fake: DELETE requires a set")
+					}
+					item[name] = addNumbers(item[name], v, 1)
+				case *types.AttributeValueMemberSS:
+					existing, _ := item[name].(*types.AttributeValueMemberSS)
+					var set []string
+					if existing != nil {
+						set = slices.Clone(existing.Value)
+					}
+					for _, member := range v.Value {
+						if section[0] == "ADD" && !slices.Contains(set, member) {
+							set = append(set, member)
+						}
+						if section[0] == "DELETE" {
+							set = slices.DeleteFunc(set, func(s string) bool { return s == member })
+						}
+					}
+					if len(set) == 0 {
+						delete(item, name)
+					} else {
+						item[name] = &types.AttributeValueMemberSS{Value: set}
+					}
+				default:
+					return nil, fmt.Errorf("fake: unsupported %s operand %#v", section[0], operand)
+				}
+			case "REMOVE":
+				delete(item, e.name(clause))
+			}
+		}
+	}
+
+	return item, nil
+}
+
+// condition evaluates a condition expression made of OR and AND joined comparisons
+func (e expression) condition(cond string, item map[string]types.AttributeValue, exists bool) (bool, error) {
+	if !exists {
+		item = map[string]types.AttributeValue{}
+	}
+
+	for _, disjunct := range splitKeyword(cond, " OR ") {
+		matched := true
+		for _, atom := range splitKeyword(disjunct, " AND ") {
+			atom = strings.TrimSpace(atom)
+
+			var ok bool
+			var err error
+			if inner, wrapped := unwrapParens(atom); wrapped {
+				ok, err = e.condition(inner, item, true)
+			} else {
+				ok, err = e.atom(atom, item)
+			}
+			if err != nil {
+				return false, err
+			}
+			matched = matched && ok
+		}
+		if matched {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (e expression) atom(atom string, item map[string]types.AttributeValue) (bool, error) {
+	switch {
+	case strings.HasPrefix(atom, "attribute_exists("):
+		_, ok := item[e.name(strings.TrimSuffix(strings.TrimPrefix(atom, "attribute_exists("), ")"))]
+		return ok, nil
+	case strings.HasPrefix(atom, "attribute_not_exists("):
+		_, ok := item[e.name(strings.TrimSuffix(strings.TrimPrefix(atom, "attribute_not_exists("), ")"))]
+		return !ok, nil
+	case strings.HasPrefix(atom, "begins_with("):
+		name, prefix, _ := strings.Cut(strings.TrimSuffix(strings.TrimPrefix(atom, "begins_with("), ")"), ", ")
+		s, ok := item[e.name(name)].(*types.AttributeValueMemberS)
+		p, _ := e.operand(prefix, item).(*types.AttributeValueMemberS)
+		return ok && p != nil && strings.HasPrefix(s.Value, p.Value), nil
+	}
+
+	for _, op := range []string{"<=", ">=", "<>", "<", ">", "="} {
+		left, right, ok := strings.Cut(atom, " "+op+" ")
+		if !ok {
+			continue
+		}
+
+		var l types.AttributeValue
+		if strings.HasPrefix(left, "size(") {
+			l = &types.AttributeValueMemberN{Value: strconv.Itoa(size(item[e.name(strings.TrimSuffix(strings.TrimPrefix(left, "size("), ")"))]))}
+		} else {
+			l = e.operand(left, item)
+		}
+
+		return compare(l, e.operand(right, item), op), nil
+	}
+
+	return false, fmt.Errorf("fake: unsupported condition %q", atom)
+}
+
+func size(v types.AttributeValue) int {
+	switch t := v.(type) {
+	case *types.AttributeValueMemberSS:
+		return len(t.Value)
+	case *types.AttributeValueMemberL:
+		return len(t.Value)
+	case *types.AttributeValueMemberM:
+		return len(t.Value)
+	case *types.AttributeValueMemberS:
+		return len(t.Value)
+	case *types.AttributeValueMemberB:
+		return len(t.Value)
+	}
+	return 0
+}
+
+func compare(l, r types.AttributeValue, op string) bool {
+	var c int
+	switch lv := l.(type) {
+	case *types.AttributeValueMemberN:
+		rv, ok := r.(*types.AttributeValueMemberN)
+		if !ok {
+			return op == "<>"
+		}
+		a, _ := strconv.ParseFloat(lv.Value, 64)
+		b, _ := strconv.ParseFloat(rv.Value, 64)
+		c = cmp.Compare(a, b)
+	case *types.AttributeValueMemberS:
+		rv, ok := r.(*types.AttributeValueMemberS)
+		if !ok {
+			return op == "<>"
+		}
+		c = strings.Compare(lv.Value, rv.Value)
+	default:
+		return op == "<>"
+	}
+
+	switch op {
+	case "=":
+		return c == 0
+	case "<>":
+		return c != 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	default:
+		return c >= 0
+	}
+}
+
+func addNumbers(l, r types.AttributeValue, sign int) types.AttributeValue {
+	var a, b float64
+	if n, ok := l.(*types.AttributeValueMemberN); ok {
+		a, _ = strconv.ParseFloat(n.Value, 64)
+	}
+	if n, ok := r.(*types.AttributeValueMemberN); ok {
+		b, _ = strconv.ParseFloat(n.Value, 64)
+	}
+	return &types.AttributeValueMemberN{Value: strconv.FormatFloat(a+float64(sign)*b, 'f', -1, 64)}
+}
+
+// splitKeyword splits s on the keyword sep, ignoring keywords nested in parentheses
+func splitKeyword(s, sep string) []string {
+	var parts []string
+	var depth, start int
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '(':
+			depth++
+		case s[i] == ')':
+			depth--
+		case depth == 0 && strings.HasPrefix(s[i:], sep):
+			parts = append(parts, s[start:i])
+			start = i + len(sep)
+			i += len(sep) - 1
+		}
+	}
+
+	return append(parts, s[start:])
+}
+
+// unwrapParens returns s without its enclosing parentheses, if it is wrapped in a
+// single pair of them
+func unwrapParens(s string) (string, bool) {
+	if !strings.HasPrefix(s, "(") || !strings.HasSuffix(s, ")") {
+		return s, false
+	}
+
+	depth := 0
+	for i, r := range s {
+		switch r {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 && i != len(s)-1 {
+				return s, false
+			}
+		}
+	}
+
+	return s[1 : len(s)-1], true
+}
+
+// splitTopLevel splits s on sep, ignoring separators nested in parentheses
+func splitTopLevel(s string, sep rune) []string {
+	var parts []string
+	var depth, start int
+	for i, r := range s {
+		switch r {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case sep:
+			if depth == 0 {
+				parts = append(parts, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+
+	return append(parts, s[start:])
+}
+
+func (f *fakeDynamoDB) Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	expr := expression{names: params.ExpressionAttributeNames, values: params.ExpressionAttributeValues}
+
+	var out []map[string]types.AttributeValue
+	items := f.tableItems(params.TableName)
+	for _, id := range slices.Sorted(maps.Keys(items)) {
+		item := items[id]
+
+		matched, err := expr.condition(aws.ToString(params.KeyConditionExpression), item, true)
+		if err != nil {
+			return nil, err
+		}
+		if filter := aws.ToString(params.FilterExpression); matched && filter != "" {
+			matched, err = expr.condition(filter, item, true)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if matched {
+			out = append(out, item)
+		}
+	}
+
+	if start := params.ExclusiveStartKey; start != nil {
+		startID := f.key(start)
+		idx := slices.IndexFunc(out, func(item map[string]types.AttributeValue) bool { return f.key(item) == startID })
+		out = out[idx+1:]
+	}
+
+	result := &dynamodb.QueryOutput{}
+	if limit := int(aws.ToInt32(params.Limit)); limit > 0 && len(out) > limit {
+		out = out[:limit]
+		result.LastEvaluatedKey = map[string]types.AttributeValue{f.primaryKey: out[limit-1][f.primaryKey]}
+	}
+
+	result.Items = out
+	result.Count = int32(len(out))
+
+	return result, nil
+}
+
+func (f *fakeDynamoDB) Scan(ctx context.Context, params *dynamodb.ScanInput, optFns ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var out []map[string]types.AttributeValue
+	items := f.tableItems(params.TableName)
+	for _, id := range slices.Sorted(maps.Keys(items)) {
+		if total := aws.ToInt32(params.TotalSegments); total > 1 {
+			h := fnv.New32a()
+			h.Write([]byte(id))
+			if int32(h.Sum32()%uint32(total)) != aws.ToInt32(params.Segment) {
+				continue
+			}
+		}
+		out = append(out, items[id])
+	}
+
+	if start := params.ExclusiveStartKey; start != nil {
+		startID := f.key(start)
+		idx := slices.IndexFunc(out, func(item map[string]types.AttributeValue) bool { return f.key(item) == startID })
+		out = out[idx+1:]
+	}
+
+	result := &dynamodb.ScanOutput{}
+	if limit := int(aws.ToInt32(params.Limit)); limit > 0 && len(out) > limit {
+		out = out[:limit]
+		result.LastEvaluatedKey = map[string]types.AttributeValue{f.primaryKey: out[limit-1][f.primaryKey]}
+	}
+
+	if filter := aws.ToString(params.FilterExpression); filter != "" {
+		expr := expression{names: params.ExpressionAttributeNames, values: params.ExpressionAttributeValues}
+		out = slices.DeleteFunc(slices.Clone(out), func(item map[string]types.AttributeValue) bool {
+			matched, _ := expr.condition(filter, item, true)
+			return !matched
+		})
+	}
+
+	result.Items = out
+	result.Count = int32(len(out))
+
+	return result, nil
+}
+
+func (f *fakeDynamoDB) DescribeTimeToLive(ctx context.Context, params *dynamodb.DescribeTimeToLiveInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DescribeTimeToLiveOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	desc := f.ttl
+	if desc == nil {
+		desc = &types.TimeToLiveDescription{TimeToLiveStatus: types.TimeToLiveStatusDisabled}
+	}
+
+	return &dynamodb.DescribeTimeToLiveOutput{TimeToLiveDescription: desc}, nil
+}
+
+func (f *fakeDynamoDB) UpdateTimeToLive(ctx context.Context, params *dynamodb.UpdateTimeToLiveInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateTimeToLiveOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	status := types.TimeToLiveStatusDisabled
+	if aws.ToBool(params.TimeToLiveSpecification.Enabled) {
+		status = types.TimeToLiveStatusEnabled
+	}
+
+	f.ttl = &types.TimeToLiveDescription{
+		AttributeName:    params.TimeToLiveSpecification.AttributeName,
+		TimeToLiveStatus: status,
+	}
+
+	return &dynamodb.UpdateTimeToLiveOutput{TimeToLiveSpecification: params.TimeToLiveSpecification}, nil
+}
+
+func (f *fakeDynamoDB) CreateTable(ctx context.Context, params *dynamodb.CreateTableInput, optFns ...func(*dynamodb.Options)) (*dynamodb.CreateTableOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.table != nil {
+		return nil, &types.ResourceInUseException{Message: aws.String("Table already exists")}
+	}
+
+	f.table = &types.TableDescription{
+		TableName:            params.TableName,
+		TableStatus:          types.TableStatusActive,
+		AttributeDefinitions: params.AttributeDefinitions,
+		KeySchema:            params.KeySchema,
+		BillingModeSummary:   &types.BillingModeSummary{BillingMode: params.BillingMode},
+	}
+	for _, gsi := range params.GlobalSecondaryIndexes {
+		f.table.GlobalSecondaryIndexes = append(f.table.GlobalSecondaryIndexes, types.GlobalSecondaryIndexDescription{
+			IndexName:   gsi.IndexName,
+			KeySchema:   gsi.KeySchema,
+			Projection:  gsi.Projection,
+			IndexStatus: types.IndexStatusActive,
+		})
+	}
+
+	return &dynamodb.CreateTableOutput{TableDescription: f.table}, nil
+}
+
+func (f *fakeDynamoDB) DescribeTable(ctx context.Context, params *dynamodb.DescribeTableInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DescribeTableOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.table == nil {
+		return nil, &types.ResourceNotFoundException{Message: aws.String("Requested resource not found")}
+	}
+
+	return &dynamodb.DescribeTableOutput{Table: f.table}, nil
+}
+
+func (f *fakeDynamoDB) TransactWriteItems(ctx context.Context, params *dynamodb.TransactWriteItemsInput, optFns ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.transactions++
+
+	// transactions are all or nothing: check every condition first
+	reasons := make([]types.CancellationReason, len(params.TransactItems))
+	canceled := false
+	for i, item := range params.TransactItems {
+		reasons[i].Code = aws.String("None")
+
+		var (
+			table, cond *string
+			key         map[string]types.AttributeValue
+			expr        expression
+		)
+		switch {
+		case item.Update != nil:
+			table, cond, key = item.Update.TableName, item.Update.ConditionExpression, item.Update.Key
+			expr = expression{names: item.Update.ExpressionAttributeNames, values: item.Update.ExpressionAttributeValues}
+		case item.Put != nil:
+			table, cond, key = item.Put.TableName, item.Put.ConditionExpression, item.Put.Item
+			expr = expression{names: item.Put.ExpressionAttributeNames, values: item.Put.ExpressionAttributeValues}
+		case item.Delete != nil:
+			table, cond, key = item.Delete.TableName, item.Delete.ConditionExpression, item.Delete.Key
+			expr = expression{names: item.Delete.ExpressionAttributeNames, values: item.Delete.ExpressionAttributeValues}
+		}
+		if cond == nil {
+			continue
+		}
+
+		current, ok := f.tableItems(table)[f.key(key)]
+		matched, err := expr.condition(aws.ToString(cond), current, ok)
+		if err != nil {
+			return nil, err
+		}
+		if !matched {
+			reasons[i].Code = aws.String("ConditionalCheckFailed")
+			canceled = true
+		}
+	}
+	if canceled {
+		return nil, &types.TransactionCanceledException{Message: aws.String("Transaction cancelled"), CancellationReasons: reasons}
+	}
+
+	for _, item := range params.TransactItems {
+		switch {
+		case item.Update != nil:
+			expr := expression{names: item.Update.ExpressionAttributeNames, values: item.Update.ExpressionAttributeValues}
+			items := f.tableItems(item.Update.TableName)
+			id := f.key(item.Update.Key)
+			current, ok := items[id]
+			if !ok {
+				current = map[string]types.AttributeValue{f.primaryKey: item.Update.Key[f.primaryKey]}
+			}
+			updated, err := expr.update(aws.ToString(item.Update.UpdateExpression), maps.Clone(current))
+			if err != nil {
+				return nil, err
+			}
+			items[id] = updated
+		case item.Put != nil:
+			f.tableItems(item.Put.TableName)[f.key(item.Put.Item)] = item.Put.Item
+		case item.Delete != nil:
+			delete(f.tableItems(item.Delete.TableName), f.key(item.Delete.Key))
+		default:
+			return nil, fmt.Errorf("fake: unsupported transact item %#v", item)
+		}
+	}
+
+	return &dynamodb.TransactWriteItemsOutput{}, nil
+}
+
+func (f *fakeDynamoDB) BatchWriteItem(ctx context.Context, params *dynamodb.BatchWriteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchWriteItemOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.batches++
+
+	for table, requests := range params.RequestItems {
+		items := f.tableItems(aws.String(table))
+		if len(requests) > 25 {
+			return nil, fmt.Errorf("fake: too many items in batch")
+		}
+
+		for _, request := range requests {
+			switch {
+			case request.PutRequest != nil:
+				items[f.key(request.PutRequest.Item)] = request.PutRequest.Item
+			case request.DeleteRequest != nil:
+				delete(items, f.key(request.DeleteRequest.Key))
+			}
+		}
+	}
+
+	return &dynamodb.BatchWriteItemOutput{}, nil
+}
@@ -0,0 +1,145 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+)
+
+// fallbackSuffix is appended to the session cookie name to name the cookie
+// carrying a degraded session
+const fallbackSuffix = "-fallback"
+
+// degradedKey flags a session held in a fallback cookie rather than dynamodb
+type degradedKey struct{}
+
+// cookieFallback holds the codecs of the cookies written by WithCookieFallback
+type cookieFallback struct {
+	codecs []securecookie.Codec
+}
+
+// fallbackPayload is the content of a fallback cookie
+type fallbackPayload struct {
+	ID     string         `json:"id"`
+	Values map[string]any `json:"values"`
+}
+
+// Degraded reports whether the session is held in a fallback cookie because
+// dynamodb could not be reached (see WithCookieFallback)
+func Degraded(session *sessions.Session) bool {
+	degraded, _ := session.Values[degradedKey{}].(bool)
+	return degraded
+}
+
+// fallbackEnabled reports whether sessions fall back to cookies on an outage
+func (store *Store) fallbackEnabled() bool {
+	return store.fallback != nil && store.bearerHeader == ""
+}
+
+// storeOutage reports whether err means dynamodb couldn't be used, as opposed to
+// the session being refused
+func storeOutage(err error) bool {
+	var ccf *types.ConditionalCheckFailedException
+	var verr *ValidationError
+
+	switch {
+	case err == nil,
+		errors.Is(err, context.Canceled),
+		errors.Is(err, ErrTooManySessions),
+		errors.Is(err, ErrSessionTooLarge),
+		errors.Is(err, errNoEncryptionKey),
+		errors.As(err, &ccf),
+		errors.As(err, &verr):
+		return false
+	default:
+		return true
+	}
+}
+
+// loadFallback returns the degraded session held in the fallback cookie of the
+// request, if any
+func (store *Store) loadFallback(req *http.Request, name string) (*sessions.Session, bool) {
+	cookie, err := req.Cookie(name + fallbackSuffix)
+	if err != nil {
+		return nil, false
+	}
+
+	var payload fallbackPayload
+	if err := securecookie.DecodeMulti(name+fallbackSuffix, cookie.Value, &payload, store.fallback.codecs...); err != nil {
+		return nil, false
+	}
+	if !store.validID(payload.ID) {
+		return nil, false
+	}
+
+	s := sessions.NewSession(store, name)
+	s.ID = payload.ID
+	s.Options = store.newOptions()
+	setValues(s, payload.Values)
+	s.Values[degradedKey{}] = true
+
+	return s, true
+}
+
+// saveFallback writes the session to its fallback cookie. Only string keyed
+// values are kept; the deadlines of values and client bindings are lost.
+func (store *Store) saveFallback(w http.ResponseWriter, session *sessions.Session, cause error) error {
+	values := convertToMapStringAny(session.Values)
+	delete(values, store.primaryKey)
+
+	name := session.Name() + fallbackSuffix
+	value, err := securecookie.EncodeMulti(name, fallbackPayload{ID: session.ID, Values: values}, store.fallback.codecs...)
+	if err != nil {
+		return fmt.Errorf("failed to fall back to a cookie after %w: %v", cause, err)
+	}
+	if len(value) > maxCookieValueLength {
+		return fmt.Errorf("session too large to fall back to a cookie: %w", cause)
+	}
+
+	http.SetCookie(w, newCookie(session.Options, name, value))
+	session.Values[degradedKey{}] = true
+
+	return nil
+}
+
+// clearFallback expires the fallback cookie presented with the request, once the
+// session it held has been written to dynamodb, and hands the client the cookie
+// of the session instead
+func (store *Store) clearFallback(req *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	if _, err := req.Cookie(session.Name() + fallbackSuffix); err != nil {
+		return nil
+	}
+
+	expired := *store.newOptions()
+	expired.MaxAge = -1
+	http.SetCookie(w, newCookie(&expired, session.Name()+fallbackSuffix, ""))
+
+	if !Degraded(session) {
+		return nil
+	}
+	delete(session.Values, degradedKey{})
+
+	if session.Options != nil && session.Options.MaxAge < 0 {
+		return nil
+	}
+
+	return store.setCookie(req.Context(), w, session)
+}
@@ -0,0 +1,89 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/gorilla/securecookie"
+)
+
+type outageDynamoDB struct {
+	*fakeDynamoDB
+	down bool
+}
+
+func (o *outageDynamoDB) PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	if o.down {
+		return nil, fmt.Errorf("service unavailable")
+	}
+	return o.fakeDynamoDB.PutItem(ctx, params, optFns...)
+}
+
+func TestCookieFallback(t *testing.T) {
+	ddb := &outageDynamoDB{fakeDynamoDB: newFakeDynamoDB(), down: true}
+	store, _ := New(ddb, MaxAge(3600), WithCookieFallback(securecookie.GenerateRandomKey(32)))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	session, _ := store.New(req, "session")
+	session.Values["user_id"] = "bob"
+
+	w := httptest.NewRecorder()
+	if err := store.Save(req, w, session); err != nil {
+		t.Fatalf("expected the session to fall back to a cookie; got %v", err)
+	}
+	if !Degraded(session) {
+		t.Error("expected the session to be flagged as degraded")
+	}
+
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != "session"+fallbackSuffix {
+		t.Fatalf("expected only the fallback cookie; got %v", cookies)
+	}
+
+	next := httptest.NewRequest(http.MethodGet, "/", nil)
+	next.AddCookie(cookies[0])
+	loaded, _ := store.New(next, "session")
+	if !Degraded(loaded) || loaded.Values["user_id"] != "bob" || loaded.ID != session.ID {
+		t.Fatalf("expected the degraded session to be recovered; got %v", loaded.Values)
+	}
+
+	ddb.down = false
+	w = httptest.NewRecorder()
+	if err := store.Save(next, w, loaded); err != nil {
+		t.Fatal(err)
+	}
+	if Degraded(loaded) {
+		t.Error("expected the session to be healthy once saved to dynamodb")
+	}
+	if _, ok := ddb.items[session.ID]; !ok {
+		t.Error("expected the session to be persisted")
+	}
+
+	names := map[string]int{}
+	for _, c := range w.Result().Cookies() {
+		names[c.Name] = c.MaxAge
+	}
+	if age, ok := names["session"+fallbackSuffix]; !ok || age >= 0 {
+		t.Errorf("expected the fallback cookie to be expired; got %v", names)
+	}
+	if _, ok := names["session"]; !ok {
+		t.Errorf("expected the session cookie to be set; got %v", names)
+	}
+}
@@ -0,0 +1,54 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"context"
+
+	"github.com/gorilla/sessions"
+)
+
+// SessionHook is called with a session around store operations, see
+// WithBeforeSave and WithAfterLoad. Returning an error fails the operation.
+type SessionHook func(ctx context.Context, session *sessions.Session) error
+
+// DeleteHook is called with the id of a deleted session, see WithOnDelete
+type DeleteHook func(ctx context.Context, id string) error
+
+// hooks holds the lifecycle hooks registered with the store, run in order
+type hooks struct {
+	beforeSave []SessionHook
+	afterLoad  []SessionHook
+	onDelete   []DeleteHook
+}
+
+func runSessionHooks(ctx context.Context, fns []SessionHook, session *sessions.Session) error {
+	for _, fn := range fns {
+		if err := fn(ctx, session); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func runDeleteHooks(ctx context.Context, fns []DeleteHook, id string) error {
+	for _, fn := range fns {
+		if err := fn(ctx, id); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
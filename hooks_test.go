@@ -0,0 +1,69 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/gorilla/sessions"
+)
+
+func TestHooks(t *testing.T) {
+	ctx := context.TODO()
+
+	var deleted []string
+	store, _ := New(newFakeDynamoDB(), MaxAge(3600),
+		WithBeforeSave(func(ctx context.Context, session *sessions.Session) error {
+			session.Values["tenant"] = "acme"
+			return nil
+		}, func(ctx context.Context, session *sessions.Session) error {
+			if session.Values["user_id"] == "mallory" {
+				return fmt.Errorf("banned")
+			}
+			return nil
+		}),
+		WithAfterLoad(func(ctx context.Context, session *sessions.Session) error {
+			session.Values["loaded"] = true
+			return nil
+		}),
+		WithOnDelete(func(ctx context.Context, id string) error {
+			deleted = append(deleted, id)
+			return nil
+		}),
+	)
+
+	persistUserSessions(t, store, "bob", 1)
+
+	session := sessions.NewSession(store, "session")
+	if err := store.Load(ctx, "bob-0", session); err != nil {
+		t.Fatal(err)
+	}
+	if session.Values["tenant"] != "acme" || session.Values["loaded"] != true {
+		t.Errorf("expected the hooks to enrich the session; got %v", session.Values)
+	}
+
+	session.Values["user_id"] = "mallory"
+	if err := store.Persist(ctx, "session", session); err == nil {
+		t.Error("expected BeforeSave errors to abort the write")
+	}
+
+	if err := store.Delete(ctx, "bob-0"); err != nil {
+		t.Fatal(err)
+	}
+	if len(deleted) != 1 || deleted[0] != "bob-0" {
+		t.Errorf("expected OnDelete to be called; got %v", deleted)
+	}
+}
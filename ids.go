@@ -0,0 +1,210 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// maxGeneratedIDLength bounds the ids minted by a WithIDGenerator generator
+	maxGeneratedIDLength = 128
+
+	// minIDBytes and maxIDBytes bound the random bytes of ids set with WithIDLength
+	minIDBytes = 16
+	maxIDBytes = 64
+)
+
+var (
+	errIDTooShort            = fmt.Errorf("session ids must hold at least %d random bytes", minIDBytes)
+	errIDTooLong             = fmt.Errorf("session ids must hold at most %d random bytes", maxIDBytes)
+	errIDFormatWithGenerator = fmt.Errorf("WithIDLength and WithIDEncoding can't be combined with WithIDGenerator")
+)
+
+// IDEncoding is the encoding of the random bytes of session ids
+type IDEncoding int
+
+const (
+	// IDEncodingBase32 is unpadded standard base32, the default
+	IDEncodingBase32 IDEncoding = iota
+
+	// IDEncodingBase64URL is unpadded url safe base64
+	IDEncodingBase64URL
+
+	// IDEncodingHex is lower case hexadecimal
+	IDEncodingHex
+)
+
+// idFormat describes the ids minted by the store: a number of random bytes and
+// their encoding
+type idFormat struct {
+	bytes    int
+	encoding IDEncoding
+}
+
+// defaultIDFormat is 32 random bytes in unpadded base32, 52 characters
+var defaultIDFormat = idFormat{bytes: 32, encoding: IDEncodingBase32}
+
+func (f idFormat) validate() error {
+	switch {
+	case f.bytes < minIDBytes:
+		return errIDTooShort
+	case f.bytes > maxIDBytes:
+		return errIDTooLong
+	case f.encoding < IDEncodingBase32 || f.encoding > IDEncodingHex:
+		return fmt.Errorf("unknown session id encoding %d", f.encoding)
+	}
+
+	return nil
+}
+
+// new returns a new random id
+func (f idFormat) new() string {
+	b := make([]byte, f.bytes)
+	randomize(b)
+
+	switch f.encoding {
+	case IDEncodingBase64URL:
+		return base64.RawURLEncoding.EncodeToString(b)
+	case IDEncodingHex:
+		return hex.EncodeToString(b)
+	default:
+		return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b)
+	}
+}
+
+// valid reports whether id has the length and alphabet of the ids returned by new
+func (f idFormat) valid(id string) bool {
+	var length int
+	var allowed func(c byte) bool
+
+	switch f.encoding {
+	case IDEncodingBase64URL:
+		length = base64.RawURLEncoding.EncodedLen(f.bytes)
+		allowed = func(c byte) bool {
+			return c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '_'
+		}
+	case IDEncodingHex:
+		length = hex.EncodedLen(f.bytes)
+		allowed = func(c byte) bool {
+			return c >= '0' && c <= '9' || c >= 'a' && c <= 'f'
+		}
+	default:
+		length = base32.StdEncoding.WithPadding(base32.NoPadding).EncodedLen(f.bytes)
+		allowed = func(c byte) bool {
+			return c >= 'A' && c <= 'Z' || c >= '2' && c <= '7'
+		}
+	}
+
+	if len(id) != length {
+		return false
+	}
+	for _, c := range []byte(id) {
+		if !allowed(c) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// crockford is the Crockford base32 alphabet ULIDs are encoded with
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// ULID returns a new ULID: a 48 bit millisecond timestamp and 80 random bits in
+// 26 characters of Crockford base32, sorting by creation time
+func ULID() string {
+	var b [16]byte
+	timestamp(b[:6])
+	randomize(b[6:])
+
+	// 26 characters hold 130 bits, the first two of which are zero
+	var out [26]byte
+	for i := range out {
+		var v byte
+		for j := 0; j < 5; j++ {
+			v <<= 1
+			if bit := i*5 + j - 2; bit >= 0 && b[bit/8]&(0x80>>(bit%8)) != 0 {
+				v |= 1
+			}
+		}
+		out[i] = crockford[v]
+	}
+
+	return string(out[:])
+}
+
+// UUIDv7 returns a new version 7 UUID: a 48 bit millisecond timestamp and 74
+// random bits in the canonical hyphenated form, sorting by creation time
+func UUIDv7() string {
+	var b uuid.UUID
+	timestamp(b[:6])
+	randomize(b[6:])
+
+	b[6] = 0x70 | b[6]&0x0f
+	b[8] = 0x80 | b[8]&0x3f
+
+	return b.String()
+}
+
+// timestamp writes the current unix time in milliseconds to the 6 bytes of b
+func timestamp(b []byte) {
+	var ms [8]byte
+	binary.BigEndian.PutUint64(ms[:], uint64(time.Now().UnixMilli()))
+	copy(b, ms[2:])
+}
+
+func randomize(b []byte) {
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+}
+
+// newID returns a new session id from the generator set with WithIDGenerator,
+// or of the format set with WithIDLength and WithIDEncoding
+func (store *Store) newID() string {
+	if store.idGenerator != nil {
+		return store.idGenerator()
+	}
+
+	return store.idFormat.new()
+}
+
+// validID reports whether id could have been minted by the store. With a
+// generator set by WithIDGenerator any id of up to 128 letters, digits, '-', '_'
+// and '.' is accepted, which includes the default ids.
+func (store *Store) validID(id string) bool {
+	if store.idGenerator == nil {
+		return store.idFormat.valid(id)
+	}
+
+	if len(id) == 0 || len(id) > maxGeneratedIDLength {
+		return false
+	}
+	for _, c := range []byte(id) {
+		if (c < 'A' || c > 'Z') && (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '-' && c != '_' && c != '.' {
+			return false
+		}
+	}
+
+	return true
+}
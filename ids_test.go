@@ -0,0 +1,154 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestULID(t *testing.T) {
+	format := regexp.MustCompile(`^[0-7][0-9A-HJKMNP-TV-Z]{25}$`)
+
+	first := ULID()
+	time.Sleep(2 * time.Millisecond)
+	second := ULID()
+
+	for _, id := range []string{first, second} {
+		if !format.MatchString(id) {
+			t.Errorf("expected a ULID; got %q", id)
+		}
+	}
+	if first >= second {
+		t.Errorf("expected ULIDs to sort by creation time; got %v then %v", first, second)
+	}
+}
+
+func TestUUIDv7(t *testing.T) {
+	first := UUIDv7()
+	time.Sleep(2 * time.Millisecond)
+	second := UUIDv7()
+
+	for _, id := range []string{first, second} {
+		parsed, err := uuid.Parse(id)
+		if err != nil || parsed.Version() != 7 || parsed.Variant() != uuid.RFC4122 {
+			t.Errorf("expected a version 7 UUID; got %q, %v", id, err)
+		}
+	}
+	if first >= second {
+		t.Errorf("expected UUIDs to sort by creation time; got %v then %v", first, second)
+	}
+}
+
+func TestIDGenerator(t *testing.T) {
+	store, _ := New(newFakeDynamoDB(), MaxAge(3600), WithIDGenerator(UUIDv7))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	session, _ := store.New(req, "session")
+	if _, err := uuid.Parse(session.ID); err != nil {
+		t.Fatalf("expected a generated id; got %q", session.ID)
+	}
+
+	w := httptest.NewRecorder()
+	if err := store.Save(req, w, session); err != nil {
+		t.Fatal(err)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(w.Result().Cookies()[0])
+	if loaded, err := store.New(req, "session"); err != nil || loaded.ID != session.ID {
+		t.Errorf("expected the session to load; got %v", err)
+	}
+
+	testCases := map[string]struct {
+		id    string
+		valid bool
+	}{
+		"default":  {id: defaultIDFormat.new(), valid: true},
+		"ulid":     {id: ULID(), valid: true},
+		"empty":    {id: ""},
+		"reserved": {id: "ping#"},
+		"long":     {id: string(make([]byte, maxGeneratedIDLength+1))},
+	}
+
+	for label, tc := range testCases {
+		t.Run(label, func(t *testing.T) {
+			if got := store.validID(tc.id); got != tc.valid {
+				t.Errorf("expected %v; got %v", tc.valid, got)
+			}
+		})
+	}
+}
+
+func TestIDFormat(t *testing.T) {
+	testCases := map[string]struct {
+		opts   []Option
+		length int
+		err    error
+	}{
+		"default":   {length: 52},
+		"base64url": {opts: []Option{WithIDEncoding(IDEncodingBase64URL)}, length: 43},
+		"hex":       {opts: []Option{WithIDLength(16), WithIDEncoding(IDEncodingHex)}, length: 32},
+		"long":      {opts: []Option{WithIDLength(64)}, length: 103},
+		"too short": {opts: []Option{WithIDLength(8)}, err: errIDTooShort},
+		"too long":  {opts: []Option{WithIDLength(65)}, err: errIDTooLong},
+		"generator": {opts: []Option{WithIDLength(24), WithIDGenerator(ULID)}, err: errIDFormatWithGenerator},
+	}
+
+	for label, tc := range testCases {
+		t.Run(label, func(t *testing.T) {
+			store, err := New(newFakeDynamoDB(), tc.opts...)
+			if err != tc.err {
+				t.Fatalf("expected %v; got %v", tc.err, err)
+			}
+			if err != nil {
+				return
+			}
+
+			id := store.newID()
+			if len(id) != tc.length || !store.validID(id) {
+				t.Errorf("expected a valid id of %d characters; got %q", tc.length, id)
+			}
+			if store.validID(id[1:]) {
+				t.Errorf("expected a truncated id to be rejected")
+			}
+		})
+	}
+
+	store, _ := New(newFakeDynamoDB(), WithIDEncoding(IDEncodingHex))
+	if store.validID(defaultIDFormat.new()) {
+		t.Error("expected ids of another encoding to be rejected")
+	}
+	if _, err := New(newFakeDynamoDB(), WithIDEncoding(IDEncoding(9))); err == nil {
+		t.Error("expected an unknown encoding to be rejected")
+	}
+}
+
+func TestIDFormatOfOtherIDs(t *testing.T) {
+	store, _ := New(newFakeDynamoDB(), WithIDLength(16), WithIDEncoding(IDEncodingHex), WithUserIndex("user-index", "user_id"))
+
+	erasure, err := store.EraseUser(context.TODO(), "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !store.validID(erasure.ID) {
+		t.Errorf("expected ids minted by the store to follow its format; got %v", erasure.ID)
+	}
+}
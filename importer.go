@@ -0,0 +1,134 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"iter"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/gorilla/sessions"
+)
+
+// ImportRecord is a session read from another store, such as a redis key written
+// by redistore
+type ImportRecord struct {
+	// ID is the session id, preserved so cookies issued by the other store
+	// remain valid
+	ID string
+
+	// Data is the serialized session values
+	Data []byte
+
+	// ExpiresAt is when the session expires, e.g. from the ttl of the redis
+	// key. The zero time gives the session the default lifetime.
+	ExpiresAt time.Time
+}
+
+// SessionDeserializer decodes the values of sessions serialized by another
+// gorilla store. The serializers of redistore satisfy it, as do GobSerializer
+// and JSONSerializer.
+type SessionDeserializer interface {
+	Deserialize(d []byte, session *sessions.Session) error
+}
+
+// GobSerializer decodes values serialized with encoding/gob, the default of
+// redistore and boltstore. Types stored in the values must be registered.
+type GobSerializer struct{}
+
+// Deserialize decodes gob encoded session values
+func (GobSerializer) Deserialize(d []byte, session *sessions.Session) error {
+	return gob.NewDecoder(bytes.NewReader(d)).Decode(&session.Values)
+}
+
+// JSONSerializer decodes values serialized as a JSON object
+type JSONSerializer struct{}
+
+// Deserialize decodes JSON encoded session values
+func (JSONSerializer) Deserialize(d []byte, session *sessions.Session) error {
+	values := map[string]any{}
+	if err := json.Unmarshal(d, &values); err != nil {
+		return err
+	}
+	setValues(session, values)
+
+	return nil
+}
+
+// Import writes the sessions of records into the table in the format of the
+// store, for moving off another gorilla store. Ids and expiries are preserved;
+// sessions already expired are skipped. name is the session name the values
+// are decoded under. The number of sessions imported is returned, along with the
+// first error from records, deserializer or dynamodb.
+func (store *Store) Import(ctx context.Context, name string, records iter.Seq2[ImportRecord, error], deserializer SessionDeserializer) (int, error) {
+	store = store.scoped(ctx)
+
+	var requests []types.WriteRequest
+	imported := 0
+
+	flush := func() error {
+		if err := store.batchWrite(ctx, requests); err != nil {
+			return err
+		}
+		imported += len(requests)
+		requests = requests[:0]
+		return nil
+	}
+
+	for record, err := range records {
+		if err != nil {
+			return imported, err
+		}
+		if !record.ExpiresAt.IsZero() && !store.now().Before(record.ExpiresAt) {
+			continue
+		}
+
+		session := sessions.NewSession(store, name)
+		session.ID = record.ID
+		session.Options = store.newOptions()
+		if err := deserializer.Deserialize(record.Data, session); err != nil {
+			return imported, fmt.Errorf("failed to deserialize session %s: %w", record.ID, err)
+		}
+
+		item, err := store.marshalSession(ctx, session)
+		if err != nil {
+			return imported, err
+		}
+		if !record.ExpiresAt.IsZero() && store.enableTTL {
+			item[DefaultTTLField] = &types.AttributeValueMemberN{Value: strconv.FormatInt(record.ExpiresAt.Unix(), 10)}
+			if store.writeExpiresAt {
+				item[ExpiresAtField] = &types.AttributeValueMemberS{Value: record.ExpiresAt.UTC().Format(time.RFC3339)}
+			}
+		}
+
+		requests = append(requests, types.WriteRequest{PutRequest: &types.PutRequest{Item: item}})
+		if len(requests) == maxBatchWriteItems {
+			if err := flush(); err != nil {
+				return imported, err
+			}
+		}
+	}
+
+	if err := flush(); err != nil {
+		return imported, err
+	}
+
+	return imported, nil
+}
@@ -0,0 +1,77 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+)
+
+func TestImport(t *testing.T) {
+	ctx := context.TODO()
+	now := time.Now()
+
+	ddb := newFakeDynamoDB()
+	store, _ := New(ddb, TTLEnabled(), MaxAge(3600))
+
+	encode := func(values map[any]any) []byte {
+		var buf bytes.Buffer
+		if err := gob.NewEncoder(&buf).Encode(values); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+
+	records := func(yield func(ImportRecord, error) bool) {
+		for i := 0; i < 30; i++ {
+			record := ImportRecord{
+				ID:        fmt.Sprintf("session-%d", i),
+				Data:      encode(map[any]any{"user_id": fmt.Sprintf("user-%d", i)}),
+				ExpiresAt: now.Add(10 * time.Minute),
+			}
+			if i == 0 {
+				record.ExpiresAt = now.Add(-time.Minute)
+			}
+			if !yield(record, nil) {
+				return
+			}
+		}
+	}
+
+	n, err := store.Import(ctx, "session", records, GobSerializer{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 29 || len(ddb.items) != 29 {
+		t.Errorf("expected the 29 live sessions to be imported; got %v, %v", n, len(ddb.items))
+	}
+
+	if got := attributeTime(ddb.items["session-1"][DefaultTTLField]); got.Unix() != now.Add(10*time.Minute).Unix() {
+		t.Errorf("expected the expiry to be preserved; got %v", got)
+	}
+
+	session := sessions.NewSession(store, "session")
+	if err := store.Load(ctx, "session-1", session); err != nil {
+		t.Fatal(err)
+	}
+	if session.Values["user_id"] != "user-1" {
+		t.Errorf("expected the values to be imported; got %v", session.Values)
+	}
+}
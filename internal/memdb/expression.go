@@ -0,0 +1,323 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package memdb
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// expression evaluates the subset of the dynamodb expression syntax used by
+// dynastore.Store
+type expression struct {
+	names  map[string]string
+	values map[string]types.AttributeValue
+}
+
+func (e expression) name(token string) string {
+	token = strings.TrimSpace(token)
+	if n, ok := e.names[token]; ok {
+		return n
+	}
+	return token
+}
+
+// operand resolves an attribute name, value placeholder or if_not_exists call
+func (e expression) operand(token string, item map[string]types.AttributeValue) types.AttributeValue {
+	token = strings.TrimSpace(token)
+	switch {
+	case strings.HasPrefix(token, ":"):
+		return e.values[token]
+	case strings.HasPrefix(token, "if_not_exists("):
+		args := splitTopLevel(strings.TrimSuffix(strings.TrimPrefix(token, "if_not_exists("), ")"), ',')
+		if v, ok := item[e.name(args[0])]; ok {
+			return v
+		}
+		return e.operand(args[1], item)
+	default:
+		return item[e.name(token)]
+	}
+}
+
+func (e expression) update(update string, item map[string]types.AttributeValue) (map[string]types.AttributeValue, error) {
+	keywords := []string{"SET", "ADD", "DELETE", "REMOVE"}
+
+	var sections [][2]string
+	fields := strings.Fields(update)
+	for _, field := range fields {
+		if slices.Contains(keywords, field) {
+			sections = append(sections, [2]string{field, ""})
+			continue
+		}
+		if len(sections) == 0 {
+			return nil, fmt.Errorf("memdb: unsupported update expression %q", update)
+		}
+		sections[len(sections)-1][1] += " " + field
+	}
+
+	for _, section := range sections {
+		for _, clause := range splitTopLevel(section[1], ',') {
+			clause = strings.TrimSpace(clause)
+			switch section[0] {
+			case "SET":
+				target, value, ok := strings.Cut(clause, "=")
+				if !ok {
+					return nil, fmt.Errorf("memdb: unsupported SET clause %q", clause)
+				}
+
+				if left, right, ok := strings.Cut(value, " + "); ok {
+					item[e.name(target)] = addNumbers(e.operand(left, item), e.operand(right, item), 1)
+				} else if left, right, ok := strings.Cut(value, " - "); ok {
+					item[e.name(target)] = addNumbers(e.operand(left, item), e.operand(right, item), -1)
+				} else {
+					item[e.name(target)] = e.operand(value, item)
+				}
+			case "ADD", "DELETE":
+				target, value, _ := strings.Cut(clause, " ")
+				name := e.name(target)
+				operand := e.operand(value, item)
+				switch v := operand.(type) {
+				case *types.AttributeValueMemberN:
+					if section[0] == "DELETE" {
+						return nil, fmt.Errorf("memdb: DELETE requires a set")
+					}
+					item[name] = addNumbers(item[name], v, 1)
+				case *types.AttributeValueMemberSS:
+					existing, _ := item[name].(*types.AttributeValueMemberSS)
+					var set []string
+					if existing != nil {
+						set = slices.Clone(existing.Value)
+					}
+					for _, member := range v.Value {
+						if section[0] == "ADD" && !slices.Contains(set, member) {
+							set = append(set, member)
+						}
+						if section[0] == "DELETE" {
+							set = slices.DeleteFunc(set, func(s string) bool { return s == member })
+						}
+					}
+					if len(set) == 0 {
+						delete(item, name)
+					} else {
+						item[name] = &types.AttributeValueMemberSS{Value: set}
+					}
+				default:
+					return nil, fmt.Errorf("memdb: unsupported %s operand %#v", section[0], operand)
+				}
+			case "REMOVE":
+				delete(item, e.name(clause))
+			}
+		}
+	}
+
+	return item, nil
+}
+
+// condition evaluates a condition expression made of OR and AND joined comparisons
+func (e expression) condition(cond string, item map[string]types.AttributeValue, exists bool) (bool, error) {
+	if !exists {
+		item = map[string]types.AttributeValue{}
+	}
+
+	for _, disjunct := range splitKeyword(cond, " OR ") {
+		matched := true
+		for _, atom := range splitKeyword(disjunct, " AND ") {
+			atom = strings.TrimSpace(atom)
+
+			var ok bool
+			var err error
+			if inner, wrapped := unwrapParens(atom); wrapped {
+				ok, err = e.condition(inner, item, true)
+			} else {
+				ok, err = e.atom(atom, item)
+			}
+			if err != nil {
+				return false, err
+			}
+			matched = matched && ok
+		}
+		if matched {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (e expression) atom(atom string, item map[string]types.AttributeValue) (bool, error) {
+	switch {
+	case strings.HasPrefix(atom, "attribute_exists("):
+		_, ok := item[e.name(strings.TrimSuffix(strings.TrimPrefix(atom, "attribute_exists("), ")"))]
+		return ok, nil
+	case strings.HasPrefix(atom, "attribute_not_exists("):
+		_, ok := item[e.name(strings.TrimSuffix(strings.TrimPrefix(atom, "attribute_not_exists("), ")"))]
+		return !ok, nil
+	case strings.HasPrefix(atom, "begins_with("):
+		name, prefix, _ := strings.Cut(strings.TrimSuffix(strings.TrimPrefix(atom, "begins_with("), ")"), ", ")
+		s, ok := item[e.name(name)].(*types.AttributeValueMemberS)
+		p, _ := e.operand(prefix, item).(*types.AttributeValueMemberS)
+		return ok && p != nil && strings.HasPrefix(s.Value, p.Value), nil
+	}
+
+	for _, op := range []string{"<=", ">=", "<>", "<", ">", "="} {
+		left, right, ok := strings.Cut(atom, " "+op+" ")
+		if !ok {
+			continue
+		}
+
+		var l types.AttributeValue
+		if strings.HasPrefix(left, "size(") {
+			l = &types.AttributeValueMemberN{Value: strconv.Itoa(size(item[e.name(strings.TrimSuffix(strings.TrimPrefix(left, "size("), ")"))]))}
+		} else {
+			l = e.operand(left, item)
+		}
+
+		return compare(l, e.operand(right, item), op), nil
+	}
+
+	return false, fmt.Errorf("memdb: unsupported condition %q", atom)
+}
+
+func size(v types.AttributeValue) int {
+	switch t := v.(type) {
+	case *types.AttributeValueMemberSS:
+		return len(t.Value)
+	case *types.AttributeValueMemberL:
+		return len(t.Value)
+	case *types.AttributeValueMemberM:
+		return len(t.Value)
+	case *types.AttributeValueMemberS:
+		return len(t.Value)
+	case *types.AttributeValueMemberB:
+		return len(t.Value)
+	}
+	return 0
+}
+
+func compare(l, r types.AttributeValue, op string) bool {
+	var c int
+	switch lv := l.(type) {
+	case *types.AttributeValueMemberN:
+		rv, ok := r.(*types.AttributeValueMemberN)
+		if !ok {
+			return op == "<>"
+		}
+		a, _ := strconv.ParseFloat(lv.Value, 64)
+		b, _ := strconv.ParseFloat(rv.Value, 64)
+		c = cmp.Compare(a, b)
+	case *types.AttributeValueMemberS:
+		rv, ok := r.(*types.AttributeValueMemberS)
+		if !ok {
+			return op == "<>"
+		}
+		c = strings.Compare(lv.Value, rv.Value)
+	default:
+		return op == "<>"
+	}
+
+	switch op {
+	case "=":
+		return c == 0
+	case "<>":
+		return c != 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	default:
+		return c >= 0
+	}
+}
+
+func addNumbers(l, r types.AttributeValue, sign int) types.AttributeValue {
+	var a, b float64
+	if n, ok := l.(*types.AttributeValueMemberN); ok {
+		a, _ = strconv.ParseFloat(n.Value, 64)
+	}
+	if n, ok := r.(*types.AttributeValueMemberN); ok {
+		b, _ = strconv.ParseFloat(n.Value, 64)
+	}
+	return &types.AttributeValueMemberN{Value: strconv.FormatFloat(a+float64(sign)*b, 'f', -1, 64)}
+}
+
+// splitKeyword splits s on the keyword sep, ignoring keywords nested in parentheses
+func splitKeyword(s, sep string) []string {
+	var parts []string
+	var depth, start int
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '(':
+			depth++
+		case s[i] == ')':
+			depth--
+		case depth == 0 && strings.HasPrefix(s[i:], sep):
+			parts = append(parts, s[start:i])
+			start = i + len(sep)
+			i += len(sep) - 1
+		}
+	}
+
+	return append(parts, s[start:])
+}
+
+// unwrapParens returns s without its enclosing parentheses, if it is wrapped in a
+// single pair of them
+func unwrapParens(s string) (string, bool) {
+	if !strings.HasPrefix(s, "(") || !strings.HasSuffix(s, ")") {
+		return s, false
+	}
+
+	depth := 0
+	for i, r := range s {
+		switch r {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 && i != len(s)-1 {
+				return s, false
+			}
+		}
+	}
+
+	return s[1 : len(s)-1], true
+}
+
+// splitTopLevel splits s on sep, ignoring separators nested in parentheses
+func splitTopLevel(s string, sep rune) []string {
+	var parts []string
+	var depth, start int
+	for i, r := range s {
+		switch r {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case sep:
+			if depth == 0 {
+				parts = append(parts, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+
+	return append(parts, s[start:])
+}
@@ -0,0 +1,694 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package memdb is an in-memory implementation of the dynamodb client used by
+// dynastore.Store, behind both dynastoretest and dynastore.WithInMemory.
+package memdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"maps"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// defaultPrimaryKey is the partition key of tables written to without being
+// created, matching dynastore.DefaultPrimaryKey
+const defaultPrimaryKey = "id"
+
+// Option configures a DB
+type Option func(*DB)
+
+// WithClock sets the clock Expire compares ttl attributes with. Defaults to
+// time.Now.
+func WithClock(now func() time.Time) Option {
+	return func(d *DB) {
+		d.now = now
+	}
+}
+
+// WithPrimaryKey sets the partition key of tables that are written to without
+// being created with CreateTable. Defaults to "id".
+func WithPrimaryKey(key string) Option {
+	return func(d *DB) {
+		d.primaryKey = key
+	}
+}
+
+// WithTTL enables time to live on attribute for every table, as if
+// UpdateTimeToLive had been called on each
+func WithTTL(attribute string) Option {
+	return func(d *DB) {
+		d.ttlAttribute = attribute
+	}
+}
+
+// DB is an in-memory dynastore.DynamoDBClient holding any number of
+// tables keyed on a single string partition key. Tables spring into existence
+// on first write, or are created with CreateTable. It is safe for concurrent use.
+type DB struct {
+	mu           sync.Mutex
+	now          func() time.Time
+	primaryKey   string
+	ttlAttribute string
+	tables       map[string]*table
+	faults       map[string]*fault
+	calls        map[string]int
+}
+
+// table is an in-memory dynamodb table
+type table struct {
+	key   string
+	items map[string]map[string]types.AttributeValue
+	ttl   *types.TimeToLiveDescription
+	desc  *types.TableDescription
+}
+
+// fault is an error injected with Fail or FailNext; remaining is negative for
+// faults that persist until cleared
+type fault struct {
+	err       error
+	remaining int
+}
+
+// New returns an empty in-memory DB
+func New(opts ...Option) *DB {
+	d := &DB{
+		now:        time.Now,
+		primaryKey: defaultPrimaryKey,
+		tables:     map[string]*table{},
+		faults:     map[string]*fault{},
+		calls:      map[string]int{},
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+
+	return d
+}
+
+// Fail makes every call of operation, a DynamoDBClient method name such as
+// "GetItem", return err until Fail is called again with a nil err
+func (d *DB) Fail(operation string, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err == nil {
+		delete(d.faults, operation)
+		return
+	}
+	d.faults[operation] = &fault{err: err, remaining: -1}
+}
+
+// FailNext makes the next n calls of operation return err, e.g. a
+// *types.ProvisionedThroughputExceededException to exercise retries
+func (d *DB) FailNext(operation string, n int, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.faults[operation] = &fault{err: err, remaining: n}
+}
+
+// Calls returns the number of calls made to operation, including failed ones
+func (d *DB) Calls(operation string) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.calls[operation]
+}
+
+// Item returns a copy of the item stored under key in the named table
+func (d *DB) Item(tableName, key string) (map[string]types.AttributeValue, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	t, ok := d.tables[tableName]
+	if !ok {
+		return nil, false
+	}
+
+	item, ok := t.items[key]
+	return maps.Clone(item), ok
+}
+
+// Len returns the number of items in the named table
+func (d *DB) Len(tableName string) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if t, ok := d.tables[tableName]; ok {
+		return len(t.items)
+	}
+	return 0
+}
+
+// Expire deletes the items whose ttl attribute has passed from the tables with
+// time to live enabled, as dynamodb does in the background some time after
+// expiry, and returns the number of items deleted. Until it is called expired
+// items remain readable, as they may in dynamodb.
+func (d *DB) Expire() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := d.now().Unix()
+
+	var deleted int
+	for _, t := range d.tables {
+		attribute := d.ttlOf(t)
+		if attribute == "" {
+			continue
+		}
+
+		for key, item := range t.items {
+			n, ok := item[attribute].(*types.AttributeValueMemberN)
+			if !ok {
+				continue
+			}
+			if expires, err := strconv.ParseInt(n.Value, 10, 64); err == nil && expires <= now {
+				delete(t.items, key)
+				deleted++
+			}
+		}
+	}
+
+	return deleted
+}
+
+// ttlOf returns the ttl attribute of t, empty when time to live is disabled
+func (d *DB) ttlOf(t *table) string {
+	if t.ttl != nil {
+		if t.ttl.TimeToLiveStatus == types.TimeToLiveStatusEnabled {
+			return aws.ToString(t.ttl.AttributeName)
+		}
+		return ""
+	}
+
+	return d.ttlAttribute
+}
+
+// call counts a call of operation and returns the error injected for it, if any
+func (d *DB) call(operation string) error {
+	d.calls[operation]++
+
+	f, ok := d.faults[operation]
+	if !ok {
+		return nil
+	}
+	if f.remaining > 0 {
+		if f.remaining--; f.remaining == 0 {
+			delete(d.faults, operation)
+		}
+	}
+
+	return f.err
+}
+
+// lookup returns the named table for reading, an empty one if it doesn't exist
+func (d *DB) lookup(name *string) *table {
+	if t, ok := d.tables[aws.ToString(name)]; ok {
+		return t
+	}
+
+	return &table{key: d.primaryKey}
+}
+
+// table returns the named table for writing, creating it on first use
+func (d *DB) table(name *string) *table {
+	n := aws.ToString(name)
+	t, ok := d.tables[n]
+	if !ok {
+		t = &table{key: d.primaryKey, items: map[string]map[string]types.AttributeValue{}}
+		d.tables[n] = t
+	}
+
+	return t
+}
+
+// keyOf returns the partition key value of attrs
+func (t *table) keyOf(attrs map[string]types.AttributeValue) (string, error) {
+	s, ok := attrs[t.key].(*types.AttributeValueMemberS)
+	if !ok {
+		return "", fmt.Errorf("memdb: missing string key attribute %s", t.key)
+	}
+
+	return s.Value, nil
+}
+
+// check evaluates the condition expression of a write against the item stored under key
+func (t *table) check(cond *string, names map[string]string, values map[string]types.AttributeValue, key string) error {
+	if aws.ToString(cond) == "" {
+		return nil
+	}
+
+	expr := expression{names: names, values: values}
+	item, ok := t.items[key]
+	matched, err := expr.condition(aws.ToString(cond), item, ok)
+	if err != nil {
+		return err
+	}
+	if !matched {
+		return &types.ConditionalCheckFailedException{Message: aws.String("The conditional request failed")}
+	}
+
+	return nil
+}
+
+// update applies an update expression to the item stored under key, or to a new
+// item holding just the key
+func (t *table) update(key string, keyAttrs map[string]types.AttributeValue, cond, update *string, names map[string]string, values map[string]types.AttributeValue) (map[string]types.AttributeValue, error) {
+	if err := t.check(cond, names, values, key); err != nil {
+		return nil, err
+	}
+
+	item, ok := t.items[key]
+	if !ok {
+		item = map[string]types.AttributeValue{t.key: keyAttrs[t.key]}
+	}
+
+	expr := expression{names: names, values: values}
+	updated, err := expr.update(aws.ToString(update), maps.Clone(item))
+	if err != nil {
+		return nil, err
+	}
+	t.items[key] = updated
+
+	return updated, nil
+}
+
+func (d *DB) GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.call("GetItem"); err != nil {
+		return nil, err
+	}
+
+	t, ok := d.tables[aws.ToString(params.TableName)]
+	if !ok {
+		return &dynamodb.GetItemOutput{}, nil
+	}
+	key, err := t.keyOf(params.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &dynamodb.GetItemOutput{Item: maps.Clone(t.items[key])}, nil
+}
+
+func (d *DB) PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.call("PutItem"); err != nil {
+		return nil, err
+	}
+
+	t := d.table(params.TableName)
+	key, err := t.keyOf(params.Item)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.check(params.ConditionExpression, params.ExpressionAttributeNames, params.ExpressionAttributeValues, key); err != nil {
+		return nil, err
+	}
+
+	t.items[key] = maps.Clone(params.Item)
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func (d *DB) UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.call("UpdateItem"); err != nil {
+		return nil, err
+	}
+
+	t := d.table(params.TableName)
+	key, err := t.keyOf(params.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	updated, err := t.update(key, params.Key, params.ConditionExpression, params.UpdateExpression, params.ExpressionAttributeNames, params.ExpressionAttributeValues)
+	if err != nil {
+		return nil, err
+	}
+
+	return &dynamodb.UpdateItemOutput{Attributes: maps.Clone(updated)}, nil
+}
+
+func (d *DB) DeleteItem(ctx context.Context, params *dynamodb.DeleteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.call("DeleteItem"); err != nil {
+		return nil, err
+	}
+
+	t := d.table(params.TableName)
+	key, err := t.keyOf(params.Key)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.check(params.ConditionExpression, params.ExpressionAttributeNames, params.ExpressionAttributeValues, key); err != nil {
+		return nil, err
+	}
+
+	out := &dynamodb.DeleteItemOutput{}
+	if params.ReturnValues == types.ReturnValueAllOld {
+		out.Attributes = t.items[key]
+	}
+	delete(t.items, key)
+	return out, nil
+}
+
+func (d *DB) BatchWriteItem(ctx context.Context, params *dynamodb.BatchWriteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchWriteItemOutput, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.call("BatchWriteItem"); err != nil {
+		return nil, err
+	}
+
+	for name, requests := range params.RequestItems {
+		if len(requests) > 25 {
+			return nil, fmt.Errorf("memdb: too many items in batch")
+		}
+
+		t := d.table(aws.String(name))
+		for _, request := range requests {
+			switch {
+			case request.PutRequest != nil:
+				key, err := t.keyOf(request.PutRequest.Item)
+				if err != nil {
+					return nil, err
+				}
+				t.items[key] = maps.Clone(request.PutRequest.Item)
+			case request.DeleteRequest != nil:
+				key, err := t.keyOf(request.DeleteRequest.Key)
+				if err != nil {
+					return nil, err
+				}
+				delete(t.items, key)
+			}
+		}
+	}
+
+	return &dynamodb.BatchWriteItemOutput{}, nil
+}
+
+// Query evaluates the key condition against every item of the table, so it serves
+// queries of global secondary indexes as well as of the table
+func (d *DB) Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.call("Query"); err != nil {
+		return nil, err
+	}
+
+	t := d.lookup(params.TableName)
+	expr := expression{names: params.ExpressionAttributeNames, values: params.ExpressionAttributeValues}
+
+	var out []map[string]types.AttributeValue
+	for _, key := range slices.Sorted(maps.Keys(t.items)) {
+		item := t.items[key]
+
+		matched, err := expr.condition(aws.ToString(params.KeyConditionExpression), item, true)
+		if err != nil {
+			return nil, err
+		}
+		if filter := aws.ToString(params.FilterExpression); matched && filter != "" {
+			if matched, err = expr.condition(filter, item, true); err != nil {
+				return nil, err
+			}
+		}
+		if matched {
+			out = append(out, maps.Clone(item))
+		}
+	}
+
+	out, last := t.page(out, params.ExclusiveStartKey, params.Limit)
+	return &dynamodb.QueryOutput{Items: out, Count: int32(len(out)), LastEvaluatedKey: last}, nil
+}
+
+func (d *DB) Scan(ctx context.Context, params *dynamodb.ScanInput, optFns ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.call("Scan"); err != nil {
+		return nil, err
+	}
+
+	t := d.lookup(params.TableName)
+
+	var out []map[string]types.AttributeValue
+	for _, key := range slices.Sorted(maps.Keys(t.items)) {
+		if total := aws.ToInt32(params.TotalSegments); total > 1 {
+			h := fnv.New32a()
+			h.Write([]byte(key))
+			if int32(h.Sum32()%uint32(total)) != aws.ToInt32(params.Segment) {
+				continue
+			}
+		}
+		out = append(out, maps.Clone(t.items[key]))
+	}
+
+	// the limit applies to the items read, before filtering, as in dynamodb
+	out, last := t.page(out, params.ExclusiveStartKey, params.Limit)
+	if filter := aws.ToString(params.FilterExpression); filter != "" {
+		expr := expression{names: params.ExpressionAttributeNames, values: params.ExpressionAttributeValues}
+		out = slices.DeleteFunc(out, func(item map[string]types.AttributeValue) bool {
+			matched, _ := expr.condition(filter, item, true)
+			return !matched
+		})
+	}
+
+	return &dynamodb.ScanOutput{Items: out, Count: int32(len(out)), LastEvaluatedKey: last}, nil
+}
+
+// page returns the items of out following start, at most limit of them, and the
+// key to resume from when more remain
+func (t *table) page(out []map[string]types.AttributeValue, start map[string]types.AttributeValue, limit *int32) ([]map[string]types.AttributeValue, map[string]types.AttributeValue) {
+	if start != nil {
+		startKey, _ := t.keyOf(start)
+		idx := slices.IndexFunc(out, func(item map[string]types.AttributeValue) bool {
+			key, _ := t.keyOf(item)
+			return key == startKey
+		})
+		out = out[idx+1:]
+	}
+
+	if n := int(aws.ToInt32(limit)); n > 0 && len(out) > n {
+		out = out[:n]
+		return out, map[string]types.AttributeValue{t.key: out[n-1][t.key]}
+	}
+
+	return out, nil
+}
+
+// TransactWriteItems applies every item or, if a condition fails, none of them,
+// returning a *types.TransactionCanceledException with a reason per item
+func (d *DB) TransactWriteItems(ctx context.Context, params *dynamodb.TransactWriteItemsInput, optFns ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.call("TransactWriteItems"); err != nil {
+		return nil, err
+	}
+
+	reasons := make([]types.CancellationReason, len(params.TransactItems))
+	canceled := false
+	for i, item := range params.TransactItems {
+		reasons[i].Code = aws.String("None")
+
+		var err error
+		switch {
+		case item.Update != nil:
+			err = d.transactCheck(item.Update.TableName, item.Update.Key, item.Update.ConditionExpression, item.Update.ExpressionAttributeNames, item.Update.ExpressionAttributeValues)
+		case item.Put != nil:
+			err = d.transactCheck(item.Put.TableName, item.Put.Item, item.Put.ConditionExpression, item.Put.ExpressionAttributeNames, item.Put.ExpressionAttributeValues)
+		case item.Delete != nil:
+			err = d.transactCheck(item.Delete.TableName, item.Delete.Key, item.Delete.ConditionExpression, item.Delete.ExpressionAttributeNames, item.Delete.ExpressionAttributeValues)
+		case item.ConditionCheck != nil:
+			err = d.transactCheck(item.ConditionCheck.TableName, item.ConditionCheck.Key, item.ConditionCheck.ConditionExpression, item.ConditionCheck.ExpressionAttributeNames, item.ConditionCheck.ExpressionAttributeValues)
+		}
+
+		var ccf *types.ConditionalCheckFailedException
+		switch {
+		case errors.As(err, &ccf):
+			reasons[i].Code = aws.String("ConditionalCheckFailed")
+			canceled = true
+		case err != nil:
+			return nil, err
+		}
+	}
+	if canceled {
+		return nil, &types.TransactionCanceledException{Message: aws.String("Transaction cancelled"), CancellationReasons: reasons}
+	}
+
+	for _, item := range params.TransactItems {
+		switch {
+		case item.Update != nil:
+			t := d.table(item.Update.TableName)
+			key, _ := t.keyOf(item.Update.Key)
+			if _, err := t.update(key, item.Update.Key, nil, item.Update.UpdateExpression, item.Update.ExpressionAttributeNames, item.Update.ExpressionAttributeValues); err != nil {
+				return nil, err
+			}
+		case item.Put != nil:
+			t := d.table(item.Put.TableName)
+			key, _ := t.keyOf(item.Put.Item)
+			t.items[key] = maps.Clone(item.Put.Item)
+		case item.Delete != nil:
+			t := d.table(item.Delete.TableName)
+			key, _ := t.keyOf(item.Delete.Key)
+			delete(t.items, key)
+		}
+	}
+
+	return &dynamodb.TransactWriteItemsOutput{}, nil
+}
+
+// transactCheck evaluates the condition of a transaction item
+func (d *DB) transactCheck(tableName *string, keyAttrs map[string]types.AttributeValue, cond *string, names map[string]string, values map[string]types.AttributeValue) error {
+	t := d.table(tableName)
+	key, err := t.keyOf(keyAttrs)
+	if err != nil {
+		return err
+	}
+
+	return t.check(cond, names, values, key)
+}
+
+// CreateTable creates an active table, taking its partition key from the key
+// schema of params
+func (d *DB) CreateTable(ctx context.Context, params *dynamodb.CreateTableInput, optFns ...func(*dynamodb.Options)) (*dynamodb.CreateTableOutput, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.call("CreateTable"); err != nil {
+		return nil, err
+	}
+
+	name := aws.ToString(params.TableName)
+	if _, ok := d.tables[name]; ok {
+		return nil, &types.ResourceInUseException{Message: aws.String("Table already exists: " + name)}
+	}
+
+	t := d.table(params.TableName)
+	for _, element := range params.KeySchema {
+		if element.KeyType == types.KeyTypeHash {
+			t.key = aws.ToString(element.AttributeName)
+		}
+	}
+
+	t.desc = &types.TableDescription{
+		TableName:            params.TableName,
+		TableStatus:          types.TableStatusActive,
+		AttributeDefinitions: params.AttributeDefinitions,
+		KeySchema:            params.KeySchema,
+		BillingModeSummary:   &types.BillingModeSummary{BillingMode: params.BillingMode},
+	}
+	for _, gsi := range params.GlobalSecondaryIndexes {
+		t.desc.GlobalSecondaryIndexes = append(t.desc.GlobalSecondaryIndexes, types.GlobalSecondaryIndexDescription{
+			IndexName:   gsi.IndexName,
+			KeySchema:   gsi.KeySchema,
+			Projection:  gsi.Projection,
+			IndexStatus: types.IndexStatusActive,
+		})
+	}
+
+	return &dynamodb.CreateTableOutput{TableDescription: t.desc}, nil
+}
+
+// DescribeTable describes tables created with CreateTable or written to, the
+// latter as having a single string partition key
+func (d *DB) DescribeTable(ctx context.Context, params *dynamodb.DescribeTableInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DescribeTableOutput, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.call("DescribeTable"); err != nil {
+		return nil, err
+	}
+
+	t, ok := d.tables[aws.ToString(params.TableName)]
+	if !ok {
+		return nil, &types.ResourceNotFoundException{Message: aws.String("Requested resource not found")}
+	}
+
+	desc := t.desc
+	if desc == nil {
+		desc = &types.TableDescription{
+			TableName:   params.TableName,
+			TableStatus: types.TableStatusActive,
+			AttributeDefinitions: []types.AttributeDefinition{
+				{AttributeName: aws.String(t.key), AttributeType: types.ScalarAttributeTypeS},
+			},
+			KeySchema: []types.KeySchemaElement{
+				{AttributeName: aws.String(t.key), KeyType: types.KeyTypeHash},
+			},
+		}
+	}
+	desc.ItemCount = aws.Int64(int64(len(t.items)))
+
+	return &dynamodb.DescribeTableOutput{Table: desc}, nil
+}
+
+func (d *DB) DescribeTimeToLive(ctx context.Context, params *dynamodb.DescribeTimeToLiveInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DescribeTimeToLiveOutput, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.call("DescribeTimeToLive"); err != nil {
+		return nil, err
+	}
+
+	t := d.lookup(params.TableName)
+	desc := &types.TimeToLiveDescription{TimeToLiveStatus: types.TimeToLiveStatusDisabled}
+	if attribute := d.ttlOf(t); attribute != "" {
+		desc = &types.TimeToLiveDescription{AttributeName: aws.String(attribute), TimeToLiveStatus: types.TimeToLiveStatusEnabled}
+	}
+
+	return &dynamodb.DescribeTimeToLiveOutput{TimeToLiveDescription: desc}, nil
+}
+
+func (d *DB) UpdateTimeToLive(ctx context.Context, params *dynamodb.UpdateTimeToLiveInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateTimeToLiveOutput, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.call("UpdateTimeToLive"); err != nil {
+		return nil, err
+	}
+
+	status := types.TimeToLiveStatusDisabled
+	if aws.ToBool(params.TimeToLiveSpecification.Enabled) {
+		status = types.TimeToLiveStatusEnabled
+	}
+
+	d.table(params.TableName).ttl = &types.TimeToLiveDescription{
+		AttributeName:    params.TimeToLiveSpecification.AttributeName,
+		TimeToLiveStatus: status,
+	}
+
+	return &dynamodb.UpdateTimeToLiveOutput{TimeToLiveSpecification: params.TimeToLiveSpecification}, nil
+}
@@ -0,0 +1,205 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// SessionItem is a session visited by Iterate
+type SessionItem struct {
+	SessionSummary
+
+	// User is the value of the user key, if WithUserIndex is set
+	User string
+
+	// Item holds the raw attributes of the session item
+	Item map[string]types.AttributeValue
+}
+
+// IterateOption configures Iterate
+type IterateOption func(*iterateConfig)
+
+type iterateConfig struct {
+	segments       int
+	pageSize       int32
+	pagesPerSecond int
+}
+
+// IterateSegments scans the table with n parallel segments. Defaults to 1.
+func IterateSegments(n int) IterateOption {
+	return func(c *iterateConfig) {
+		c.segments = n
+	}
+}
+
+// IteratePageSize sets the number of items read per Scan call
+func IteratePageSize(n int32) IterateOption {
+	return func(c *iterateConfig) {
+		c.pageSize = n
+	}
+}
+
+// IterateRateLimit caps the number of Scan calls per second across all segments,
+// so iterating a large table in production doesn't starve the application of
+// read capacity
+func IterateRateLimit(pagesPerSecond int) IterateOption {
+	return func(c *iterateConfig) {
+		c.pagesPerSecond = pagesPerSecond
+	}
+}
+
+// throttle returns a channel ticking at the configured rate of Scan calls, or nil
+// if unlimited, and a function releasing it
+func (c iterateConfig) throttle() (<-chan time.Time, func()) {
+	if c.pagesPerSecond <= 0 {
+		return nil, func() {}
+	}
+
+	ticker := time.NewTicker(time.Second / time.Duration(c.pagesPerSecond))
+	return ticker.C, ticker.Stop
+}
+
+// Iterate calls fn for every session in the table, e.g. to audit, count or
+// migrate sessions. Auxiliary items such as user registries and nonces are
+// skipped; expired sessions dynamodb hasn't removed yet are not. Segments are
+// scanned in parallel, but fn is never called concurrently. Iteration stops at
+// the first error returned by fn or the scan, which Iterate returns.
+func (store *Store) Iterate(ctx context.Context, fn func(SessionItem) error, opts ...IterateOption) error {
+	store = store.scoped(ctx)
+
+	config := iterateConfig{segments: 1}
+	for _, opt := range opts {
+		opt(&config)
+	}
+	if config.segments < 1 {
+		config.segments = 1
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	throttle, stop := config.throttle()
+	defer stop()
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+
+	fail := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if firstErr == nil {
+			firstErr = err
+			cancel()
+		}
+	}
+
+	visit := func(item map[string]types.AttributeValue) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if firstErr != nil {
+			return firstErr
+		}
+		return fn(store.sessionItem(item))
+	}
+
+	for segment := 0; segment < config.segments; segment++ {
+		wg.Add(1)
+		go func(segment int) {
+			defer wg.Done()
+			if err := store.scanSegment(ctx, segment, config, throttle, visit); err != nil {
+				fail(err)
+			}
+		}(segment)
+	}
+
+	wg.Wait()
+
+	return firstErr
+}
+
+func (store *Store) scanSegment(ctx context.Context, segment int, config iterateConfig, throttle <-chan time.Time, visit func(map[string]types.AttributeValue) error) error {
+	input := &dynamodb.ScanInput{
+		TableName: aws.String(store.tableName),
+	}
+	if config.segments > 1 {
+		input.Segment = aws.Int32(int32(segment))
+		input.TotalSegments = aws.Int32(int32(config.segments))
+	}
+	input.FilterExpression, input.ExpressionAttributeNames, input.ExpressionAttributeValues = store.namespaceFilter(nil, nil, nil)
+	if config.pageSize > 0 {
+		input.Limit = aws.Int32(config.pageSize)
+	}
+
+	for {
+		if throttle != nil {
+			select {
+			case <-throttle:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		page, err := store.ddb.Scan(ctx, input)
+		if err != nil {
+			return fmt.Errorf("failed to scan sessions: %w", err)
+		}
+
+		for _, item := range page.Items {
+			if reservedID(strings.TrimPrefix(attributeString(item[store.primaryKey]), store.namespaced(""))) {
+				continue
+			}
+			if err := visit(item); err != nil {
+				return err
+			}
+		}
+
+		if len(page.LastEvaluatedKey) == 0 {
+			return nil
+		}
+		input.ExclusiveStartKey = page.LastEvaluatedKey
+	}
+}
+
+// sessionItem describes a raw session item
+func (store *Store) sessionItem(item map[string]types.AttributeValue) SessionItem {
+	s := SessionItem{
+		SessionSummary: SessionSummary{
+			ID:        attributeString(item[store.primaryKey]),
+			CreatedAt: attributeTime(item[CreatedAtField]),
+			LastSeen:  attributeTime(item[LastSeenField]),
+			ExpiresAt: attributeTime(item[DefaultTTLField]),
+			Device:    attributeString(item[FingerprintField]),
+			IP:        attributeString(item[OriginIPField]),
+			UserAgent: attributeString(item[UserAgentField]),
+		},
+		Item: item,
+	}
+	if store.userKey != "" {
+		s.User = attributeString(item[store.userKey])
+	}
+
+	return s
+}
@@ -0,0 +1,57 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestIterate(t *testing.T) {
+	ctx := context.TODO()
+
+	ddb := newFakeDynamoDB()
+	store, _ := New(ddb, WithUserIndex("user-index", "user_id"), WithMaxSessionsPerUser(100, RejectNewSessions))
+
+	persistUserSessions(t, store, "bob", 40)
+	persistUserSessions(t, store, "alice", 10)
+	if err := store.PutNonce(ctx, "state", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	users := map[string]int{}
+	err := store.Iterate(ctx, func(item SessionItem) error {
+		users[item.User]++
+		return nil
+	}, IterateSegments(4), IteratePageSize(3), IterateRateLimit(1000))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if users["bob"] != 40 || users["alice"] != 10 || len(users) != 2 {
+		t.Errorf("expected every session and nothing else to be visited; got %v", users)
+	}
+
+	stop := fmt.Errorf("stop")
+	visited := 0
+	err = store.Iterate(ctx, func(item SessionItem) error {
+		visited++
+		return stop
+	}, IterateSegments(4))
+	if err != stop || visited != 1 {
+		t.Errorf("expected iteration to stop at the first error; got %v after %v", err, visited)
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Keys holds the key material used to sign session ids and encrypt session
+// values. New signatures and ciphertext always use the primary keys; the previous
+// keys are only tried when verifying and decrypting, so keys can be rotated
+// without invalidating active sessions. Serialized as JSON, []byte fields are
+// base64 encoded.
+type Keys struct {
+	SigningKey             []byte   `json:"signing_key,omitempty"`
+	PreviousSigningKeys    [][]byte `json:"previous_signing_keys,omitempty"`
+	EncryptionKey          []byte   `json:"encryption_key,omitempty"`
+	PreviousEncryptionKeys [][]byte `json:"previous_encryption_keys,omitempty"`
+}
+
+// KeyProvider supplies the current keys, allowing them to be rotated without
+// restarting the application
+type KeyProvider interface {
+	Keys(ctx context.Context) (Keys, error)
+}
+
+// staticKeys is the KeyProvider used for keys supplied directly as options
+type staticKeys Keys
+
+func (k staticKeys) Keys(ctx context.Context) (Keys, error) {
+	return Keys(k), nil
+}
+
+// keyProviderSealer encrypts values with the encryption key from a KeyProvider,
+// rebuilding the cipher only when the key changes
+type keyProviderSealer struct {
+	provider KeyProvider
+
+	mu   sync.Mutex
+	key  []byte
+	aead *aesGCM
+}
+
+func (k *keyProviderSealer) cipher(ctx context.Context) (*aesGCM, error) {
+	keys, err := k.provider.Keys(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve encryption key: %w", err)
+	}
+
+	return k.primary(keys)
+}
+
+func (k *keyProviderSealer) primary(keys Keys) (*aesGCM, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if k.aead != nil && bytes.Equal(k.key, keys.EncryptionKey) {
+		return k.aead, nil
+	}
+
+	aead, err := newAESGCM(keys.EncryptionKey)
+	if err != nil {
+		return nil, err
+	}
+
+	k.key, k.aead = keys.EncryptionKey, aead
+
+	return aead, nil
+}
+
+func (k *keyProviderSealer) seal(ctx context.Context, plaintext, additionalData []byte) ([]byte, error) {
+	aead, err := k.cipher(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return aead.seal(ctx, plaintext, additionalData)
+}
+
+func (k *keyProviderSealer) open(ctx context.Context, ciphertext, additionalData []byte) ([]byte, error) {
+	keys, err := k.provider.Keys(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve encryption key: %w", err)
+	}
+
+	aead, err := k.primary(keys)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := aead.open(ctx, ciphertext, additionalData)
+	for _, key := range keys.PreviousEncryptionKeys {
+		if err == nil {
+			break
+		}
+
+		previous, errCipher := newAESGCM(key)
+		if errCipher != nil {
+			continue
+		}
+		plaintext, err = previous.open(ctx, ciphertext, additionalData)
+	}
+
+	return plaintext, err
+}
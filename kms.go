@@ -0,0 +1,263 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/aws-sdk-go-v2/service/kms/types"
+)
+
+// KMSClient is the subset of the kms API used for envelope encryption. It is
+// satisfied by *kms.Client.
+type KMSClient interface {
+	GenerateDataKey(ctx context.Context, params *kms.GenerateDataKeyInput, optFns ...func(*kms.Options)) (*kms.GenerateDataKeyOutput, error)
+	Decrypt(ctx context.Context, params *kms.DecryptInput, optFns ...func(*kms.Options)) (*kms.DecryptOutput, error)
+}
+
+// kmsEnvelope seals values with a data key generated by kms. The wrapped data key
+// is stored ahead of the ciphertext, prefixed by its length:
+//
+//	uint16 length | wrapped data key | nonce | AES-GCM ciphertext
+type kmsEnvelope struct {
+	client KMSClient
+	keyID  string
+
+	// table is bound to every data key as its encryption context, unless the
+	// request selects another table with ContextWithTable, so a wrapped key can
+	// only be unwrapped on behalf of the table it was generated for
+	table string
+
+	// cache is nil unless WithKMSDataKeyCache is set
+	cache *dataKeyCache
+}
+
+func (k *kmsEnvelope) seal(ctx context.Context, plaintext, additionalData []byte) ([]byte, error) {
+	table := contextTable(ctx, k.table)
+	if dataKey, wrapped, ok := k.cache.encryptionKey(table); ok {
+		return sealWithDataKey(dataKey, wrapped, plaintext, additionalData)
+	}
+
+	result, err := k.client.GenerateDataKey(ctx, &kms.GenerateDataKeyInput{
+		KeyId:             aws.String(k.keyID),
+		KeySpec:           types.DataKeySpecAes256,
+		EncryptionContext: map[string]string{"table": table},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate data key: %w", err)
+	}
+
+	k.cache.putEncryptionKey(table, result.Plaintext, result.CiphertextBlob)
+
+	return sealWithDataKey(result.Plaintext, result.CiphertextBlob, plaintext, additionalData)
+}
+
+func (k *kmsEnvelope) open(ctx context.Context, ciphertext, additionalData []byte) ([]byte, error) {
+	wrapped, ciphertext, err := splitEnvelope(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+
+	table := contextTable(ctx, k.table)
+	dataKey, ok := k.cache.decryptionKey(table, wrapped)
+	if !ok {
+		result, err := k.client.Decrypt(ctx, &kms.DecryptInput{
+			KeyId:             aws.String(k.keyID),
+			CiphertextBlob:    wrapped,
+			EncryptionContext: map[string]string{"table": table},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt data key: %w", err)
+		}
+
+		dataKey = result.Plaintext
+		k.cache.putDecryptionKey(table, wrapped, dataKey)
+	}
+
+	aead, err := newAESGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return aead.open(ctx, ciphertext, additionalData)
+}
+
+// maxCachedDecryptionKeys bounds the number of unwrapped data keys held in memory
+const maxCachedDecryptionKeys = 1000
+
+// dataKeyCache implements the kms data key caching guidance: a data key is reused
+// for encryption until it reaches maxAge or maxUses, and unwrapped keys are kept
+// for maxAge so reading a session doesn't require a kms call. Keys are cached per
+// table, as they are bound to the table they were generated for. All methods are
+// safe to call on a nil cache, which caches nothing.
+type dataKeyCache struct {
+	mu      sync.Mutex
+	now     func() time.Time
+	maxAge  time.Duration
+	maxUses int
+
+	current    map[string]*cachedDataKey
+	decryption map[string]*cachedDataKey
+}
+
+type cachedDataKey struct {
+	plaintext []byte
+	wrapped   []byte
+	created   time.Time
+	uses      int
+}
+
+func newDataKeyCache(maxAge time.Duration, maxUses int) *dataKeyCache {
+	return &dataKeyCache{
+		now:        time.Now,
+		maxAge:     maxAge,
+		maxUses:    maxUses,
+		current:    make(map[string]*cachedDataKey),
+		decryption: make(map[string]*cachedDataKey),
+	}
+}
+
+func (c *dataKeyCache) expired(key *cachedDataKey) bool {
+	return c.maxAge > 0 && c.now().Sub(key.created) >= c.maxAge
+}
+
+func (c *dataKeyCache) encryptionKey(table string) ([]byte, []byte, bool) {
+	if c == nil {
+		return nil, nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := c.current[table]
+	if key == nil || c.expired(key) || (c.maxUses > 0 && key.uses >= c.maxUses) {
+		delete(c.current, table)
+		return nil, nil, false
+	}
+
+	key.uses++
+	return key.plaintext, key.wrapped, true
+}
+
+func (c *dataKeyCache) putEncryptionKey(table string, plaintext, wrapped []byte) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.current[table] = &cachedDataKey{plaintext: plaintext, wrapped: wrapped, created: c.now(), uses: 1}
+	c.addDecryptionKey(table, wrapped, plaintext)
+}
+
+func (c *dataKeyCache) decryptionKey(table string, wrapped []byte) ([]byte, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	id := decryptionKeyID(table, wrapped)
+	key, ok := c.decryption[id]
+	if !ok {
+		return nil, false
+	}
+
+	if c.expired(key) {
+		delete(c.decryption, id)
+		return nil, false
+	}
+
+	return key.plaintext, true
+}
+
+func (c *dataKeyCache) putDecryptionKey(table string, wrapped, plaintext []byte) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.addDecryptionKey(table, wrapped, plaintext)
+}
+
+// decryptionKeyID identifies an unwrapped key by the table it was unwrapped for,
+// so a cached key isn't handed to another table kms would refuse it to
+func decryptionKeyID(table string, wrapped []byte) string {
+	return table + "\x00" + string(wrapped)
+}
+
+// addDecryptionKey stores an unwrapped key, evicting the oldest when full. c.mu must be held.
+func (c *dataKeyCache) addDecryptionKey(table string, wrapped, plaintext []byte) {
+	if len(c.decryption) >= maxCachedDecryptionKeys {
+		var oldest string
+		for k, key := range c.decryption {
+			if c.expired(key) {
+				delete(c.decryption, k)
+				continue
+			}
+			if oldest == "" || key.created.Before(c.decryption[oldest].created) {
+				oldest = k
+			}
+		}
+
+		if len(c.decryption) >= maxCachedDecryptionKeys {
+			delete(c.decryption, oldest)
+		}
+	}
+
+	c.decryption[decryptionKeyID(table, wrapped)] = &cachedDataKey{plaintext: plaintext, wrapped: wrapped, created: c.now()}
+}
+
+// sealWithDataKey encrypts plaintext with dataKey and prepends the wrapped form of the key
+func sealWithDataKey(dataKey, wrapped, plaintext, additionalData []byte) ([]byte, error) {
+	aead, err := newAESGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	sealed, err := aead.seal(context.Background(), plaintext, additionalData)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 2, 2+len(wrapped)+len(sealed))
+	binary.BigEndian.PutUint16(out, uint16(len(wrapped)))
+	out = append(out, wrapped...)
+
+	return append(out, sealed...), nil
+}
+
+// splitEnvelope separates the wrapped data key from the ciphertext
+func splitEnvelope(envelope []byte) ([]byte, []byte, error) {
+	if len(envelope) < 2 {
+		return nil, nil, fmt.Errorf("envelope too short")
+	}
+
+	n := int(binary.BigEndian.Uint16(envelope))
+	if len(envelope) < 2+n {
+		return nil, nil, fmt.Errorf("envelope too short")
+	}
+
+	return envelope[2 : 2+n], envelope[2+n:], nil
+}
@@ -0,0 +1,174 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+)
+
+// fakeKMS "wraps" data keys by prefixing them, and counts calls
+type fakeKMS struct {
+	generated atomic.Int32
+	decrypted atomic.Int32
+}
+
+func (f *fakeKMS) GenerateDataKey(ctx context.Context, params *kms.GenerateDataKeyInput, optFns ...func(*kms.Options)) (*kms.GenerateDataKeyOutput, error) {
+	f.generated.Add(1)
+
+	key := securecookie.GenerateRandomKey(32)
+	return &kms.GenerateDataKeyOutput{
+		Plaintext:      key,
+		CiphertextBlob: append([]byte(params.EncryptionContext["table"]+":"), key...),
+	}, nil
+}
+
+func (f *fakeKMS) Decrypt(ctx context.Context, params *kms.DecryptInput, optFns ...func(*kms.Options)) (*kms.DecryptOutput, error) {
+	f.decrypted.Add(1)
+
+	prefix := []byte(params.EncryptionContext["table"] + ":")
+	if !bytes.HasPrefix(params.CiphertextBlob, prefix) {
+		return nil, fmt.Errorf("fake: encryption context mismatch")
+	}
+
+	return &kms.DecryptOutput{Plaintext: bytes.TrimPrefix(params.CiphertextBlob, prefix)}, nil
+}
+
+func TestKMSEncryption(t *testing.T) {
+	ctx := context.TODO()
+
+	ddb := newFakeDynamoDB()
+	client := &fakeKMS{}
+	store, err := New(ddb, WithKMSEncryption(client, "alias/sessions"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session := sessions.NewSession(store, "session")
+	session.ID = "abc"
+	session.Values["email"] = "bob@example.com"
+
+	if err := store.Persist(ctx, session.Name(), session); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := ddb.items["abc"]["email"]; ok {
+		t.Error("expected values to be encrypted")
+	}
+
+	found := sessions.NewSession(store, "session")
+	if err := store.Load(ctx, "abc", found); err != nil {
+		t.Fatal(err)
+	}
+	if found.Values["email"] != "bob@example.com" {
+		t.Errorf("expected values to be decrypted; got %v", found.Values)
+	}
+
+	ddb.tableItems(aws.String("other"))["abc"] = ddb.items["abc"]
+	other, _ := New(ddb, TableName("other"), WithKMSEncryption(client, "alias/sessions"))
+	if err := other.Load(ctx, "abc", sessions.NewSession(store, "session")); err == nil {
+		t.Error("expected a data key bound to another table to be rejected")
+	}
+}
+
+func TestKMSDataKeyCache(t *testing.T) {
+	ctx := context.TODO()
+
+	now := time.Unix(1700000000, 0)
+	client := &fakeKMS{}
+	store, err := New(newFakeDynamoDB(),
+		WithKMSDataKeyCache(time.Minute, 2),
+		WithKMSEncryption(client, "alias/sessions"),
+		WithClock(func() time.Time { return now }),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		session := sessions.NewSession(store, "session")
+		session.ID = fmt.Sprintf("session-%d", i)
+		session.Values["n"] = i
+		if err := store.Persist(ctx, session.Name(), session); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if v := client.generated.Load(); v != 2 {
+		t.Errorf("expected a data key to seal 2 sessions, generating 2 keys; got %v", v)
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := store.Load(ctx, fmt.Sprintf("session-%d", i), sessions.NewSession(store, "session")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if v := client.decrypted.Load(); v != 0 {
+		t.Errorf("expected generated keys to be served from the cache; got %v decrypt calls", v)
+	}
+
+	now = now.Add(time.Minute)
+	if err := store.Load(ctx, "session-0", sessions.NewSession(store, "session")); err != nil {
+		t.Fatal(err)
+	}
+	if v := client.decrypted.Load(); v != 1 {
+		t.Errorf("expected an expired key to be unwrapped again; got %v decrypt calls", v)
+	}
+}
+
+func TestKMSEncryptionScopedTable(t *testing.T) {
+	ddb := newFakeDynamoDB()
+	client := &fakeKMS{}
+	store, err := New(ddb, WithKMSDataKeyCache(time.Minute, 0), WithKMSEncryption(client, "alias/sessions"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tenant := ContextWithTable(context.TODO(), "tenant")
+	session := sessions.NewSession(store, "session")
+	session.ID = "abc"
+	session.Values["email"] = "bob@example.com"
+	if err := store.Persist(tenant, session.Name(), session); err != nil {
+		t.Fatal(err)
+	}
+
+	item := ddb.tableItems(aws.String("tenant"))["abc"]
+	wrapped, _, err := splitEnvelope(item[DataField].(*types.AttributeValueMemberB).Value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(wrapped, []byte("tenant:")) {
+		t.Errorf("expected the data key to be bound to the scoped table; got %q", wrapped)
+	}
+
+	if err := store.Load(tenant, "abc", sessions.NewSession(store, "session")); err != nil {
+		t.Fatal(err)
+	}
+
+	ddb.tableItems(aws.String("other"))["abc"] = item
+	other := ContextWithTable(context.TODO(), "other")
+	if err := store.Load(other, "abc", sessions.NewSession(store, "session")); err == nil {
+		t.Error("expected a data key bound to another table to be rejected, even when cached")
+	}
+}
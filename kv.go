@@ -0,0 +1,101 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"context"
+	"errors"
+
+	"github.com/gorilla/sessions"
+)
+
+// KV exposes the sessions of a Store as plain key-value records, without the
+// *http.Request and cookie handling of the gorilla sessions.Store interface, so
+// background workers, gRPC services and tests can work with the same sessions
+// as the web tier
+type KV struct {
+	store *Store
+}
+
+// KV returns a key-value view of the sessions held by the store
+func (store *Store) KV() *KV {
+	return &KV{store: store}
+}
+
+// Create stores a new session holding values and returns its id
+func (kv *KV) Create(ctx context.Context, values map[string]any) (string, error) {
+	session := kv.session()
+	session.ID = kv.store.newID()
+	session.IsNew = true
+	setValues(session, values)
+
+	if err := kv.store.Persist(ctx, session.Name(), session); err != nil {
+		return "", err
+	}
+
+	return session.ID, nil
+}
+
+// Load returns the values of the session identified by id. ErrSessionNotFound is
+// returned if the session does not exist.
+func (kv *KV) Load(ctx context.Context, id string) (map[string]any, error) {
+	session := kv.session()
+	if err := kv.store.Load(ctx, id, session); err != nil {
+		return nil, err
+	}
+
+	values := convertToMapStringAny(session.Values)
+	delete(values, kv.store.primaryKey)
+
+	return values, nil
+}
+
+// Save replaces the values of the session identified by id, creating it if it
+// does not exist. Metadata the store keeps alongside the values, such as the
+// creation time and client binding, is preserved.
+func (kv *KV) Save(ctx context.Context, id string, values map[string]any) error {
+	session := kv.session()
+	if err := kv.store.Load(ctx, id, session); err != nil && !errors.Is(err, ErrSessionNotFound) {
+		return err
+	}
+
+	for k := range session.Values {
+		if _, ok := k.(string); ok {
+			delete(session.Values, k)
+		}
+	}
+
+	session.ID = id
+	setValues(session, values)
+
+	return kv.store.Persist(ctx, session.Name(), session)
+}
+
+// Delete removes the session identified by id
+func (kv *KV) Delete(ctx context.Context, id string) error {
+	return kv.store.Delete(ctx, id)
+}
+
+func (kv *KV) session() *sessions.Session {
+	session := sessions.NewSession(kv.store, "")
+	session.Options = kv.store.newOptions()
+
+	return session
+}
+
+func setValues(session *sessions.Session, values map[string]any) {
+	for k, v := range values {
+		session.Values[k] = v
+	}
+}
@@ -0,0 +1,64 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestKV(t *testing.T) {
+	ctx := context.TODO()
+
+	ddb := newFakeDynamoDB()
+	store, _ := New(ddb, MaxAge(3600))
+	kv := store.KV()
+
+	id, err := kv.Create(ctx, map[string]any{"user_id": "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values, err := kv.Load(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 1 || values["user_id"] != "alice" {
+		t.Errorf("expected only the stored values; got %v", values)
+	}
+
+	if err := kv.Save(ctx, id, map[string]any{"cart": "3 items"}); err != nil {
+		t.Fatal(err)
+	}
+	if values, _ := kv.Load(ctx, id); len(values) != 1 || values["cart"] != "3 items" {
+		t.Errorf("expected the values to be replaced; got %v", values)
+	}
+
+	// Sessions written through KV are visible to the web tier
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(&http.Cookie{Name: "session", Value: id})
+	session, _ := store.New(req, "session")
+	if session.IsNew || session.Values["cart"] != "3 items" {
+		t.Errorf("expected the web tier to load the session; got %v", session.Values)
+	}
+
+	if err := kv.Delete(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := kv.Load(ctx, id); err != ErrSessionNotFound {
+		t.Errorf("expected %v; got %v", ErrSessionNotFound, err)
+	}
+}
@@ -0,0 +1,77 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"context"
+	"errors"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// legacyTable holds the configuration of WithLegacyTable
+type legacyTable struct {
+	client   DynamoDBClient
+	table    string
+	backfill bool
+}
+
+// legacyItem returns the session item stored under key in the legacy table, if
+// any, copying it into the table of the store when backfilling is enabled. An
+// item written to the table of the store in the meantime is not overwritten.
+func (store *Store) legacyItem(ctx context.Context, key map[string]types.AttributeValue) (map[string]types.AttributeValue, error) {
+	legacy := store.legacy
+
+	client := legacy.client
+	if client == nil {
+		client = store.ddb
+	}
+
+	result, err := client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(legacy.table),
+		Key:       key,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if result.Item == nil || !legacy.backfill {
+		return result.Item, nil
+	}
+
+	_, err = store.ddb.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:                aws.String(store.tableName),
+		Item:                     result.Item,
+		ConditionExpression:      aws.String("attribute_not_exists(#pk)"),
+		ExpressionAttributeNames: map[string]string{"#pk": store.primaryKey},
+	})
+
+	var ccf *types.ConditionalCheckFailedException
+	if errors.As(err, &ccf) {
+		// written concurrently, so the copy in the table of the store is fresher
+		current, err := store.ddb.GetItem(ctx, &dynamodb.GetItemInput{
+			TableName:      aws.String(store.tableName),
+			Key:            key,
+			ConsistentRead: aws.Bool(true),
+		})
+		if err != nil {
+			return nil, err
+		}
+		return current.Item, nil
+	}
+
+	// a failed backfill is retried on the next load
+	return result.Item, nil
+}
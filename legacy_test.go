@@ -0,0 +1,60 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/gorilla/sessions"
+)
+
+func TestLegacyTable(t *testing.T) {
+	ctx := context.TODO()
+
+	ddb := newFakeDynamoDB()
+	ddb.others["sessions-v1"] = map[string]map[string]types.AttributeValue{
+		"abc": {
+			DefaultPrimaryKey: &types.AttributeValueMemberS{Value: "abc"},
+			"name":            &types.AttributeValueMemberS{Value: "bob"},
+		},
+	}
+
+	testCases := map[string]struct {
+		Backfill bool
+	}{
+		"read only": {},
+		"backfill":  {Backfill: true},
+	}
+
+	for label, tc := range testCases {
+		t.Run(label, func(t *testing.T) {
+			delete(ddb.items, "abc")
+			store, _ := New(ddb, WithLegacyTable(nil, "sessions-v1", tc.Backfill))
+
+			session := sessions.NewSession(store, "session")
+			if err := store.Load(ctx, "abc", session); err != nil {
+				t.Fatal(err)
+			}
+			if session.Values["name"] != "bob" {
+				t.Errorf("expected the session to be read from the legacy table; got %v", session.Values)
+			}
+
+			if _, ok := ddb.items["abc"]; ok != tc.Backfill {
+				t.Errorf("expected backfilled %v; got %v", tc.Backfill, ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"fmt"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+)
+
+// LegacyValuesField contains the name of the attribute in which the original
+// dynastore stored the session values, encoded with securecookie
+const LegacyValuesField = "values"
+
+// legacyFormat holds the codecs of WithLegacyFormat
+type legacyFormat struct {
+	codecs []securecookie.Codec
+}
+
+// openLegacyValues decodes the values of an item written by the original,
+// aws-sdk-go v1 based dynastore, if item is one, merging them into item. The
+// values were encoded with securecookie under the name of the session, so types
+// stored in them must still be registered with gob.
+func (store *Store) openLegacyValues(session *sessions.Session, item map[string]any) error {
+	encoded, ok := item[LegacyValuesField].(string)
+	if !ok {
+		return nil
+	}
+
+	values := map[any]any{}
+	if err := securecookie.DecodeMulti(session.Name(), encoded, &values, store.legacyFormat.codecs...); err != nil {
+		return fmt.Errorf("failed to decode legacy session values: %w", err)
+	}
+
+	delete(item, LegacyValuesField)
+	for k, v := range convertToMapStringAny(values) {
+		item[k] = v
+	}
+
+	return nil
+}
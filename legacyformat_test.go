@@ -0,0 +1,60 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+)
+
+func TestLegacyFormat(t *testing.T) {
+	ctx := context.TODO()
+	hashKey := securecookie.GenerateRandomKey(32)
+
+	encoded, err := securecookie.EncodeMulti("session", map[any]any{"user_id": "bob", "visits": 3}, securecookie.CodecsFromPairs(hashKey)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ddb := newFakeDynamoDB()
+	ddb.items["abc"] = map[string]types.AttributeValue{
+		DefaultPrimaryKey: &types.AttributeValueMemberS{Value: "abc"},
+		LegacyValuesField: &types.AttributeValueMemberS{Value: encoded},
+	}
+
+	store, _ := New(ddb, MaxAge(3600), WithLegacyFormat(hashKey))
+
+	session := sessions.NewSession(store, "session")
+	if err := store.Load(ctx, "abc", session); err != nil {
+		t.Fatal(err)
+	}
+	if session.Values["user_id"] != "bob" || session.Values["visits"] != 3 {
+		t.Errorf("expected the legacy values to be decoded; got %v", session.Values)
+	}
+	if _, ok := session.Values[LegacyValuesField]; ok {
+		t.Error("expected the encoded values not to be loaded as a value")
+	}
+
+	session.Options = store.newOptions()
+	if err := store.Persist(ctx, "session", session); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := ddb.items["abc"][LegacyValuesField]; ok {
+		t.Error("expected the session to be rewritten in the current format")
+	}
+}
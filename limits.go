@@ -0,0 +1,296 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/gorilla/sessions"
+)
+
+// SessionLimitPolicy decides what happens when a user exceeds the maximum number
+// of concurrent sessions
+type SessionLimitPolicy int
+
+const (
+	// RejectNewSessions fails the save of the new session with ErrTooManySessions
+	RejectNewSessions SessionLimitPolicy = iota
+
+	// EvictOldestSession deletes the user's sessions closest to expiry to make room
+	EvictOldestSession
+)
+
+// SessionsField contains the name of the string set attribute listing the item
+// keys of a user's sessions on the user's session registry item
+const SessionsField = "sessions"
+
+// userRegistryPrefix prefixes the primary key of the item tracking a user's
+// sessions. Generated session ids never contain '#', so they can't collide.
+const userRegistryPrefix = "user#"
+
+var (
+	// ErrTooManySessions is returned when saving a session would exceed the limit
+	// set by WithMaxSessionsPerUser under the RejectNewSessions policy
+	ErrTooManySessions = fmt.Errorf("user has reached the maximum number of concurrent sessions")
+)
+
+// loadedUserKey is the session.Values key holding the user the session belonged
+// to when it was loaded, so the limit is only enforced when a session is first
+// associated with a user
+type loadedUserKey struct{}
+
+// userSession is an entry returned by a query on the user index
+type userSession struct {
+	key       string
+	expiresAt int64
+}
+
+// sessionUser returns the user the session belongs to, if any
+func (store *Store) sessionUser(session *sessions.Session) string {
+	if store.userKey == "" {
+		return ""
+	}
+
+	user, _ := session.Values[store.userKey].(string)
+	return user
+}
+
+// registryKey returns the key of the item listing the sessions of user
+func (store *Store) registryKey(user string) map[string]types.AttributeValue {
+	return store.rawKey(userRegistryPrefix + user)
+}
+
+// rawKey returns the dynamodb key of an auxiliary item kept in the sessions
+// table. Unlike session keys it is never hashed, so the reserved prefix of the
+// item survives and reservedID recognises it.
+func (store *Store) rawKey(name string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		store.primaryKey: &types.AttributeValueMemberS{Value: store.namespaced(name)},
+	}
+}
+
+// enforceSessionLimit registers the session with its user's session registry,
+// failing or evicting other sessions if the user already has the maximum number.
+// replacing is the item key of a session being superseded by this one, as when
+// RegenerateID rotates the id.
+func (store *Store) enforceSessionLimit(ctx context.Context, session *sessions.Session, replacing string) error {
+	user := store.sessionUser(session)
+	if store.maxSessions <= 0 || user == "" {
+		return nil
+	}
+
+	// A session that already belonged to the user is only counted again when its
+	// id is being replaced, in which case the replaced entry is still registered
+	limit := store.maxSessions
+	if loaded, _ := session.Values[loadedUserKey{}].(string); loaded == user {
+		if replacing == "" {
+			return nil
+		}
+		limit++
+	}
+
+	key := store.itemKey(session.ID)
+
+	update := "ADD #sessions :key"
+	names := map[string]string{"#sessions": SessionsField}
+	values := map[string]types.AttributeValue{
+		":key": &types.AttributeValueMemberSS{Value: []string{key}},
+		":max": &types.AttributeValueMemberN{Value: strconv.Itoa(limit)},
+	}
+	if store.enableTTL {
+		update += " SET #ttl = :ttl"
+		names["#ttl"] = DefaultTTLField
+		values[":ttl"] = &types.AttributeValueMemberN{Value: strconv.FormatInt(store.expiry(store.sessionMaxAge(session)).Unix(), 10)}
+	}
+
+	register := func() error {
+		_, err := store.ddb.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+			TableName:                 aws.String(store.tableName),
+			Key:                       store.registryKey(user),
+			ConditionExpression:       aws.String("attribute_not_exists(#sessions) OR size(#sessions) < :max"),
+			UpdateExpression:          aws.String(update),
+			ExpressionAttributeNames:  names,
+			ExpressionAttributeValues: values,
+		})
+		return err
+	}
+
+	// The registry is full, but it may list sessions that have since expired or
+	// been deleted. Prune it before registering again; should a concurrent login
+	// have taken the freed slot in the meantime, the limit is reached after all.
+	var errs []error
+	var ccf *types.ConditionalCheckFailedException
+	err := register()
+	if errors.As(err, &ccf) {
+		errs, err = store.pruneRegistry(ctx, user, key, replacing)
+		if err == nil {
+			err = register()
+		}
+		if errors.As(err, &ccf) {
+			err = ErrTooManySessions
+		}
+	}
+
+	if err == nil && replacing != "" {
+		_, err = store.ddb.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+			TableName:                 aws.String(store.tableName),
+			Key:                       store.registryKey(user),
+			UpdateExpression:          aws.String("DELETE #sessions :key"),
+			ExpressionAttributeNames:  map[string]string{"#sessions": SessionsField},
+			ExpressionAttributeValues: map[string]types.AttributeValue{":key": &types.AttributeValueMemberSS{Value: []string{replacing}}},
+		})
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return errors.Join(errs...)
+}
+
+// pruneRegistry removes the sessions of user's registry that are no longer live
+// according to the user index, applying the limit policy to the ones that are.
+// Evicted sessions are deleted like any other, so the errors of their OnDelete
+// hooks and audit records are returned apart from err. The registry is only
+// ever changed with set operations, so registrations of concurrent logins made
+// since it was read are kept.
+func (store *Store) pruneRegistry(ctx context.Context, user, key, replacing string) ([]error, error) {
+	out, err := store.ddb.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(store.tableName),
+		Key:            store.registryKey(user),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	live, err := store.liveUserSessions(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	var errs []error
+	live = slices.DeleteFunc(live, func(s userSession) bool { return s.key == key || s.key == replacing })
+	if excess := len(live) - store.maxSessions + 1; excess > 0 {
+		if store.sessionLimitPolicy == RejectNewSessions {
+			return nil, ErrTooManySessions
+		}
+
+		slices.SortFunc(live, func(a, b userSession) int { return cmp.Compare(a.expiresAt, b.expiresAt) })
+		for _, evicted := range live[:excess] {
+			if err := store.deleteItem(ctx, evicted.key); err != nil {
+				return errs, err
+			}
+			errs = append(errs, store.deleted(ctx, store.keyID(evicted.key), user))
+		}
+		live = live[excess:]
+	}
+
+	var removed []string
+	if registered, ok := out.Item[SessionsField].(*types.AttributeValueMemberSS); ok {
+		for _, k := range registered.Value {
+			if k != key && k != replacing && !slices.ContainsFunc(live, func(s userSession) bool { return s.key == k }) {
+				removed = append(removed, k)
+			}
+		}
+	}
+	if len(removed) == 0 {
+		return errs, nil
+	}
+
+	_, err = store.ddb.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:                 aws.String(store.tableName),
+		Key:                       store.registryKey(user),
+		UpdateExpression:          aws.String("DELETE #sessions :keys"),
+		ExpressionAttributeNames:  map[string]string{"#sessions": SessionsField},
+		ExpressionAttributeValues: map[string]types.AttributeValue{":keys": &types.AttributeValueMemberSS{Value: removed}},
+	})
+
+	return errs, err
+}
+
+// liveUserSessions queries the user index for the sessions of user that have
+// not yet expired
+func (store *Store) liveUserSessions(ctx context.Context, user string) ([]userSession, error) {
+	all, err := store.userSessions(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	return slices.DeleteFunc(all, func(s userSession) bool {
+		return s.expiresAt > 0 && s.expiresAt <= store.now().Unix()
+	}), nil
+}
+
+// userSessions queries the user index for all sessions of user
+func (store *Store) userSessions(ctx context.Context, user string) ([]userSession, error) {
+	if store.userIndex == "" {
+		return nil, fmt.Errorf("a user index must be configured with WithUserIndex")
+	}
+
+	var out []userSession
+
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(store.tableName),
+		IndexName:              aws.String(store.userIndex),
+		KeyConditionExpression: aws.String("#user = :user"),
+	}
+	input.FilterExpression, input.ExpressionAttributeNames, input.ExpressionAttributeValues = store.namespaceFilter(nil,
+		map[string]string{"#user": store.userKey},
+		map[string]types.AttributeValue{":user": &types.AttributeValueMemberS{Value: user}},
+	)
+
+	paginator := dynamodb.NewQueryPaginator(store.ddb, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query sessions of user: %w", err)
+		}
+
+		for _, item := range page.Items {
+			s := userSession{}
+			if pk, ok := item[store.primaryKey].(*types.AttributeValueMemberS); ok {
+				s.key = pk.Value
+			}
+			if ttl, ok := item[DefaultTTLField].(*types.AttributeValueMemberN); ok {
+				s.expiresAt, _ = strconv.ParseInt(ttl.Value, 10, 64)
+			}
+			out = append(out, s)
+		}
+	}
+
+	return out, nil
+}
+
+// deleteItem deletes the item stored under an item key, as opposed to a session id
+func (store *Store) deleteItem(ctx context.Context, key string) error {
+	store.discardPending(ctx, key)
+
+	_, err := store.ddb.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(store.tableName),
+		Key: map[string]types.AttributeValue{
+			store.primaryKey: &types.AttributeValueMemberS{Value: key},
+		},
+	})
+
+	return err
+}
@@ -0,0 +1,157 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"context"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// racingDynamoDB runs race once, before the first query, standing in for a
+// concurrent request
+type racingDynamoDB struct {
+	*fakeDynamoDB
+	race func()
+}
+
+func (d *racingDynamoDB) Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
+	if d.race != nil {
+		d.race()
+		d.race = nil
+	}
+	return d.fakeDynamoDB.Query(ctx, params, optFns...)
+}
+
+func TestMaxSessionsPerUser(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+
+	login := func(store *Store) (string, error) {
+		now = now.Add(time.Second)
+		req, _ := http.NewRequest(http.MethodGet, "http://localhost", nil)
+		session, _ := store.New(req, "session")
+		session.Values["user_id"] = "bob"
+		return session.ID, store.Save(req, httptest.NewRecorder(), session)
+	}
+
+	t.Run("reject", func(t *testing.T) {
+		store, _ := New(newFakeDynamoDB(), TTLEnabled(), MaxAge(3600),
+			WithUserIndex("user-index", "user_id"),
+			WithMaxSessionsPerUser(2, RejectNewSessions),
+			WithClock(func() time.Time { return now }),
+		)
+
+		for i := 0; i < 2; i++ {
+			if _, err := login(store); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if _, err := login(store); err != ErrTooManySessions {
+			t.Errorf("expected %v; got %v", ErrTooManySessions, err)
+		}
+	})
+
+	t.Run("evict", func(t *testing.T) {
+		var deleted []string
+		ddb := newFakeDynamoDB()
+		store, _ := New(ddb, TTLEnabled(), MaxAge(3600),
+			WithUserIndex("user-index", "user_id"),
+			WithMaxSessionsPerUser(2, EvictOldestSession),
+			WithClock(func() time.Time { return now }),
+			WithOnDelete(func(ctx context.Context, id string) error {
+				deleted = append(deleted, id)
+				return nil
+			}),
+		)
+
+		first, _ := login(store)
+		second, _ := login(store)
+		if _, err := login(store); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, ok := ddb.items[first]; ok {
+			t.Error("expected the oldest session to be evicted")
+		}
+		if _, ok := ddb.items[second]; !ok {
+			t.Error("expected the newer session to be kept")
+		}
+		if !slices.Equal(deleted, []string{first}) {
+			t.Errorf("expected the delete hooks to run for the evicted session; got %v", deleted)
+		}
+	})
+
+	t.Run("concurrent registrations are kept", func(t *testing.T) {
+		ddb := &racingDynamoDB{fakeDynamoDB: newFakeDynamoDB()}
+		store, _ := New(ddb, TTLEnabled(), MaxAge(3600),
+			WithUserIndex("user-index", "user_id"),
+			WithMaxSessionsPerUser(2, RejectNewSessions),
+			WithClock(func() time.Time { return now }),
+		)
+
+		for i := 0; i < 2; i++ {
+			id, err := login(store)
+			if err != nil {
+				t.Fatal(err)
+			}
+			delete(ddb.items, id)
+		}
+
+		// another login registers its session after the registry has been read
+		ddb.race = func() {
+			registry := maps.Clone(ddb.items[userRegistryPrefix+"bob"])
+			sessions := registry[SessionsField].(*types.AttributeValueMemberSS)
+			registry[SessionsField] = &types.AttributeValueMemberSS{Value: append(slices.Clone(sessions.Value), "racer")}
+			ddb.items[userRegistryPrefix+"bob"] = registry
+		}
+
+		id, err := login(store)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		registered := ddb.items[userRegistryPrefix+"bob"][SessionsField].(*types.AttributeValueMemberSS).Value
+		expected := []string{id, "racer"}
+		slices.Sort(registered)
+		slices.Sort(expected)
+		if !slices.Equal(registered, expected) {
+			t.Errorf("expected %v to be registered; got %v", expected, registered)
+		}
+	})
+
+	t.Run("expired sessions don't count", func(t *testing.T) {
+		store, _ := New(newFakeDynamoDB(), TTLEnabled(), MaxAge(3600),
+			WithUserIndex("user-index", "user_id"),
+			WithMaxSessionsPerUser(1, RejectNewSessions),
+			WithClock(func() time.Time { return now }),
+		)
+
+		if _, err := login(store); err != nil {
+			t.Fatal(err)
+		}
+
+		now = now.Add(2 * time.Hour)
+		if _, err := login(store); err != nil {
+			t.Errorf("expected expired session to be pruned from the registry; got %v", err)
+		}
+	})
+}
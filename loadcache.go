@@ -0,0 +1,42 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"context"
+)
+
+// maxRequestCachedItems bounds the items cached for a single request
+const maxRequestCachedItems = 16
+
+type loadCacheContextKey struct{}
+
+// ContextWithLoadCache returns a context under which the items loaded by the
+// store are cached, so Get and New calls of a request that present the same
+// session read it once: the sessions.Registry of gorilla only dedupes calls by
+// session name. Sessions saved or deleted under the context update the cache.
+// Middleware sets it up for the requests it handles.
+func ContextWithLoadCache(ctx context.Context) context.Context {
+	if requestCache(ctx) != nil {
+		return ctx
+	}
+
+	return context.WithValue(ctx, loadCacheContextKey{}, newItemCache(maxRequestCachedItems, 0))
+}
+
+// requestCache returns the cache set up by ContextWithLoadCache, or nil
+func requestCache(ctx context.Context) *itemCache {
+	cache, _ := ctx.Value(loadCacheContextKey{}).(*itemCache)
+	return cache
+}
@@ -0,0 +1,61 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gorilla/sessions"
+)
+
+func TestContextWithLoadCache(t *testing.T) {
+	ddb := newFakeDynamoDB()
+	store, _ := New(ddb, MaxAge(3600))
+	persistUserSessions(t, store, "bob", 1)
+
+	ctx := ContextWithLoadCache(context.TODO())
+	for range 3 {
+		if err := store.Load(ctx, "bob-0", sessions.NewSession(store, "session")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if ddb.gets != 1 {
+		t.Errorf("expected the session to be read once per request; got %v reads", ddb.gets)
+	}
+
+	session := sessions.NewSession(store, "session")
+	session.ID = "bob-0"
+	session.Options = store.newOptions()
+	session.Values["name"] = "bob"
+	if err := store.Persist(ctx, "session", session); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := sessions.NewSession(store, "session")
+	if err := store.Load(ctx, "bob-0", loaded); err != nil || loaded.Values["name"] != "bob" || ddb.gets != 1 {
+		t.Errorf("expected the saved session to be loaded from the cache; got %v, %v", loaded.Values, err)
+	}
+
+	if err := store.Delete(ctx, "bob-0"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Load(ctx, "bob-0", sessions.NewSession(store, "session")); err == nil {
+		t.Error("expected deleted session not to be served from the cache")
+	}
+
+	if err := store.Load(context.TODO(), "bob-1", sessions.NewSession(store, "session")); err == nil {
+		t.Error("expected unknown session not to be found")
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"reflect"
+
+	"github.com/gorilla/sessions"
+)
+
+// internalPkgPath is the package path of the types used as internal session.Values keys
+var internalPkgPath = reflect.TypeFor[Store]().PkgPath()
+
+// debug logs msg at debug level to the logger set with WithLogger, if any
+func (store *Store) debug(ctx context.Context, msg string, args ...any) {
+	if store.logger == nil || !store.logger.Enabled(ctx, slog.LevelDebug) {
+		return
+	}
+
+	store.logger.DebugContext(ctx, msg, args...)
+}
+
+// logID returns the attribute identifying a session in logs: a digest of its id
+// unless WithLogger was told to log ids as is, since ids are bearer credentials
+func (store *Store) logID(id string) slog.Attr {
+	if store.logRawIDs {
+		return slog.String("session_id", id)
+	}
+
+	return slog.String("session_id", redactID(id))
+}
+
+// redactID returns a short digest identifying a session without revealing its id
+func redactID(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return "sha256:" + hex.EncodeToString(sum[:6])
+}
+
+// logDroppedKeys logs the values of session that aren't persisted because their
+// key isn't a string, leaving out the internal values of the store
+func (store *Store) logDroppedKeys(ctx context.Context, session *sessions.Session) {
+	if store.logger == nil {
+		return
+	}
+
+	for k := range session.Values {
+		if _, ok := k.(string); ok {
+			continue
+		}
+		if t := reflect.TypeOf(k); t != nil && t.PkgPath() == internalPkgPath {
+			continue
+		}
+
+		store.debug(ctx, "dropping session value with a non string key", store.logID(session.ID), slog.String("key_type", reflect.TypeOf(k).String()))
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/sessions"
+)
+
+func TestWithLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	store, _ := New(newFakeDynamoDB(), MaxAge(3600), WithLogger(logger))
+
+	session := sessions.NewSession(store, "session")
+	session.ID = "secret-session-id"
+	session.Options = store.newOptions()
+	session.Values[42] = "dropped"
+	if err := store.Persist(context.TODO(), "session", session); err != nil {
+		t.Fatal(err)
+	}
+
+	logs := buf.String()
+	if !strings.Contains(logs, "dynastore Persist") {
+		t.Errorf("expected the operation to be logged; got %v", logs)
+	}
+	if !strings.Contains(logs, "non string key") || !strings.Contains(logs, "key_type=int") {
+		t.Errorf("expected the dropped value to be logged; got %v", logs)
+	}
+	if strings.Contains(logs, session.ID) {
+		t.Errorf("expected the session id to be redacted; got %v", logs)
+	}
+
+	buf.Reset()
+	store, _ = New(newFakeDynamoDB(), MaxAge(3600), WithLogger(logger), WithUnredactedLogs())
+	if err := store.Persist(context.TODO(), "session", session); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), session.ID) {
+		t.Errorf("expected the session id to be logged as is; got %v", buf.String())
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"context"
+	"time"
+
+	"github.com/ddouglas/dynastore/internal/memdb"
+)
+
+// memoryExpiryInterval is how often a store created with WithInMemory deletes
+// expired sessions, as dynamodb time to live would
+const memoryExpiryInterval = time.Minute
+
+// memoryTable is the in-memory table of a store created with WithInMemory,
+// deleting the items whose ttl has passed in the background until closed
+type memoryTable struct {
+	db   *memdb.DB
+	stop chan struct{}
+	done chan struct{}
+}
+
+func newMemoryTable(primaryKey string, now func() time.Time) *memoryTable {
+	return &memoryTable{
+		db: memdb.New(
+			memdb.WithPrimaryKey(primaryKey),
+			memdb.WithClock(now),
+			memdb.WithTTL(DefaultTTLField),
+		),
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+}
+
+func (m *memoryTable) start() {
+	go func() {
+		defer close(m.done)
+
+		ticker := time.NewTicker(memoryExpiryInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				m.db.Expire()
+			case <-m.stop:
+				return
+			}
+		}
+	}()
+}
+
+func (m *memoryTable) close(ctx context.Context) error {
+	close(m.stop)
+
+	select {
+	case <-m.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
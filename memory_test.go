@@ -0,0 +1,62 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+)
+
+func TestInMemory(t *testing.T) {
+	ctx := context.TODO()
+
+	now := time.Unix(1700000000, 0)
+	store, err := New(nil, WithInMemory(), TTLEnabled(), MaxAge(60), PrimaryKey("session_id"), WithUserIndex("user-index", "user_id"), WithClock(func() time.Time { return now }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close(ctx)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	session, _ := store.New(req, "session")
+	session.Values["user_id"] = "bob"
+	w := httptest.NewRecorder()
+	if err := store.Save(req, w, session); err != nil {
+		t.Fatalf("expected the session to be saved; got %v", err)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(w.Result().Cookies()[0])
+	loaded, err := store.New(req, "session")
+	if err != nil || loaded.IsNew || loaded.Values["user_id"] != "bob" {
+		t.Fatalf("expected the saved session; got %v, %v", loaded.Values, err)
+	}
+	if count, err := store.ActiveSessionCount(ctx, "bob"); err != nil || count != 1 {
+		t.Errorf("expected 1 session of bob; got %v, %v", count, err)
+	}
+
+	now = now.Add(2 * time.Minute)
+	if n := store.memory.db.Expire(); n != 1 {
+		t.Errorf("expected the session to expire; got %v", n)
+	}
+	if err := store.Load(ctx, session.ID, sessions.NewSession(store, "session")); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("expected %v; got %v", ErrSessionNotFound, err)
+	}
+}
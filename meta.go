@@ -0,0 +1,179 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/gorilla/sessions"
+)
+
+const (
+	// CreatedAtField contains the name of the attribute holding the time, in epoch
+	// seconds, a session was first saved
+	CreatedAtField = "created_at"
+
+	// LastSeenField contains the name of the attribute holding the time, in epoch
+	// seconds, a session was last saved, or loaded when WithLastSeenInterval is set
+	LastSeenField = "last_seen"
+
+	// OriginIPField contains the name of the attribute holding the address of the
+	// client that created a session
+	OriginIPField = "origin_ip"
+
+	// UserAgentField contains the name of the attribute holding the user agent of
+	// the client that created a session
+	UserAgentField = "user_agent"
+)
+
+// maxUserAgentLength bounds the user agent recorded for a session
+const maxUserAgentLength = 512
+
+// createdAtKey, lastSeenKey, originIPKey and userAgentKey are the session.Values
+// keys under which session metadata is tracked between Load and Persist
+type (
+	createdAtKey struct{}
+	lastSeenKey  struct{}
+	originIPKey  struct{}
+	userAgentKey struct{}
+)
+
+// SessionMeta describes where and when a session was created and last used. It
+// is recorded by the store in dedicated attributes, never in session.Values.
+type SessionMeta struct {
+	// CreatedAt is when the session was first saved
+	CreatedAt time.Time
+
+	// LastSeen is when the session was last saved, or loaded when
+	// WithLastSeenInterval is set
+	LastSeen time.Time
+
+	// IP is the address of the client that created the session
+	IP string
+
+	// UserAgent is the user agent of the client that created the session
+	UserAgent string
+}
+
+// Meta returns the metadata of the session. It is empty until the session has
+// been saved once.
+func Meta(session *sessions.Session) SessionMeta {
+	meta := SessionMeta{}
+	meta.CreatedAt, _ = session.Values[createdAtKey{}].(time.Time)
+	meta.LastSeen, _ = session.Values[lastSeenKey{}].(time.Time)
+	meta.IP, _ = session.Values[originIPKey{}].(string)
+	meta.UserAgent, _ = session.Values[userAgentKey{}].(string)
+
+	return meta
+}
+
+// captureOrigin records the client making req as the origin of sessions that
+// don't have one yet
+func (store *Store) captureOrigin(req *http.Request, session *sessions.Session) {
+	if _, ok := session.Values[originIPKey{}]; ok {
+		return
+	}
+
+	userAgent := req.UserAgent()
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = userAgent[:maxUserAgentLength]
+	}
+
+	session.Values[originIPKey{}] = store.clientIP(req)
+	session.Values[userAgentKey{}] = userAgent
+}
+
+// marshalMeta adds the metadata of the session to the values written to dynamodb.
+// The creation time is fixed on the first write and carried over afterwards.
+func (store *Store) marshalMeta(session *sessions.Session, v map[string]any) {
+	now := store.now()
+
+	createdAt, ok := session.Values[createdAtKey{}].(time.Time)
+	if !ok {
+		createdAt = now
+		session.Values[createdAtKey{}] = createdAt
+	}
+	session.Values[lastSeenKey{}] = now
+
+	v[CreatedAtField] = createdAt.Unix()
+	v[LastSeenField] = now.Unix()
+
+	if ip, ok := session.Values[originIPKey{}].(string); ok && ip != "" {
+		v[OriginIPField] = ip
+	}
+	if userAgent, ok := session.Values[userAgentKey{}].(string); ok && userAgent != "" {
+		v[UserAgentField] = userAgent
+	}
+}
+
+// loadMeta extracts the session metadata from an item read from dynamodb,
+// returning it keyed the way it is tracked in session.Values
+func loadMeta(item map[string]any) map[any]any {
+	meta := make(map[any]any)
+	if createdAt, ok := parseTTL(item[CreatedAtField]); ok {
+		meta[createdAtKey{}] = createdAt
+	}
+	if lastSeen, ok := parseTTL(item[LastSeenField]); ok {
+		meta[lastSeenKey{}] = lastSeen
+	}
+	if ip, ok := item[OriginIPField].(string); ok {
+		meta[originIPKey{}] = ip
+	}
+	if userAgent, ok := item[UserAgentField].(string); ok {
+		meta[userAgentKey{}] = userAgent
+	}
+	delete(item, CreatedAtField)
+	delete(item, LastSeenField)
+	delete(item, OriginIPField)
+	delete(item, UserAgentField)
+
+	return meta
+}
+
+// trackLastSeen bumps the last seen time of a session just loaded, if it is older
+// than the interval set with WithLastSeenInterval. Only the one attribute is
+// written, and conditionally, so concurrent requests don't all pay for it. This
+// is best effort: failures leave the session loaded as is.
+func (store *Store) trackLastSeen(ctx context.Context, id string, session *sessions.Session) {
+	if store.lastSeenInterval <= 0 {
+		return
+	}
+
+	now := store.now()
+	lastSeen, _ := session.Values[lastSeenKey{}].(time.Time)
+	if now.Sub(lastSeen) < store.lastSeenInterval {
+		return
+	}
+
+	_, err := store.ddb.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:                aws.String(store.tableName),
+		Key:                      store.key(id),
+		ConditionExpression:      aws.String("attribute_exists(#pk) AND attribute_not_exists(#lastSeen) OR attribute_exists(#pk) AND #lastSeen < :threshold"),
+		UpdateExpression:         aws.String("SET #lastSeen = :now"),
+		ExpressionAttributeNames: map[string]string{"#pk": store.primaryKey, "#lastSeen": LastSeenField},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":now":       &types.AttributeValueMemberN{Value: strconv.FormatInt(now.Unix(), 10)},
+			":threshold": &types.AttributeValueMemberN{Value: strconv.FormatInt(now.Add(-store.lastSeenInterval).Unix(), 10)},
+		},
+	})
+	if err == nil {
+		session.Values[lastSeenKey{}] = now
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+)
+
+func TestSessionMeta(t *testing.T) {
+	ddb := newFakeDynamoDB()
+	now := time.Unix(1700000000, 0)
+	store, _ := New(ddb, WithClock(func() time.Time { return now }))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	req.Header.Set("User-Agent", "browser/1.0")
+
+	session, _ := store.New(req, "session")
+	if meta := Meta(session); meta != (SessionMeta{}) {
+		t.Errorf("expected no metadata before the first save; got %+v", meta)
+	}
+
+	w := httptest.NewRecorder()
+	if err := store.Save(req, w, session); err != nil {
+		t.Fatal(err)
+	}
+	cookie := w.Result().Cookies()[0]
+
+	// Later requests from elsewhere don't change the origin
+	now = now.Add(time.Hour)
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "198.51.100.1:1234"
+	req.AddCookie(cookie)
+	loaded, _ := store.New(req, "session")
+	if err := store.Save(req, httptest.NewRecorder(), loaded); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, _ = store.New(req, "session")
+	want := SessionMeta{
+		CreatedAt: time.Unix(1700000000, 0),
+		LastSeen:  now,
+		IP:        "192.0.2.1",
+		UserAgent: "browser/1.0",
+	}
+	if meta := Meta(loaded); meta != want {
+		t.Errorf("expected %+v; got %+v", want, meta)
+	}
+	for _, field := range []string{CreatedAtField, LastSeenField, OriginIPField, UserAgentField} {
+		if _, ok := loaded.Values[field]; ok {
+			t.Errorf("expected %v to be kept out of Values", field)
+		}
+	}
+}
+
+func TestLastSeenInterval(t *testing.T) {
+	ctx := context.TODO()
+
+	ddb := newFakeDynamoDB()
+	now := time.Unix(1700000000, 0)
+	store, _ := New(ddb, WithClock(func() time.Time { return now }), WithLastSeenInterval(15*time.Minute))
+
+	session := sessions.NewSession(store, "session")
+	session.ID = "abc"
+	if err := store.Persist(ctx, session.Name(), session); err != nil {
+		t.Fatal(err)
+	}
+
+	load := func() SessionMeta {
+		loaded := sessions.NewSession(store, "session")
+		if err := store.Load(ctx, "abc", loaded); err != nil {
+			t.Fatal(err)
+		}
+		return Meta(loaded)
+	}
+
+	now = now.Add(5 * time.Minute)
+	if meta := load(); !meta.LastSeen.Equal(time.Unix(1700000000, 0)) || ddb.updates != 0 {
+		t.Errorf("expected reads within the interval not to write; got %v after %v updates", meta.LastSeen, ddb.updates)
+	}
+
+	now = now.Add(15 * time.Minute)
+	if meta := load(); !meta.LastSeen.Equal(now) || ddb.updates != 1 {
+		t.Errorf("expected last seen to be bumped; got %v after %v updates", meta.LastSeen, ddb.updates)
+	}
+
+	if meta := load(); !meta.LastSeen.Equal(now) || ddb.updates != 1 {
+		t.Errorf("expected the bump to be throttled; got %v after %v updates", meta.LastSeen, ddb.updates)
+	}
+}
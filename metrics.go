@@ -0,0 +1,115 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// MetricsRecorder receives measurements of store operations, for exporting to
+// Prometheus or any other metrics system. Its methods are called synchronously
+// and must be safe for concurrent use.
+type MetricsRecorder interface {
+	// ObserveOperation is called when New, Load, Persist or Delete returns
+	ObserveOperation(m OperationMetrics)
+
+	// ObserveCache is called for every lookup in the cache named cache, one of
+	// LRUCacheName and RequestCacheName
+	ObserveCache(cache string, hit bool)
+}
+
+// OperationMetrics describes a completed store operation
+type OperationMetrics struct {
+	// Operation is New, Load, Persist or Delete
+	Operation string
+
+	// Table is the table the operation targeted
+	Table string
+
+	Duration time.Duration
+
+	// Err is the error returned, if any, and ErrorClass its ClassifyError class
+	Err        error
+	ErrorClass string
+
+	// ItemSize is the size in bytes of the session item read or written, zero
+	// when no item was
+	ItemSize int
+
+	// ConsumedCapacity is the total capacity units consumed, as reported by dynamodb
+	ConsumedCapacity float64
+}
+
+// Names of the caches reported to MetricsRecorder.ObserveCache
+const (
+	LRUCacheName     = "lru"
+	RequestCacheName = "request"
+)
+
+// Error classes returned by ClassifyError
+const (
+	ErrorClassNone        = ""
+	ErrorClassNotFound    = "not_found"
+	ErrorClassExpired     = "expired"
+	ErrorClassConflict    = "conflict"
+	ErrorClassTooLarge    = "too_large"
+	ErrorClassInvalid     = "invalid"
+	ErrorClassCanceled    = "canceled"
+	ErrorClassThrottled   = "throttled"
+	ErrorClassUnavailable = "unavailable"
+	ErrorClassOther       = "other"
+)
+
+// ClassifyError returns the class of an error returned by the store, a low
+// cardinality label suitable for metrics
+func ClassifyError(err error) string {
+	var ccf *types.ConditionalCheckFailedException
+	var verr *ValidationError
+
+	switch {
+	case err == nil:
+		return ErrorClassNone
+	case errors.Is(err, ErrSessionExpired):
+		return ErrorClassExpired
+	case errors.Is(err, ErrInvalidSessionID):
+		return ErrorClassInvalid
+	case errors.Is(err, ErrSessionNotFound):
+		return ErrorClassNotFound
+	case errors.Is(err, ErrSessionConflict), errors.Is(err, ErrConditionFailed), errors.As(err, &ccf):
+		return ErrorClassConflict
+	case errors.Is(err, ErrSessionTooLarge):
+		return ErrorClassTooLarge
+	case errors.As(err, &verr):
+		return ErrorClassInvalid
+	case errors.Is(err, context.Canceled):
+		return ErrorClassCanceled
+	case errors.Is(err, ErrThrottled), throttlingError(err):
+		return ErrorClassThrottled
+	case errors.Is(err, ErrBackendUnavailable), transientError(err):
+		return ErrorClassUnavailable
+	default:
+		return ErrorClassOther
+	}
+}
+
+// observeCache reports a cache lookup to the recorder set with WithMetrics
+func (store *Store) observeCache(cache string, hit bool) {
+	if store.metrics != nil {
+		store.metrics.ObserveCache(cache, hit)
+	}
+}
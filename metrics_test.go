@@ -0,0 +1,94 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/gorilla/sessions"
+)
+
+type recordingMetrics struct {
+	mu         sync.Mutex
+	operations []OperationMetrics
+	hits       map[bool]int
+}
+
+func (m *recordingMetrics) ObserveOperation(op OperationMetrics) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.operations = append(m.operations, op)
+}
+
+func (m *recordingMetrics) ObserveCache(cache string, hit bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.hits[hit]++
+}
+
+func TestMetrics(t *testing.T) {
+	ctx := context.TODO()
+
+	metrics := &recordingMetrics{hits: map[bool]int{}}
+	store, _ := New(newFakeDynamoDB(), MaxAge(3600), WithCache(10, time.Minute), WithMetrics(metrics))
+
+	persistUserSessions(t, store, "bob", 1)
+	if err := store.Load(ctx, "bob-0", sessions.NewSession(store, "session")); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Load(ctx, "missing", sessions.NewSession(store, "session")); err == nil {
+		t.Fatal("expected missing session not to load")
+	}
+
+	if len(metrics.operations) != 3 {
+		t.Fatalf("expected 3 operations; got %v", metrics.operations)
+	}
+	if op := metrics.operations[0]; op.Operation != "Persist" || op.Table != DefaultTableName || op.ItemSize == 0 || op.ErrorClass != ErrorClassNone {
+		t.Errorf("expected a successful Persist; got %+v", op)
+	}
+	if op := metrics.operations[2]; op.Operation != "Load" || op.ErrorClass != ErrorClassNotFound {
+		t.Errorf("expected a not found Load; got %+v", op)
+	}
+	if metrics.hits[true] != 1 || metrics.hits[false] != 1 {
+		t.Errorf("expected one cache hit and one miss; got %v", metrics.hits)
+	}
+}
+
+func TestClassifyError(t *testing.T) {
+	testCases := map[string]struct {
+		err  error
+		want string
+	}{
+		"nil":       {want: ErrorClassNone},
+		"expired":   {err: ErrSessionExpired, want: ErrorClassExpired},
+		"conflict":  {err: fmt.Errorf("failed: %w", ErrSessionConflict), want: ErrorClassConflict},
+		"throttled": {err: &types.ProvisionedThroughputExceededException{Message: aws.String("slow down")}, want: ErrorClassThrottled},
+		"server":    {err: &types.InternalServerError{}, want: ErrorClassUnavailable},
+		"other":     {err: fmt.Errorf("boom"), want: ErrorClassOther},
+	}
+
+	for label, tc := range testCases {
+		t.Run(label, func(t *testing.T) {
+			if got := ClassifyError(tc.err); got != tc.want {
+				t.Errorf("expected %q; got %q", tc.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/sessions"
+)
+
+// contextKey is the context key under which Middleware stores loaded sessions
+type contextKey struct{}
+
+// Middleware loads the sessions named names from store before calling the next
+// handler, which retrieves them with FromContext instead of calling store.Get
+// itself. Loading errors other than ErrSessionNotFound, which leaves a new
+// session in place, are surfaced with a 500 response. Requests are given a
+// ContextWithLoadCache context.
+func Middleware(store *Store, names ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			req = req.WithContext(ContextWithLoadCache(req.Context()))
+
+			loaded := make(map[string]*sessions.Session, len(names))
+			if existing, ok := req.Context().Value(contextKey{}).(map[string]*sessions.Session); ok {
+				for name, session := range existing {
+					loaded[name] = session
+				}
+			}
+
+			for _, name := range names {
+				session, err := store.Get(req, name)
+				if err != nil && !errors.Is(err, ErrSessionNotFound) {
+					http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+					return
+				}
+				loaded[name] = session
+			}
+
+			next.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), contextKey{}, loaded)))
+		})
+	}
+}
+
+// FromContext returns the session named name loaded by Middleware
+func FromContext(ctx context.Context, name string) (*sessions.Session, bool) {
+	loaded, ok := ctx.Value(contextKey{}).(map[string]*sessions.Session)
+	if !ok {
+		return nil, false
+	}
+
+	session, ok := loaded[name]
+	return session, ok
+}
@@ -0,0 +1,124 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMiddleware(t *testing.T) {
+	store, _ := New(newFakeDynamoDB())
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	session, _ := store.New(req, "session")
+	session.Values["user_id"] = "alice"
+	w := httptest.NewRecorder()
+	if err := store.Save(req, w, session); err != nil {
+		t.Fatal(err)
+	}
+
+	var called bool
+	handler := Middleware(store, "session")(Middleware(store, "flash")(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		called = true
+
+		session, ok := FromContext(req.Context(), "session")
+		if !ok || session.Values["user_id"] != "alice" {
+			t.Errorf("expected the session to be loaded; got %v", session)
+		}
+		if flash, ok := FromContext(req.Context(), "flash"); !ok || !flash.IsNew {
+			t.Error("expected a new session to be available for names without a cookie")
+		}
+		if _, ok := FromContext(req.Context(), "other"); ok {
+			t.Error("expected sessions not named to be absent")
+		}
+	})))
+
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(w.Result().Cookies()[0])
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if !called {
+		t.Fatal("expected the handler to be called")
+	}
+}
+
+func TestAutoSave(t *testing.T) {
+	ddb := newFakeDynamoDB()
+	store, _ := New(ddb)
+
+	handler := AutoSave(store, func(req *http.Request, err error) {
+		t.Errorf("unexpected error: %v", err)
+	})(Middleware(store, "session", "flash")(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		session, _ := FromContext(req.Context(), "session")
+		if req.URL.Path == "/login" {
+			session.Values["user_id"] = "alice"
+		}
+	})))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if len(ddb.items) != 0 || len(w.Result().Cookies()) != 0 {
+		t.Fatal("expected untouched sessions not to be saved")
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/login", nil))
+	if len(ddb.items) != 1 {
+		t.Fatalf("expected only the modified session to be saved; got %v", len(ddb.items))
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != "session" {
+		t.Fatalf("expected the session cookie to be set; got %v", cookies)
+	}
+
+	// Reading a session doesn't rewrite it
+	puts := ddb.puts
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(cookies[0])
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if ddb.puts != puts {
+		t.Error("expected the session not to be rewritten")
+	}
+}
+
+func TestResponseWriter(t *testing.T) {
+	ddb := newFakeDynamoDB()
+	store, _ := New(ddb)
+
+	recorder := httptest.NewRecorder()
+	w, req := NewResponseWriter(store, recorder, httptest.NewRequest(http.MethodGet, "/", nil), nil)
+
+	session, _ := store.Get(req, "session")
+	session.Values["user_id"] = "alice"
+
+	// The body is written before the handler would have saved the session
+	w.Write([]byte("hello"))
+	if cookies := recorder.Result().Cookies(); len(cookies) != 1 {
+		t.Fatalf("expected the cookie to be set before the body; got %v", cookies)
+	}
+
+	puts := ddb.puts
+	w.SaveSessions()
+	if ddb.puts != puts {
+		t.Error("expected sessions already saved not to be saved again")
+	}
+
+	session.Values["cart"] = "3 items"
+	w.SaveSessions()
+	if ddb.puts != puts+1 {
+		t.Error("expected sessions modified after the header to be saved")
+	}
+}
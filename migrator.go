@@ -0,0 +1,258 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// ItemTransform converts an item of the source table of a Migrator into an item
+// of the target table, e.g. to rename the primary key. It is also given items
+// holding only a key, for deletes, and must convert them likewise.
+type ItemTransform func(item map[string]types.AttributeValue) (map[string]types.AttributeValue, error)
+
+// MigrationProgress reports the progress of Migrator.Backfill and Migrator.Verify
+type MigrationProgress struct {
+	// Scanned is the number of items read from the source table so far
+	Scanned int64
+
+	// Copied is the number of items written to the target table by Backfill
+	Copied int64
+
+	// Existing is the number of items Backfill found already in the target
+	// table, written there by shadow writes, and left as they were
+	Existing int64
+
+	// Missing is the number of items Verify didn't find in the target table
+	Missing int64
+}
+
+// Migrator moves the sessions from a source table to a target table, possibly
+// with another key schema, region or account, without signing anyone out. A
+// migration runs in four steps:
+//
+//  1. the application is deployed with ShadowOptions, so it keeps using the
+//     source table but mirrors its writes to the target table
+//  2. Backfill copies the items written before that to the target table
+//  3. Verify checks every item of the source table reached the target table
+//  4. the application is deployed against the target table with CutoverOptions,
+//     reading sessions it misses from the source table
+//
+// Once the sessions left in the source table have expired, it can be dropped.
+type Migrator struct {
+	source      *Store
+	target      DynamoDBClient
+	targetTable string
+	targetKey   string
+	transform   ItemTransform
+	segments    int
+	onProgress  func(MigrationProgress)
+
+	mu       sync.Mutex
+	progress MigrationProgress
+}
+
+// MigratorOption configures a Migrator
+type MigratorOption func(*Migrator)
+
+// MigrateSegments scans the source table with n parallel segments. Defaults to 1.
+func MigrateSegments(n int) MigratorOption {
+	return func(m *Migrator) {
+		m.segments = n
+	}
+}
+
+// MigrateTransform converts items on their way to the target table, whose primary
+// key is named targetKey. CutoverOptions can't be used with a transform that
+// changes the key of items, as sessions are then no longer found in the source
+// table under their key in the target table.
+func MigrateTransform(targetKey string, transform ItemTransform) MigratorOption {
+	return func(m *Migrator) {
+		m.targetKey = targetKey
+		m.transform = transform
+	}
+}
+
+// MigrateProgress calls fn with the running totals after every item of the
+// source table, never concurrently
+func MigrateProgress(fn func(MigrationProgress)) MigratorOption {
+	return func(m *Migrator) {
+		m.onProgress = fn
+	}
+}
+
+// NewMigrator returns a Migrator from sourceTable, read through source, to
+// targetTable, written through target
+func NewMigrator(source DynamoDBClient, sourceTable string, target DynamoDBClient, targetTable string, opts ...MigratorOption) (*Migrator, error) {
+	store, err := New(source, TableName(sourceTable))
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Migrator{
+		source:      store,
+		target:      target,
+		targetTable: targetTable,
+		targetKey:   DefaultPrimaryKey,
+		segments:    1,
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	m.segments = max(m.segments, 1)
+
+	return m, nil
+}
+
+// ShadowOptions returns the options making a store using the source table mirror
+// its writes to the target table, whichever operation makes them, so revoked,
+// rotated and bulk deleted sessions reach the target table too. queueSize and
+// onError are as for WithShadowWrites.
+func (m *Migrator) ShadowOptions(queueSize int, onError func(error)) []Option {
+	return []Option{
+		WithShadowWrites(m.target, m.targetTable, queueSize, onError),
+		func(s *Store) {
+			s.shadow.transform = m.transform
+		},
+	}
+}
+
+// CutoverOptions returns the options making a store use the target table, falling
+// back to the source table for sessions it doesn't hold yet and copying them over
+func (m *Migrator) CutoverOptions() []Option {
+	return []Option{
+		DynamoDB(m.target),
+		TableName(m.targetTable),
+		WithLegacyTable(m.source.ddb, m.source.tableName, true),
+	}
+}
+
+// Backfill copies every item of the source table to the target table, leaving
+// alone items already there as they were written by shadow writes, which are at
+// least as fresh. It can be interrupted and run again.
+func (m *Migrator) Backfill(ctx context.Context) (MigrationProgress, error) {
+	return m.run(ctx, func(item map[string]types.AttributeValue) error {
+		_, err := m.target.PutItem(ctx, &dynamodb.PutItemInput{
+			TableName:                aws.String(m.targetTable),
+			Item:                     item,
+			ConditionExpression:      aws.String("attribute_not_exists(#pk)"),
+			ExpressionAttributeNames: map[string]string{"#pk": m.targetKey},
+		})
+
+		var ccf *types.ConditionalCheckFailedException
+		switch {
+		case err == nil:
+			m.count(func(p *MigrationProgress) { p.Copied++ })
+		case errors.As(err, &ccf):
+			m.count(func(p *MigrationProgress) { p.Existing++ })
+		default:
+			return fmt.Errorf("failed to copy item to %s: %w", m.targetTable, err)
+		}
+
+		return nil
+	})
+}
+
+// Verify checks that every item of the source table exists in the target table,
+// reporting the number missing
+func (m *Migrator) Verify(ctx context.Context) (MigrationProgress, error) {
+	return m.run(ctx, func(item map[string]types.AttributeValue) error {
+		result, err := m.target.GetItem(ctx, &dynamodb.GetItemInput{
+			TableName:            aws.String(m.targetTable),
+			Key:                  map[string]types.AttributeValue{m.targetKey: item[m.targetKey]},
+			ProjectionExpression: aws.String("#pk"),
+			ExpressionAttributeNames: map[string]string{
+				"#pk": m.targetKey,
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to read item from %s: %w", m.targetTable, err)
+		}
+		if result.Item == nil {
+			m.count(func(p *MigrationProgress) { p.Missing++ })
+		}
+
+		return nil
+	})
+}
+
+// run scans the source table, calling fn with every item once transformed
+func (m *Migrator) run(ctx context.Context, fn func(map[string]types.AttributeValue) error) (MigrationProgress, error) {
+	m.mu.Lock()
+	m.progress = MigrationProgress{}
+	m.mu.Unlock()
+
+	config := iterateConfig{segments: m.segments}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		errMu    sync.Mutex
+		firstErr error
+	)
+
+	for segment := 0; segment < m.segments; segment++ {
+		wg.Add(1)
+		go func(segment int) {
+			defer wg.Done()
+
+			err := m.source.scanItems(ctx, segment, config, nil, func(item map[string]types.AttributeValue) error {
+				m.count(func(p *MigrationProgress) { p.Scanned++ })
+
+				if m.transform != nil {
+					var err error
+					if item, err = m.transform(item); err != nil {
+						return fmt.Errorf("failed to transform item: %w", err)
+					}
+				}
+
+				return fn(item)
+			})
+
+			errMu.Lock()
+			defer errMu.Unlock()
+			if err != nil && firstErr == nil {
+				firstErr = err
+				cancel()
+			}
+		}(segment)
+	}
+
+	wg.Wait()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.progress, firstErr
+}
+
+// count updates the progress and reports it
+func (m *Migrator) count(update func(*MigrationProgress)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	update(&m.progress)
+	if m.onProgress != nil {
+		m.onProgress(m.progress)
+	}
+}
@@ -0,0 +1,178 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"context"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/gorilla/sessions"
+)
+
+func TestMigrator(t *testing.T) {
+	ctx := context.TODO()
+
+	source := newFakeDynamoDB()
+	target := newFakeDynamoDB()
+	target.primaryKey = "session_id"
+
+	renameKey := func(item map[string]types.AttributeValue) (map[string]types.AttributeValue, error) {
+		out := maps.Clone(item)
+		out["session_id"] = out[DefaultPrimaryKey]
+		delete(out, DefaultPrimaryKey)
+		return out, nil
+	}
+
+	var reports int
+	m, err := NewMigrator(source, DefaultTableName, target, "sessions-v2",
+		MigrateSegments(2),
+		MigrateTransform("session_id", renameKey),
+		MigrateProgress(func(MigrationProgress) { reports++ }),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	old, _ := New(source, MaxAge(3600))
+	persistUserSessions(t, old, "bob", 5)
+
+	app, _ := New(source, append(m.ShadowOptions(10, nil), MaxAge(3600))...)
+	persistUserSessions(t, app, "alice", 2)
+	if err := app.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	migrated := target.others["sessions-v2"]
+	if _, ok := migrated["alice-0"]["session_id"]; !ok || len(migrated) != 2 {
+		t.Fatalf("expected shadow writes to be transformed; got %v", migrated)
+	}
+
+	progress, err := m.Verify(ctx)
+	if err != nil || progress.Missing != 5 {
+		t.Errorf("expected 5 items to be missing before the backfill; got %+v, %v", progress, err)
+	}
+
+	progress, err = m.Backfill(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if progress.Scanned != 7 || progress.Copied != 5 || progress.Existing != 2 || reports == 0 {
+		t.Errorf("expected 5 items to be copied and 2 left alone; got %+v", progress)
+	}
+
+	progress, err = m.Verify(ctx)
+	if err != nil || progress.Missing != 0 {
+		t.Errorf("expected no item to be missing; got %+v, %v", progress, err)
+	}
+}
+
+func TestMigratorCutover(t *testing.T) {
+	source := newFakeDynamoDB()
+	target := newFakeDynamoDB()
+
+	m, _ := NewMigrator(source, DefaultTableName, target, DefaultTableName)
+
+	old, _ := New(source, MaxAge(3600))
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	session, _ := old.New(req, "session")
+	session.Values["name"] = "bob"
+	w := httptest.NewRecorder()
+	if err := old.Save(req, w, session); err != nil {
+		t.Fatal(err)
+	}
+
+	store, _ := New(nil, append(m.CutoverOptions(), MaxAge(3600))...)
+	next := httptest.NewRequest(http.MethodGet, "/", nil)
+	for _, cookie := range w.Result().Cookies() {
+		next.AddCookie(cookie)
+	}
+
+	loaded, _ := store.New(next, "session")
+	if loaded.IsNew || loaded.Values["name"] != "bob" {
+		t.Errorf("expected the session to be read from the source table; got %v", loaded.Values)
+	}
+	if _, ok := target.items[session.ID]; !ok {
+		t.Error("expected the session to be copied to the target table")
+	}
+
+}
+
+func TestMigratorShadowPhase(t *testing.T) {
+	ctx := context.TODO()
+
+	source := newFakeDynamoDB()
+	target := newFakeDynamoDB()
+	target.primaryKey = "session_id"
+
+	renameKey := func(item map[string]types.AttributeValue) (map[string]types.AttributeValue, error) {
+		out := maps.Clone(item)
+		out["session_id"] = out[DefaultPrimaryKey]
+		delete(out, DefaultPrimaryKey)
+		return out, nil
+	}
+
+	m, err := NewMigrator(source, DefaultTableName, target, "sessions-v2", MigrateTransform("session_id", renameKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	old, _ := New(source, MaxAge(3600))
+	bob := persistUserSessions(t, old, "bob", 3)
+
+	app, _ := New(source, append(m.ShadowOptions(10, func(err error) {
+		t.Errorf("unexpected shadow error: %v", err)
+	}), MaxAge(3600), WithUserIndex("user-index", "user_id"))...)
+	alice := persistUserSessions(t, app, "alice", 2)
+	persistUserSessions(t, app, "carol", 2)
+
+	if err := app.Revoke(ctx, bob[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.Revoke(ctx, alice[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	session := sessions.NewSession(app, "session")
+	session.ID = alice[1]
+	session.Options = app.newOptions()
+	session.Values["user_id"] = "alice"
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if err := app.RegenerateID(ctx, req, httptest.NewRecorder(), session); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := app.DeleteAllForUser(ctx, "carol"); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := m.Backfill(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]map[string]types.AttributeValue{}
+	for id, item := range source.items {
+		want[id], _ = renameKey(item)
+	}
+	if migrated := target.others["sessions-v2"]; !reflect.DeepEqual(migrated, want) {
+		t.Errorf("expected the target table to match the source table;\n got %v\nwant %v", migrated, want)
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+const (
+	// NamespaceField holds the namespace of sessions written with WithNamespace
+	NamespaceField = "namespace"
+
+	// namespaceSeparator separates the namespace from the rest of an item key
+	namespaceSeparator = "/"
+)
+
+// errInvalidNamespace is returned by New for a namespace that is empty or
+// contains namespaceSeparator, whose prefix would also match the keys of other
+// namespaces
+var errInvalidNamespace = fmt.Errorf("WithNamespace requires a namespace that isn't empty and doesn't contain %q", namespaceSeparator)
+
+// validNamespace reports whether namespace can be used to prefix item keys
+func validNamespace(namespace string) bool {
+	return namespace != "" && !strings.Contains(namespace, namespaceSeparator)
+}
+
+// namespaced prefixes an item key with the namespace of the store, if any
+func (store *Store) namespaced(key string) string {
+	if store.namespace == "" {
+		return key
+	}
+	return store.namespace + namespaceSeparator + key
+}
+
+// keyID returns the session id stored under an item key, or its digest when
+// WithHashedIDs is set
+func (store *Store) keyID(key string) string {
+	return strings.TrimPrefix(key, store.namespaced(""))
+}
+
+// namespaceFilter narrows a scan or query filter to the items of the namespace of
+// the store, returning the filter and expression maps to use
+func (store *Store) namespaceFilter(filter *string, names map[string]string, values map[string]types.AttributeValue) (*string, map[string]string, map[string]types.AttributeValue) {
+	if store.namespace == "" {
+		return filter, names, values
+	}
+
+	cond := "begins_with(#namespace_pk, :namespace)"
+	if f := aws.ToString(filter); f != "" {
+		cond = "(" + f + ") AND " + cond
+	}
+
+	if names == nil {
+		names = map[string]string{}
+	}
+	names["#namespace_pk"] = store.primaryKey
+
+	if values == nil {
+		values = map[string]types.AttributeValue{}
+	}
+	values[":namespace"] = &types.AttributeValueMemberS{Value: store.namespaced("")}
+
+	return aws.String(cond), names, values
+}
+
+// DeleteNamespace deletes every item of the namespace set with WithNamespace,
+// sessions and auxiliary items alike, leaving other namespaces sharing the
+// table untouched. It scans the whole table.
+func (store *Store) DeleteNamespace(ctx context.Context) error {
+	store = store.scoped(ctx)
+
+	if store.namespace == "" {
+		return fmt.Errorf("a namespace must be configured with WithNamespace")
+	}
+
+	input := &dynamodb.ScanInput{
+		TableName:            aws.String(store.tableName),
+		ProjectionExpression: aws.String("#namespace_pk"),
+	}
+	input.FilterExpression, input.ExpressionAttributeNames, input.ExpressionAttributeValues = store.namespaceFilter(nil, nil, nil)
+
+	var requests []types.WriteRequest
+	paginator := dynamodb.NewScanPaginator(store.ddb, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to scan namespace: %w", err)
+		}
+
+		for _, item := range page.Items {
+			requests = append(requests, types.WriteRequest{
+				DeleteRequest: &types.DeleteRequest{
+					Key: map[string]types.AttributeValue{store.primaryKey: item[store.primaryKey]},
+				},
+			})
+		}
+	}
+
+	return store.batchWrite(ctx, requests)
+}
@@ -0,0 +1,123 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+)
+
+func TestNamespace(t *testing.T) {
+	ctx := context.TODO()
+
+	ddb := newFakeDynamoDB()
+	a, _ := New(ddb, WithNamespace("a"), WithUserIndex("user-index", "user_id"), MaxAge(3600))
+	b, _ := New(ddb, WithNamespace("b"), WithUserIndex("user-index", "user_id"), MaxAge(3600))
+
+	persistUserSessions(t, a, "bob", 3)
+	persistUserSessions(t, b, "bob", 2)
+	if err := a.PutNonce(ctx, "state", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	item, ok := ddb.items["a/bob-0"]
+	if !ok {
+		t.Fatalf("expected the item key to be prefixed with the namespace; got %v", ddb.items)
+	}
+	if v := attributeString(item[NamespaceField]); v != "a" {
+		t.Errorf("expected the namespace attribute to be a; got %v", v)
+	}
+
+	if err := b.Load(ctx, "bob-2", sessions.NewSession(b, "session")); err != ErrSessionNotFound {
+		t.Errorf("expected a session of another namespace not to load; got %v", err)
+	}
+	session := sessions.NewSession(a, "session")
+	if err := a.Load(ctx, "bob-0", session); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := session.Values[NamespaceField]; ok {
+		t.Error("expected the namespace attribute not to be loaded as a value")
+	}
+
+	if n, err := b.ActiveSessionCount(ctx, "bob"); err != nil || n != 2 {
+		t.Errorf("expected 2 sessions in namespace b; got %v, %v", n, err)
+	}
+
+	visited := 0
+	err := a.Iterate(ctx, func(item SessionItem) error {
+		visited++
+		return nil
+	})
+	if err != nil || visited != 3 {
+		t.Errorf("expected 3 sessions to be visited in namespace a; got %v, %v", visited, err)
+	}
+
+	if err := a.DeleteNamespace(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(ddb.items) != 2 {
+		t.Errorf("expected only the items of namespace b to remain; got %v", ddb.items)
+	}
+}
+
+func TestNamespaceValidation(t *testing.T) {
+	testCases := map[string]struct {
+		Namespace string
+		Valid     bool
+	}{
+		"plain":     {Namespace: "a", Valid: true},
+		"empty":     {Namespace: ""},
+		"separator": {Namespace: "a/b"},
+	}
+
+	for label, tc := range testCases {
+		t.Run(label, func(t *testing.T) {
+			_, err := New(newFakeDynamoDB(), WithNamespace(tc.Namespace))
+			if tc.Valid != (err == nil) {
+				t.Errorf("expected valid %v; got %v", tc.Valid, err)
+			}
+
+			defer func() {
+				if panicked := recover() != nil; panicked == tc.Valid {
+					t.Errorf("expected ContextWithNamespace to panic: %v", !tc.Valid)
+				}
+			}()
+			ContextWithNamespace(context.TODO(), tc.Namespace)
+		})
+	}
+}
+
+func TestDeleteNamespaceOfPrefixTenant(t *testing.T) {
+	ctx := context.TODO()
+
+	ddb := newFakeDynamoDB()
+	a, _ := New(ddb, WithNamespace("a"), MaxAge(3600))
+	ab, _ := New(ddb, WithNamespace("ab"), MaxAge(3600))
+
+	persistUserSessions(t, a, "bob", 2)
+	persistUserSessions(t, ab, "bob", 2)
+
+	if err := a.DeleteNamespace(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(ddb.items) != 2 {
+		t.Errorf("expected the items of the other tenant to remain; got %v", ddb.items)
+	}
+	if err := ab.Load(ctx, "bob-0", sessions.NewSession(ab, "session")); err != nil {
+		t.Errorf("expected the other tenant's session to load; got %v", err)
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// noncePrefix prefixes the primary key of nonce items, which share the sessions
+// table but can never be loaded as sessions
+const noncePrefix = "nonce#"
+
+var (
+	// ErrNonceExists is returned by PutNonce if the nonce is already pending
+	ErrNonceExists = fmt.Errorf("nonce already exists")
+
+	// ErrNonceNotFound is returned by ConsumeNonce if the nonce doesn't exist,
+	// has expired or has already been consumed
+	ErrNonceNotFound = fmt.Errorf("nonce missing, expired or already consumed")
+)
+
+// PutNonce stores a single-use nonce, such as an OAuth state parameter or the
+// idempotency key of a form, that can be consumed once within ttl. The item
+// carries a ttl attribute, so dynamodb's ttl processing cleans up nonces that
+// are never consumed when it is enabled for the table.
+func (store *Store) PutNonce(ctx context.Context, nonce string, ttl time.Duration) error {
+	store = store.scoped(ctx)
+
+	now := store.now()
+
+	item := store.rawKey(noncePrefix + nonce)
+	item[DefaultTTLField] = &types.AttributeValueMemberN{Value: strconv.FormatInt(now.Add(ttl).Unix(), 10)}
+
+	_, err := store.ddb.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:                 aws.String(store.tableName),
+		Item:                      item,
+		ConditionExpression:       aws.String("attribute_not_exists(#pk) OR #ttl <= :now"),
+		ExpressionAttributeNames:  map[string]string{"#pk": store.primaryKey, "#ttl": DefaultTTLField},
+		ExpressionAttributeValues: map[string]types.AttributeValue{":now": &types.AttributeValueMemberN{Value: strconv.FormatInt(now.Unix(), 10)}},
+	})
+
+	var ccf *types.ConditionalCheckFailedException
+	if errors.As(err, &ccf) {
+		return ErrNonceExists
+	}
+	if err != nil {
+		return fmt.Errorf("failed to put nonce: %w", err)
+	}
+
+	return nil
+}
+
+// ConsumeNonce deletes the nonce, succeeding only if it exists and hasn't
+// expired. The delete is conditional, so of several concurrent calls for the
+// same nonce exactly one succeeds and the others get ErrNonceNotFound.
+func (store *Store) ConsumeNonce(ctx context.Context, nonce string) error {
+	store = store.scoped(ctx)
+
+	_, err := store.ddb.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName:                 aws.String(store.tableName),
+		Key:                       store.rawKey(noncePrefix + nonce),
+		ConditionExpression:       aws.String("attribute_exists(#pk) AND #ttl > :now"),
+		ExpressionAttributeNames:  map[string]string{"#pk": store.primaryKey, "#ttl": DefaultTTLField},
+		ExpressionAttributeValues: map[string]types.AttributeValue{":now": &types.AttributeValueMemberN{Value: strconv.FormatInt(store.now().Unix(), 10)}},
+	})
+
+	var ccf *types.ConditionalCheckFailedException
+	if errors.As(err, &ccf) {
+		return ErrNonceNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to consume nonce: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,58 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+)
+
+func TestNonce(t *testing.T) {
+	ctx := context.TODO()
+
+	now := time.Unix(1700000000, 0)
+	store, _ := New(newFakeDynamoDB(), WithClock(func() time.Time { return now }))
+
+	if err := store.PutNonce(ctx, "state", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.PutNonce(ctx, "state", time.Minute); err != ErrNonceExists {
+		t.Errorf("expected %v; got %v", ErrNonceExists, err)
+	}
+
+	if err := store.Load(ctx, noncePrefix+"state", sessions.NewSession(store, "session")); err != ErrSessionNotFound {
+		t.Errorf("expected nonces not to load as sessions; got %v", err)
+	}
+
+	if err := store.ConsumeNonce(ctx, "state"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.ConsumeNonce(ctx, "state"); err != ErrNonceNotFound {
+		t.Errorf("expected %v; got %v", ErrNonceNotFound, err)
+	}
+
+	if err := store.PutNonce(ctx, "expiring", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(2 * time.Minute)
+	if err := store.ConsumeNonce(ctx, "expiring"); err != ErrNonceNotFound {
+		t.Errorf("expected expired nonces to be rejected; got %v", err)
+	}
+	if err := store.PutNonce(ctx, "expiring", time.Minute); err != nil {
+		t.Errorf("expected an expired nonce to be replaceable; got %v", err)
+	}
+}
@@ -14,7 +14,11 @@
 package dynastore
 
 import (
-	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/securecookie"
 	"github.com/gorilla/sessions"
 )
 
@@ -22,12 +26,22 @@ import (
 type Option func(*Store)
 
 // DynamoDB allows a pre-configured dynamodb client to be supplied
-func DynamoDB(ddb *dynamodb.Client) Option {
+func DynamoDB(ddb DynamoDBClient) Option {
 	return func(s *Store) {
 		s.ddb = ddb
 	}
 }
 
+// WithInMemory keeps sessions in memory instead of dynamodb, ignoring the client
+// passed to New, so an application can run locally without AWS while keeping
+// the session semantics of every other option. Sessions are lost on restart and
+// not shared between processes. Expired sessions are deleted every minute.
+func WithInMemory() Option {
+	return func(s *Store) {
+		s.inMemory = true
+	}
+}
+
 // TableName allows a custom table name to be specified
 func TableName(tableName string) Option {
 	return func(s *Store) {
@@ -89,9 +103,693 @@ func HTTPOnly() Option {
 	}
 }
 
+// SameSite sets the default session option of the same name. SameSite=None
+// requires Secure, which Save enforces.
+func SameSite(v http.SameSite) Option {
+	return func(s *Store) {
+		s.options.SameSite = v
+	}
+}
+
+// Partitioned sets the default session option of the same name, placing the
+// cookie in partitioned (CHIPS) storage so sessions keep working when embedded in
+// third party iframes. Partitioned cookies must also be Secure.
+func Partitioned() Option {
+	return func(s *Store) {
+		s.options.Partitioned = true
+	}
+}
+
+// SecureDefaultMaxAge is the MaxAge applied by WithSecureDefaults
+const SecureDefaultMaxAge = 24 * 60 * 60
+
+// WithSecureDefaults applies a hardened configuration: HttpOnly and Secure
+// cookies with SameSite=Lax, a MaxAge of SecureDefaultMaxAge, the ttl attribute
+// and signed session ids. Since the signing key must be shared by every instance
+// it isn't generated; New fails unless one is supplied with WithSigningKey or
+// WithKeyProvider. Options given after WithSecureDefaults override its values.
+func WithSecureDefaults() Option {
+	return func(s *Store) {
+		s.options.HttpOnly = true
+		s.options.Secure = true
+		s.options.SameSite = http.SameSiteLaxMode
+		s.options.MaxAge = SecureDefaultMaxAge
+		s.enableTTL = true
+		s.requireSigning = true
+	}
+}
+
 // TTL enables setting a ttl key on the session prior to saving to dynamodb
 func TTLEnabled() Option {
 	return func(s *Store) {
 		s.enableTTL = true
 	}
 }
+
+// WithServerTTL sets the lifetime used to compute the ttl written to dynamodb,
+// independent of the cookie MaxAge. This allows browser session cookies (MaxAge 0)
+// to be backed by items that do not expire the moment they are written.
+func WithServerTTL(d time.Duration) Option {
+	return func(s *Store) {
+		s.serverTTL = d
+	}
+}
+
+// WithTTLGrace lets Load accept items up to d past their ttl, so clock skew
+// between app servers and dynamodb ttl processing doesn't bounce users right at
+// the boundary. The ttl written to dynamodb is not padded, so a session lives
+// exactly its lifetime plus d.
+func WithTTLGrace(d time.Duration) Option {
+	return func(s *Store) {
+		s.ttlGrace = d
+	}
+}
+
+// WithMinTTL sets the shortest lifetime written to dynamodb. Zero, negative or
+// tiny MaxAge values are raised to d rather than producing already-expired items.
+func WithMinTTL(d time.Duration) Option {
+	return func(s *Store) {
+		s.minTTL = d
+	}
+}
+
+// WithMaxTTL sets the longest lifetime written to dynamodb, so an absurdly large
+// MaxAge can't produce items that are effectively immortal.
+func WithMaxTTL(d time.Duration) Option {
+	return func(s *Store) {
+		s.maxTTL = d
+	}
+}
+
+// WithTTLJitter adds a random duration in [0, d) to each ttl written to dynamodb,
+// spreading out the expiry of sessions that were created at the same moment.
+// Lifetimes clamped to WithMaxTTL are shortened by the jitter instead.
+func WithTTLJitter(d time.Duration) Option {
+	return func(s *Store) {
+		s.ttlJitter = d
+	}
+}
+
+// WithExpiresAt writes a human readable ISO-8601 expires_at attribute alongside
+// the epoch ttl, for operators browsing the table or running PartiQL queries.
+func WithExpiresAt() Option {
+	return func(s *Store) {
+		s.writeExpiresAt = true
+	}
+}
+
+// WithClock replaces the clock used to compute ttls and evaluate expiry, allowing
+// tests to simulate the passage of time without sleeping.
+func WithClock(now func() time.Time) Option {
+	return func(s *Store) {
+		s.now = now
+	}
+}
+
+// WithAutoEnableTTL calls EnsureTTL when the store is created, so that the ttl
+// attribute written by TTLEnabled actually results in items being cleaned up
+func WithAutoEnableTTL() Option {
+	return func(s *Store) {
+		s.autoEnableTTL = true
+	}
+}
+
+// WithUserIndex declares a global secondary index whose partition key is the
+// session value userKey (e.g. "user_id"). Values are written as top level
+// attributes, so any string value can back such an index.
+func WithUserIndex(indexName, userKey string) Option {
+	return func(s *Store) {
+		s.userIndex = indexName
+		s.userKey = userKey
+	}
+}
+
+// WithCodecs encodes the session id placed in the cookie using the given codecs,
+// so the cookie carries a signed, and optionally encrypted, value. Cookies are
+// encoded with the first codec and decoded by trying each in turn, allowing keys
+// to be rotated. securecookie.CodecsFromPairs is a convenient way to build them.
+func WithCodecs(codecs ...securecookie.Codec) Option {
+	return func(s *Store) {
+		s.codecs = codecs
+	}
+}
+
+// WithIDGenerator mints session ids with generate instead of 32 random bytes in
+// base32, e.g. ULID or UUIDv7 for ids that sort by creation time, or an
+// organization wide identifier scheme. Ids must be unguessable, made of letters,
+// digits, '-', '_' and '.', and at most 128 long. ULID and UUIDv7 carry 80 and
+// 74 random bits against the 256 of the default and reveal when the session was
+// created.
+func WithIDGenerator(generate func() string) Option {
+	return func(s *Store) {
+		s.idGenerator = generate
+	}
+}
+
+// WithIDLength mints session ids from bytes random bytes instead of 32. At least
+// 16 bytes, 128 bits of entropy, and at most 64 are accepted.
+func WithIDLength(bytes int) Option {
+	return func(s *Store) {
+		s.idFormat.bytes = bytes
+	}
+}
+
+// WithIDEncoding encodes the random bytes of session ids with encoding instead
+// of base32. Ids of another length or encoding, such as those issued before a
+// change, are rejected as malformed.
+func WithIDEncoding(encoding IDEncoding) Option {
+	return func(s *Store) {
+		s.idFormat.encoding = encoding
+	}
+}
+
+// WithSigningKey signs the session id placed in the cookie with HMAC-SHA256. The
+// signature is verified before dynamodb is queried, so forged or randomly
+// guessed ids are rejected without consuming read capacity.
+func WithSigningKey(key []byte) Option {
+	return func(s *Store) {
+		s.signingKey = key
+	}
+}
+
+// WithHashedIDs stores a digest of the session id as the item key instead of the
+// id itself, so anyone able to read the table cannot lift usable session tokens.
+// The digest is an HMAC-SHA256 keyed with key, or a plain SHA-256 if key is nil.
+func WithHashedIDs(key []byte) Option {
+	return func(s *Store) {
+		s.hashIDs = true
+		s.hashKey = key
+	}
+}
+
+// WithEncryption encrypts the session values with AES-GCM before they are written,
+// so they are opaque to anyone able to read the table. The key must be 16, 24 or
+// 32 bytes long. The user index key, if configured, is still stored in the clear.
+func WithEncryption(key []byte) Option {
+	return func(s *Store) {
+		s.encryptionKey = key
+	}
+}
+
+// WithKMSEncryption encrypts the session values using envelope encryption: each
+// write generates a data key under the kms key keyID (an id, ARN or alias), and
+// the wrapped data key is stored alongside the ciphertext. Load unwraps it with
+// kms Decrypt. It takes precedence over WithEncryption.
+func WithKMSEncryption(client KMSClient, keyID string) Option {
+	return func(s *Store) {
+		s.kms = &kmsEnvelope{client: client, keyID: keyID}
+	}
+}
+
+// WithKMSDataKeyCache caches kms data keys when WithKMSEncryption is set. A data
+// key is reused for encryption until it is maxAge old or has sealed maxUses
+// sessions, and unwrapped data keys are reused for decryption for up to maxAge.
+// A zero value disables the corresponding bound.
+func WithKMSDataKeyCache(maxAge time.Duration, maxUses int) Option {
+	return func(s *Store) {
+		s.kmsCache = newDataKeyCache(maxAge, maxUses)
+	}
+}
+
+// WithKeyProvider sources the signing and encryption keys from provider rather
+// than WithSigningKey and WithEncryption, so they can be rotated without a
+// redeploy. The keys are fetched once when the store is created; signing and
+// encryption are enabled if the corresponding key is present.
+func WithKeyProvider(provider KeyProvider) Option {
+	return func(s *Store) {
+		s.keys = provider
+	}
+}
+
+// WithEncryptedFields restricts encryption to the named session values, which are
+// encrypted individually while all other values remain readable and queryable in
+// the table. It requires WithEncryption, WithKMSEncryption or a KeyProvider
+// supplying an encryption key.
+func WithEncryptedFields(keys ...string) Option {
+	return func(s *Store) {
+		s.encryptedFields = keys
+	}
+}
+
+// WithPreviousSigningKeys accepts session ids signed by keys that have been
+// rotated out. New ids are always signed with the key given to WithSigningKey.
+func WithPreviousSigningKeys(keys ...[]byte) Option {
+	return func(s *Store) {
+		s.previousSigningKeys = keys
+	}
+}
+
+// WithPreviousEncryptionKeys allows sessions encrypted with keys that have been
+// rotated out to be decrypted. Sessions are re-encrypted with the key given to
+// WithEncryption the next time they are saved.
+func WithPreviousEncryptionKeys(keys ...[]byte) Option {
+	return func(s *Store) {
+		s.previousEncryptionKeys = keys
+	}
+}
+
+// WithIDRotation regenerates the session id on Save whenever one of the predicates
+// reports a privilege transition, so handlers don't need to remember to call
+// RegenerateID. For example WithIDRotation(RotateOnChange("user_id")).
+func WithIDRotation(predicates ...RotationPredicate) Option {
+	return func(s *Store) {
+		s.rotationPredicates = append(s.rotationPredicates, predicates...)
+	}
+}
+
+// WithMaxSessionsPerUser caps the number of concurrent sessions of a user, as
+// identified by the user index configured with WithUserIndex. When a session is
+// first saved for a user who already has max sessions, policy decides whether it
+// is rejected or the oldest sessions are evicted. Sessions are tracked on a
+// registry item per user updated with conditional writes, so concurrent logins
+// can't exceed the cap.
+func WithMaxSessionsPerUser(max int, policy SessionLimitPolicy) Option {
+	return func(s *Store) {
+		s.maxSessions = max
+		s.sessionLimitPolicy = policy
+	}
+}
+
+// WithRevocationTable names a companion table, keyed like the sessions table,
+// to which Revoke adds sessions and which Load consults on every call. Unlike
+// the flag Revoke sets on the session item, the blocklist is checked even when
+// the session itself is served from a cache.
+func WithRevocationTable(tableName string) Option {
+	return func(s *Store) {
+		s.revocationTable = tableName
+	}
+}
+
+// WithIPBinding binds sessions to the address of the client that first saved
+// them, to blunt stolen cookies. A session is only accepted from addresses in the
+// same ipv4Bits or ipv6Bits sized network as the bound address, so 32 and 128
+// require an exact match while e.g. 24 and 64 tolerate address churn within a
+// network. onMismatch decides what happens otherwise; RejectMismatch and
+// FlagMismatch cover the common cases.
+func WithIPBinding(ipv4Bits, ipv6Bits int, onMismatch MismatchHandler) Option {
+	return func(s *Store) {
+		s.ipBinding = &ipBinding{
+			ipv4Bits:   ipv4Bits,
+			ipv6Bits:   ipv6Bits,
+			onMismatch: onMismatch,
+		}
+	}
+}
+
+// WithClientIPFunc overrides how the client address used by WithIPBinding is
+// derived from a request. By default the host of req.RemoteAddr is used, which
+// behind a load balancer is the balancer rather than the client; fn may read a
+// trusted forwarding header instead.
+func WithClientIPFunc(fn func(req *http.Request) string) Option {
+	return func(s *Store) {
+		s.clientIPFunc = fn
+	}
+}
+
+// WithDeviceBinding binds sessions to a fingerprint of the device that first
+// saved them, a digest of its user agent and of the id returned by deviceID,
+// which may be nil or return e.g. a device cookie or app installation id.
+// onMismatch decides what happens when a session is replayed from a device with
+// a different fingerprint, e.g. FlagMismatch to challenge the user.
+func WithDeviceBinding(deviceID func(req *http.Request) string, onMismatch MismatchHandler) Option {
+	return func(s *Store) {
+		s.deviceBinding = &deviceBinding{
+			deviceID:   deviceID,
+			onMismatch: onMismatch,
+		}
+	}
+}
+
+// WithRememberMe enables Remember and Recall, which keep users signed in across
+// sessions with a cookie named cookieName valid for lifetime. Sessions promoted
+// by Recall hold the remembered user id under the session value userKey.
+func WithRememberMe(cookieName string, lifetime time.Duration, userKey string) Option {
+	return func(s *Store) {
+		s.rememberMe = &rememberMe{
+			cookieName: cookieName,
+			lifetime:   lifetime,
+			userKey:    userKey,
+		}
+	}
+}
+
+// WithInvalidIDHandler calls fn whenever a request presents a session cookie that
+// is rejected as malformed (ErrInvalidSessionID) or, with signed ids, forged,
+// before any dynamodb call is made. The request proceeds with a new session
+// either way; fn is meant for abuse monitoring.
+func WithInvalidIDHandler(fn func(req *http.Request, value string, err error)) Option {
+	return func(s *Store) {
+		s.invalidIDHandler = fn
+	}
+}
+
+// WithLastSeenInterval updates the last_seen attribute when a session is loaded,
+// not only when it is saved, making "active in the last 15 minutes" queries
+// possible. To keep write traffic down the attribute is rewritten at most once
+// per interval per session, with an UpdateItem touching nothing else.
+func WithLastSeenInterval(interval time.Duration) Option {
+	return func(s *Store) {
+		s.lastSeenInterval = interval
+	}
+}
+
+// WithLocationResolver sets the function Devices uses to turn the origin address
+// of a device into a human readable location hint, typically backed by a GeoIP
+// database
+func WithLocationResolver(fn func(ip string) string) Option {
+	return func(s *Store) {
+		s.locationResolver = fn
+	}
+}
+
+// WithBearerTokens reads the session id from the request header named header
+// instead of a cookie, for mobile and API clients. With AuthorizationHeader the
+// "Bearer" scheme is expected; other headers carry the bare token. Save doesn't
+// set cookies in this mode; SaveToken returns the token to hand to the client.
+// Tokens are encoded like cookies, so signing and codecs apply to them as well.
+func WithBearerTokens(header string) Option {
+	return func(s *Store) {
+		s.bearerHeader = header
+	}
+}
+
+// WithReadOnly never writes to dynamodb: sessions are loaded, validated and
+// their cookies set as usual, but Save, Delete and most other writes succeed
+// without touching the table. Writes that callers rely on for security, Revoke
+// and conditional deletes such as ConsumeNonce, fail with ErrReadOnly. Useful for canaries, read replicas and dry runs
+// against production tables.
+func WithReadOnly() Option {
+	return func(s *Store) {
+		s.readOnly = true
+	}
+}
+
+// WithNamespace prefixes the keys of every item written by the store with
+// namespace, and records it in the namespace attribute of sessions, so several
+// applications or tenants can share one table without their session ids ever
+// colliding. Iterate and the user index queries only see the namespace's items,
+// and DeleteNamespace wipes them. The namespace must not be empty nor contain
+// "/", which separates it from the rest of the key.
+func WithNamespace(namespace string) Option {
+	return func(s *Store) {
+		s.namespace = namespace
+		s.hasNamespace = true
+	}
+}
+
+// WithSchema validates session values against schema whenever a session is
+// persisted or loaded, so sessions of an unexpected shape are rejected at the
+// store rather than failing deep in a handler. Problems are reported as
+// *ValidationError. A session failing validation on Load is replaced by a new
+// one by New.
+func WithSchema(schema Schema) Option {
+	return func(s *Store) {
+		s.schema = schema
+	}
+}
+
+// WithCookieFallback keeps sessions alive through a dynamodb outage: when a
+// session can't be persisted, it is written instead to a cookie named after the
+// session cookie with a -fallback suffix, signed (and encrypted, given a second
+// key) with keyPairs as with securecookie.CodecsFromPairs. Such sessions are
+// flagged by Degraded and moved back to dynamodb by the first Save that succeeds.
+// Only string keyed values survive, within the 4096 byte limit of a cookie. It
+// has no effect with WithBearerTokens.
+func WithCookieFallback(keyPairs ...[]byte) Option {
+	return func(s *Store) {
+		codecs := securecookie.CodecsFromPairs(keyPairs...)
+		for _, codec := range codecs {
+			if sc, ok := codec.(*securecookie.SecureCookie); ok {
+				sc.SetSerializer(securecookie.JSONEncoder{})
+			}
+		}
+		s.fallback = &cookieFallback{codecs: codecs}
+	}
+}
+
+// WithFailover sends requests to table through secondary, e.g. a client of
+// another region, once threshold requests in a row have failed with errors
+// other than refusals such as failed conditions. The primary is retried after
+// cooldown and takes the traffic back on its first success. An empty table keeps
+// the name of the primary table. Sessions written during the failover are only
+// in the secondary table unless it replicates back, as a global table does.
+func WithFailover(secondary DynamoDBClient, table string, threshold int, cooldown time.Duration) Option {
+	return func(s *Store) {
+		s.failover = &failoverClient{
+			secondary:      secondary,
+			secondaryTable: table,
+			threshold:      max(threshold, 1),
+			cooldown:       cooldown,
+		}
+	}
+}
+
+// WithRegionPinning is meant for global tables: sessions record region, the
+// region of the client given to New, as the region that last wrote them, and a
+// session last written elsewhere is read again from its region, through the
+// client remotes holds for it, with a consistent read. Sessions not replicated
+// yet are looked for in every region of remotes. This spares users roaming
+// between regions from being signed out by replication lag, at the cost of a
+// cross-region read on their first request in a new region.
+func WithRegionPinning(region string, remotes map[string]DynamoDBClient) Option {
+	return func(s *Store) {
+		s.regionPinning = &regionPinning{region: region, remotes: remotes}
+	}
+}
+
+// WithLastWriterWins stamps sessions with a monotonic updated_at and makes
+// Persist refuse to overwrite a session written more recently, so a stale copy,
+// e.g. from another region of an active-active deployment, can't resurrect old
+// state. When the stored session changed since it was loaded, onConflict, if
+// not nil, sees the stored values before the write is retried; the write then
+// only succeeds if it is the newest, failing with ErrSessionConflict otherwise.
+// SaveAll writes each session with the same conditional put instead of batching
+// them, saving the other sessions when one of them conflicts.
+func WithLastWriterWins(onConflict ConflictHandler) Option {
+	return func(s *Store) {
+		s.lastWriterWins = &lastWriterWins{onConflict: onConflict}
+	}
+}
+
+// WithShadowWrites mirrors every write to the table of the store, whether made by
+// Persist, Delete, Touch, Revoke, RegenerateID or the bulk operations, to table
+// through client, which may target another region or account, to try out a new
+// sessions table with production traffic before cutting over. Items changed by
+// an update are copied whole, as read back from the table of the store, which
+// costs a read per update. Mirroring happens in the background through a queue
+// of queueSize writes and never fails or slows down requests: errors, including
+// writes dropped when the queue is full, are passed to onError, which may be
+// nil. Close waits for queued writes.
+func WithShadowWrites(client DynamoDBClient, table string, queueSize int, onError func(error)) Option {
+	return func(s *Store) {
+		s.shadow = &shadowWriter{
+			client:  client,
+			table:   table,
+			onError: onError,
+			queue:   make(chan shadowWrite, max(queueSize, 1)),
+		}
+	}
+}
+
+// WithLegacyTable makes Load look sessions missing from the table of the store up
+// in table, read through client or, if nil, the client of the store, so moving to
+// a new table signs nobody out. With backfill, sessions found there are copied
+// into the new table, unless written to it in the meantime. Sessions are always
+// written to the new table only.
+func WithLegacyTable(client DynamoDBClient, table string, backfill bool) Option {
+	return func(s *Store) {
+		s.legacy = &legacyTable{client: client, table: table, backfill: backfill}
+	}
+}
+
+// WithLegacyFormat lets Load read sessions written by the original dynastore,
+// which kept the values in a single securecookie encoded string attribute, so a
+// table in production can be adopted without signing everyone out. keyPairs are
+// the key pairs the original store was created with. Sessions are rewritten in
+// the current format the next time they are saved.
+func WithLegacyFormat(keyPairs ...[]byte) Option {
+	return func(s *Store) {
+		s.legacyFormat = &legacyFormat{codecs: securecookie.CodecsFromPairs(keyPairs...)}
+	}
+}
+
+// WithWriteBehind makes Persist buffer sessions in memory instead of writing
+// them to the table, trading a durability window for lower request latency:
+// workers background goroutines write the buffer in batches of 25 whenever a
+// batch is full or every interval, 100ms if not positive. A session saved again
+// before being written replaces the buffered item, and Load reads buffered
+// sessions back. Once size sessions are buffered, Persist writes synchronously
+// again. Failed writes are passed to onError, which may be nil, and the
+// sessions they held stay buffered for the next flush; Flush and Close write
+// the buffer out, and sessions still buffered when the process exits are lost.
+// Deletes wait for any flush in flight holding the session, so it can't be
+// written back after them. WithLastWriterWins takes precedence.
+func WithWriteBehind(size, workers int, interval time.Duration, onError func(error)) Option {
+	if interval <= 0 {
+		interval = defaultWriteBehindInterval
+	}
+
+	return func(s *Store) {
+		s.writeBehind = &writeBehind{
+			size:     max(size, 1),
+			workers:  workers,
+			interval: interval,
+			onError:  onError,
+		}
+	}
+}
+
+// WithCache keeps up to size recently loaded sessions in memory for ttl, so hot
+// sessions, say dashboards polling every second, don't cost a read each time.
+// Writes made through the store update or invalidate the cache, but writes made
+// by other processes go unnoticed until ttl has passed, so ttl bounds how stale
+// a session may be. A ttl that isn't positive never expires entries.
+func WithCache(size int, ttl time.Duration) Option {
+	return func(s *Store) {
+		s.cache = newItemCache(size, ttl)
+	}
+}
+
+// WithServeStale makes Load serve the copy of a session kept by WithCache, which
+// it requires, when reading it fails with a throttling, server side or network
+// error, so users stay signed in through brief turbulence. Copies cached more
+// than maxAge ago aren't served; a maxAge that isn't positive serves them however
+// old. Stale flags sessions served this way. Revocation is still checked.
+func WithServeStale(maxAge time.Duration) Option {
+	return func(s *Store) {
+		s.serveStale = &maxAge
+	}
+}
+
+// WithTouchCoalescing makes Touch queue sessions in memory instead of
+// refreshing their ttl right away, so a session touched on every page view
+// costs one write per interval, a second if not positive. Queued sessions are
+// refreshed together in TransactWriteItems batches; those with a MaxAge of
+// their own are refreshed one by one as usual. Touch returns nil without
+// checking the session exists, and refresh errors are passed to onError, which
+// may be nil. Close writes the queue out.
+func WithTouchCoalescing(interval time.Duration, onError func(error)) Option {
+	if interval <= 0 {
+		interval = time.Second
+	}
+
+	return func(s *Store) {
+		s.touches = &touchCoalescer{interval: interval, onError: onError}
+	}
+}
+
+// WithTracer traces New, Load, Persist and Delete with spans started by tracer,
+// carrying the table, the operation, the size of the session item and the
+// capacity consumed, so session latency shows up in existing traces. See Tracer
+// for adapting an OpenTelemetry tracer.
+func WithTracer(tracer Tracer) Option {
+	return func(s *Store) {
+		s.tracer = tracer
+	}
+}
+
+// WithMetrics reports the count, latency, errors by class, item size and
+// consumed capacity of store operations, along with cache lookups, to recorder,
+// which typically exports them as Prometheus counters and histograms
+func WithMetrics(recorder MetricsRecorder) Option {
+	return func(s *Store) {
+		s.metrics = recorder
+	}
+}
+
+// WithStats keeps counts, errors, latency percentiles and consumed capacity of
+// store operations, along with cache hits and misses, in memory for Stats to
+// report. It can be combined with WithMetrics.
+func WithStats() Option {
+	return func(s *Store) {
+		s.stats = newStatsRecorder()
+	}
+}
+
+// WithLogger logs store operations, batch write retries, fallbacks to cookies,
+// stale copies and legacy tables, and session values dropped for having a non
+// string key, at debug level to logger. Session ids are logged as a short digest;
+// WithUnredactedLogs logs them as is.
+func WithLogger(logger *slog.Logger) Option {
+	return func(s *Store) {
+		s.logger = logger
+	}
+}
+
+// WithUnredactedLogs makes WithLogger log session ids as is. Ids are bearer
+// credentials: only use it where logs are as protected as the table.
+func WithUnredactedLogs() Option {
+	return func(s *Store) {
+		s.logRawIDs = true
+	}
+}
+
+// WithBeforeSave registers hooks called by Persist, and so Save, before the
+// session is written, to enrich it, say by stamping a tenant id, or to validate
+// it: an error aborts the write and is returned
+func WithBeforeSave(hooks ...SessionHook) Option {
+	return func(s *Store) {
+		s.hooks.beforeSave = append(s.hooks.beforeSave, hooks...)
+	}
+}
+
+// WithAfterLoad registers hooks called once Load has read a session. An error
+// fails the Load, so New hands out a new session instead.
+func WithAfterLoad(hooks ...SessionHook) Option {
+	return func(s *Store) {
+		s.hooks.afterLoad = append(s.hooks.afterLoad, hooks...)
+	}
+}
+
+// WithOnDelete registers hooks called once Delete, SaveAll or
+// DeleteAllForUserExcept has removed a session, e.g. to count logouts. Their
+// error is returned by the operation. Sessions deleted through the user index
+// are passed as the digest of their id when WithHashedIDs is set.
+func WithOnDelete(hooks ...DeleteHook) Option {
+	return func(s *Store) {
+		s.hooks.onDelete = append(s.hooks.onDelete, hooks...)
+	}
+}
+
+// WithOnLargeSession calls fn with the id and item size in bytes of every session
+// written whose item exceeds threshold bytes, a soft limit for noticing session
+// bloat long before writes fail with ErrSessionTooLarge at the 400KB item limit.
+// fn is called synchronously on every such write and should be cheap.
+func WithOnLargeSession(threshold int, fn func(id string, size int)) Option {
+	return func(s *Store) {
+		s.largeSession = &largeSession{threshold: threshold, fn: fn}
+	}
+}
+
+// WithEvents publishes session lifecycle events to publisher, say EventBridge or
+// SNS, so downstream systems can react to logins and logouts without polling.
+// Events are published in the background through a queue of queueSize events
+// and never fail or slow down requests: errors, including events dropped when
+// the queue is full, are passed to onError, which may be nil. Close waits for
+// queued events. Sessions removed by dynamodb ttl processing without being
+// loaded again produce no event; ConsumeStream reports those from the stream of
+// the table.
+func WithEvents(publisher EventPublisher, queueSize int, onError func(error)) Option {
+	return func(s *Store) {
+		s.events = &eventQueue{
+			publisher: publisher,
+			onError:   onError,
+			queue:     make(chan Event, max(queueSize, 1)),
+		}
+	}
+}
+
+// WithAuditLog appends a record of every Persist and Delete to table: a digest of
+// the session id, its user, the operation, its time and, for a Persist, the keys
+// changed since the session was loaded, for security compliance reviews. The
+// table needs a string partition key named like the one of the sessions table.
+// Records expire after retention through the ttl attribute, unless it isn't
+// positive. Records are written after the session, and an error writing one is
+// returned by the operation although the session was written. SaveAll,
+// RegenerateID and the deletes through the user index are recorded too, a
+// rotation as the delete of the old id and the persist of the new one.
+func WithAuditLog(table string, retention time.Duration) Option {
+	return func(s *Store) {
+		s.audit = &auditLog{table: table, retention: retention}
+	}
+}
@@ -0,0 +1,153 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// rateLimitPrefix prefixes the keys of the items holding rate limit counters
+const rateLimitPrefix = "rate#"
+
+// legacyRateLimitPrefix prefixes the attributes of session items that held
+// rate limit counters before they got items of their own
+const legacyRateLimitPrefix = "rate:"
+
+// Attributes of the items holding rate limit counters
+const (
+	rateLimitCountField  = "count"
+	rateLimitWindowField = "window"
+)
+
+// maxRateLimitAttempts bounds the retries of Allow when racing the start of a
+// new window
+const maxRateLimitAttempts = 3
+
+// Allow counts an event against the fixed window rate limiter identified by name
+// on the session identified by id, and reports whether the event is within limit
+// events per window. Counters live in an item of their own next to the session,
+// expiring with the window, and are updated with atomic ADDs, so every instance
+// serving the session shares them and saving the session leaves them alone.
+// ErrSessionNotFound is returned if the session does not exist when a window
+// starts.
+func (store *Store) Allow(ctx context.Context, id, name string, limit int, window time.Duration) (bool, error) {
+	store = store.scoped(ctx)
+
+	start := store.now().Truncate(window)
+
+	key := store.rateLimitKey(id, name)
+	names := map[string]string{
+		"#count":  rateLimitCountField,
+		"#window": rateLimitWindowField,
+	}
+	values := map[string]types.AttributeValue{
+		":one":    &types.AttributeValueMemberN{Value: "1"},
+		":window": &types.AttributeValueMemberN{Value: strconv.FormatInt(start.Unix(), 10)},
+	}
+
+	for attempt := 0; attempt < maxRateLimitAttempts; attempt++ {
+		// Count within the current window...
+		result, err := store.ddb.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+			TableName:                 aws.String(store.tableName),
+			Key:                       key,
+			ConditionExpression:       aws.String("#window = :window"),
+			UpdateExpression:          aws.String("ADD #count :one"),
+			ExpressionAttributeNames:  names,
+			ExpressionAttributeValues: values,
+			ReturnValues:              types.ReturnValueUpdatedNew,
+		})
+
+		var ccf *types.ConditionalCheckFailedException
+		if err == nil {
+			count, _ := strconv.Atoi(attributeNumber(result.Attributes[rateLimitCountField]))
+			return count <= limit, nil
+		}
+		if !errors.As(err, &ccf) {
+			return false, fmt.Errorf("failed to update rate limit: %w", err)
+		}
+
+		// ...or start a new one, for a session that exists
+		exists, err := store.ddb.GetItem(ctx, &dynamodb.GetItemInput{
+			TableName:                aws.String(store.tableName),
+			Key:                      store.key(id),
+			ProjectionExpression:     aws.String("#pk"),
+			ExpressionAttributeNames: map[string]string{"#pk": store.primaryKey},
+		})
+		if err != nil {
+			return false, fmt.Errorf("failed to update rate limit: %w", err)
+		}
+		if exists.Item == nil {
+			return false, ErrSessionNotFound
+		}
+
+		item := maps.Clone(key)
+		item[rateLimitCountField] = values[":one"]
+		item[rateLimitWindowField] = values[":window"]
+		item[DefaultTTLField] = &types.AttributeValueMemberN{Value: strconv.FormatInt(start.Add(window).Unix(), 10)}
+
+		_, err = store.ddb.PutItem(ctx, &dynamodb.PutItemInput{
+			TableName:                 aws.String(store.tableName),
+			Item:                      item,
+			ConditionExpression:       aws.String("attribute_not_exists(#window) OR #window <> :window"),
+			ExpressionAttributeNames:  map[string]string{"#window": rateLimitWindowField},
+			ExpressionAttributeValues: map[string]types.AttributeValue{":window": values[":window"]},
+		})
+		if err == nil {
+			return 1 <= limit, nil
+		}
+		if !errors.As(err, &ccf) {
+			return false, fmt.Errorf("failed to update rate limit: %w", err)
+		}
+
+		// another request started the window first, so counting within it
+		// succeeds on the next attempt
+	}
+
+	return false, fmt.Errorf("failed to update rate limit: too much contention")
+}
+
+// rateLimitKey returns the key of the item counting the events of the rate
+// limiter name on the session identified by id
+func (store *Store) rateLimitKey(id, name string) map[string]types.AttributeValue {
+	return store.rawKey(rateLimitPrefix + store.keyID(store.itemKey(id)) + "#" + name)
+}
+
+// attributeNumber returns the value of a number attribute, or "" if v isn't one
+func attributeNumber(v types.AttributeValue) string {
+	if n, ok := v.(*types.AttributeValueMemberN); ok {
+		return n.Value
+	}
+
+	return ""
+}
+
+// dropLegacyRateLimits removes the rate limit counters that earlier versions
+// kept on the session item, so they aren't mistaken for session values
+func dropLegacyRateLimits(item map[string]any) {
+	for k := range item {
+		if strings.HasPrefix(k, legacyRateLimitPrefix) {
+			delete(item, k)
+		}
+	}
+}
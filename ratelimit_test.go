@@ -0,0 +1,107 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+)
+
+func TestAllow(t *testing.T) {
+	ctx := context.TODO()
+
+	now := time.Unix(1700000000, 0)
+	store, _ := New(newFakeDynamoDB(), WithClock(func() time.Time { return now }))
+
+	session := sessions.NewSession(store, "session")
+	session.ID = "abc"
+	if err := store.Persist(ctx, session.Name(), session); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if ok, err := store.Allow(ctx, "abc", "login", 3, time.Minute); err != nil || !ok {
+			t.Fatalf("expected event %v to be allowed; got %v, %v", i, ok, err)
+		}
+	}
+	if ok, _ := store.Allow(ctx, "abc", "login", 3, time.Minute); ok {
+		t.Error("expected the fourth event to be throttled")
+	}
+
+	// Saving the session doesn't reset its counters
+	loaded := sessions.NewSession(store, "session")
+	if err := store.Load(ctx, "abc", loaded); err != nil {
+		t.Fatal(err)
+	}
+	for k := range loaded.Values {
+		if k, ok := k.(string); ok && k != "id" {
+			t.Errorf("expected counters to be kept out of Values; got %v", k)
+		}
+	}
+	if err := store.Persist(ctx, loaded.Name(), loaded); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := store.Allow(ctx, "abc", "login", 3, time.Minute); ok {
+		t.Error("expected the counter to survive Save")
+	}
+
+	now = now.Add(time.Minute)
+	if ok, err := store.Allow(ctx, "abc", "login", 3, time.Minute); err != nil || !ok {
+		t.Errorf("expected a new window to start; got %v, %v", ok, err)
+	}
+
+	if _, err := store.Allow(ctx, "missing", "login", 3, time.Minute); err != ErrSessionNotFound {
+		t.Errorf("expected %v; got %v", ErrSessionNotFound, err)
+	}
+}
+
+func TestAllowAcrossSaves(t *testing.T) {
+	ctx := context.TODO()
+
+	ddb := newFakeDynamoDB()
+	store, _ := New(ddb)
+
+	session := sessions.NewSession(store, "session")
+	session.ID = "abc"
+	if err := store.Persist(ctx, session.Name(), session); err != nil {
+		t.Fatal(err)
+	}
+
+	// every request loads the session, counts an event and saves the session
+	for i := 1; i <= 4; i++ {
+		loaded := sessions.NewSession(store, "session")
+		if err := store.Load(ctx, "abc", loaded); err != nil {
+			t.Fatal(err)
+		}
+		ok, err := store.Allow(ctx, "abc", "login", 3, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != (i <= 3) {
+			t.Errorf("expected event %v to be allowed: %v; got %v", i, i <= 3, ok)
+		}
+		if err := store.Persist(ctx, loaded.Name(), loaded); err != nil {
+			t.Fatal(err)
+		}
+
+		counter := ddb.items[attributeString(store.rateLimitKey("abc", "login")[store.primaryKey])]
+		if got := attributeNumber(counter[rateLimitCountField]); got != strconv.Itoa(i) {
+			t.Errorf("expected the counter to reach %v; got %v", i, got)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/gorilla/sessions"
+)
+
+func TestReadOnly(t *testing.T) {
+	ddb := newFakeDynamoDB()
+	ddb.items["existing"] = map[string]types.AttributeValue{
+		DefaultPrimaryKey: &types.AttributeValueMemberS{Value: "existing"},
+		"user_id":         &types.AttributeValueMemberS{Value: "alice"},
+	}
+
+	store, _ := New(ddb, WithReadOnly())
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	session, _ := store.New(req, "session")
+	session.Values["user_id"] = "bob"
+	w := httptest.NewRecorder()
+	if err := store.Save(req, w, session); err != nil {
+		t.Fatal(err)
+	}
+	if len(w.Result().Cookies()) != 1 {
+		t.Error("expected the cookie to be set")
+	}
+
+	if err := store.Delete(context.TODO(), "existing"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Touch(context.TODO(), "existing"); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ddb.items) != 1 || ddb.puts != 0 || ddb.updates != 0 {
+		t.Errorf("expected the table to be left untouched; got %v", ddb.items)
+	}
+
+	if _, err := store.ddb.CreateTable(context.TODO(), store.CreateTableInput()); err == nil {
+		t.Error("expected the read only client to refuse creating tables")
+	}
+	if ddb.table != nil {
+		t.Errorf("expected no table to be created; got %v", ddb.table)
+	}
+}
+
+func TestReadOnlySecurityWrites(t *testing.T) {
+	ctx := context.TODO()
+	ddb := newFakeDynamoDB()
+
+	writer, _ := New(ddb)
+	persistUserSessions(t, writer, "alice", 1)
+	if err := writer.PutNonce(ctx, "nonce", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	store, _ := New(ddb, WithReadOnly())
+	if err := store.ConsumeNonce(ctx, "nonce"); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("expected ConsumeNonce to fail with ErrReadOnly; got %v", err)
+	}
+	if err := writer.ConsumeNonce(ctx, "nonce"); err != nil {
+		t.Errorf("expected the nonce to be left unconsumed; got %v", err)
+	}
+
+	if err := store.Revoke(ctx, "alice-0"); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("expected Revoke to fail with ErrReadOnly; got %v", err)
+	}
+	if err := writer.Load(ctx, "alice-0", sessions.NewSession(writer, "session")); err != nil {
+		t.Errorf("expected the session to be left unrevoked; got %v", err)
+	}
+}
+
+func TestReadOnlyCache(t *testing.T) {
+	ddb := newFakeDynamoDB()
+	store, _ := New(ddb, WithReadOnly(), WithCache(10, time.Minute))
+
+	persistUserSessions(t, store, "bob", 1)
+
+	err := store.Load(context.TODO(), "bob-0", sessions.NewSession(store, "session"))
+	if err != ErrSessionNotFound {
+		t.Errorf("expected the dropped write not to be cached; got %v", err)
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"context"
+	"maps"
+	"slices"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// RegionField contains the name of the attribute recording the region that last
+// wrote a session, set with WithRegionPinning
+const RegionField = "region"
+
+// regionPinning holds the configuration of WithRegionPinning
+type regionPinning struct {
+	region  string
+	remotes map[string]DynamoDBClient
+}
+
+// pinnedItem returns the freshest copy of the session item stored under key
+// that can be found, given the copy item read from the local replica. A session
+// last written by another region is read again from that region with a
+// consistent read, and a session missing locally, not replicated yet, is looked
+// for in every other region. The local copy is kept when a remote read fails.
+func (store *Store) pinnedItem(ctx context.Context, key map[string]types.AttributeValue, item map[string]types.AttributeValue) map[string]types.AttributeValue {
+	pinning := store.regionPinning
+
+	var regions []string
+	if item != nil {
+		region := attributeString(item[RegionField])
+		if region == "" || region == pinning.region {
+			return item
+		}
+		regions = []string{region}
+	} else {
+		regions = slices.Sorted(maps.Keys(pinning.remotes))
+	}
+
+	for _, region := range regions {
+		client, ok := pinning.remotes[region]
+		if !ok {
+			continue
+		}
+
+		result, err := client.GetItem(ctx, &dynamodb.GetItemInput{
+			TableName:      aws.String(store.tableName),
+			Key:            key,
+			ConsistentRead: aws.Bool(true),
+		})
+		if err == nil && result.Item != nil {
+			return result.Item
+		}
+	}
+
+	return item
+}
@@ -0,0 +1,65 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gorilla/sessions"
+)
+
+func TestRegionPinning(t *testing.T) {
+	ctx := context.TODO()
+
+	east := newFakeDynamoDB()
+	west := newFakeDynamoDB()
+	eastStore, _ := New(east, MaxAge(3600), WithRegionPinning("us-east-1", map[string]DynamoDBClient{"us-west-2": west}))
+	westStore, _ := New(west, MaxAge(3600), WithRegionPinning("us-west-2", map[string]DynamoDBClient{"us-east-1": east}))
+
+	// written in the west, not replicated to the east yet
+	session := sessions.NewSession(westStore, "session")
+	session.ID = "abc"
+	session.Options = westStore.newOptions()
+	session.Values["cart"] = "v2"
+	if err := westStore.Persist(ctx, "session", session); err != nil {
+		t.Fatal(err)
+	}
+	if v := attributeString(west.items["abc"][RegionField]); v != "us-west-2" {
+		t.Errorf("expected the writing region to be recorded; got %v", v)
+	}
+
+	loaded := sessions.NewSession(eastStore, "session")
+	if err := eastStore.Load(ctx, "abc", loaded); err != nil {
+		t.Fatalf("expected a session missing locally to be read from its region; got %v", err)
+	}
+
+	// replicated a stale copy
+	east.items["abc"] = west.items["abc"]
+	session.Values["cart"] = "v3"
+	if err := westStore.Persist(ctx, "session", session); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded = sessions.NewSession(eastStore, "session")
+	if err := eastStore.Load(ctx, "abc", loaded); err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Values["cart"] != "v3" {
+		t.Errorf("expected the copy of the writing region to be preferred; got %v", loaded.Values["cart"])
+	}
+	if _, ok := loaded.Values[RegionField]; ok {
+		t.Error("expected the region attribute not to be loaded as a value")
+	}
+}
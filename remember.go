@@ -0,0 +1,371 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+)
+
+const (
+	// RememberTokenField contains the name of the attribute holding the digest
+	// of the current token of a remember-me series
+	RememberTokenField = "token_hash"
+
+	// RememberUserField contains the name of the attribute holding the user a
+	// remember-me series belongs to. It deliberately differs from the user key,
+	// so series never show up on the user index.
+	RememberUserField = "remember_user"
+
+	// RememberSeriesField contains the name of the attribute listing the
+	// remember-me series of a user, so ForgetUser can find them
+	RememberSeriesField = "series"
+)
+
+// rememberPrefix prefixes the primary key of remember-me series items, which
+// share the sessions table but can never be loaded as sessions
+const rememberPrefix = "remember#"
+
+// rememberUserPrefix prefixes the primary key of the item listing the series of
+// a user. Series ids are url safe base64, so they can't collide with it.
+const rememberUserPrefix = rememberPrefix + "user#"
+
+var (
+	// ErrNotRemembered is returned by Recall if the request carries no valid
+	// remember-me cookie
+	ErrNotRemembered = fmt.Errorf("no valid remember-me token presented")
+
+	// ErrRememberTokenTheft is returned by Recall when a stale token of a known
+	// series is presented, meaning the cookie was copied and used elsewhere. The
+	// series is deleted, along with the user's sessions if WithUserIndex is set.
+	ErrRememberTokenTheft = fmt.Errorf("stale remember-me token presented, series revoked")
+)
+
+type rememberMe struct {
+	cookieName string
+	lifetime   time.Duration
+	userKey    string
+}
+
+// Remember starts a remember-me series for userID and writes its cookie to w.
+// The cookie carries a series id and a token; only a digest of the token is
+// stored, and every Recall replaces the token while keeping the series.
+func (store *Store) Remember(ctx context.Context, w http.ResponseWriter, userID string) error {
+	store = store.scoped(ctx)
+
+	if store.rememberMe == nil {
+		return fmt.Errorf("remember-me is not enabled, see WithRememberMe")
+	}
+
+	series, token := newRememberToken(), newRememberToken()
+
+	item := store.rawKey(rememberPrefix + series)
+	item[RememberUserField] = &types.AttributeValueMemberS{Value: userID}
+	item[RememberTokenField] = &types.AttributeValueMemberS{Value: hashRememberToken(token)}
+	item[DefaultTTLField] = &types.AttributeValueMemberN{Value: store.rememberExpiry()}
+
+	_, err := store.ddb.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(store.tableName),
+		Item:      item,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to put remember-me series: %w", err)
+	}
+
+	_, err = store.ddb.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:                aws.String(store.tableName),
+		Key:                      store.rawKey(rememberUserPrefix + userID),
+		UpdateExpression:         aws.String("ADD #series :series SET #ttl = :ttl"),
+		ExpressionAttributeNames: map[string]string{"#series": RememberSeriesField, "#ttl": DefaultTTLField},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":series": &types.AttributeValueMemberSS{Value: []string{series}},
+			":ttl":    item[DefaultTTLField],
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to register remember-me series: %w", err)
+	}
+
+	http.SetCookie(w, store.rememberCookie(series+"."+token))
+
+	return nil
+}
+
+// Recall promotes the remember-me cookie of req into a new, saved session named
+// name holding the remembered user, rotating the token of the series in the
+// process. ErrNotRemembered is returned if there is no usable cookie and
+// ErrRememberTokenTheft if the token presented is stale.
+func (store *Store) Recall(req *http.Request, w http.ResponseWriter, name string) (*sessions.Session, error) {
+	store = store.scoped(req.Context())
+
+	if store.rememberMe == nil {
+		return nil, ErrNotRemembered
+	}
+
+	ctx := req.Context()
+
+	cookie, err := req.Cookie(store.rememberMe.cookieName)
+	if err != nil {
+		return nil, ErrNotRemembered
+	}
+
+	series, token, ok := strings.Cut(cookie.Value, ".")
+	if !ok || series == "" || token == "" {
+		http.SetCookie(w, store.rememberCookie(""))
+		return nil, ErrNotRemembered
+	}
+
+	id := rememberPrefix + series
+	key := store.rawKey(id)
+
+	result, err := store.ddb.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(store.tableName),
+		Key:            key,
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get remember-me series: %w", err)
+	}
+
+	user, _ := result.Item[RememberUserField].(*types.AttributeValueMemberS)
+	stored, _ := result.Item[RememberTokenField].(*types.AttributeValueMemberS)
+	expiresAt, _ := result.Item[DefaultTTLField].(*types.AttributeValueMemberN)
+	if user == nil || stored == nil || expiresAt == nil || !store.rememberLive(expiresAt.Value) {
+		http.SetCookie(w, store.rememberCookie(""))
+		return nil, ErrNotRemembered
+	}
+
+	if subtle.ConstantTimeCompare([]byte(stored.Value), []byte(hashRememberToken(token))) != 1 {
+		http.SetCookie(w, store.rememberCookie(""))
+		if err := store.revokeSeries(ctx, series, user.Value); err != nil {
+			return nil, err
+		}
+		return nil, ErrRememberTokenTheft
+	}
+
+	next := newRememberToken()
+
+	_, err = store.ddb.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:                aws.String(store.tableName),
+		Key:                      key,
+		ConditionExpression:      aws.String("#token = :current"),
+		UpdateExpression:         aws.String("SET #token = :next, #ttl = :ttl"),
+		ExpressionAttributeNames: map[string]string{"#token": RememberTokenField, "#ttl": DefaultTTLField},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":current": stored,
+			":next":    &types.AttributeValueMemberS{Value: hashRememberToken(next)},
+			":ttl":     &types.AttributeValueMemberN{Value: store.rememberExpiry()},
+		},
+	})
+
+	// A concurrent Recall with the same cookie won the race; it isn't theft, but
+	// only one of the requests gets to promote the series
+	var ccf *types.ConditionalCheckFailedException
+	if errors.As(err, &ccf) {
+		return nil, ErrNotRemembered
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to rotate remember-me token: %w", err)
+	}
+
+	http.SetCookie(w, store.rememberCookie(series+"."+next))
+
+	session := sessions.NewSession(store, name)
+	session.ID = store.newID()
+	session.IsNew = true
+	session.Options = store.newOptions()
+	session.Values[store.rememberMe.userKey] = user.Value
+
+	if err := store.Save(req, w, session); err != nil {
+		return nil, err
+	}
+
+	return session, nil
+}
+
+// Forget ends the remember-me series of req, if any, and clears its cookie
+func (store *Store) Forget(req *http.Request, w http.ResponseWriter) error {
+	store = store.scoped(req.Context())
+
+	if store.rememberMe == nil {
+		return nil
+	}
+
+	cookie, err := req.Cookie(store.rememberMe.cookieName)
+	if err != nil {
+		return nil
+	}
+
+	http.SetCookie(w, store.rememberCookie(""))
+
+	series, _, _ := strings.Cut(cookie.Value, ".")
+	if series == "" {
+		return nil
+	}
+
+	ctx := req.Context()
+
+	result, err := store.ddb.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName:    aws.String(store.tableName),
+		Key:          store.rawKey(rememberPrefix + series),
+		ReturnValues: types.ReturnValueAllOld,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete remember-me series: %w", err)
+	}
+
+	if user, ok := result.Attributes[RememberUserField].(*types.AttributeValueMemberS); ok {
+		return store.unregisterSeries(ctx, user.Value, series)
+	}
+
+	return nil
+}
+
+// ForgetUser ends every remember-me series of userID, so none of the devices
+// remembered for the user can Recall a session. DeleteAllForUser and EraseUser
+// call it; it doesn't require WithUserIndex.
+func (store *Store) ForgetUser(ctx context.Context, userID string) error {
+	store = store.scoped(ctx)
+
+	key := store.rawKey(rememberUserPrefix + userID)
+
+	result, err := store.ddb.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(store.tableName),
+		Key:            key,
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to get remember-me series of user: %w", err)
+	}
+
+	registered, _ := result.Item[RememberSeriesField].(*types.AttributeValueMemberSS)
+	if registered != nil {
+		for _, series := range registered.Value {
+			if err := store.deleteItem(ctx, store.namespaced(rememberPrefix+series)); err != nil {
+				return fmt.Errorf("failed to delete remember-me series: %w", err)
+			}
+		}
+	}
+
+	if result.Item == nil {
+		return nil
+	}
+
+	if _, err := store.ddb.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(store.tableName),
+		Key:       key,
+	}); err != nil {
+		return fmt.Errorf("failed to delete remember-me series of user: %w", err)
+	}
+
+	return nil
+}
+
+// unregisterSeries removes series from the series listed for user, deleting the
+// list once it is empty
+func (store *Store) unregisterSeries(ctx context.Context, user, series string) error {
+	key := store.rawKey(rememberUserPrefix + user)
+
+	result, err := store.ddb.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:                 aws.String(store.tableName),
+		Key:                       key,
+		ConditionExpression:       aws.String("attribute_exists(#pk)"),
+		UpdateExpression:          aws.String("DELETE #series :series"),
+		ExpressionAttributeNames:  map[string]string{"#pk": store.primaryKey, "#series": RememberSeriesField},
+		ExpressionAttributeValues: map[string]types.AttributeValue{":series": &types.AttributeValueMemberSS{Value: []string{series}}},
+		ReturnValues:              types.ReturnValueAllNew,
+	})
+
+	var ccf *types.ConditionalCheckFailedException
+	if errors.As(err, &ccf) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to unregister remember-me series: %w", err)
+	}
+	if _, ok := result.Attributes[RememberSeriesField]; ok {
+		return nil
+	}
+
+	// a series registered in the meantime keeps the list
+	_, err = store.ddb.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName:                aws.String(store.tableName),
+		Key:                      key,
+		ConditionExpression:      aws.String("attribute_not_exists(#series)"),
+		ExpressionAttributeNames: map[string]string{"#series": RememberSeriesField},
+	})
+	if err != nil && !errors.As(err, &ccf) {
+		return fmt.Errorf("failed to delete remember-me series of user: %w", err)
+	}
+
+	return nil
+}
+
+// revokeSeries deletes a remember-me series whose token was stolen along with
+// every other series of its user, and the sessions of the user when they can
+// be found through the user index
+func (store *Store) revokeSeries(ctx context.Context, series, user string) error {
+	if err := store.deleteItem(ctx, store.namespaced(rememberPrefix+series)); err != nil {
+		return fmt.Errorf("failed to delete remember-me series: %w", err)
+	}
+
+	if store.userIndex == "" {
+		return store.ForgetUser(ctx, user)
+	}
+
+	return store.DeleteAllForUser(ctx, user)
+}
+
+func (store *Store) rememberCookie(value string) *http.Cookie {
+	opts := store.newOptions()
+	opts.MaxAge = int(store.rememberMe.lifetime / time.Second)
+	if value == "" {
+		opts.MaxAge = -1
+	}
+
+	return newCookie(opts, store.rememberMe.cookieName, value)
+}
+
+func (store *Store) rememberExpiry() string {
+	return strconv.FormatInt(store.now().Add(store.rememberMe.lifetime).Unix(), 10)
+}
+
+func (store *Store) rememberLive(expiresAt string) bool {
+	n, err := strconv.ParseInt(expiresAt, 10, 64)
+	return err == nil && store.now().Before(time.Unix(n, 0))
+}
+
+func newRememberToken() string {
+	return base64.RawURLEncoding.EncodeToString(securecookie.GenerateRandomKey(32))
+}
+
+func hashRememberToken(token string) string {
+	digest := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(digest[:])
+}
@@ -0,0 +1,169 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRememberMe(t *testing.T) {
+	ddb := newFakeDynamoDB()
+	store, _ := New(ddb, WithUserIndex("user_id-index", "user_id"), WithRememberMe("remember", 30*24*time.Hour, "user_id"))
+
+	w := httptest.NewRecorder()
+	if err := store.Remember(context.TODO(), w, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	stolen := w.Result().Cookies()[0]
+
+	recall := func(cookie *http.Cookie) (*httptest.ResponseRecorder, error) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.AddCookie(cookie)
+		w := httptest.NewRecorder()
+		session, err := store.Recall(req, w, "session")
+		if err == nil && session.Values["user_id"] != "alice" {
+			t.Errorf("expected the promoted session to hold the user; got %v", session.Values)
+		}
+		return w, err
+	}
+
+	w, err := recall(stolen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rotated, sessionCookie *http.Cookie
+	for _, cookie := range w.Result().Cookies() {
+		switch cookie.Name {
+		case "remember":
+			rotated = cookie
+		case "session":
+			sessionCookie = cookie
+		}
+	}
+	if rotated == nil || rotated.Value == stolen.Value {
+		t.Fatal("expected the token to be rotated")
+	}
+	if sessionCookie == nil {
+		t.Fatal("expected a session cookie to be set")
+	}
+
+	if _, err := recall(rotated); err != nil {
+		t.Fatal(err)
+	}
+
+	// The original cookie is now stale: the series is revoked along with the
+	// user's sessions
+	if _, err := recall(stolen); err != ErrRememberTokenTheft {
+		t.Fatalf("expected %v; got %v", ErrRememberTokenTheft, err)
+	}
+	if _, err := recall(rotated); err != ErrNotRemembered {
+		t.Errorf("expected the series to be revoked; got %v", err)
+	}
+	for id, item := range ddb.items {
+		if _, ok := item["user_id"]; ok {
+			t.Errorf("expected session %v to be deleted", id)
+		}
+	}
+
+	if _, err := recall(&http.Cookie{Name: "remember", Value: "garbage"}); err != ErrNotRemembered {
+		t.Errorf("expected %v; got %v", ErrNotRemembered, err)
+	}
+}
+
+func TestForget(t *testing.T) {
+	ddb := newFakeDynamoDB()
+	store, _ := New(ddb, WithRememberMe("remember", time.Hour, "user_id"))
+
+	w := httptest.NewRecorder()
+	if err := store.Remember(context.TODO(), w, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	cookie := w.Result().Cookies()[0]
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(cookie)
+	w = httptest.NewRecorder()
+	if err := store.Forget(req, w); err != nil {
+		t.Fatal(err)
+	}
+	if cleared := w.Result().Cookies()[0]; cleared.MaxAge >= 0 {
+		t.Errorf("expected the cookie to be cleared; got MaxAge %v", cleared.MaxAge)
+	}
+	if len(ddb.items) != 0 {
+		t.Errorf("expected the series to be deleted; got %v", ddb.items)
+	}
+}
+
+func TestRecallAfterSignOutEverywhere(t *testing.T) {
+	ctx := context.TODO()
+
+	testCases := map[string]struct {
+		Opts    []Option
+		SignOut func(store *Store) error
+	}{
+		"delete all for user": {
+			Opts:    []Option{WithUserIndex("user_id-index", "user_id")},
+			SignOut: func(store *Store) error { return store.DeleteAllForUser(ctx, "alice") },
+		},
+		"erase user": {
+			Opts: []Option{WithUserIndex("user_id-index", "user_id")},
+			SignOut: func(store *Store) error {
+				_, err := store.EraseUser(ctx, "alice")
+				return err
+			},
+		},
+		"forget user without an index": {
+			SignOut: func(store *Store) error { return store.ForgetUser(ctx, "alice") },
+		},
+	}
+
+	for label, tc := range testCases {
+		t.Run(label, func(t *testing.T) {
+			ddb := newFakeDynamoDB()
+			store, _ := New(ddb, append(tc.Opts, WithRememberMe("remember", time.Hour, "user_id"))...)
+
+			var cookies []*http.Cookie
+			for _, user := range []string{"alice", "alice", "bob"} {
+				w := httptest.NewRecorder()
+				if err := store.Remember(ctx, w, user); err != nil {
+					t.Fatal(err)
+				}
+				cookies = append(cookies, w.Result().Cookies()[0])
+			}
+
+			if err := tc.SignOut(store); err != nil {
+				t.Fatal(err)
+			}
+
+			for i, cookie := range cookies {
+				req := httptest.NewRequest(http.MethodGet, "/", nil)
+				req.AddCookie(cookie)
+				_, err := store.Recall(req, httptest.NewRecorder(), "session")
+				if i < 2 && err != ErrNotRemembered {
+					t.Errorf("expected the series of alice to be ended; got %v", err)
+				}
+				if i == 2 && err != nil {
+					t.Errorf("expected the series of bob to survive; got %v", err)
+				}
+			}
+			if _, ok := ddb.items[rememberUserPrefix+"alice"]; ok {
+				t.Error("expected the series list of alice to be deleted")
+			}
+		})
+	}
+}
@@ -0,0 +1,203 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// RevokedField contains the name of the attribute marking a session as revoked
+const RevokedField = "revoked_at"
+
+// notRevokedCondition guards the writes of sessions, so a Save racing a Revoke
+// can't overwrite the revoked item and bring the session back
+const notRevokedCondition = "attribute_not_exists(#revoked)"
+
+var (
+	// ErrSessionRevoked is returned by Load and the saves of sessions that have
+	// been revoked
+	ErrSessionRevoked = fmt.Errorf("session has been revoked")
+)
+
+// Revoke marks the session identified by id as revoked, so any further Load
+// fails with ErrSessionRevoked. When WithRevocationTable is set the session is
+// also added to the blocklist table, which Load consults before anything else,
+// until the revoked item itself would have expired. Saves of the session made
+// after it has been revoked, alone or with SaveAll, fail with ErrSessionRevoked;
+// with WithWriteBehind they are dropped when flushed instead, ErrSessionRevoked
+// being passed to its onError. ErrSessionNotFound is returned if the session
+// does not exist, and ErrReadOnly by a store made with WithReadOnly.
+func (store *Store) Revoke(ctx context.Context, id string) error {
+	store = store.scoped(ctx)
+
+	if store.readOnly {
+		return ErrReadOnly
+	}
+
+	now := strconv.FormatInt(store.now().Unix(), 10)
+
+	// a write still buffered would overwrite the revoked item once flushed
+	if store.writeBehind != nil {
+		store.writeBehind.discard(store.tableName, store.itemKey(id))
+	}
+
+	result, err := store.ddb.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:                 aws.String(store.tableName),
+		Key:                       store.key(id),
+		ConditionExpression:       aws.String("attribute_exists(#pk)"),
+		UpdateExpression:          aws.String("SET #revoked = :now"),
+		ExpressionAttributeNames:  map[string]string{"#pk": store.primaryKey, "#revoked": RevokedField},
+		ExpressionAttributeValues: map[string]types.AttributeValue{":now": &types.AttributeValueMemberN{Value: now}},
+		ReturnValues:              types.ReturnValueAllNew,
+	})
+
+	var ccf *types.ConditionalCheckFailedException
+	if errors.As(err, &ccf) {
+		return ErrSessionNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to revoke session: %w", err)
+	}
+
+	if store.revocationTable == "" {
+		return nil
+	}
+
+	entry := store.key(id)
+	entry[RevokedField] = &types.AttributeValueMemberN{Value: now}
+	if ttl, ok := result.Attributes[DefaultTTLField]; ok {
+		entry[DefaultTTLField] = ttl
+	}
+
+	_, err = store.ddb.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(store.revocationTable),
+		Item:      entry,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to add session to revocation table: %w", err)
+	}
+
+	return nil
+}
+
+// checkNotRevoked returns ErrSessionRevoked if the stored session identified by
+// id has been revoked, for telling revocation apart from the other conditions
+// of a failed conditional write
+func (store *Store) checkNotRevoked(ctx context.Context, id string) error {
+	result, err := store.ddb.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:                aws.String(store.tableName),
+		Key:                      store.key(id),
+		ConsistentRead:           aws.Bool(true),
+		ProjectionExpression:     aws.String("#revoked"),
+		ExpressionAttributeNames: map[string]string{"#revoked": RevokedField},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read conflicting session: %w", err)
+	}
+
+	if _, ok := result.Item[RevokedField]; ok {
+		return ErrSessionRevoked
+	}
+
+	return nil
+}
+
+// checkRevoked consults the revocation table, if configured, for the session
+// identified by id
+func (store *Store) checkRevoked(ctx context.Context, id string) error {
+	if store.revocationTable == "" {
+		return nil
+	}
+
+	result, err := store.ddb.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(store.revocationTable),
+		Key:       store.key(id),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to check revocation table: %w", err)
+	}
+
+	if result.Item != nil {
+		return ErrSessionRevoked
+	}
+
+	return nil
+}
+
+// putNotRevoked writes items in transactions of puts conditional on the sessions
+// not having been revoked, so writing sessions in bulk can't bring a revoked one
+// back. The items of revoked sessions are left out, and their keys returned.
+func (store *Store) putNotRevoked(ctx context.Context, items []map[string]types.AttributeValue) (map[string]bool, error) {
+	revoked := map[string]bool{}
+	for len(items) > 0 {
+		n := min(len(items), maxTransactItems)
+		if err := store.transactPuts(ctx, items[:n], revoked); err != nil {
+			return revoked, err
+		}
+		items = items[n:]
+	}
+
+	return revoked, nil
+}
+
+// transactPuts writes items in a single transaction. Revoked sessions cancel the
+// transaction; they are added to revoked and the rest is retried.
+func (store *Store) transactPuts(ctx context.Context, items []map[string]types.AttributeValue, revoked map[string]bool) error {
+	for len(items) > 0 {
+		transact := make([]types.TransactWriteItem, 0, len(items))
+		for _, item := range items {
+			transact = append(transact, types.TransactWriteItem{
+				Put: &types.Put{
+					TableName:                aws.String(store.tableName),
+					Item:                     item,
+					ConditionExpression:      aws.String(notRevokedCondition),
+					ExpressionAttributeNames: map[string]string{"#revoked": RevokedField},
+				},
+			})
+		}
+
+		_, err := store.ddb.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{TransactItems: transact})
+
+		var canceled *types.TransactionCanceledException
+		if !errors.As(err, &canceled) || len(canceled.CancellationReasons) != len(items) {
+			if err != nil {
+				return fmt.Errorf("failed to write %d sessions: %w", len(items), err)
+			}
+			return nil
+		}
+
+		var retry []map[string]types.AttributeValue
+		for i, reason := range canceled.CancellationReasons {
+			if aws.ToString(reason.Code) == "ConditionalCheckFailed" {
+				revoked[itemID(store, items[i])] = true
+				continue
+			}
+			retry = append(retry, items[i])
+		}
+
+		if len(retry) == len(items) {
+			return fmt.Errorf("failed to write %d sessions: %w", len(items), err)
+		}
+		items = retry
+	}
+
+	return nil
+}
@@ -0,0 +1,198 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/gorilla/sessions"
+)
+
+func TestRevoke(t *testing.T) {
+	ctx := context.TODO()
+
+	ddb := newFakeDynamoDB()
+	store, _ := New(ddb, WithRevocationTable("revoked_sessions"))
+
+	session := sessions.NewSession(store, "session")
+	session.ID = "abc"
+	if err := store.Persist(ctx, session.Name(), session); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Revoke(ctx, "abc"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Load(ctx, "abc", sessions.NewSession(store, "session")); err != ErrSessionRevoked {
+		t.Errorf("expected %v; got %v", ErrSessionRevoked, err)
+	}
+
+	// A session re-written by a node that still holds a stale copy stays revoked
+	if err := store.Persist(ctx, session.Name(), session); err != ErrSessionRevoked {
+		t.Errorf("expected the write to be refused with %v; got %v", ErrSessionRevoked, err)
+	}
+	if _, ok := ddb.items["abc"][RevokedField]; !ok {
+		t.Error("expected the item to stay revoked")
+	}
+	if err := store.Load(ctx, "abc", sessions.NewSession(store, "session")); err != ErrSessionRevoked {
+		t.Errorf("expected the blocklist to reject the session; got %v", err)
+	}
+	if _, ok := ddb.tableItems(aws.String("revoked_sessions"))["abc"]; !ok {
+		t.Error("expected the session to be added to the revocation table")
+	}
+
+	if err := store.Revoke(ctx, "missing"); err != ErrSessionNotFound {
+		t.Errorf("expected %v; got %v", ErrSessionNotFound, err)
+	}
+}
+
+func TestRevokeBlocklistTTL(t *testing.T) {
+	ctx := context.TODO()
+	now := time.Unix(1700000000, 0)
+
+	testCases := map[string]struct {
+		Opts          []Option
+		SessionMaxAge int
+		Expected      time.Duration
+	}{
+		"default max age": {
+			Opts:     []Option{TTLEnabled(), MaxAge(60)},
+			Expected: time.Minute,
+		},
+		"persisted max age": {
+			Opts:          []Option{TTLEnabled(), MaxAge(60)},
+			SessionMaxAge: 3600,
+			Expected:      time.Hour,
+		},
+		"browser session cookie": {
+			Opts:     []Option{TTLEnabled(), WithServerTTL(time.Hour)},
+			Expected: time.Hour,
+		},
+		"ttl disabled": {},
+	}
+
+	for label, tc := range testCases {
+		t.Run(label, func(t *testing.T) {
+			ddb := newFakeDynamoDB()
+			store, _ := New(ddb, append(tc.Opts, WithRevocationTable("revoked_sessions"), WithClock(func() time.Time { return now }))...)
+
+			session := sessions.NewSession(store, "session")
+			session.ID = "abc"
+			session.Options = store.newOptions()
+			if tc.SessionMaxAge > 0 {
+				session.Options.MaxAge = tc.SessionMaxAge
+			}
+			if err := store.Persist(ctx, session.Name(), session); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := store.Revoke(ctx, "abc"); err != nil {
+				t.Fatal(err)
+			}
+
+			ttl, ok := ddb.tableItems(aws.String("revoked_sessions"))["abc"][DefaultTTLField]
+			if tc.Expected == 0 {
+				if ok {
+					t.Errorf("expected no ttl on the blocklist entry of a session without one; got %v", ttl)
+				}
+				return
+			}
+			if got := attributeTime(ttl); !got.Equal(now.Add(tc.Expected)) {
+				t.Errorf("expected the blocklist entry to expire at %v; got %v", now.Add(tc.Expected), got)
+			}
+		})
+	}
+}
+
+func TestRevokeLastWriterWins(t *testing.T) {
+	ctx := context.TODO()
+
+	ddb := newFakeDynamoDB()
+	store, _ := New(ddb, WithLastWriterWins(nil))
+
+	session := sessions.NewSession(store, "session")
+	session.ID = "abc"
+	if err := store.Persist(ctx, session.Name(), session); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Revoke(ctx, "abc"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Persist(ctx, session.Name(), session); err != ErrSessionRevoked {
+		t.Errorf("expected %v; got %v", ErrSessionRevoked, err)
+	}
+}
+
+func TestRevokeBulkWrites(t *testing.T) {
+	testCases := map[string]struct {
+		Options []Option
+		Save    func(store *Store, session *sessions.Session) error
+	}{
+		"save all": {
+			Save: func(store *Store, session *sessions.Session) error {
+				req := httptest.NewRequest(http.MethodGet, "/", nil)
+				requestTracker(req).track(session)
+				return store.SaveAll(req, httptest.NewRecorder())
+			},
+		},
+		"write behind": {
+			Options: []Option{WithWriteBehind(10, 1, time.Hour, nil)},
+			Save: func(store *Store, session *sessions.Session) error {
+				if err := store.Persist(context.TODO(), "session", session); err != nil {
+					return err
+				}
+				return store.Flush(context.TODO())
+			},
+		},
+	}
+
+	for label, tc := range testCases {
+		t.Run(label, func(t *testing.T) {
+			ctx := context.TODO()
+
+			ddb := newFakeDynamoDB()
+			store, _ := New(ddb, append(tc.Options, MaxAge(3600))...)
+
+			session := sessions.NewSession(store, "session")
+			session.ID = "abc"
+			session.Options = store.newOptions()
+			if err := store.Persist(ctx, "session", session); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.Flush(ctx); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.Revoke(ctx, "abc"); err != nil {
+				t.Fatal(err)
+			}
+
+			session.IsNew = false
+			if err := tc.Save(store, session); !errors.Is(err, ErrSessionRevoked) {
+				t.Errorf("expected %v; got %v", ErrSessionRevoked, err)
+			}
+			if _, ok := ddb.items["abc"][RevokedField]; !ok {
+				t.Errorf("expected the session to stay revoked; got %v", ddb.items["abc"])
+			}
+		})
+	}
+}
@@ -0,0 +1,152 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/gorilla/sessions"
+)
+
+// RotationPredicate reports whether a session's id should be regenerated when it
+// is saved, given its values as they were loaded and as they are now. For new
+// sessions previous is empty.
+type RotationPredicate func(previous, current map[string]any) bool
+
+// RotateOnChange returns a RotationPredicate that triggers when any of the given
+// values is set, changed or removed, such as a user id being set at login
+func RotateOnChange(keys ...string) RotationPredicate {
+	return func(previous, current map[string]any) bool {
+		for _, key := range keys {
+			before, hadBefore := previous[key]
+			after, hasAfter := current[key]
+			if hadBefore != hasAfter || fmt.Sprint(before) != fmt.Sprint(after) {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+// loadedValuesKey is the session.Values key holding a snapshot of the values as
+// they were loaded, for evaluating rotation predicates on Save
+type loadedValuesKey struct{}
+
+// snapshotValues records the current values of the session as its loaded state
+func snapshotValues(session *sessions.Session, values map[string]any) {
+	session.Values[loadedValuesKey{}] = maps.Clone(values)
+}
+
+// shouldRotate evaluates the rotation predicates against the session. Sessions
+// that are new already carry a freshly generated id and are never rotated.
+func (store *Store) shouldRotate(session *sessions.Session) bool {
+	if session.IsNew || len(store.rotationPredicates) == 0 {
+		return false
+	}
+
+	previous, _ := session.Values[loadedValuesKey{}].(map[string]any)
+	current := convertToMapStringAny(session.Values)
+	for _, predicate := range store.rotationPredicates {
+		if predicate(previous, current) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// RegenerateID gives the session a new id, defending against session fixation.
+// The session is written under the new id and the item under the old id is
+// deleted in a single transaction, then a cookie carrying the new id is set.
+// The write goes through the same hooks and checks as Save, and the old id is
+// deleted like with Delete. A revoked session can't be rotated, failing with
+// ErrSessionRevoked. It should be called whenever the privilege level of a
+// session changes, most notably at login.
+func (store *Store) RegenerateID(ctx context.Context, req *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	store = store.scoped(ctx)
+
+	oldID := session.ID
+	session.ID = store.newID()
+
+	items, err := store.prepareSave(ctx, session, store.itemKey(oldID))
+	if err != nil {
+		session.ID = oldID
+		session.Values[store.primaryKey] = oldID
+		return err
+	}
+
+	// a write of the old id still buffered would bring it back once flushed
+	store.discardPending(ctx, store.itemKey(oldID))
+
+	// both writes are conditional on the session not being revoked: the delete
+	// catches a revoked old item, which the put would otherwise replace under a
+	// clean id
+	_, err = store.ddb.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: []types.TransactWriteItem{
+			{
+				Put: &types.Put{
+					TableName:                aws.String(store.tableName),
+					Item:                     items,
+					ConditionExpression:      aws.String(notRevokedCondition),
+					ExpressionAttributeNames: map[string]string{"#revoked": RevokedField},
+				},
+			},
+			{
+				Delete: &types.Delete{
+					TableName:                aws.String(store.tableName),
+					Key:                      store.key(oldID),
+					ConditionExpression:      aws.String(notRevokedCondition),
+					ExpressionAttributeNames: map[string]string{"#revoked": RevokedField},
+				},
+			},
+		},
+	})
+	if err != nil {
+		session.ID = oldID
+		session.Values[store.primaryKey] = oldID
+		if conditionFailed(err) {
+			return ErrSessionRevoked
+		}
+		return fmt.Errorf("failed to regenerate session id: %w", err)
+	}
+
+	requestCache(ctx).put(store.tableName, itemID(store, items), items)
+
+	user := store.sessionUser(session)
+	err = store.deleted(ctx, oldID, user)
+	store.publish(SessionCreated, session.ID, user)
+	err = errors.Join(err, store.auditPersist(ctx, session))
+
+	if len(store.rotationPredicates) > 0 {
+		snapshotValues(session, convertToMapStringAny(session.Values))
+	}
+
+	if user != "" {
+		session.Values[loadedUserKey{}] = user
+	}
+
+	if store.bearerHeader != "" {
+		return err
+	}
+
+	return errors.Join(err, store.setCookie(ctx, w, session))
+}
@@ -0,0 +1,171 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+)
+
+func TestRegenerateID(t *testing.T) {
+	ctx := context.TODO()
+
+	ddb := newFakeDynamoDB()
+	store, _ := New(ddb)
+
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost", nil)
+	session, _ := store.New(req, "session")
+	session.Values["cart"] = "3 items"
+	if err := store.Save(req, httptest.NewRecorder(), session); err != nil {
+		t.Fatal(err)
+	}
+
+	oldID := session.ID
+	w := httptest.NewRecorder()
+	if err := store.RegenerateID(ctx, req, w, session); err != nil {
+		t.Fatal(err)
+	}
+
+	if session.ID == oldID {
+		t.Fatal("expected a new session id")
+	}
+	if _, ok := ddb.items[oldID]; ok {
+		t.Error("expected the item under the old id to be deleted")
+	}
+
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Value != session.ID {
+		t.Fatalf("expected a cookie carrying the new id; got %v", cookies)
+	}
+
+	req, _ = http.NewRequest(http.MethodGet, "http://localhost", nil)
+	req.AddCookie(cookies[0])
+	found, _ := store.New(req, "session")
+	if found.IsNew || found.Values["cart"] != "3 items" {
+		t.Errorf("expected values to carry over to the new id; got %v", found.Values)
+	}
+}
+
+func TestIDRotation(t *testing.T) {
+	ddb := newFakeDynamoDB()
+	store, _ := New(ddb, WithIDRotation(RotateOnChange("user_id")))
+
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost", nil)
+	session, _ := store.New(req, "session")
+	if err := store.Save(req, httptest.NewRecorder(), session); err != nil {
+		t.Fatal(err)
+	}
+
+	load := func(id string) *http.Request {
+		req, _ := http.NewRequest(http.MethodGet, "http://localhost", nil)
+		req.AddCookie(&http.Cookie{Name: "session", Value: id})
+		return req
+	}
+
+	anonymousID := session.ID
+	req = load(anonymousID)
+	session, _ = store.New(req, "session")
+	session.Values["user_id"] = 42
+	if err := store.Save(req, httptest.NewRecorder(), session); err != nil {
+		t.Fatal(err)
+	}
+	if session.ID == anonymousID {
+		t.Fatal("expected the id to rotate when user_id is set")
+	}
+
+	loggedInID := session.ID
+	req = load(loggedInID)
+	session, _ = store.New(req, "session")
+	session.Values["theme"] = "dark"
+	if err := store.Save(req, httptest.NewRecorder(), session); err != nil {
+		t.Fatal(err)
+	}
+	if session.ID != loggedInID {
+		t.Error("expected the id to be kept when user_id is unchanged")
+	}
+}
+
+func TestRegenerateIDWritePath(t *testing.T) {
+	ctx := context.TODO()
+
+	var saved, deleted []string
+	ddb := newFakeDynamoDB()
+	store, _ := New(ddb, MaxAge(3600),
+		WithWriteBehind(10, 1, time.Hour, nil),
+		WithBeforeSave(func(ctx context.Context, session *sessions.Session) error {
+			saved = append(saved, session.ID)
+			return nil
+		}),
+		WithOnDelete(func(ctx context.Context, id string) error {
+			deleted = append(deleted, id)
+			return nil
+		}),
+	)
+
+	// buffered by write behind, so still pending when the id rotates
+	session := sessions.NewSession(store, "session")
+	session.ID = "old"
+	session.Options = store.newOptions()
+	if err := store.Persist(ctx, "session", session); err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if err := store.RegenerateID(ctx, req, httptest.NewRecorder(), session); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := ddb.items["old"]; ok {
+		t.Errorf("expected the buffered write of the old id to be discarded; got %v", ddb.items)
+	}
+	if len(saved) != 2 || saved[1] != session.ID {
+		t.Errorf("expected the before save hooks to run for the new id; got %v", saved)
+	}
+	if len(deleted) != 1 || deleted[0] != "old" {
+		t.Errorf("expected the on delete hooks to run for the old id; got %v", deleted)
+	}
+}
+
+func TestRegenerateIDRevoked(t *testing.T) {
+	ctx := context.TODO()
+
+	ddb := newFakeDynamoDB()
+	store, _ := New(ddb, MaxAge(3600))
+
+	session := sessions.NewSession(store, "session")
+	session.ID = "abc"
+	session.Options = store.newOptions()
+	if err := store.Persist(ctx, "session", session); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Revoke(ctx, "abc"); err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if err := store.RegenerateID(ctx, req, httptest.NewRecorder(), session); err != ErrSessionRevoked {
+		t.Errorf("expected %v; got %v", ErrSessionRevoked, err)
+	}
+	if session.ID != "abc" || len(ddb.items) != 1 {
+		t.Errorf("expected the revoked session to keep its id; got %v, %v", session.ID, ddb.items)
+	}
+}
@@ -0,0 +1,365 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// ObjectStore is the subset of an object store such as S3 used by ExportToS3 and
+// RestoreFromS3. The S3 SDK isn't a dependency of dynastore; adapting an
+// *s3.Client takes a few lines calling PutObject (through an upload manager for
+// streaming bodies), GetObject and ListObjectsV2.
+type ObjectStore interface {
+	PutObject(ctx context.Context, bucket, key string, body io.Reader) error
+	GetObject(ctx context.Context, bucket, key string) (io.ReadCloser, error)
+	ListObjects(ctx context.Context, bucket, prefix string) ([]string, error)
+}
+
+// exportLine is a line of an export, in the layout of the native dynamodb
+// export to S3
+type exportLine struct {
+	Item map[string]any `json:"Item"`
+}
+
+// ExportToS3 snapshots every item of the table, auxiliary items included, to
+// objects under prefix in bucket: one gzipped JSON lines object per scan segment,
+// each line holding an item in dynamodb JSON as the native export does. Segments
+// are scanned in parallel as configured with IterateSegments, IteratePageSize and
+// IterateRateLimit. The number of items exported is returned.
+func (store *Store) ExportToS3(ctx context.Context, objects ObjectStore, bucket, prefix string, opts ...IterateOption) (int, error) {
+	store = store.scoped(ctx)
+
+	config := iterateConfig{segments: 1}
+	for _, opt := range opts {
+		opt(&config)
+	}
+	config.segments = max(config.segments, 1)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		total    int
+		firstErr error
+	)
+
+	throttle, stop := config.throttle()
+	defer stop()
+
+	for segment := 0; segment < config.segments; segment++ {
+		wg.Add(1)
+		go func(segment int) {
+			defer wg.Done()
+
+			key := fmt.Sprintf("%s%05d.json.gz", prefix, segment)
+			n, err := store.exportSegment(ctx, objects, bucket, key, segment, config, throttle)
+
+			mu.Lock()
+			defer mu.Unlock()
+			total += n
+			if err != nil && firstErr == nil {
+				firstErr = err
+				cancel()
+			}
+		}(segment)
+	}
+
+	wg.Wait()
+
+	return total, firstErr
+}
+
+// exportSegment streams one scan segment to the object named key
+func (store *Store) exportSegment(ctx context.Context, objects ObjectStore, bucket, key string, segment int, config iterateConfig, throttle <-chan time.Time) (int, error) {
+	pr, pw := io.Pipe()
+
+	n := 0
+	go func() {
+		zw := gzip.NewWriter(pw)
+		enc := json.NewEncoder(zw)
+
+		err := store.scanItems(ctx, segment, config, throttle, func(item map[string]types.AttributeValue) error {
+			n++
+			return enc.Encode(exportLine{Item: encodeDynamoJSON(item)})
+		})
+		if err == nil {
+			err = zw.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+
+	if err := objects.PutObject(ctx, bucket, key, pr); err != nil {
+		pr.CloseWithError(err)
+		return 0, fmt.Errorf("failed to write export object %s: %w", key, err)
+	}
+
+	return n, nil
+}
+
+// scanItems calls fn with every item of a scan segment
+func (store *Store) scanItems(ctx context.Context, segment int, config iterateConfig, throttle <-chan time.Time, fn func(map[string]types.AttributeValue) error) error {
+	input := &dynamodb.ScanInput{
+		TableName: aws.String(store.tableName),
+	}
+	input.FilterExpression, input.ExpressionAttributeNames, input.ExpressionAttributeValues = store.namespaceFilter(nil, nil, nil)
+	if config.segments > 1 {
+		input.Segment = aws.Int32(int32(segment))
+		input.TotalSegments = aws.Int32(int32(config.segments))
+	}
+	if config.pageSize > 0 {
+		input.Limit = aws.Int32(config.pageSize)
+	}
+
+	paginator := dynamodb.NewScanPaginator(store.ddb, input)
+	for paginator.HasMorePages() {
+		if throttle != nil {
+			select {
+			case <-throttle:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to scan table: %w", err)
+		}
+		for _, item := range page.Items {
+			if err := fn(item); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// RestoreFromS3 writes the items of every export object under prefix in bucket,
+// as written by ExportToS3, back into the table, overwriting items with the same
+// key. The number of items restored is returned.
+func (store *Store) RestoreFromS3(ctx context.Context, objects ObjectStore, bucket, prefix string) (int, error) {
+	store = store.scoped(ctx)
+
+	keys, err := objects.ListObjects(ctx, bucket, prefix)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list export objects: %w", err)
+	}
+
+	restored := 0
+	for _, key := range keys {
+		n, err := store.restoreObject(ctx, objects, bucket, key)
+		restored += n
+		if err != nil {
+			return restored, err
+		}
+	}
+
+	return restored, nil
+}
+
+func (store *Store) restoreObject(ctx context.Context, objects ObjectStore, bucket, key string) (int, error) {
+	body, err := objects.GetObject(ctx, bucket, key)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read export object %s: %w", key, err)
+	}
+	defer body.Close()
+
+	zr, err := gzip.NewReader(body)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read export object %s: %w", key, err)
+	}
+
+	var requests []types.WriteRequest
+	restored := 0
+
+	scanner := bufio.NewScanner(zr)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var line exportLine
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			return restored, fmt.Errorf("invalid line in export object %s: %w", key, err)
+		}
+		item, err := decodeDynamoJSON(line.Item)
+		if err != nil {
+			return restored, fmt.Errorf("invalid item in export object %s: %w", key, err)
+		}
+
+		requests = append(requests, types.WriteRequest{PutRequest: &types.PutRequest{Item: item}})
+		if len(requests) == maxBatchWriteItems {
+			if err := store.batchWrite(ctx, requests); err != nil {
+				return restored, err
+			}
+			restored += len(requests)
+			requests = requests[:0]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return restored, fmt.Errorf("failed to read export object %s: %w", key, err)
+	}
+
+	if err := store.batchWrite(ctx, requests); err != nil {
+		return restored, err
+	}
+
+	return restored + len(requests), nil
+}
+
+// encodeDynamoJSON converts an item to dynamodb JSON, where every value is an
+// object keyed by its type, e.g. {"S": "abc"}
+func encodeDynamoJSON(item map[string]types.AttributeValue) map[string]any {
+	out := make(map[string]any, len(item))
+	for k, v := range item {
+		out[k] = encodeDynamoValue(v)
+	}
+	return out
+}
+
+func encodeDynamoValue(v types.AttributeValue) map[string]any {
+	switch t := v.(type) {
+	case *types.AttributeValueMemberS:
+		return map[string]any{"S": t.Value}
+	case *types.AttributeValueMemberN:
+		return map[string]any{"N": t.Value}
+	case *types.AttributeValueMemberB:
+		return map[string]any{"B": base64.StdEncoding.EncodeToString(t.Value)}
+	case *types.AttributeValueMemberBOOL:
+		return map[string]any{"BOOL": t.Value}
+	case *types.AttributeValueMemberNULL:
+		return map[string]any{"NULL": true}
+	case *types.AttributeValueMemberSS:
+		return map[string]any{"SS": t.Value}
+	case *types.AttributeValueMemberNS:
+		return map[string]any{"NS": t.Value}
+	case *types.AttributeValueMemberBS:
+		set := make([]string, len(t.Value))
+		for i, b := range t.Value {
+			set[i] = base64.StdEncoding.EncodeToString(b)
+		}
+		return map[string]any{"BS": set}
+	case *types.AttributeValueMemberL:
+		list := make([]any, len(t.Value))
+		for i, e := range t.Value {
+			list[i] = encodeDynamoValue(e)
+		}
+		return map[string]any{"L": list}
+	case *types.AttributeValueMemberM:
+		return map[string]any{"M": encodeDynamoJSON(t.Value)}
+	default:
+		return map[string]any{"NULL": true}
+	}
+}
+
+// decodeDynamoJSON reverses encodeDynamoJSON
+func decodeDynamoJSON(item map[string]any) (map[string]types.AttributeValue, error) {
+	out := make(map[string]types.AttributeValue, len(item))
+	for k, v := range item {
+		value, err := decodeDynamoValue(v)
+		if err != nil {
+			return nil, fmt.Errorf("attribute %s: %w", k, err)
+		}
+		out[k] = value
+	}
+	return out, nil
+}
+
+func decodeDynamoValue(v any) (types.AttributeValue, error) {
+	typed, ok := v.(map[string]any)
+	if !ok || len(typed) != 1 {
+		return nil, fmt.Errorf("expected an object with a single type key")
+	}
+
+	for kind, raw := range typed {
+		switch kind {
+		case "S", "N":
+			s, ok := raw.(string)
+			if !ok {
+				return nil, fmt.Errorf("expected a string for %s", kind)
+			}
+			if kind == "S" {
+				return &types.AttributeValueMemberS{Value: s}, nil
+			}
+			return &types.AttributeValueMemberN{Value: s}, nil
+		case "B":
+			s, _ := raw.(string)
+			b, err := base64.StdEncoding.DecodeString(s)
+			if err != nil {
+				return nil, err
+			}
+			return &types.AttributeValueMemberB{Value: b}, nil
+		case "BOOL":
+			b, _ := raw.(bool)
+			return &types.AttributeValueMemberBOOL{Value: b}, nil
+		case "NULL":
+			return &types.AttributeValueMemberNULL{Value: true}, nil
+		case "SS", "NS", "BS":
+			list, _ := raw.([]any)
+			set := make([]string, len(list))
+			for i, e := range list {
+				set[i], _ = e.(string)
+			}
+			switch kind {
+			case "SS":
+				return &types.AttributeValueMemberSS{Value: set}, nil
+			case "NS":
+				return &types.AttributeValueMemberNS{Value: set}, nil
+			}
+			bs := make([][]byte, len(set))
+			for i, s := range set {
+				b, err := base64.StdEncoding.DecodeString(s)
+				if err != nil {
+					return nil, err
+				}
+				bs[i] = b
+			}
+			return &types.AttributeValueMemberBS{Value: bs}, nil
+		case "L":
+			list, _ := raw.([]any)
+			values := make([]types.AttributeValue, len(list))
+			for i, e := range list {
+				value, err := decodeDynamoValue(e)
+				if err != nil {
+					return nil, err
+				}
+				values[i] = value
+			}
+			return &types.AttributeValueMemberL{Value: values}, nil
+		case "M":
+			m, _ := raw.(map[string]any)
+			values, err := decodeDynamoJSON(m)
+			if err != nil {
+				return nil, err
+			}
+			return &types.AttributeValueMemberM{Value: values}, nil
+		default:
+			return nil, fmt.Errorf("unknown type %s", kind)
+		}
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,98 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+type memoryObjects struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func (m *memoryObjects) PutObject(ctx context.Context, bucket, key string, body io.Reader) error {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.objects[bucket+"/"+key] = data
+	return nil
+}
+
+func (m *memoryObjects) GetObject(ctx context.Context, bucket, key string) (io.ReadCloser, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return io.NopCloser(bytes.NewReader(m.objects[bucket+"/"+key])), nil
+}
+
+func (m *memoryObjects) ListObjects(ctx context.Context, bucket, prefix string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var keys []string
+	for _, k := range slices.Sorted(maps.Keys(m.objects)) {
+		if key, ok := strings.CutPrefix(k, bucket+"/"); ok && strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+func TestExportToS3(t *testing.T) {
+	ctx := context.TODO()
+
+	ddb := newFakeDynamoDB()
+	store, _ := New(ddb, WithUserIndex("user-index", "user_id"), MaxAge(3600))
+	persistUserSessions(t, store, "bob", 40)
+	ddb.items["typed"] = map[string]types.AttributeValue{
+		DefaultPrimaryKey: &types.AttributeValueMemberS{Value: "typed"},
+		"data":            &types.AttributeValueMemberB{Value: []byte{0, 1, 2}},
+		"tags":            &types.AttributeValueMemberSS{Value: []string{"a", "b"}},
+		"nested":          &types.AttributeValueMemberM{Value: map[string]types.AttributeValue{"ok": &types.AttributeValueMemberBOOL{Value: true}}},
+	}
+
+	objects := &memoryObjects{objects: map[string][]byte{}}
+	n, err := store.ExportToS3(ctx, objects, "backups", "sessions/", IterateSegments(3), IteratePageSize(7))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 41 || len(objects.objects) != 3 {
+		t.Errorf("expected 41 items in 3 objects; got %v in %v", n, len(objects.objects))
+	}
+
+	original := maps.Clone(ddb.items)
+	clear(ddb.items)
+
+	n, err = store.RestoreFromS3(ctx, objects, "backups", "sessions/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 41 || !reflect.DeepEqual(ddb.items, original) {
+		t.Errorf("expected the table to be restored as it was; got %v items", n)
+	}
+}
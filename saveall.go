@@ -0,0 +1,125 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/gorilla/sessions"
+)
+
+// SaveAll saves every session retrieved with Get during the request, writing
+// them in batches instead of one PutItem per session: deletes with
+// BatchWriteItem, and puts with TransactWriteItems so that, as with Save, a
+// session revoked since it was loaded isn't written back. Sessions go through
+// the same hooks and checks as with Save; only those written by
+// WithLastWriterWins or buffered by WithWriteBehind, and those whose id must be
+// rotated, are still written individually. A session that was revoked, or that
+// WithLastWriterWins finds stored by a newer write, isn't written and doesn't
+// keep the other sessions from being saved; its ErrSessionRevoked or
+// ErrSessionConflict is returned once they are.
+func (store *Store) SaveAll(req *http.Request, w http.ResponseWriter) error {
+	store = store.scoped(req.Context())
+
+	ctx := req.Context()
+	tracker := requestTracker(req)
+
+	var (
+		deletes []types.WriteRequest
+		puts    []map[string]types.AttributeValue
+		written []*sessions.Session
+		items   = map[*sessions.Session]map[string]types.AttributeValue{}
+		errs    []error
+	)
+
+	for _, session := range tracker.all() {
+		switch {
+		case deleting(session):
+			if store.bearerHeader == "" {
+				http.SetCookie(w, newCookie(session.Options, session.Name(), ""))
+			}
+			deletes = append(deletes, types.WriteRequest{
+				DeleteRequest: &types.DeleteRequest{Key: store.key(session.ID)},
+			})
+
+		case store.shouldRotate(session):
+			if err := store.Save(req, w, session); err != nil {
+				return err
+			}
+			tracker.saved(session)
+			continue
+
+		default:
+			store.bindClient(req, session)
+			store.captureOrigin(req, session)
+
+			item, err := store.prepareSave(ctx, session, "")
+			if err != nil {
+				return err
+			}
+			item, queued, err := store.putUnbatched(ctx, session, item)
+			if errors.Is(err, ErrSessionConflict) || errors.Is(err, ErrSessionRevoked) {
+				// the stored session is the one to keep
+				errs = append(errs, err)
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if !queued {
+				puts = append(puts, item)
+			}
+			items[session] = item
+		}
+
+		written = append(written, session)
+	}
+
+	if err := store.batchWrite(ctx, deletes); err != nil {
+		return err
+	}
+
+	revoked, err := store.putNotRevoked(ctx, puts)
+	if err != nil {
+		return err
+	}
+
+	for _, session := range written {
+		if deleting(session) {
+			errs = append(errs, store.deleted(ctx, session.ID, ""))
+			tracker.saved(session)
+			continue
+		}
+
+		item := items[session]
+		if revoked[itemID(store, item)] {
+			errs = append(errs, ErrSessionRevoked)
+			continue
+		}
+
+		requestCache(ctx).put(store.tableName, itemID(store, item), item)
+		store.publishSaved(session)
+		errs = append(errs, store.auditPersist(ctx, session))
+		if store.canSetCookie(session) {
+			if err := store.setCookie(ctx, w, session); err != nil {
+				return err
+			}
+		}
+		tracker.saved(session)
+	}
+
+	return errors.Join(errs...)
+}
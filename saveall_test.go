@@ -0,0 +1,183 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+)
+
+func TestSaveAll(t *testing.T) {
+	ddb := newFakeDynamoDB()
+	store, _ := New(ddb)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	for _, name := range []string{"session", "flash", "prefs"} {
+		session, _ := store.Get(req, name)
+		session.Values["name"] = name
+	}
+
+	w := httptest.NewRecorder()
+	if err := store.SaveAll(req, w); err != nil {
+		t.Fatal(err)
+	}
+
+	if ddb.puts != 0 || ddb.transactions != 1 {
+		t.Errorf("expected a single transaction; got %v puts and %v transactions", ddb.puts, ddb.transactions)
+	}
+	if len(ddb.items) != 3 {
+		t.Errorf("expected 3 sessions to be saved; got %v", len(ddb.items))
+	}
+
+	cookies := w.Result().Cookies()
+	if len(cookies) != 3 {
+		t.Fatalf("expected 3 cookies; got %v", cookies)
+	}
+
+	// Deleting sessions goes through the same batch
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	session, _ := store.Get(req, "flash")
+	session.Options.MaxAge = -1
+	if err := store.SaveAll(req, httptest.NewRecorder()); err != nil {
+		t.Fatal(err)
+	}
+	if len(ddb.items) != 2 {
+		t.Errorf("expected the deleted session to be removed; got %v items", len(ddb.items))
+	}
+}
+
+func TestSaveAllHooks(t *testing.T) {
+	var saved, deleted []string
+	ddb := newFakeDynamoDB()
+	store, _ := New(ddb,
+		WithBeforeSave(func(ctx context.Context, session *sessions.Session) error {
+			saved = append(saved, session.Name())
+			return nil
+		}),
+		WithOnDelete(func(ctx context.Context, id string) error {
+			deleted = append(deleted, id)
+			return nil
+		}),
+	)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	for _, name := range []string{"session", "flash"} {
+		if _, err := store.Get(req, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w := httptest.NewRecorder()
+	if err := store.SaveAll(req, w); err != nil {
+		t.Fatal(err)
+	}
+	if slices.Sort(saved); !slices.Equal(saved, []string{"flash", "session"}) {
+		t.Errorf("expected the before save hooks to run for every session; got %v", saved)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	for _, cookie := range w.Result().Cookies() {
+		req.AddCookie(cookie)
+	}
+	flash, _ := store.Get(req, "flash")
+	flash.Options.MaxAge = -1
+	if err := store.SaveAll(req, httptest.NewRecorder()); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(deleted, []string{flash.ID}) {
+		t.Errorf("expected the on delete hooks to run for the deleted session; got %v", deleted)
+	}
+}
+
+func TestSaveAllWriteBehind(t *testing.T) {
+	ddb := newFakeDynamoDB()
+	store, _ := New(ddb, WithWriteBehind(10, 1, time.Hour, nil))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	for _, name := range []string{"session", "flash"} {
+		session, _ := store.Get(req, name)
+		session.Values["name"] = name
+	}
+	if err := store.SaveAll(req, httptest.NewRecorder()); err != nil {
+		t.Fatal(err)
+	}
+	if ddb.transactions != 0 || len(ddb.items) != 0 {
+		t.Fatalf("expected the sessions to be buffered; got %v", ddb.items)
+	}
+
+	if err := store.Flush(context.TODO()); err != nil {
+		t.Fatal(err)
+	}
+	if len(ddb.items) != 2 {
+		t.Errorf("expected the buffered sessions to be flushed; got %v", ddb.items)
+	}
+}
+
+func TestSaveAllLastWriterWins(t *testing.T) {
+	ctx := context.TODO()
+	now := time.Now()
+
+	ddb := newFakeDynamoDB()
+	store, _ := New(ddb, MaxAge(3600), WithLastWriterWins(nil))
+	store.now = func() time.Time { return now }
+
+	session := sessions.NewSession(store, "session")
+	session.ID = "abc"
+	session.Options = store.newOptions()
+	session.Values["cart"] = "book"
+	if err := store.Persist(ctx, "session", session); err != nil {
+		t.Fatal(err)
+	}
+
+	// a stale copy saved in a batch must not overwrite the newer session
+	stale := sessions.NewSession(store, "session")
+	stale.ID = "abc"
+	stale.Options = store.newOptions()
+	stale.Values[updatedAtKey{}] = int64(1)
+	stale.Values["cart"] = "empty"
+	now = now.Add(-time.Hour)
+
+	other := sessions.NewSession(store, "other")
+	other.ID = "def"
+	other.Options = store.newOptions()
+	other.IsNew = true
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	requestTracker(req).track(stale)
+	requestTracker(req).track(other)
+	if err := store.SaveAll(req, httptest.NewRecorder()); !errors.Is(err, ErrSessionConflict) {
+		t.Errorf("expected ErrSessionConflict; got %v", err)
+	}
+	if _, ok := ddb.items["def"]; !ok {
+		t.Error("expected the conflict not to keep the other session from being saved")
+	}
+	if ddb.batches != 0 {
+		t.Errorf("expected the session to be written with a conditional put; got %v batches", ddb.batches)
+	}
+
+	now = now.Add(time.Hour)
+	loaded := sessions.NewSession(store, "session")
+	if err := store.Load(ctx, "abc", loaded); err != nil || loaded.Values["cart"] != "book" {
+		t.Errorf("expected the newer session to be kept; got %v, %v", loaded.Values, err)
+	}
+}
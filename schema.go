@@ -0,0 +1,150 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// ValueType is the type a Schema requires of a session value
+type ValueType int
+
+const (
+	// AnyType accepts values of any type
+	AnyType ValueType = iota
+	// StringType accepts strings
+	StringType
+	// NumberType accepts integers and floats
+	NumberType
+	// BoolType accepts booleans
+	BoolType
+	// BinaryType accepts byte slices
+	BinaryType
+	// ListType accepts slices and arrays
+	ListType
+	// MapType accepts maps and structs
+	MapType
+)
+
+func (t ValueType) String() string {
+	switch t {
+	case StringType:
+		return "string"
+	case NumberType:
+		return "number"
+	case BoolType:
+		return "bool"
+	case BinaryType:
+		return "binary"
+	case ListType:
+		return "list"
+	case MapType:
+		return "map"
+	default:
+		return "any"
+	}
+}
+
+// FieldRule describes one session value of a Schema
+type FieldRule struct {
+	// Type is the type the value must have
+	Type ValueType
+
+	// Required makes sessions without the value invalid
+	Required bool
+
+	// MaxLen caps the length of a string or binary value, or the number of
+	// elements of a list or map. Zero means no limit.
+	MaxLen int
+}
+
+// Schema declares the expected session values by key. Values not in the schema
+// are not checked.
+type Schema map[string]FieldRule
+
+// ValidationError reports a session value that doesn't match the Schema set with
+// WithSchema. Persist and Load return all the problems found joined together, each
+// of which can be inspected with errors.As.
+type ValidationError struct {
+	// Key is the key of the offending value
+	Key string
+
+	// Problem describes what is wrong with it
+	Problem string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid session value %q: %s", e.Key, e.Problem)
+}
+
+// validate checks values against the schema
+func (s Schema) validate(values map[string]any) error {
+	var errs []error
+	for key, rule := range s {
+		v, ok := values[key]
+		if !ok || v == nil {
+			if rule.Required {
+				errs = append(errs, &ValidationError{Key: key, Problem: "missing"})
+			}
+			continue
+		}
+
+		t, n := describeValue(v)
+		if rule.Type != AnyType && t != rule.Type {
+			errs = append(errs, &ValidationError{Key: key, Problem: fmt.Sprintf("expected %v, got %v", rule.Type, t)})
+			continue
+		}
+		if rule.MaxLen > 0 && n > rule.MaxLen {
+			errs = append(errs, &ValidationError{Key: key, Problem: fmt.Sprintf("length %d exceeds %d", n, rule.MaxLen)})
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// describeValue returns the type of v and its length, as checked by FieldRule
+func describeValue(v any) (ValueType, int) {
+	if b, ok := v.([]byte); ok {
+		return BinaryType, len(b)
+	}
+
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			return AnyType, 0
+		}
+		rv = rv.Elem()
+	}
+
+	switch rv.Kind() {
+	case reflect.String:
+		return StringType, len(rv.String())
+	case reflect.Bool:
+		return BoolType, 0
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return NumberType, 0
+	case reflect.Slice, reflect.Array:
+		return ListType, rv.Len()
+	case reflect.Map:
+		return MapType, rv.Len()
+	case reflect.Struct:
+		return MapType, rv.NumField()
+	default:
+		return AnyType, 0
+	}
+}
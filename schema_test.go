@@ -0,0 +1,91 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/gorilla/sessions"
+)
+
+func TestSchema(t *testing.T) {
+	ddb := newFakeDynamoDB()
+	store, _ := New(ddb, MaxAge(3600), WithSchema(Schema{
+		"user_id": {Type: StringType, Required: true, MaxLen: 8},
+		"visits":  {Type: NumberType},
+		"roles":   {Type: ListType, MaxLen: 2},
+	}))
+
+	testCases := map[string]struct {
+		Values   map[any]any
+		Problems []string
+	}{
+		"valid": {
+			Values: map[any]any{"user_id": "bob", "visits": 3, "roles": []string{"admin"}},
+		},
+		"missing": {
+			Values:   map[any]any{"visits": 3},
+			Problems: []string{"user_id"},
+		},
+		"wrong type and too long": {
+			Values:   map[any]any{"user_id": "bob", "visits": "3", "roles": []string{"a", "b", "c"}},
+			Problems: []string{"visits", "roles"},
+		},
+	}
+
+	for label, tc := range testCases {
+		t.Run(label, func(t *testing.T) {
+			session := sessions.NewSession(store, "session")
+			session.ID = "abc"
+			session.Options = store.newOptions()
+			session.Values = tc.Values
+
+			err := store.Persist(context.TODO(), "session", session)
+			if len(tc.Problems) == 0 {
+				if err != nil {
+					t.Fatalf("expected nil; got %v", err)
+				}
+				return
+			}
+
+			var verr *ValidationError
+			if !errors.As(err, &verr) {
+				t.Fatalf("expected a ValidationError; got %v", err)
+			}
+			for _, key := range tc.Problems {
+				found := false
+				for _, e := range err.(interface{ Unwrap() []error }).Unwrap() {
+					if errors.As(e, &verr) && verr.Key == key {
+						found = true
+					}
+				}
+				if !found {
+					t.Errorf("expected a problem with %v; got %v", key, err)
+				}
+			}
+		})
+	}
+
+	ddb.items["corrupt"] = map[string]types.AttributeValue{
+		DefaultPrimaryKey: &types.AttributeValueMemberS{Value: "corrupt"},
+		"user_id":         &types.AttributeValueMemberN{Value: "42"},
+	}
+	var verr *ValidationError
+	if err := store.Load(context.TODO(), "corrupt", sessions.NewSession(store, "session")); !errors.As(err, &verr) {
+		t.Errorf("expected a ValidationError loading a corrupt session; got %v", err)
+	}
+}
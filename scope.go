@@ -0,0 +1,69 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"context"
+)
+
+type tableContextKey struct{}
+
+type namespaceContextKey struct{}
+
+// ContextWithTable directs the sessions of requests carrying the returned context
+// to table instead of the table of the store, e.g. from middleware that resolves
+// the tenant of a request. The revocation table is not affected.
+func ContextWithTable(ctx context.Context, table string) context.Context {
+	return context.WithValue(ctx, tableContextKey{}, table)
+}
+
+// ContextWithNamespace uses namespace instead of the one set with WithNamespace
+// for requests carrying the returned context. It panics if namespace is empty or
+// contains "/", which would let it reach the items of other namespaces.
+func ContextWithNamespace(ctx context.Context, namespace string) context.Context {
+	if !validNamespace(namespace) {
+		panic(errInvalidNamespace)
+	}
+	return context.WithValue(ctx, namespaceContextKey{}, namespace)
+}
+
+// contextTable returns the table selected for ctx with ContextWithTable, or table
+// if there is none
+func contextTable(ctx context.Context, table string) string {
+	if t, ok := ctx.Value(tableContextKey{}).(string); ok {
+		return t
+	}
+	return table
+}
+
+// scoped returns the store to use for ctx: the store itself, or a copy of it
+// targeting the table and namespace selected with ContextWithTable and
+// ContextWithNamespace
+func (store *Store) scoped(ctx context.Context) *Store {
+	table, hasTable := ctx.Value(tableContextKey{}).(string)
+	namespace, hasNamespace := ctx.Value(namespaceContextKey{}).(string)
+	if (!hasTable || table == store.tableName) && (!hasNamespace || namespace == store.namespace) {
+		return store
+	}
+
+	scoped := *store
+	if hasTable {
+		scoped.tableName = table
+	}
+	if hasNamespace {
+		scoped.namespace = namespace
+	}
+
+	return &scoped
+}
@@ -0,0 +1,60 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestContextScope(t *testing.T) {
+	ddb := newFakeDynamoDB()
+	store, _ := New(ddb, MaxAge(3600))
+
+	tenant := func(req *http.Request) *http.Request {
+		ctx := ContextWithTable(req.Context(), "tenant-b")
+		return req.WithContext(ContextWithNamespace(ctx, "b"))
+	}
+
+	req := tenant(httptest.NewRequest(http.MethodGet, "/", nil))
+	session, _ := store.New(req, "session")
+	session.Values["name"] = "bob"
+	w := httptest.NewRecorder()
+	if err := store.Save(req, w, session); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ddb.items) != 0 {
+		t.Errorf("expected nothing to be written to the default table; got %v", ddb.items)
+	}
+	if _, ok := ddb.others["tenant-b"]["b/"+session.ID]; !ok {
+		t.Fatalf("expected the session to be written to the tenant table; got %v", ddb.others)
+	}
+
+	next := httptest.NewRequest(http.MethodGet, "/", nil)
+	for _, cookie := range w.Result().Cookies() {
+		next.AddCookie(cookie)
+	}
+
+	loaded, _ := store.New(tenant(next), "session")
+	if loaded.IsNew || loaded.Values["name"] != "bob" {
+		t.Errorf("expected the session to load from the tenant table; got %v", loaded.Values)
+	}
+
+	loaded, _ = store.New(next, "session")
+	if !loaded.IsNew {
+		t.Error("expected the session not to load from the default table")
+	}
+}
@@ -0,0 +1,156 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+)
+
+// SecretsManagerClient is the subset of the secrets manager API used by
+// SecretsManagerKeyProvider. It is satisfied by *secretsmanager.Client.
+type SecretsManagerClient interface {
+	GetSecretValue(ctx context.Context, params *secretsmanager.GetSecretValueInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error)
+}
+
+// SecretsManagerKeyProvider is a KeyProvider that reads Keys, serialized as JSON,
+// from an AWS Secrets Manager secret. The secret is fetched again once it is older
+// than the refresh interval; if that fails the previous keys continue to be used.
+// The previous keys are also served while a refresh is in flight, so lookups
+// never wait on secrets manager once the secret has been fetched. After a failed
+// fetch the secret isn't fetched again for a backoff that doubles with every
+// further failure, so an outage of secrets manager isn't made worse by retrying
+// on every lookup; until then the error is returned if there are no keys yet.
+type SecretsManagerKeyProvider struct {
+	client   SecretsManagerClient
+	secretID string
+	refresh  time.Duration
+	now      func() time.Time
+
+	mu      sync.Mutex
+	keys    Keys
+	fetched time.Time
+
+	// failures counts the fetches that failed in a row, the last with err;
+	// none is attempted before retryAt
+	failures int
+	err      error
+	retryAt  time.Time
+
+	// fetching is closed once the fetch in flight completes, nil when idle
+	fetching chan struct{}
+}
+
+// The backoff after a failed fetch of the secret starts at minSecretRetry and
+// doubles up to maxSecretRetry
+const (
+	minSecretRetry = time.Second
+	maxSecretRetry = time.Minute
+)
+
+// NewSecretsManagerKeyProvider returns a KeyProvider reading secretID, which may be
+// the name or ARN of the secret, and refreshing it every refresh interval.
+func NewSecretsManagerKeyProvider(client SecretsManagerClient, secretID string, refresh time.Duration) *SecretsManagerKeyProvider {
+	return &SecretsManagerKeyProvider{
+		client:   client,
+		secretID: secretID,
+		refresh:  refresh,
+		now:      time.Now,
+	}
+}
+
+// Keys returns the current keys, fetching the secret if it hasn't been fetched
+// within the refresh interval. Only one fetch runs at a time: callers arriving
+// during it get the previous keys, or wait for it if there are none yet.
+func (p *SecretsManagerKeyProvider) Keys(ctx context.Context) (Keys, error) {
+	p.mu.Lock()
+	for {
+		backingOff := p.now().Before(p.retryAt)
+		if !p.fetched.IsZero() && (p.fetching != nil || backingOff || p.now().Sub(p.fetched) < p.refresh) {
+			keys := p.keys
+			p.mu.Unlock()
+			return keys, nil
+		}
+		if p.fetching == nil && backingOff {
+			err := p.err
+			p.mu.Unlock()
+			return Keys{}, err
+		}
+		if p.fetching == nil {
+			break
+		}
+
+		fetching := p.fetching
+		p.mu.Unlock()
+		select {
+		case <-fetching:
+		case <-ctx.Done():
+			return Keys{}, ctx.Err()
+		}
+		p.mu.Lock()
+	}
+
+	fetching := make(chan struct{})
+	p.fetching = fetching
+	p.mu.Unlock()
+
+	keys, err := p.fetch(ctx)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.fetching = nil
+	close(fetching)
+
+	if err != nil {
+		p.failures++
+		p.err = err
+		p.retryAt = p.now().Add(min(minSecretRetry<<min(p.failures-1, 6), maxSecretRetry))
+		if !p.fetched.IsZero() {
+			return p.keys, nil
+		}
+		return Keys{}, err
+	}
+
+	p.keys, p.fetched = keys, p.now()
+	p.failures, p.err, p.retryAt = 0, nil, time.Time{}
+
+	return keys, nil
+}
+
+func (p *SecretsManagerKeyProvider) fetch(ctx context.Context) (Keys, error) {
+	result, err := p.client.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+		SecretId: aws.String(p.secretID),
+	})
+	if err != nil {
+		return Keys{}, fmt.Errorf("failed to get secret %s: %w", p.secretID, err)
+	}
+
+	secret := result.SecretBinary
+	if result.SecretString != nil {
+		secret = []byte(aws.ToString(result.SecretString))
+	}
+
+	var keys Keys
+	if err := json.Unmarshal(secret, &keys); err != nil {
+		return Keys{}, fmt.Errorf("failed to parse secret %s: %w", p.secretID, err)
+	}
+
+	return keys, nil
+}
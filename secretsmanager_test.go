@@ -0,0 +1,215 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+)
+
+type fakeSecretsManager struct {
+	keys Keys
+	err  error
+
+	// block, when set, holds every call until it is closed
+	block chan struct{}
+	calls atomic.Int32
+}
+
+func (f *fakeSecretsManager) GetSecretValue(ctx context.Context, params *secretsmanager.GetSecretValueInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error) {
+	f.calls.Add(1)
+	if f.block != nil {
+		<-f.block
+	}
+
+	if f.err != nil {
+		return nil, f.err
+	}
+
+	secret, _ := json.Marshal(f.keys)
+	return &secretsmanager.GetSecretValueOutput{SecretString: aws.String(string(secret))}, nil
+}
+
+func TestSecretsManagerKeyProvider(t *testing.T) {
+	ctx := context.TODO()
+
+	now := time.Unix(1700000000, 0)
+	client := &fakeSecretsManager{keys: Keys{SigningKey: []byte("one")}}
+	provider := NewSecretsManagerKeyProvider(client, "sessions", time.Minute)
+	provider.now = func() time.Time { return now }
+
+	store, err := New(newFakeDynamoDB(), WithKeyProvider(provider))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	value, err := store.encodeCookie(ctx, "session", "abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client.keys.SigningKey = []byte("two")
+	if _, err := store.decodeCookie(ctx, "session", value); err != nil {
+		t.Errorf("expected keys to be cached until the refresh interval; got %v", err)
+	}
+
+	now = now.Add(time.Minute)
+	if _, err := store.decodeCookie(ctx, "session", value); err != errInvalidSignature {
+		t.Errorf("expected rotated key to be picked up; got %v", err)
+	}
+
+	now = now.Add(time.Minute)
+	client.err = fmt.Errorf("unavailable")
+	keys, err := provider.Keys(ctx)
+	if err != nil || string(keys.SigningKey) != "two" {
+		t.Errorf("expected previous keys to be served when refresh fails; got %q, %v", keys.SigningKey, err)
+	}
+
+	if _, err := New(newFakeDynamoDB(), WithKeyProvider(NewSecretsManagerKeyProvider(client, "sessions", time.Minute))); err == nil {
+		t.Error("expected New to fail when keys cannot be fetched")
+	}
+}
+
+func TestSecretsManagerKeyProviderRefresh(t *testing.T) {
+	ctx := context.TODO()
+
+	testCases := map[string]struct {
+		Fetched  bool
+		Expected string
+	}{
+		"previous keys served during refresh": {Fetched: true, Expected: "one"},
+		"first fetch shared by callers":       {Expected: "two"},
+	}
+
+	for label, tc := range testCases {
+		t.Run(label, func(t *testing.T) {
+			now := time.Unix(1700000000, 0)
+			client := &fakeSecretsManager{keys: Keys{SigningKey: []byte("one")}}
+			provider := NewSecretsManagerKeyProvider(client, "sessions", time.Minute)
+			provider.now = func() time.Time { return now }
+
+			if tc.Fetched {
+				if _, err := provider.Keys(ctx); err != nil {
+					t.Fatal(err)
+				}
+				now = now.Add(time.Minute)
+			}
+			calls := client.calls.Load()
+
+			client.keys.SigningKey = []byte("two")
+			client.block = make(chan struct{})
+
+			refreshed := make(chan Keys)
+			go func() {
+				keys, _ := provider.Keys(ctx)
+				refreshed <- keys
+			}()
+			for client.calls.Load() == calls {
+				time.Sleep(time.Millisecond)
+			}
+
+			var wg sync.WaitGroup
+			got := make([]string, 4)
+			for i := range got {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					keys, _ := provider.Keys(ctx)
+					got[i] = string(keys.SigningKey)
+				}()
+			}
+			if tc.Fetched {
+				wg.Wait()
+			}
+			close(client.block)
+			wg.Wait()
+
+			for _, key := range got {
+				if key != tc.Expected {
+					t.Errorf("expected key %v; got %v", tc.Expected, key)
+				}
+			}
+			if keys := <-refreshed; string(keys.SigningKey) != "two" {
+				t.Errorf("expected the refresh to return the new keys; got %q", keys.SigningKey)
+			}
+			if n := client.calls.Load() - calls; n != 1 {
+				t.Errorf("expected a single fetch; got %v", n)
+			}
+		})
+	}
+}
+
+func TestSecretsManagerKeyProviderBackoff(t *testing.T) {
+	ctx := context.TODO()
+
+	testCases := map[string]struct {
+		Fetched bool
+	}{
+		"previous keys":  {Fetched: true},
+		"no keys so far": {},
+	}
+
+	for label, tc := range testCases {
+		t.Run(label, func(t *testing.T) {
+			now := time.Unix(1700000000, 0)
+			client := &fakeSecretsManager{keys: Keys{SigningKey: []byte("one")}}
+			provider := NewSecretsManagerKeyProvider(client, "sessions", time.Minute)
+			provider.now = func() time.Time { return now }
+
+			if tc.Fetched {
+				if _, err := provider.Keys(ctx); err != nil {
+					t.Fatal(err)
+				}
+				now = now.Add(time.Minute)
+			}
+			calls := client.calls.Load()
+
+			client.err = fmt.Errorf("unavailable")
+			fetch := func() {
+				keys, err := provider.Keys(ctx)
+				if tc.Fetched && (err != nil || string(keys.SigningKey) != "one") {
+					t.Errorf("expected the previous keys; got %q, %v", keys.SigningKey, err)
+				}
+				if !tc.Fetched && err == nil {
+					t.Error("expected the fetch error")
+				}
+			}
+
+			for _, wait := range []time.Duration{0, time.Second / 2, time.Second / 2, time.Second, time.Second} {
+				now = now.Add(wait)
+				fetch()
+			}
+			if n := client.calls.Load() - calls; n != 3 {
+				t.Errorf("expected retries to back off, doubling the delay; got %v fetches", n)
+			}
+
+			client.err = nil
+			now = now.Add(4 * time.Second)
+			if keys, err := provider.Keys(ctx); err != nil || string(keys.SigningKey) != "one" {
+				t.Errorf("expected the secret to be fetched once the backoff elapsed; got %q, %v", keys.SigningKey, err)
+			}
+			if n := client.calls.Load() - calls; n != 4 {
+				t.Errorf("expected a fetch after the backoff; got %v fetches", n)
+			}
+		})
+	}
+}
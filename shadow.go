@@ -0,0 +1,286 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// ErrShadowQueueFull is passed to the error handler of WithShadowWrites when a
+// write is dropped because the shadow table can't keep up
+var ErrShadowQueueFull = fmt.Errorf("shadow write queue is full")
+
+// shadowWriter mirrors the writes made to the table of the store to a second
+// table in the background
+type shadowWriter struct {
+	client    DynamoDBClient
+	table     string
+	transform ItemTransform
+	onError   func(error)
+	queue     chan shadowWrite
+	done      chan struct{}
+	mu        sync.RWMutex
+	closed    bool
+
+	// source and sourceTable are read by writes that copy an item as stored,
+	// set by New
+	source      DynamoDBClient
+	sourceTable string
+}
+
+// shadowWrite is a pending write: an item to put, the key of one to delete, or,
+// with copy, the key of one to copy from the source table as it is stored then
+type shadowWrite struct {
+	item map[string]types.AttributeValue
+	key  map[string]types.AttributeValue
+	copy bool
+}
+
+func (s *shadowWriter) start() {
+	s.done = make(chan struct{})
+	go func() {
+		defer close(s.done)
+		for w := range s.queue {
+			s.apply(w)
+		}
+	}()
+}
+
+func (s *shadowWriter) apply(w shadowWrite) {
+	var err error
+	if w.copy {
+		// updates only carry the changed attributes, so the item is mirrored
+		// whole, or deleted if it's gone by now
+		if w.item, err = s.stored(w.key); err != nil {
+			s.fail(err)
+			return
+		}
+	}
+
+	if s.transform != nil {
+		if w.item != nil {
+			w.item, err = s.transform(w.item)
+		} else {
+			w.key, err = s.transform(w.key)
+		}
+		if err != nil {
+			s.fail(fmt.Errorf("failed to transform shadow write: %w", err))
+			return
+		}
+	}
+
+	if w.item != nil {
+		_, err = s.client.PutItem(context.Background(), &dynamodb.PutItemInput{
+			TableName: aws.String(s.table),
+			Item:      w.item,
+		})
+	} else {
+		_, err = s.client.DeleteItem(context.Background(), &dynamodb.DeleteItemInput{
+			TableName: aws.String(s.table),
+			Key:       w.key,
+		})
+	}
+
+	if err != nil {
+		s.fail(fmt.Errorf("failed to shadow write to table %s: %w", s.table, err))
+	}
+}
+
+// stored reads the item under key from the source table, nil if there is none
+func (s *shadowWriter) stored(key map[string]types.AttributeValue) (map[string]types.AttributeValue, error) {
+	result, err := s.source.GetItem(context.Background(), &dynamodb.GetItemInput{
+		TableName:      aws.String(s.sourceTable),
+		Key:            key,
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read item to shadow from table %s: %w", s.sourceTable, err)
+	}
+
+	return result.Item, nil
+}
+
+func (s *shadowWriter) fail(err error) {
+	if s.onError != nil {
+		s.onError(err)
+	}
+}
+
+// enqueue queues w without blocking the request, dropping it if the queue is
+// full or the writer closed
+func (s *shadowWriter) enqueue(w shadowWrite) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.closed {
+		return
+	}
+
+	select {
+	case s.queue <- w:
+	default:
+		s.fail(ErrShadowQueueFull)
+	}
+}
+
+func (s *shadowWriter) put(item map[string]types.AttributeValue) {
+	s.enqueue(shadowWrite{item: item})
+}
+
+func (s *shadowWriter) delete(key map[string]types.AttributeValue) {
+	s.enqueue(shadowWrite{key: key})
+}
+
+func (s *shadowWriter) copy(key map[string]types.AttributeValue) {
+	s.enqueue(shadowWrite{key: key, copy: true})
+}
+
+// shadowingClient queues a copy of every successful write to the table of the
+// store with the shadow writer, whichever store operation made it
+type shadowingClient struct {
+	DynamoDBClient
+	shadow     *shadowWriter
+	table      string
+	primaryKey string
+}
+
+func (c shadowingClient) mirrored(table *string) bool {
+	return aws.ToString(table) == c.table
+}
+
+// keyOf returns the key of an item or key
+func (c shadowingClient) keyOf(item map[string]types.AttributeValue) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{c.primaryKey: item[c.primaryKey]}
+}
+
+func (c shadowingClient) PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	out, err := c.DynamoDBClient.PutItem(ctx, params, optFns...)
+	if err == nil && c.mirrored(params.TableName) {
+		c.shadow.put(params.Item)
+	}
+	return out, err
+}
+
+func (c shadowingClient) UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error) {
+	out, err := c.DynamoDBClient.UpdateItem(ctx, params, optFns...)
+	if err == nil && c.mirrored(params.TableName) {
+		if params.ReturnValues == types.ReturnValueAllNew && out.Attributes != nil {
+			c.shadow.put(out.Attributes)
+		} else {
+			c.shadow.copy(c.keyOf(params.Key))
+		}
+	}
+	return out, err
+}
+
+func (c shadowingClient) DeleteItem(ctx context.Context, params *dynamodb.DeleteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error) {
+	out, err := c.DynamoDBClient.DeleteItem(ctx, params, optFns...)
+	if err == nil && c.mirrored(params.TableName) {
+		c.shadow.delete(c.keyOf(params.Key))
+	}
+	return out, err
+}
+
+// BatchWriteItem mirrors the requests that were processed; unprocessed ones are
+// mirrored when the caller retries them
+func (c shadowingClient) BatchWriteItem(ctx context.Context, params *dynamodb.BatchWriteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchWriteItemOutput, error) {
+	out, err := c.DynamoDBClient.BatchWriteItem(ctx, params, optFns...)
+	if err != nil {
+		return out, err
+	}
+
+	unprocessed := map[string]bool{}
+	for _, request := range out.UnprocessedItems[c.table] {
+		unprocessed[c.requestKey(request)] = true
+	}
+
+	for table, requests := range params.RequestItems {
+		if table != c.table {
+			continue
+		}
+		for _, request := range requests {
+			if unprocessed[c.requestKey(request)] {
+				continue
+			}
+			switch {
+			case request.PutRequest != nil:
+				c.shadow.put(request.PutRequest.Item)
+			case request.DeleteRequest != nil:
+				c.shadow.delete(c.keyOf(request.DeleteRequest.Key))
+			}
+		}
+	}
+
+	return out, nil
+}
+
+func (c shadowingClient) TransactWriteItems(ctx context.Context, params *dynamodb.TransactWriteItemsInput, optFns ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error) {
+	out, err := c.DynamoDBClient.TransactWriteItems(ctx, params, optFns...)
+	if err != nil {
+		return out, err
+	}
+
+	for _, item := range params.TransactItems {
+		switch {
+		case item.Put != nil && c.mirrored(item.Put.TableName):
+			c.shadow.put(item.Put.Item)
+		case item.Update != nil && c.mirrored(item.Update.TableName):
+			c.shadow.copy(c.keyOf(item.Update.Key))
+		case item.Delete != nil && c.mirrored(item.Delete.TableName):
+			c.shadow.delete(c.keyOf(item.Delete.Key))
+		}
+	}
+
+	return out, nil
+}
+
+// requestKey identifies the item a batch write request is about
+func (c shadowingClient) requestKey(request types.WriteRequest) string {
+	var key map[string]types.AttributeValue
+	switch {
+	case request.PutRequest != nil:
+		key = request.PutRequest.Item
+	case request.DeleteRequest != nil:
+		key = request.DeleteRequest.Key
+	}
+
+	if v, ok := key[c.primaryKey].(*types.AttributeValueMemberS); ok {
+		return v.Value
+	}
+	return ""
+}
+
+// close stops accepting writes and waits for the queued ones to be applied, or
+// for ctx to be done
+func (s *shadowWriter) close(ctx context.Context) error {
+	s.mu.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.queue)
+	}
+	s.mu.Unlock()
+
+	select {
+	case <-s.done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("shadow writes still pending: %w", ctx.Err())
+	}
+}
@@ -0,0 +1,152 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/gorilla/sessions"
+)
+
+func TestShadowWrites(t *testing.T) {
+	ctx := context.TODO()
+
+	ddb := newFakeDynamoDB()
+	shadow := newFakeDynamoDB()
+	store, _ := New(ddb, MaxAge(3600), WithShadowWrites(shadow, "sessions-v2", 10, func(err error) {
+		t.Errorf("unexpected shadow error: %v", err)
+	}))
+
+	for _, id := range []string{"a", "b"} {
+		session := sessions.NewSession(store, "session")
+		session.ID = id
+		session.Options = store.newOptions()
+		session.Values["name"] = id
+		if err := store.Persist(ctx, "session", session); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Delete(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	mirrored := shadow.others["sessions-v2"]
+	if _, ok := mirrored["a"]; ok || len(mirrored) != 1 {
+		t.Errorf("expected the shadow table to match the primary; got %v", mirrored)
+	}
+	if attributeString(mirrored["b"]["name"]) != "b" {
+		t.Errorf("expected the item to be mirrored as is; got %v", mirrored["b"])
+	}
+}
+
+func TestShadowWritesEveryOperation(t *testing.T) {
+	testCases := map[string]struct {
+		Op func(t *testing.T, store *Store, ids []string)
+	}{
+		"touch": {
+			Op: func(t *testing.T, store *Store, ids []string) {
+				if err := store.Touch(context.TODO(), ids[0]); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		"revoke": {
+			Op: func(t *testing.T, store *Store, ids []string) {
+				if err := store.Revoke(context.TODO(), ids[0]); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		"regenerate id": {
+			Op: func(t *testing.T, store *Store, ids []string) {
+				req := httptest.NewRequest(http.MethodGet, "/", nil)
+				session := sessions.NewSession(store, "session")
+				session.ID = ids[0]
+				session.Options = store.newOptions()
+				session.Values["user_id"] = "bob"
+				if err := store.RegenerateID(context.TODO(), req, httptest.NewRecorder(), session); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		"save all": {
+			Op: func(t *testing.T, store *Store, ids []string) {
+				req := httptest.NewRequest(http.MethodGet, "/", nil)
+				for _, name := range []string{"flash", "prefs"} {
+					session, _ := store.Get(req, name)
+					session.Values["name"] = name
+				}
+				if err := store.SaveAll(req, httptest.NewRecorder()); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		"delete all for user": {
+			Op: func(t *testing.T, store *Store, ids []string) {
+				if err := store.DeleteAllForUser(context.TODO(), "bob"); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		"delete all for user except": {
+			Op: func(t *testing.T, store *Store, ids []string) {
+				if err := store.DeleteAllForUserExcept(context.TODO(), "bob", ids[1]); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		"erase user": {
+			Op: func(t *testing.T, store *Store, ids []string) {
+				if _, err := store.EraseUser(context.TODO(), "bob"); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+	}
+
+	for label, tc := range testCases {
+		t.Run(label, func(t *testing.T) {
+			ctx := context.TODO()
+
+			ddb := newFakeDynamoDB()
+			shadow := newFakeDynamoDB()
+			store, _ := New(ddb,
+				MaxAge(3600),
+				WithUserIndex("user-index", "user_id"),
+				WithShadowWrites(shadow, "sessions-v2", 10, func(err error) {
+					t.Errorf("unexpected shadow error: %v", err)
+				}),
+			)
+
+			ids := persistUserSessions(t, store, "bob", 3)
+			tc.Op(t, store, ids)
+
+			if err := store.Close(ctx); err != nil {
+				t.Fatal(err)
+			}
+
+			if mirrored := shadow.others["sessions-v2"]; !reflect.DeepEqual(mirrored, ddb.items) {
+				t.Errorf("expected the shadow table to match the primary;\n got %v\nwant %v", mirrored, ddb.items)
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/gorilla/sessions"
+)
+
+func TestItemSize(t *testing.T) {
+	item := map[string]types.AttributeValue{
+		"id":    &types.AttributeValueMemberS{Value: "abc"},
+		"ttl":   &types.AttributeValueMemberN{Value: "1700000000"},
+		"admin": &types.AttributeValueMemberBOOL{Value: true},
+		"tags": &types.AttributeValueMemberL{Value: []types.AttributeValue{
+			&types.AttributeValueMemberS{Value: "a"},
+		}},
+	}
+
+	// id: 2+3, ttl: 3+6, admin: 5+1, tags: 4+3+1+1
+	if got, want := itemSize(item), 29; got != want {
+		t.Errorf("expected %v; got %v", want, got)
+	}
+}
+
+func TestOnLargeSession(t *testing.T) {
+	var large []string
+	store, _ := New(newFakeDynamoDB(), MaxAge(3600), WithOnLargeSession(1024, func(id string, size int) {
+		if size <= 1024 {
+			t.Errorf("expected only sessions over the threshold; got %v bytes", size)
+		}
+		large = append(large, id)
+	}))
+
+	for id, blob := range map[string]int{"small": 10, "large": 2048} {
+		session := sessions.NewSession(store, "session")
+		session.ID = id
+		session.Options = store.newOptions()
+		session.Values["blob"] = strings.Repeat("x", blob)
+		if err := store.Persist(context.TODO(), "session", session); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(large) != 1 || large[0] != "large" {
+		t.Errorf("expected the large session to be reported; got %v", large)
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/gorilla/sessions"
+)
+
+var errServeStaleCacheRequired = fmt.Errorf("WithServeStale requires WithCache")
+
+// staleKey flags a session loaded from the cache because dynamodb failed
+type staleKey struct{}
+
+// Stale reports whether the session was served from the cache set up by
+// WithCache because reading it from dynamodb failed, see WithServeStale. Its
+// values may be behind those in the table.
+func Stale(session *sessions.Session) bool {
+	stale, _ := session.Values[staleKey{}].(bool)
+	return stale
+}
+
+// throttlingError reports whether err means dynamodb throttled the request
+func throttlingError(err error) bool {
+	var throughput *types.ProvisionedThroughputExceededException
+	var limit *types.RequestLimitExceeded
+	var coded interface{ ErrorCode() string }
+
+	switch {
+	case errors.As(err, &throughput), errors.As(err, &limit):
+		return true
+	case errors.As(err, &coded):
+		return coded.ErrorCode() == "ThrottlingException"
+	}
+
+	return false
+}
+
+// transientError reports whether err is a throttling, server side or network
+// error that is likely to go away on its own
+func transientError(err error) bool {
+	var internal *types.InternalServerError
+	var netErr net.Error
+	var coded interface{ ErrorCode() string }
+
+	switch {
+	case throttlingError(err),
+		errors.As(err, &internal),
+		errors.As(err, &netErr),
+		errors.Is(err, context.DeadlineExceeded):
+		return true
+	case errors.As(err, &coded):
+		switch coded.ErrorCode() {
+		case "ServiceUnavailable", "InternalFailure":
+			return true
+		}
+	}
+
+	return false
+}
+
+// staleItem returns the cached copy of the session identified by id when err,
+// the error reading it, is transient
+func (store *Store) staleItem(id string, err error) (*dynamodb.GetItemOutput, bool) {
+	if store.serveStale == nil || !transientError(err) {
+		return nil, false
+	}
+
+	item, ok := store.cache.stale(store.tableName, store.itemKey(id), *store.serveStale)
+	if !ok {
+		return nil, false
+	}
+
+	return &dynamodb.GetItemOutput{Item: item}, true
+}
+
+// stale returns the item cached for key in table even past the ttl of the cache,
+// unless maxAge is positive and the item was stored longer ago
+func (c *itemCache) stale(table, key string, maxAge time.Duration) (map[string]types.AttributeValue, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[bufferKey(table, key)]
+	if !ok {
+		return nil, false
+	}
+
+	entry := element.Value.(*cachedItem)
+	if maxAge > 0 && c.now().Sub(entry.stored) >= maxAge {
+		return nil, false
+	}
+
+	return entry.item, true
+}
@@ -0,0 +1,84 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/gorilla/sessions"
+)
+
+// throttledDynamoDB fails reads with err while set
+type throttledDynamoDB struct {
+	*fakeDynamoDB
+	err error
+}
+
+func (d *throttledDynamoDB) GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	if d.err != nil {
+		return nil, d.err
+	}
+	return d.fakeDynamoDB.GetItem(ctx, params, optFns...)
+}
+
+func TestServeStale(t *testing.T) {
+	ctx := context.TODO()
+
+	ddb := &throttledDynamoDB{fakeDynamoDB: newFakeDynamoDB()}
+	store, err := New(ddb, MaxAge(3600), WithCache(10, time.Second), WithServeStale(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	store.now = func() time.Time { return now }
+
+	persistUserSessions(t, store, "bob", 1)
+	now = now.Add(time.Minute)
+
+	ddb.err = &types.ProvisionedThroughputExceededException{Message: aws.String("slow down")}
+	session := sessions.NewSession(store, "session")
+	if err := store.Load(ctx, "bob-0", session); err != nil {
+		t.Fatal(err)
+	}
+	if !Stale(session) || session.Values["user_id"] != "bob" {
+		t.Errorf("expected the cached copy to be served as stale; got %v", session.Values)
+	}
+
+	ddb.err = fmt.Errorf("access denied")
+	if err := store.Load(ctx, "bob-0", sessions.NewSession(store, "session")); err == nil {
+		t.Error("expected errors that aren't transient to be returned")
+	}
+
+	ddb.err = &types.InternalServerError{}
+	now = now.Add(time.Hour)
+	if err := store.Load(ctx, "bob-0", sessions.NewSession(store, "session")); err == nil {
+		t.Error("expected copies older than the max age not to be served")
+	}
+
+	ddb.err = nil
+	session = sessions.NewSession(store, "session")
+	if err := store.Load(ctx, "bob-0", session); err != nil || Stale(session) {
+		t.Errorf("expected a fresh session once dynamodb recovers; got %v", err)
+	}
+
+	if _, err := New(ddb, WithServeStale(0)); err == nil {
+		t.Error("expected WithServeStale to require WithCache")
+	}
+}
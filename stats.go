@@ -0,0 +1,183 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// statsWindow is the number of most recent calls of an operation whose latency
+// makes up the percentiles reported by Stats
+const statsWindow = 1024
+
+// Stats is a snapshot of the counters kept by a store created with WithStats
+type Stats struct {
+	// Since is when the store started counting
+	Since time.Time
+
+	// Operations holds the stats of New, Load, Persist and Delete, by operation
+	Operations map[string]OperationStats
+
+	// Caches holds the lookups of the caches named LRUCacheName and
+	// RequestCacheName, by cache
+	Caches map[string]CacheStats
+}
+
+// OperationStats counts the calls of a store operation
+type OperationStats struct {
+	Count  int64
+	Errors int64
+
+	// P50 and P99 are latency percentiles over the most recent 1024 calls
+	P50 time.Duration
+	P99 time.Duration
+
+	// ConsumedCapacity is the total capacity units consumed, as reported by dynamodb
+	ConsumedCapacity float64
+}
+
+// CacheStats counts the lookups of a cache
+type CacheStats struct {
+	Hits   int64
+	Misses int64
+}
+
+// statsRecorder is the MetricsRecorder keeping the counters behind Stats
+type statsRecorder struct {
+	mu         sync.Mutex
+	since      time.Time
+	operations map[string]*operationStats
+	caches     map[string]CacheStats
+}
+
+// operationStats accumulates the calls of an operation, keeping the latency of
+// the last statsWindow of them in a ring
+type operationStats struct {
+	count     int64
+	errors    int64
+	capacity  float64
+	latencies []time.Duration
+	next      int
+}
+
+func newStatsRecorder() *statsRecorder {
+	return &statsRecorder{
+		operations: map[string]*operationStats{},
+		caches:     map[string]CacheStats{},
+	}
+}
+
+func (r *statsRecorder) ObserveOperation(m OperationMetrics) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	op, ok := r.operations[m.Operation]
+	if !ok {
+		op = &operationStats{}
+		r.operations[m.Operation] = op
+	}
+
+	op.count++
+	if m.Err != nil {
+		op.errors++
+	}
+	op.capacity += m.ConsumedCapacity
+
+	if len(op.latencies) < statsWindow {
+		op.latencies = append(op.latencies, m.Duration)
+	} else {
+		op.latencies[op.next] = m.Duration
+		op.next = (op.next + 1) % statsWindow
+	}
+}
+
+func (r *statsRecorder) ObserveCache(cache string, hit bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	c := r.caches[cache]
+	if hit {
+		c.Hits++
+	} else {
+		c.Misses++
+	}
+	r.caches[cache] = c
+}
+
+func (r *statsRecorder) snapshot() Stats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stats := Stats{
+		Since:      r.since,
+		Operations: make(map[string]OperationStats, len(r.operations)),
+		Caches:     make(map[string]CacheStats, len(r.caches)),
+	}
+
+	for name, op := range r.operations {
+		latencies := slices.Clone(op.latencies)
+		slices.Sort(latencies)
+		stats.Operations[name] = OperationStats{
+			Count:            op.count,
+			Errors:           op.errors,
+			P50:              percentile(latencies, 50),
+			P99:              percentile(latencies, 99),
+			ConsumedCapacity: op.capacity,
+		}
+	}
+	for name, c := range r.caches {
+		stats.Caches[name] = c
+	}
+
+	return stats
+}
+
+// percentile returns the p-th percentile of sorted, using the nearest rank
+func percentile(sorted []time.Duration, p int) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+
+	rank := (len(sorted)*p + 99) / 100
+	return sorted[max(rank, 1)-1]
+}
+
+// metricsRecorders reports to several recorders, so WithStats and WithMetrics
+// can be used together
+type metricsRecorders []MetricsRecorder
+
+func (rs metricsRecorders) ObserveOperation(m OperationMetrics) {
+	for _, r := range rs {
+		r.ObserveOperation(m)
+	}
+}
+
+func (rs metricsRecorders) ObserveCache(cache string, hit bool) {
+	for _, r := range rs {
+		r.ObserveCache(cache, hit)
+	}
+}
+
+// Stats returns a snapshot of the operation and cache counters of a store
+// created with WithStats, for surfacing session health on a debug endpoint. The
+// snapshot is empty otherwise.
+func (store *Store) Stats() Stats {
+	if store.stats == nil {
+		return Stats{}
+	}
+
+	return store.stats.snapshot()
+}
@@ -0,0 +1,77 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+)
+
+func TestStats(t *testing.T) {
+	ctx := context.TODO()
+
+	metrics := &recordingMetrics{hits: map[bool]int{}}
+	store, _ := New(newFakeDynamoDB(), MaxAge(3600), WithCache(10, time.Minute), WithStats(), WithMetrics(metrics))
+
+	clock := time.Unix(0, 0)
+	store.now = func() time.Time {
+		clock = clock.Add(time.Millisecond)
+		return clock
+	}
+
+	persistUserSessions(t, store, "bob", 2)
+	for _, id := range []string{"bob-0", "bob-1", "missing"} {
+		_ = store.Load(ctx, id, sessions.NewSession(store, "session"))
+	}
+
+	stats := store.Stats()
+	if load := stats.Operations["Load"]; load.Count != 3 || load.Errors != 1 {
+		t.Errorf("expected 3 loads and 1 error; got %+v", load)
+	} else if load.P50 <= 0 || load.P99 < load.P50 {
+		t.Errorf("expected load latency percentiles; got %+v", load)
+	}
+	if persist := stats.Operations["Persist"]; persist.Count != 2 || persist.Errors != 0 {
+		t.Errorf("expected 2 persists; got %+v", persist)
+	}
+	if lru := stats.Caches[LRUCacheName]; lru.Hits != 2 || lru.Misses != 1 {
+		t.Errorf("expected 2 hits and 1 miss; got %+v", lru)
+	}
+	if len(metrics.operations) != 5 {
+		t.Errorf("expected operations to reach WithMetrics too; got %v", len(metrics.operations))
+	}
+
+	if stats := (&Store{}).Stats(); stats.Operations != nil {
+		t.Errorf("expected empty stats without WithStats; got %+v", stats)
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	latencies := make([]time.Duration, 100)
+	for i := range latencies {
+		latencies[i] = time.Duration(i+1) * time.Millisecond
+	}
+
+	if got := percentile(latencies, 50); got != 50*time.Millisecond {
+		t.Errorf("expected p50 of 50ms; got %v", got)
+	}
+	if got := percentile(latencies, 99); got != 99*time.Millisecond {
+		t.Errorf("expected p99 of 99ms; got %v", got)
+	}
+	if got := percentile(nil, 99); got != 0 {
+		t.Errorf("expected no percentile without samples; got %v", got)
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"time"
+
+	"github.com/gorilla/sessions"
+)
+
+// stepUpPrefix namespaces the session values holding step-up markers
+const stepUpPrefix = "step_up:"
+
+// MarkStepUp records that the step-up check identified by name, e.g. "mfa", was
+// satisfied now and remains valid for validFor, independently of the lifetime of
+// the session. The marker is stored as a value with its own deadline (see
+// SetValueExpiry), so it is dropped on Load once expired no matter what the
+// client sends.
+func (store *Store) MarkStepUp(session *sessions.Session, name string, validFor time.Duration) {
+	now := store.now()
+	key := stepUpPrefix + name
+
+	session.Values[key] = now.Unix()
+	SetValueExpiry(session, key, now.Add(validFor))
+}
+
+// StepUp reports when the step-up check identified by name was satisfied, if its
+// marker is present and hasn't expired yet
+func (store *Store) StepUp(session *sessions.Session, name string) (time.Time, bool) {
+	key := stepUpPrefix + name
+
+	deadline, ok := ValueExpiry(session, key)
+	if !ok || !store.now().Before(deadline) {
+		return time.Time{}, false
+	}
+
+	// int64 until the session is saved, float64 once loaded back from dynamodb
+	switch satisfiedAt := session.Values[key].(type) {
+	case int64:
+		return time.Unix(satisfiedAt, 0), true
+	case float64:
+		return time.Unix(int64(satisfiedAt), 0), true
+	}
+
+	return time.Time{}, false
+}
+
+// ClearStepUp removes the step-up marker identified by name, e.g. on logout or
+// when the factor it attests to is removed
+func ClearStepUp(session *sessions.Session, name string) {
+	key := stepUpPrefix + name
+
+	delete(session.Values, key)
+	delete(valueExpiries(session), key)
+}
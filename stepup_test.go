@@ -0,0 +1,71 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+)
+
+func TestStepUp(t *testing.T) {
+	ctx := context.TODO()
+
+	now := time.Unix(1700000000, 0)
+	store, _ := New(newFakeDynamoDB(), TTLEnabled(), MaxAge(3600), WithClock(func() time.Time { return now }))
+
+	session := sessions.NewSession(store, "session")
+	session.ID = "abc"
+	session.Options = store.newOptions()
+
+	if _, ok := store.StepUp(session, "mfa"); ok {
+		t.Fatal("expected no step-up marker")
+	}
+
+	store.MarkStepUp(session, "mfa", 15*time.Minute)
+	if err := store.Persist(ctx, session.Name(), session); err != nil {
+		t.Fatal(err)
+	}
+
+	now = now.Add(10 * time.Minute)
+	loaded := sessions.NewSession(store, "session")
+	if err := store.Load(ctx, "abc", loaded); err != nil {
+		t.Fatal(err)
+	}
+	satisfiedAt, ok := store.StepUp(loaded, "mfa")
+	if !ok || !satisfiedAt.Equal(time.Unix(1700000000, 0)) {
+		t.Errorf("expected the marker to be valid; got %v, %v", satisfiedAt, ok)
+	}
+
+	now = now.Add(10 * time.Minute)
+	if _, ok := store.StepUp(loaded, "mfa"); ok {
+		t.Error("expected the marker to have expired")
+	}
+
+	loaded = sessions.NewSession(store, "session")
+	if err := store.Load(ctx, "abc", loaded); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := loaded.Values[stepUpPrefix+"mfa"]; ok {
+		t.Error("expected the expired marker to be stripped on Load")
+	}
+
+	store.MarkStepUp(loaded, "mfa", time.Minute)
+	ClearStepUp(loaded, "mfa")
+	if _, ok := store.StepUp(loaded, "mfa"); ok {
+		t.Error("expected the marker to be cleared")
+	}
+}
@@ -15,9 +15,16 @@ package dynastore
 
 import (
 	"context"
-	"encoding/base32"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"hash"
+	"log/slog"
+	"math/rand/v2"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -38,133 +45,632 @@ const (
 
 	// DefaultTTLField contains the default name of the ttl field
 	DefaultTTLField = "ttl"
+
+	// MaxAgeField contains the name of the field holding a per-session MaxAge
+	// that differs from the store default
+	MaxAgeField = "max_age"
+
+	// ExpiresAtField contains the name of the optional ISO-8601 expiry field
+	ExpiresAtField = "expires_at"
 )
 
 var (
-	errStateNotFound = fmt.Errorf("state missing or deleted from store")
+	// ErrSessionExpired is returned by Load when the stored ttl has passed but
+	// dynamodb has not yet deleted the item
+	ErrSessionExpired = fmt.Errorf("session has expired")
 )
 
+// DynamoDBClient is the subset of the dynamodb API used by Store. It is satisfied by
+// *dynamodb.Client and allows a fake to be supplied in tests.
+type DynamoDBClient interface {
+	GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error)
+	PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
+	UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error)
+	DeleteItem(ctx context.Context, params *dynamodb.DeleteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error)
+	BatchWriteItem(ctx context.Context, params *dynamodb.BatchWriteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchWriteItemOutput, error)
+	Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error)
+	Scan(ctx context.Context, params *dynamodb.ScanInput, optFns ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error)
+	TransactWriteItems(ctx context.Context, params *dynamodb.TransactWriteItemsInput, optFns ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error)
+	CreateTable(ctx context.Context, params *dynamodb.CreateTableInput, optFns ...func(*dynamodb.Options)) (*dynamodb.CreateTableOutput, error)
+	DescribeTable(ctx context.Context, params *dynamodb.DescribeTableInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DescribeTableOutput, error)
+	DescribeTimeToLive(ctx context.Context, params *dynamodb.DescribeTimeToLiveInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DescribeTimeToLiveOutput, error)
+	UpdateTimeToLive(ctx context.Context, params *dynamodb.UpdateTimeToLiveInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateTimeToLiveOutput, error)
+}
+
 // Store provides an implementation of the gorilla sessions.Store interface backed by DynamoDB
 type Store struct {
 	tableName      string
 	primaryKey     string
 	refreshCookies bool
 	enableTTL      bool
+	serverTTL      time.Duration
+	ttlGrace       time.Duration
+	minTTL         time.Duration
+	maxTTL         time.Duration
+	ttlJitter      time.Duration
+	writeExpiresAt bool
+	autoEnableTTL  bool
+	userIndex      string
+	userKey        string
 
-	ddb     *dynamodb.Client
-	options sessions.Options
+	ddb                    DynamoDBClient
+	codecs                 []securecookie.Codec
+	signingKey             []byte
+	hashIDs                bool
+	hashKey                []byte
+	encryptionKey          []byte
+	previousSigningKeys    [][]byte
+	previousEncryptionKeys [][]byte
+	keys                   KeyProvider
+	encryptedFields        []string
+	rotationPredicates     []RotationPredicate
+	maxSessions            int
+	sessionLimitPolicy     SessionLimitPolicy
+	revocationTable        string
+	ipBinding              *ipBinding
+	clientIPFunc           func(*http.Request) string
+	deviceBinding          *deviceBinding
+	rememberMe             *rememberMe
+	requireSigning         bool
+	invalidIDHandler       func(req *http.Request, value string, err error)
+	lastSeenInterval       time.Duration
+	locationResolver       func(ip string) string
+	bearerHeader           string
+	readOnly               bool
+	inMemory               bool
+	idGenerator            func() string
+	idFormat               idFormat
+	memory                 *memoryTable
+	namespace              string
+	hasNamespace           bool
+	lifecycle              *lifecycle
+	schema                 Schema
+	fallback               *cookieFallback
+	failover               *failoverClient
+	regionPinning          *regionPinning
+	lastWriterWins         *lastWriterWins
+	shadow                 *shadowWriter
+	writeBehind            *writeBehind
+	cache                  *itemCache
+	serveStale             *time.Duration
+	touches                *touchCoalescer
+	tracer                 Tracer
+	metrics                MetricsRecorder
+	largeSession           *largeSession
+	stats                  *statsRecorder
+	logger                 *slog.Logger
+	logRawIDs              bool
+	hooks                  hooks
+	events                 *eventQueue
+	audit                  *auditLog
+	legacy                 *legacyTable
+	legacyFormat           *legacyFormat
+	signIDs                bool
+	sealer                 sealer
+	kms                    *kmsEnvelope
+	kmsCache               *dataKeyCache
+	options                sessions.Options
+	now                    func() time.Time
 }
 
 // New instantiates a new Store that implements gorilla's sessions.Store interface
-func New(client *dynamodb.Client, opts ...Option) (*Store, error) {
+func New(client DynamoDBClient, opts ...Option) (*Store, error) {
+	return newStore(client, false, opts...)
+}
+
+// NewWithOptions is New, but first checks the options together and fails with
+// an error wrapping ErrInvalidOptions, listing every problem found, when they
+// can't work as intended: an empty table name, a ttl that expires sessions as
+// soon as they are written, or modes that cancel each other out.
+func NewWithOptions(client DynamoDBClient, opts ...Option) (*Store, error) {
+	return newStore(client, true, opts...)
+}
+
+func newStore(client DynamoDBClient, strict bool, opts ...Option) (*Store, error) {
 	store := &Store{
 		ddb:        client,
 		tableName:  DefaultTableName,
 		primaryKey: DefaultPrimaryKey,
+		lifecycle:  &lifecycle{},
+		now:        time.Now,
+		idFormat:   defaultIDFormat,
 	}
 
 	for _, opt := range opts {
 		opt(store)
 	}
 
+	if strict {
+		if err := store.checkOptions(); err != nil {
+			return nil, err
+		}
+	}
+
+	if store.inMemory {
+		store.memory = newMemoryTable(store.primaryKey, func() time.Time { return store.now() })
+		store.ddb = store.memory.db
+	}
+
+	if store.failover != nil {
+		store.failover.primary = store.ddb
+		store.failover.primaryTable = store.tableName
+		store.failover.now = func() time.Time { return store.now() }
+		store.ddb = store.failover
+	}
+
+	if store.shadow != nil {
+		store.shadow.source = store.ddb
+		store.shadow.sourceTable = store.tableName
+		store.ddb = shadowingClient{DynamoDBClient: store.ddb, shadow: store.shadow, table: store.tableName, primaryKey: store.primaryKey}
+	}
+
+	if store.hasNamespace && !validNamespace(store.namespace) {
+		return nil, errInvalidNamespace
+	}
+
+	if store.serveStale != nil && store.cache == nil {
+		return nil, errServeStaleCacheRequired
+	}
+
+	if store.idFormat != defaultIDFormat {
+		if store.idGenerator != nil {
+			return nil, errIDFormatWithGenerator
+		}
+		if err := store.idFormat.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	if store.stats != nil {
+		store.stats.since = store.now()
+		if store.metrics != nil {
+			store.metrics = metricsRecorders{store.metrics, store.stats}
+		} else {
+			store.metrics = store.stats
+		}
+	}
+
+	if store.tracer != nil || store.metrics != nil || store.logger != nil {
+		store.ddb = tracingClient{DynamoDBClient: store.ddb}
+	}
+
+	store.ddb = classifyingClient{DynamoDBClient: store.ddb}
+
+	if store.cache != nil {
+		store.cache.now = func() time.Time { return store.now() }
+		store.ddb = cachingClient{DynamoDBClient: store.ddb, cache: store.cache, primaryKey: store.primaryKey}
+	}
+
+	// outside the cache, so dropped writes never reach it
+	if store.readOnly {
+		store.ddb = readOnlyClient{DynamoDBClient: store.ddb}
+	}
+
+	if store.memory != nil {
+		store.memory.start()
+		store.onClose(store.memory.close)
+	}
+
+	if store.shadow != nil {
+		store.shadow.start()
+		store.onClose(store.shadow.close)
+	}
+
+	if store.events != nil {
+		store.events.start()
+		store.onClose(store.events.close)
+	}
+
+	if store.touches != nil {
+		store.touches.start()
+		store.onClose(store.touches.close)
+	}
+
+	if store.writeBehind != nil {
+		store.writeBehind.start()
+		store.onClose(store.writeBehind.close)
+	}
+
+	if store.keys == nil {
+		store.keys = staticKeys{
+			SigningKey:             store.signingKey,
+			PreviousSigningKeys:    store.previousSigningKeys,
+			EncryptionKey:          store.encryptionKey,
+			PreviousEncryptionKeys: store.previousEncryptionKeys,
+		}
+	}
+
+	keys, err := store.keys.Keys(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	store.signIDs = len(keys.SigningKey) > 0
+	if store.requireSigning && !store.signIDs {
+		return nil, errSigningKeyRequired
+	}
+
+	if len(keys.EncryptionKey) > 0 {
+		if _, err := newAESGCM(keys.EncryptionKey); err != nil {
+			return nil, err
+		}
+		store.sealer = &keyProviderSealer{provider: store.keys}
+	}
+
+	if store.kms != nil {
+		store.kms.table = store.tableName
+		if store.kmsCache != nil {
+			store.kmsCache.now = store.now
+			store.kms.cache = store.kmsCache
+		}
+		store.sealer = store.kms
+	}
+
+	if len(store.encryptedFields) > 0 && store.sealer == nil {
+		return nil, errEncryptedFieldsWithoutKey
+	}
+
+	if store.rememberMe != nil {
+		if err := validateCookie(store.rememberMe.cookieName, &store.options); err != nil {
+			return nil, err
+		}
+	}
+
+	if store.autoEnableTTL {
+		if err := store.EnsureTTL(context.Background()); err != nil {
+			return nil, err
+		}
+	}
+
 	return store, nil
 }
 
+// MaxAge sets the default MaxAge of new sessions, mirroring the method of the
+// same name on gorilla's CookieStore and FilesystemStore.
+func (store *Store) MaxAge(age int) {
+	store.options.MaxAge = age
+
+	for _, codec := range store.codecs {
+		if sc, ok := codec.(*securecookie.SecureCookie); ok {
+			sc.MaxAge(age)
+		}
+	}
+}
+
+// Options replaces the default options of new sessions
+func (store *Store) Options(opts sessions.Options) {
+	store.options = opts
+}
+
 // Get should return a cached session.
 func (store *Store) Get(req *http.Request, name string) (*sessions.Session, error) {
-	return sessions.GetRegistry(req).Get(store, name)
+	session, err := sessions.GetRegistry(req).Get(store, name)
+	requestTracker(req).track(session)
+
+	return session, err
 }
 
 // New should create and return a new session.
 //
 // Note that New should never return a nil session, even in the case of
 // an error if using the Registry infrastructure to cache the session.
+//
+// When the request presents a session that cannot be used, New returns a new
+// session alongside an error. The error wraps ErrSessionNotFound when the
+// session is missing, expired, revoked, malformed or bound to another client,
+// so a stale cookie can be told apart from a failure to reach dynamodb.
 func (store *Store) New(req *http.Request, name string) (*sessions.Session, error) {
-	if cookie, errCookie := req.Cookie(name); errCookie == nil {
+	ctx, span := store.startSpan(req.Context(), "New")
+	if span != nil {
+		req = req.WithContext(ctx)
+	}
+
+	s, err := store.newSession(req, name)
+	span.end(err)
+
+	return s, err
+}
+
+// newSession loads the session presented by req or creates a new one
+func (store *Store) newSession(req *http.Request, name string) (*sessions.Session, error) {
+	store = store.scoped(req.Context())
+
+	if store.fallbackEnabled() {
+		if s, ok := store.loadFallback(req, name); ok {
+			store.debug(req.Context(), "serving session from fallback cookie", store.logID(s.ID))
+			return s, nil
+		}
+	}
+
+	var loadErr error
+	if value, ok := store.presentedValue(req, name); ok {
 		s := sessions.NewSession(store, name)
-		err := store.Load(req.Context(), cookie.Value, s)
+		s.Options = store.newOptions()
+		id, err := store.cookieID(req.Context(), name, value)
+		if err != nil {
+			store.reportInvalidID(req, value, err)
+		}
+		if err == nil {
+			err = store.Load(req.Context(), id, s)
+		}
+		if err == nil {
+			err = store.verifyClient(req, s)
+		}
 		if err == nil {
 			return s, nil
 		}
+		loadErr = sessionLoadError(err)
 	}
 
 	s := sessions.NewSession(store, name)
-	s.ID = strings.TrimRight(base32.StdEncoding.EncodeToString(securecookie.GenerateRandomKey(32)), "=")
+	s.ID = store.newID()
 	s.IsNew = true
-	s.Options = &sessions.Options{
-		Path:     store.options.Path,
-		Domain:   store.options.Domain,
-		MaxAge:   store.options.MaxAge,
-		Secure:   store.options.Secure,
-		HttpOnly: store.options.HttpOnly,
-	}
+	s.Options = store.newOptions()
+
+	return s, loadErr
+}
 
-	return s, nil
+// sessionLoadError wraps err, the reason a presented session could not be
+// loaded, with ErrSessionNotFound when the session is simply unusable
+func sessionLoadError(err error) error {
+	switch {
+	case errors.Is(err, ErrSessionNotFound):
+		return err
+	case errors.Is(err, ErrSessionExpired),
+		errors.Is(err, ErrSessionRevoked),
+		errors.Is(err, ErrInvalidSessionID),
+		errors.Is(err, errInvalidSignature),
+		errors.Is(err, ErrClientMismatch):
+		return fmt.Errorf("%w: %w", ErrSessionNotFound, err)
+	default:
+		return fmt.Errorf("failed to load session: %w", err)
+	}
 }
 
 // Save should persist session to the underlying store implementation.
 func (store *Store) Save(req *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	store = store.scoped(req.Context())
+
+	store.bindClient(req, session)
+	store.captureOrigin(req, session)
+
+	if (session.Options == nil || session.Options.MaxAge >= 0) && store.shouldRotate(session) {
+		return store.RegenerateID(req.Context(), req, w, session)
+	}
+
 	err := store.Persist(req.Context(), session.Name(), session)
 	if err != nil {
+		if store.fallbackEnabled() && storeOutage(err) {
+			store.debug(req.Context(), "saving session to fallback cookie", store.logID(session.ID), slog.Any("error", err))
+			return store.saveFallback(w, session, err)
+		}
 		return err
 	}
 
+	if store.fallbackEnabled() {
+		if err := store.clearFallback(req, w, session); err != nil {
+			return err
+		}
+	}
+
 	if session.Options != nil && session.Options.MaxAge < 0 {
-		cookie := newCookie(session, session.Name(), "")
-		http.SetCookie(w, cookie)
+		if store.bearerHeader == "" {
+			cookie := newCookie(session.Options, session.Name(), "")
+			http.SetCookie(w, cookie)
+		}
 		return store.Delete(req.Context(), session.ID)
 	}
 
 	if store.canSetCookie(session) {
-		cookie := newCookie(session, session.Name(), session.ID)
-		http.SetCookie(w, cookie)
+		return store.setCookie(req.Context(), w, session)
+	}
+
+	return nil
+}
+
+// setCookie writes the cookie carrying the session id to w
+func (store *Store) setCookie(ctx context.Context, w http.ResponseWriter, session *sessions.Session) error {
+	if err := validateCookie(session.Name(), session.Options); err != nil {
+		return err
 	}
 
+	value, err := store.encodeCookie(ctx, session.Name(), session.ID)
+	if err != nil {
+		return err
+	}
+
+	cookie := newCookie(session.Options, session.Name(), value)
+	http.SetCookie(w, cookie)
+
 	return nil
 }
 
+// newOptions returns a copy of the default session options
+func (store *Store) newOptions() *sessions.Options {
+	return &sessions.Options{
+		Path:        store.options.Path,
+		Domain:      store.options.Domain,
+		MaxAge:      store.options.MaxAge,
+		Secure:      store.options.Secure,
+		HttpOnly:    store.options.HttpOnly,
+		SameSite:    store.options.SameSite,
+		Partitioned: store.options.Partitioned,
+	}
+}
+
+// canSetCookie reports whether saving session sends its cookie: when it is new,
+// when cookies are refreshed on every save, or when its MaxAge was changed since
+// it was loaded, so the cookie expires along with the item
 func (store *Store) canSetCookie(session *sessions.Session) bool {
-	return session.IsNew || store.refreshCookies
+	loaded, ok := session.Values[loadedMaxAgeKey{}].(int)
+	maxAgeChanged := ok && loaded != store.sessionMaxAge(session)
+
+	return store.bearerHeader == "" && (session.IsNew || store.refreshCookies || maxAgeChanged)
 }
 
-func newCookie(session *sessions.Session, name, value string) *http.Cookie {
+func newCookie(opts *sessions.Options, name, value string) *http.Cookie {
 	cookie := &http.Cookie{
 		Name:  name,
 		Value: value,
 	}
 
-	if opts := session.Options; opts != nil {
+	if opts != nil {
 		cookie.Path = opts.Path
 		cookie.Domain = opts.Domain
 		cookie.MaxAge = opts.MaxAge
 		cookie.HttpOnly = opts.HttpOnly
 		cookie.Secure = opts.Secure
+		cookie.SameSite = opts.SameSite
+		cookie.Partitioned = opts.Partitioned
 	}
 
+	applyCookiePrefix(cookie)
+
 	return cookie
 }
 
+// Persist writes session to the table without setting a cookie
 func (store *Store) Persist(ctx context.Context, name string, session *sessions.Session) error {
+	ctx, span := store.startSpan(ctx, "Persist")
+	err := store.persist(ctx, session)
+	if err == nil {
+		store.scoped(ctx).publishSaved(session)
+		err = store.scoped(ctx).auditPersist(ctx, session)
+	}
+	span.end(err)
+
+	return err
+}
+
+func (store *Store) persist(ctx context.Context, session *sessions.Session) error {
+	store = store.scoped(ctx)
+
+	items, err := store.prepareSave(ctx, session, "")
+	if err != nil {
+		return err
+	}
+
+	items, written, err := store.putUnbatched(ctx, session, items)
+	if err == nil && !written {
+		_, err = store.ddb.PutItem(ctx, &dynamodb.PutItemInput{
+			TableName:                aws.String(store.tableName),
+			Item:                     items,
+			ConditionExpression:      aws.String(notRevokedCondition),
+			ExpressionAttributeNames: map[string]string{"#revoked": RevokedField},
+		})
+		var ccf *types.ConditionalCheckFailedException
+		if errors.As(err, &ccf) {
+			err = ErrSessionRevoked
+		}
+	}
+
+	if err == nil {
+		requestCache(ctx).put(store.tableName, itemID(store, items), items)
+	}
+
+	return err
+}
+
+// prepareSave runs the BeforeSave hooks on session and converts it into the
+// item to write, enforcing the session limit, with the item stored under
+// replacing, if any, not counted. Every write of a session goes through it,
+// whether saved alone, in a batch or under a new id.
+func (store *Store) prepareSave(ctx context.Context, session *sessions.Session, replacing string) (map[string]types.AttributeValue, error) {
+	if err := runSessionHooks(ctx, store.hooks.beforeSave, session); err != nil {
+		return nil, err
+	}
+
+	items, err := store.marshalSession(ctx, session)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := store.enforceSessionLimit(ctx, session, replacing); err != nil {
+		return nil, err
+	}
+
+	return items, nil
+}
+
+// putUnbatched writes items the ways that can't be batched: with the conditional
+// put of WithLastWriterWins, or into the buffer of WithWriteBehind. It returns
+// false, leaving items for the caller to put, when neither applies.
+func (store *Store) putUnbatched(ctx context.Context, session *sessions.Session, items map[string]types.AttributeValue) (map[string]types.AttributeValue, bool, error) {
+	switch {
+	case store.lastWriterWins != nil:
+		items, err := store.putLastWriterWins(ctx, session, items)
+		return items, true, err
+	case store.writeBehind != nil && store.writeBehind.enqueue(store, items):
+		return items, true, nil
+	}
+
+	return items, false, nil
+}
+
+// marshalSession converts a session into the dynamodb item written by Persist
+func (store *Store) marshalSession(ctx context.Context, session *sessions.Session) (map[string]types.AttributeValue, error) {
+	if store.schema != nil {
+		if err := store.schema.validate(convertToMapStringAny(session.Values)); err != nil {
+			return nil, err
+		}
+	}
 
 	session.Values[store.primaryKey] = session.ID
+	store.logDroppedKeys(ctx, session)
 
-	v := convertToMapStringAny(session.Values)
+	deadlines := expireValues(session, store.now())
+
+	v, err := store.sealValues(ctx, session.ID, convertToMapStringAny(session.Values))
+	if err != nil {
+		return nil, err
+	}
+
+	maxAge := store.sessionMaxAge(session)
+	if maxAge != store.options.MaxAge {
+		v[MaxAgeField] = maxAge
+	}
 
 	if store.enableTTL {
-		v[DefaultTTLField] = time.Now().Add(time.Second * time.Duration(store.options.MaxAge))
+		expiresAt := store.expiry(maxAge)
+		v[DefaultTTLField] = expiresAt.Unix()
+		if store.writeExpiresAt {
+			v[ExpiresAtField] = expiresAt.UTC().Format(time.RFC3339)
+		}
+	}
+
+	if len(deadlines) > 0 {
+		v[ValueExpiryField] = deadlines
+	}
+
+	marshalBinding(session, v)
+	store.marshalMeta(session, v)
+
+	if store.namespace != "" {
+		v[NamespaceField] = store.namespace
+	}
+
+	if store.regionPinning != nil {
+		v[RegionField] = store.regionPinning.region
+	}
+
+	if store.lastWriterWins != nil {
+		v[UpdatedAtField] = store.updatedAt(session)
 	}
 
+	v[store.primaryKey] = store.itemKey(session.ID)
+
 	items, err := av.MarshalMap(v)
 	if err != nil {
-		return fmt.Errorf("failed marshall session for dynamodb: %w", err)
+		return nil, fmt.Errorf("failed marshall session for dynamodb: %w", err)
 	}
 
-	_, err = store.ddb.PutItem(ctx, &dynamodb.PutItemInput{
-		TableName: aws.String(store.tableName),
-		Item:      items,
-	})
+	size := itemSize(items)
+	if size > maxItemSize {
+		return nil, fmt.Errorf("%w: %d bytes", ErrSessionTooLarge, size)
+	}
+	store.largeSession.check(session.ID, size)
 
-	return err
+	return items, nil
 }
 
 func convertToMapStringAny(in map[any]any) map[string]any {
@@ -180,51 +686,432 @@ func convertToMapStringAny(in map[any]any) map[string]any {
 	return out
 }
 
+// Delete removes the session identified by id from the table
 func (store *Store) Delete(ctx context.Context, id string) error {
+	ctx, span := store.startSpan(ctx, "Delete")
+	err := store.delete(ctx, id)
+	if err == nil {
+		err = store.scoped(ctx).deleted(ctx, id, "")
+	}
+	span.end(err)
+
+	return err
+}
+
+func (store *Store) delete(ctx context.Context, id string) error {
+	store = store.scoped(ctx)
+
+	store.discardPending(ctx, store.itemKey(id))
 
 	_, err := store.ddb.DeleteItem(ctx, &dynamodb.DeleteItemInput{
 		TableName: aws.String(store.tableName),
-		Key: map[string]types.AttributeValue{
-			store.primaryKey: &types.AttributeValueMemberS{Value: id},
-		},
+		Key:       store.key(id),
 	})
 
 	return err
 }
 
-// load loads a session data from the database.
-// True is returned if there is a session data in the database.
-func (store *Store) Load(ctx context.Context, value string, session *sessions.Session) error {
+// discardPending drops the copies of the item stored under key that a delete
+// must not leave behind: the one of the load cache and the buffered write of
+// WithWriteBehind. Every delete of a session goes through it, whether deleted
+// alone or in a batch.
+func (store *Store) discardPending(ctx context.Context, key string) {
+	requestCache(ctx).invalidate(store.tableName, key)
+
+	if store.writeBehind != nil {
+		store.writeBehind.discard(store.tableName, key)
+	}
+}
+
+// deleted publishes the SessionDestroyed event, records the delete in the audit
+// log and runs the OnDelete hooks once the session identified by id, of user if
+// known, has been deleted, whether alone or in a batch
+func (store *Store) deleted(ctx context.Context, id, user string) error {
+	store.publish(SessionDestroyed, id, user)
+
+	return errors.Join(store.auditDelete(ctx, id, user), runDeleteHooks(ctx, store.hooks.onDelete, id))
+}
+
+// key returns the dynamodb key of the item holding the session identified by id
+func (store *Store) key(id string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		store.primaryKey: &types.AttributeValueMemberS{Value: store.itemKey(id)},
+	}
+}
+
+// itemKey returns the primary key value under which the session identified by id
+// is stored. When WithHashedIDs is set only a digest of the id is stored, and
+// WithNamespace prefixes it with the namespace.
+func (store *Store) itemKey(id string) string {
+	if !store.hashIDs {
+		return store.namespaced(id)
+	}
+
+	var mac hash.Hash
+	if store.hashKey != nil {
+		mac = hmac.New(sha256.New, store.hashKey)
+	} else {
+		mac = sha256.New()
+	}
+	mac.Write([]byte(id))
+
+	return store.namespaced(hex.EncodeToString(mac.Sum(nil)))
+}
+
+// Touch extends the lifetime of the session identified by id by rewriting only
+// its ttl attribute. The session payload is left untouched, making Touch suitable
+// for keep-alive endpoints and background jobs. A MaxAge persisted for the session
+// is honoured. ErrSessionNotFound is returned if the session does not exist. With
+// WithTouchCoalescing, the refresh is queued instead and nil is returned.
+func (store *Store) Touch(ctx context.Context, id string) error {
+	store = store.scoped(ctx)
+
+	if store.touches != nil {
+		store.touches.add(store, id)
+		return nil
+	}
 
+	err := store.touch(ctx, id)
+	if err == nil {
+		store.publish(SessionRenewed, id, "")
+	}
+
+	return err
+}
+
+// touch rewrites the ttl attribute of the session identified by id right away
+func (store *Store) touch(ctx context.Context, id string) error {
 	result, err := store.ddb.GetItem(ctx, &dynamodb.GetItemInput{
-		TableName: aws.String(store.tableName),
-		Key: map[string]types.AttributeValue{
-			store.primaryKey: &types.AttributeValueMemberS{Value: value},
-		},
+		TableName:                aws.String(store.tableName),
+		Key:                      store.key(id),
+		ProjectionExpression:     aws.String("#pk, #maxAge"),
+		ExpressionAttributeNames: map[string]string{"#pk": store.primaryKey, "#maxAge": MaxAgeField},
+	})
+	if err != nil {
+		return err
+	}
+
+	if result.Item == nil {
+		return ErrSessionNotFound
+	}
+
+	maxAge := store.options.MaxAge
+	if n, ok := result.Item[MaxAgeField].(*types.AttributeValueMemberN); ok {
+		if parsed, err := strconv.Atoi(n.Value); err == nil {
+			maxAge = parsed
+		}
+	}
+
+	update, names, values := store.ttlUpdate(store.expiry(maxAge))
+
+	_, err = store.ddb.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:                 aws.String(store.tableName),
+		Key:                       store.key(id),
+		ConditionExpression:       aws.String("attribute_exists(#pk)"),
+		UpdateExpression:          aws.String(update),
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: values,
 	})
 
+	var ccf *types.ConditionalCheckFailedException
+	if errors.As(err, &ccf) {
+		return ErrSessionNotFound
+	}
+
+	return err
+}
+
+// ttlUpdate returns the update expression setting the ttl attributes of a
+// session to expiresAt, with its attribute names, which include #pk, and values
+func (store *Store) ttlUpdate(expiresAt time.Time) (string, map[string]string, map[string]types.AttributeValue) {
+	update := "SET #ttl = :ttl"
+	names := map[string]string{
+		"#pk":  store.primaryKey,
+		"#ttl": DefaultTTLField,
+	}
+	values := map[string]types.AttributeValue{
+		":ttl": &types.AttributeValueMemberN{Value: strconv.FormatInt(expiresAt.Unix(), 10)},
+	}
+
+	if store.writeExpiresAt {
+		update += ", #expiresAt = :expiresAt"
+		names["#expiresAt"] = ExpiresAtField
+		values[":expiresAt"] = &types.AttributeValueMemberS{Value: expiresAt.UTC().Format(time.RFC3339)}
+	}
+
+	return update, names, values
+}
+
+// loadedMaxAgeKey is the session.Values key holding the MaxAge the session had
+// when it was loaded, so changing it re-sends the cookie
+type loadedMaxAgeKey struct{}
+
+// sessionMaxAge returns the MaxAge of the session, falling back to the store default
+func (store *Store) sessionMaxAge(session *sessions.Session) int {
+	if session.Options != nil {
+		return session.Options.MaxAge
+	}
+
+	return store.options.MaxAge
+}
+
+// expiry returns the point in time at which a session with the given MaxAge written
+// now should expire. A server ttl configured with WithServerTTL takes precedence over
+// the default MaxAge, but not over a MaxAge explicitly set on the session.
+// The lifetime is clamped to the configured min/max ttl before any jitter is
+// applied, so sessions clamped to the max ttl are still spread out: jitter that
+// would overshoot the max is subtracted instead. The ttl grace is not included;
+// it is applied once, when Load evaluates expiry.
+func (store *Store) expiry(maxAge int) time.Time {
+	lifetime := time.Second * time.Duration(maxAge)
+	if store.serverTTL > 0 && maxAge == store.options.MaxAge {
+		lifetime = store.serverTTL
+	}
+
+	lifetime = store.clampTTL(lifetime)
+
+	if store.ttlJitter > 0 {
+		jitter := rand.N(store.ttlJitter)
+		if store.maxTTL > 0 && lifetime+jitter > store.maxTTL {
+			jitter = -jitter
+		}
+		lifetime = store.clampTTL(lifetime + jitter)
+	}
+
+	return store.now().Add(lifetime)
+}
+
+// clampTTL bounds lifetime by the min and max ttl, when set
+func (store *Store) clampTTL(lifetime time.Duration) time.Duration {
+	if store.minTTL > 0 && lifetime < store.minTTL {
+		lifetime = store.minTTL
+	}
+
+	if store.maxTTL > 0 && lifetime > store.maxTTL {
+		lifetime = store.maxTTL
+	}
+
+	return lifetime
+}
+
+// getItem reads the item of the session identified by id, from the cache of the
+// request, the write-behind buffer if it hasn't been written to the table yet, or
+// from the cache set up by WithCache
+func (store *Store) getItem(ctx context.Context, id string) (*dynamodb.GetItemOutput, error) {
+	key := store.itemKey(id)
+
+	if cache := requestCache(ctx); cache != nil {
+		item, ok := cache.get(store.tableName, key)
+		store.observeCache(RequestCacheName, ok)
+		if ok {
+			return &dynamodb.GetItemOutput{Item: item}, nil
+		}
+	}
+
+	if store.writeBehind != nil {
+		if item := store.writeBehind.lookup(store.tableName, key); item != nil {
+			return &dynamodb.GetItemOutput{Item: item}, nil
+		}
+	}
+
+	if store.cache != nil {
+		item, ok := store.cache.get(store.tableName, key)
+		store.observeCache(LRUCacheName, ok)
+		if ok {
+			return &dynamodb.GetItemOutput{Item: item}, nil
+		}
+	}
+
+	result, err := store.ddb.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(store.tableName),
+		Key:       store.key(id),
+	})
 	if err != nil {
+		return nil, err
+	}
+
+	store.cache.put(store.tableName, key, result.Item)
+	requestCache(ctx).put(store.tableName, key, result.Item)
+	return result, nil
+}
+
+// load loads a session data from the database.
+// True is returned if there is a session data in the database.
+func (store *Store) Load(ctx context.Context, value string, session *sessions.Session) error {
+	ctx, span := store.startSpan(ctx, "Load")
+	err := store.load(ctx, value, session)
+	if err == nil {
+		err = runSessionHooks(ctx, store.hooks.afterLoad, session)
+	}
+	if errors.Is(err, ErrSessionExpired) {
+		store.scoped(ctx).publish(SessionExpired, value, "")
+	}
+	span.end(err)
+
+	return err
+}
+
+func (store *Store) load(ctx context.Context, value string, session *sessions.Session) error {
+	store = store.scoped(ctx)
+
+	if reservedID(value) {
+		return ErrSessionNotFound
+	}
+
+	if err := store.checkRevoked(ctx, value); err != nil {
 		return err
 	}
 
+	result, err := store.getItem(ctx, value)
+	stale := false
+	if err != nil {
+		if result, stale = store.staleItem(value, err); !stale {
+			return err
+		}
+		store.debug(ctx, "serving stale session from cache", store.logID(value), slog.Any("error", err))
+	}
+
+	if store.regionPinning != nil {
+		result.Item = store.pinnedItem(ctx, store.key(value), result.Item)
+	}
+
+	if result.Item == nil && store.legacy != nil {
+		if result.Item, err = store.legacyItem(ctx, store.key(value)); err != nil {
+			return err
+		}
+		if result.Item != nil {
+			store.debug(ctx, "session found in legacy table", store.logID(value), slog.String("table", store.legacy.table))
+		}
+	}
+
 	if result.Item == nil {
-		return errStateNotFound
+		return ErrSessionNotFound
+	}
+
+	out, err := store.decodeItem(ctx, store.itemKey(value), result.Item, session, true)
+	if err != nil {
+		return err
+	}
+
+	if stale {
+		session.Values[staleKey{}] = true
 	}
 
+	session.ID = value
+	session.Values[store.primaryKey] = value
+
+	if len(store.rotationPredicates) > 0 || store.audit != nil {
+		snapshotValues(session, out)
+	}
+
+	if user := store.sessionUser(session); user != "" {
+		session.Values[loadedUserKey{}] = user
+	}
+
+	store.trackLastSeen(ctx, value, session)
+
+	return nil
+}
+
+// decodeItem decrypts the session item stored under key and copies its values
+// into session, returning them as read. With checkExpiry, items past their ttl
+// are refused with ErrSessionExpired.
+func (store *Store) decodeItem(ctx context.Context, key string, item map[string]types.AttributeValue, session *sessions.Session, checkExpiry bool) (map[string]any, error) {
 	out := make(map[string]any, 0)
 
-	err = attributevalue.UnmarshalMap(result.Item, &out)
+	err := attributevalue.UnmarshalMap(item, &out)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	if _, ok := out[RevokedField]; ok {
+		return nil, ErrSessionRevoked
+	}
+
+	if err := store.openValues(ctx, key, out); err != nil {
+		return nil, err
+	}
+
+	if store.legacyFormat != nil {
+		if err := store.openLegacyValues(session, out); err != nil {
+			return nil, err
+		}
+	}
+
+	if expiresAt, ok := parseTTL(out[DefaultTTLField]); ok && checkExpiry && !store.now().Before(expiresAt.Add(store.ttlGrace)) {
+		return nil, ErrSessionExpired
+	}
+
+	delete(out, ExpiresAtField)
+	delete(out, NamespaceField)
+	delete(out, RegionField)
+	updatedAt := loadUpdatedAt(out)
+
+	if maxAge, ok := out[MaxAgeField].(float64); ok {
+		if session.Options == nil {
+			session.Options = store.newOptions()
+		}
+		session.Options.MaxAge = int(maxAge)
+	}
+	delete(out, MaxAgeField)
+	session.Values[loadedMaxAgeKey{}] = store.sessionMaxAge(session)
+
+	deadlines := loadValueExpiry(out, store.now())
+
+	binding := loadBinding(out)
+	meta := loadMeta(out)
+	dropLegacyRateLimits(out)
+
+	if store.schema != nil {
+		if err := store.schema.validate(out); err != nil {
+			return nil, err
+		}
 	}
 
 	for i, v := range out {
 		session.Values[i] = v
 	}
 
-	if _, ok := session.Values[store.primaryKey]; ok {
-		session.ID = session.Values[store.primaryKey].(string)
+	if len(deadlines) > 0 {
+		session.Values[valueExpiryKey{}] = deadlines
 	}
 
-	return err
+	for k, v := range binding {
+		session.Values[k] = v
+	}
+	for k, v := range meta {
+		session.Values[k] = v
+	}
+	if updatedAt > 0 {
+		session.Values[updatedAtKey{}] = updatedAt
+	}
+
+	return out, nil
+}
+
+// reservedID reports whether value is the key of one of the auxiliary items, such
+// as user registries and nonces, kept in the sessions table
+func reservedID(value string) bool {
+	for _, prefix := range []string{userRegistryPrefix, noncePrefix, rememberPrefix, erasurePrefix, rateLimitPrefix} {
+		if strings.HasPrefix(value, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseTTL converts a ttl attribute read back from dynamodb into a time. Epoch
+// seconds are expected, but RFC3339 strings written by earlier versions are
+// also understood.
+func parseTTL(v any) (time.Time, bool) {
+	switch t := v.(type) {
+	case float64:
+		return time.Unix(int64(t), 0), true
+	case string:
+		parsed, err := time.Parse(time.RFC3339Nano, t)
+		return parsed, err == nil
+	}
+
+	return time.Time{}, false
 }
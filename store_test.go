@@ -16,10 +16,13 @@ package dynastore
 import (
 	"context"
 	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/sessions"
@@ -109,6 +112,165 @@ func TestSaveRequest(t *testing.T) {
 
 }
 
+func TestParseTTL(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+
+	testCases := map[string]any{
+		"number": float64(now.Unix()),
+		"string": now.Format(time.RFC3339Nano),
+	}
+
+	for label, tc := range testCases {
+		t.Run(label, func(t *testing.T) {
+			expiresAt, ok := parseTTL(tc)
+			if !ok {
+				t.Fatalf("expected ttl %v to parse", tc)
+			}
+			if !expiresAt.Equal(now) {
+				t.Errorf("expected %v; got %v", now, expiresAt)
+			}
+		})
+	}
+
+	if _, ok := parseTTL(true); ok {
+		t.Error("expected unsupported ttl type to be rejected")
+	}
+}
+
+func TestExpiryWithClock(t *testing.T) {
+	ctx := context.TODO()
+
+	now := time.Unix(1700000000, 0)
+	store, err := New(newFakeDynamoDB(), TTLEnabled(), MaxAge(60), WithClock(func() time.Time { return now }))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session := sessions.NewSession(store, "session")
+	session.ID = uuid.NewString()
+	session.Options = store.newOptions()
+	session.Values["test"] = "one"
+
+	if err := store.Persist(ctx, session.Name(), session); err != nil {
+		t.Fatal(err)
+	}
+
+	now = now.Add(59 * time.Second)
+	if err := store.Load(ctx, session.ID, sessions.NewSession(store, "session")); err != nil {
+		t.Errorf("expected session to load before expiry; got %v", err)
+	}
+
+	if err := store.Touch(ctx, session.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	now = now.Add(59 * time.Second)
+	if err := store.Load(ctx, session.ID, sessions.NewSession(store, "session")); err != nil {
+		t.Errorf("expected touched session to load; got %v", err)
+	}
+
+	now = now.Add(time.Second)
+	if err := store.Load(ctx, session.ID, sessions.NewSession(store, "session")); err != ErrSessionExpired {
+		t.Errorf("expected %v; got %v", ErrSessionExpired, err)
+	}
+
+	if err := store.Touch(ctx, "missing"); err != ErrSessionNotFound {
+		t.Errorf("expected %v; got %v", ErrSessionNotFound, err)
+	}
+}
+
+func TestSessionMaxAgeOverride(t *testing.T) {
+	ctx := context.TODO()
+
+	now := time.Unix(1700000000, 0)
+	store, err := New(newFakeDynamoDB(), TTLEnabled(), MaxAge(60), WithClock(func() time.Time { return now }))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost", nil)
+	session, _ := store.New(req, "session")
+	session.Options.MaxAge = 3600
+
+	w := httptest.NewRecorder()
+	if err := store.Save(req, w, session); err != nil {
+		t.Fatal(err)
+	}
+
+	now = now.Add(30 * time.Minute)
+	if err := store.Touch(ctx, session.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	now = now.Add(59 * time.Minute)
+	req.AddCookie(w.Result().Cookies()[0])
+	found, _ := store.New(req, "session")
+	if found.IsNew {
+		t.Fatal("expected existing session; got new session")
+	}
+	if found.Options.MaxAge != 3600 {
+		t.Errorf("expected MaxAge 3600; got %v", found.Options.MaxAge)
+	}
+	if _, ok := found.Values[MaxAgeField]; ok {
+		t.Error("expected max age attribute to be removed from values")
+	}
+
+	w = httptest.NewRecorder()
+	if err := store.Save(req, w, found); err != nil {
+		t.Fatal(err)
+	}
+	if cookies := w.Result().Cookies(); len(cookies) != 0 {
+		t.Errorf("expected no cookie for an unchanged MaxAge; got %v", cookies)
+	}
+
+	found.Options.MaxAge = 7200
+	w = httptest.NewRecorder()
+	if err := store.Save(req, w, found); err != nil {
+		t.Fatal(err)
+	}
+	if cookies := w.Result().Cookies(); len(cookies) != 1 || cookies[0].MaxAge != 7200 {
+		t.Errorf("expected the cookie to be re-sent with the new MaxAge; got %v", cookies)
+	}
+}
+
+func TestHashedIDs(t *testing.T) {
+	ctx := context.TODO()
+
+	ddb := newFakeDynamoDB()
+	store, _ := New(ddb, WithHashedIDs([]byte("pepper")))
+
+	session := sessions.NewSession(store, "session")
+	session.ID = uuid.NewString()
+	session.Values["test"] = "one"
+
+	if err := store.Persist(ctx, session.Name(), session); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := ddb.items[session.ID]; ok {
+		t.Error("expected the raw session id not to be used as the item key")
+	}
+	for _, item := range ddb.items {
+		for name, v := range item {
+			if s, ok := v.(*types.AttributeValueMemberS); ok && s.Value == session.ID {
+				t.Errorf("expected the raw session id not to be stored; found in %v", name)
+			}
+		}
+	}
+
+	found := sessions.NewSession(store, "session")
+	if err := store.Load(ctx, session.ID, found); err != nil {
+		t.Fatal(err)
+	}
+	if found.ID != session.ID || found.Values["test"] != "one" {
+		t.Errorf("expected session %v to load; got %v %v", session.ID, found.ID, found.Values)
+	}
+
+	if err := store.Delete(ctx, session.ID); err != nil || len(ddb.items) != 0 {
+		t.Errorf("expected session to be deleted; got %v", err)
+	}
+}
+
 type FakeResponseWriter struct{}
 
 func (f FakeResponseWriter) Header() http.Header {
@@ -218,3 +380,327 @@ func (f FakeResponseWriter) WriteHeader(int) {}
 // 		})
 // 	}
 // }
+
+func TestTTLGrace(t *testing.T) {
+	ctx := context.TODO()
+	start := time.Unix(1700000000, 0)
+
+	testCases := map[string]struct {
+		Elapsed  time.Duration
+		Expected error
+	}{
+		"before expiry":       {Elapsed: 59 * time.Second},
+		"inside grace window": {Elapsed: 60*time.Second + 14*time.Second},
+		"end of grace window": {Elapsed: 60*time.Second + 15*time.Second, Expected: ErrSessionExpired},
+	}
+
+	for label, tc := range testCases {
+		t.Run(label, func(t *testing.T) {
+			ddb := newFakeDynamoDB()
+			now := start
+			clock := WithClock(func() time.Time { return now })
+
+			store, _ := New(ddb, TTLEnabled(), MaxAge(60), WithTTLGrace(15*time.Second), clock)
+			session := sessions.NewSession(store, "session")
+			session.ID = uuid.NewString()
+			session.Options = store.newOptions()
+			if err := store.Persist(ctx, session.Name(), session); err != nil {
+				t.Fatal(err)
+			}
+
+			now = start.Add(tc.Elapsed)
+			if err := store.Load(ctx, session.ID, sessions.NewSession(store, "session")); err != tc.Expected {
+				t.Errorf("expected %v; got %v", tc.Expected, err)
+			}
+		})
+	}
+}
+
+func TestTTLJitter(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	clock := WithClock(func() time.Time { return now })
+
+	testCases := map[string]struct {
+		Opts     []Option
+		Min, Max time.Duration
+	}{
+		"unclamped": {
+			Opts: []Option{MaxAge(3600)},
+			Min:  time.Hour,
+			Max:  time.Hour + 10*time.Minute,
+		},
+		"clamped to max ttl": {
+			Opts: []Option{MaxAge(86400), WithMaxTTL(time.Hour)},
+			Min:  50 * time.Minute,
+			Max:  time.Hour,
+		},
+		"clamped to min ttl": {
+			Opts: []Option{MaxAge(0), WithMinTTL(time.Hour)},
+			Min:  time.Hour,
+			Max:  time.Hour + 10*time.Minute,
+		},
+	}
+
+	for label, tc := range testCases {
+		t.Run(label, func(t *testing.T) {
+			store, _ := New(newFakeDynamoDB(), append(tc.Opts, TTLEnabled(), WithTTLJitter(10*time.Minute), clock)...)
+
+			seen := map[time.Time]bool{}
+			for range 100 {
+				expiresAt := store.expiry(store.options.MaxAge)
+				if lifetime := expiresAt.Sub(now); lifetime < tc.Min || lifetime > tc.Max {
+					t.Fatalf("expected a lifetime within [%v, %v]; got %v", tc.Min, tc.Max, lifetime)
+				}
+				seen[expiresAt] = true
+			}
+			if len(seen) < 2 {
+				t.Errorf("expected jittered expiries to differ; got %v", seen)
+			}
+		})
+	}
+}
+
+func TestTouch(t *testing.T) {
+	ctx := context.TODO()
+	start := time.Unix(1700000000, 0)
+
+	testCases := map[string]struct {
+		SessionMaxAge int
+		Missing       bool
+		ExpectedTTL   time.Duration
+		Expected      error
+	}{
+		"default max age": {
+			ExpectedTTL: 60 * time.Second,
+		},
+		"persisted max age": {
+			SessionMaxAge: 600,
+			ExpectedTTL:   600 * time.Second,
+		},
+		"missing session": {
+			Missing:  true,
+			Expected: ErrSessionNotFound,
+		},
+	}
+
+	for label, tc := range testCases {
+		t.Run(label, func(t *testing.T) {
+			ddb := newFakeDynamoDB()
+			now := start
+			store, _ := New(ddb, TTLEnabled(), MaxAge(60), WithClock(func() time.Time { return now }))
+
+			session := sessions.NewSession(store, "session")
+			session.ID = uuid.NewString()
+			session.Options = store.newOptions()
+			if tc.SessionMaxAge > 0 {
+				session.Options.MaxAge = tc.SessionMaxAge
+			}
+			session.Values["test"] = "one"
+			if !tc.Missing {
+				if err := store.Persist(ctx, session.Name(), session); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			now = start.Add(30 * time.Second)
+			puts := ddb.puts
+			if err := store.Touch(ctx, session.ID); err != tc.Expected {
+				t.Fatalf("expected %v; got %v", tc.Expected, err)
+			}
+			if tc.Missing {
+				if _, ok := ddb.items[session.ID]; ok {
+					t.Error("expected Touch not to create the session")
+				}
+				return
+			}
+
+			item := ddb.items[session.ID]
+			if got := attributeTime(item[DefaultTTLField]); !got.Equal(now.Add(tc.ExpectedTTL)) {
+				t.Errorf("expected ttl %v; got %v", now.Add(tc.ExpectedTTL), got)
+			}
+			if ddb.puts != puts {
+				t.Error("expected Touch to update the ttl without rewriting the item")
+			}
+			if v, ok := item["test"].(*types.AttributeValueMemberS); !ok || v.Value != "one" {
+				t.Errorf("expected the payload to be left untouched; got %v", item["test"])
+			}
+		})
+	}
+}
+
+func TestServerTTL(t *testing.T) {
+	ctx := context.TODO()
+	now := time.Unix(1700000000, 0)
+
+	testCases := map[string]struct {
+		MaxAge        int
+		SessionMaxAge int
+		ServerTTL     time.Duration
+		ExpectedTTL   time.Duration
+	}{
+		"browser session cookie": {
+			ServerTTL:   time.Hour,
+			ExpectedTTL: time.Hour,
+		},
+		"overrides default max age": {
+			MaxAge:      60,
+			ServerTTL:   time.Hour,
+			ExpectedTTL: time.Hour,
+		},
+		"explicit session max age wins": {
+			MaxAge:        60,
+			SessionMaxAge: 600,
+			ServerTTL:     time.Hour,
+			ExpectedTTL:   600 * time.Second,
+		},
+		"unset": {
+			MaxAge:      60,
+			ExpectedTTL: 60 * time.Second,
+		},
+	}
+
+	for label, tc := range testCases {
+		t.Run(label, func(t *testing.T) {
+			ddb := newFakeDynamoDB()
+			store, _ := New(ddb, TTLEnabled(), MaxAge(tc.MaxAge), WithServerTTL(tc.ServerTTL), WithClock(func() time.Time { return now }))
+
+			session := sessions.NewSession(store, "session")
+			session.ID = uuid.NewString()
+			session.Options = store.newOptions()
+			if tc.SessionMaxAge > 0 {
+				session.Options.MaxAge = tc.SessionMaxAge
+			}
+			if err := store.Persist(ctx, session.Name(), session); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := attributeTime(ddb.items[session.ID][DefaultTTLField]); !got.Equal(now.Add(tc.ExpectedTTL)) {
+				t.Errorf("expected ttl %v; got %v", now.Add(tc.ExpectedTTL), got)
+			}
+			if err := store.Load(ctx, session.ID, sessions.NewSession(store, "session")); err != nil {
+				t.Errorf("expected the session to load; got %v", err)
+			}
+		})
+	}
+}
+
+func TestMinMaxTTL(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+
+	testCases := map[string]struct {
+		MaxAge      int
+		ExpectedTTL time.Duration
+	}{
+		"zero max age":     {MaxAge: 0, ExpectedTTL: time.Minute},
+		"negative max age": {MaxAge: -1, ExpectedTTL: time.Minute},
+		"within range":     {MaxAge: 3600, ExpectedTTL: time.Hour},
+		"huge max age":     {MaxAge: 10 * 365 * 86400, ExpectedTTL: 30 * 24 * time.Hour},
+	}
+
+	for label, tc := range testCases {
+		t.Run(label, func(t *testing.T) {
+			store, _ := New(newFakeDynamoDB(), TTLEnabled(), WithMinTTL(time.Minute), WithMaxTTL(30*24*time.Hour),
+				WithClock(func() time.Time { return now }))
+
+			if got := store.expiry(tc.MaxAge); !got.Equal(now.Add(tc.ExpectedTTL)) {
+				t.Errorf("expected ttl %v; got %v", now.Add(tc.ExpectedTTL), got)
+			}
+		})
+	}
+}
+
+func TestExpiresAt(t *testing.T) {
+	ctx := context.TODO()
+	start := time.Unix(1700000000, 0)
+
+	testCases := map[string]struct {
+		Opts     []Option
+		Expected bool
+	}{
+		"enabled":  {Opts: []Option{WithExpiresAt()}, Expected: true},
+		"disabled": {},
+	}
+
+	for label, tc := range testCases {
+		t.Run(label, func(t *testing.T) {
+			ddb := newFakeDynamoDB()
+			now := start
+			store, _ := New(ddb, append(tc.Opts, TTLEnabled(), MaxAge(60), WithClock(func() time.Time { return now }))...)
+
+			session := sessions.NewSession(store, "session")
+			session.ID = uuid.NewString()
+			session.Options = store.newOptions()
+			if err := store.Persist(ctx, session.Name(), session); err != nil {
+				t.Fatal(err)
+			}
+			assertExpiresAt(t, ddb.items[session.ID], tc.Expected, now.Add(time.Minute))
+
+			now = start.Add(30 * time.Second)
+			if err := store.Touch(ctx, session.ID); err != nil {
+				t.Fatal(err)
+			}
+			assertExpiresAt(t, ddb.items[session.ID], tc.Expected, now.Add(time.Minute))
+
+			loaded := sessions.NewSession(store, "session")
+			if err := store.Load(ctx, session.ID, loaded); err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := loaded.Values[ExpiresAtField]; ok {
+				t.Errorf("expected %v to be left out of the session values", ExpiresAtField)
+			}
+		})
+	}
+}
+
+func assertExpiresAt(t *testing.T, item map[string]types.AttributeValue, expected bool, expiresAt time.Time) {
+	t.Helper()
+
+	v, ok := item[ExpiresAtField].(*types.AttributeValueMemberS)
+	if ok != expected {
+		t.Fatalf("expected %v attribute %v; got %v", ExpiresAtField, expected, item[ExpiresAtField])
+	}
+	if ok && v.Value != expiresAt.UTC().Format(time.RFC3339) {
+		t.Errorf("expected %v; got %v", expiresAt.UTC().Format(time.RFC3339), v.Value)
+	}
+}
+
+func TestOptionSetters(t *testing.T) {
+	testCases := map[string]struct {
+		Set      func(store *Store)
+		Expected sessions.Options
+	}{
+		"max age": {
+			Set:      func(store *Store) { store.MaxAge(120) },
+			Expected: sessions.Options{MaxAge: 120},
+		},
+		"options": {
+			Set: func(store *Store) {
+				store.Options(sessions.Options{Path: "/app", Domain: "example.com", MaxAge: 300, Secure: true, HttpOnly: true})
+			},
+			Expected: sessions.Options{Path: "/app", Domain: "example.com", MaxAge: 300, Secure: true, HttpOnly: true},
+		},
+		"max age after options": {
+			Set: func(store *Store) {
+				store.Options(sessions.Options{Path: "/app", MaxAge: 300})
+				store.MaxAge(-1)
+			},
+			Expected: sessions.Options{Path: "/app", MaxAge: -1},
+		},
+	}
+
+	for label, tc := range testCases {
+		t.Run(label, func(t *testing.T) {
+			store, _ := New(newFakeDynamoDB(), MaxAge(60))
+			tc.Set(store)
+
+			req, _ := http.NewRequest(http.MethodGet, "http://localhost", nil)
+			session, err := store.New(req, "session")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *session.Options != tc.Expected {
+				t.Errorf("expected %+v; got %+v", tc.Expected, *session.Options)
+			}
+		})
+	}
+}
@@ -0,0 +1,281 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodbstreams"
+	streamtypes "github.com/aws/aws-sdk-go-v2/service/dynamodbstreams/types"
+	"github.com/gorilla/sessions"
+)
+
+// streamPollInterval is how long ConsumeStream waits when no shard has new records
+const streamPollInterval = time.Second
+
+// ttlPrincipal is the principal of the stream records of items deleted by ttl processing
+const ttlPrincipal = "dynamodb.amazonaws.com"
+
+// StreamsClient is the subset of the dynamodb streams API used by ConsumeStream.
+// It is satisfied by *dynamodbstreams.Client.
+type StreamsClient interface {
+	DescribeStream(ctx context.Context, params *dynamodbstreams.DescribeStreamInput, optFns ...func(*dynamodbstreams.Options)) (*dynamodbstreams.DescribeStreamOutput, error)
+	GetShardIterator(ctx context.Context, params *dynamodbstreams.GetShardIteratorInput, optFns ...func(*dynamodbstreams.Options)) (*dynamodbstreams.GetShardIteratorOutput, error)
+	GetRecords(ctx context.Context, params *dynamodbstreams.GetRecordsInput, optFns ...func(*dynamodbstreams.Options)) (*dynamodbstreams.GetRecordsOutput, error)
+}
+
+// StreamEvent is a change to a session read from the stream of the table
+type StreamEvent struct {
+	// Operation is INSERT for a new session, MODIFY for a saved or touched one
+	// and REMOVE for an ended one
+	Operation streamtypes.OperationType
+
+	// Expired is set for the REMOVE of a session deleted by dynamodb ttl
+	// processing, as opposed to an explicit logout or revocation
+	Expired bool
+
+	// Session is the session after the change, or before it for a REMOVE, which
+	// requires a stream view type including the images. Its ID is the item key:
+	// the session id, or its digest with WithHashedIDs. It is nil when Err is set.
+	Session *sessions.Session
+
+	// Err is set when the item couldn't be decoded into Session, e.g. because
+	// the stream holds keys only or the session was revoked
+	Err error
+
+	Time time.Time
+}
+
+// DecodeStreamRecord decodes a record of the stream of the table into a session
+// event, reporting false for records of items that aren't sessions, such as the
+// user registries of WithUserIndex. Sessions are named name.
+func (store *Store) DecodeStreamRecord(ctx context.Context, name string, record streamtypes.Record) (StreamEvent, bool) {
+	change := record.Dynamodb
+	if change == nil {
+		return StreamEvent{}, false
+	}
+
+	itemKey := attributeString(streamValue(change.Keys[store.primaryKey]))
+	key, ok := store.sessionKey(itemKey)
+	if !ok {
+		return StreamEvent{}, false
+	}
+
+	event := StreamEvent{
+		Operation: record.EventName,
+		Time:      aws.ToTime(change.ApproximateCreationDateTime),
+	}
+
+	if record.EventName == streamtypes.OperationTypeRemove && record.UserIdentity != nil {
+		event.Expired = aws.ToString(record.UserIdentity.Type) == "Service" && aws.ToString(record.UserIdentity.PrincipalId) == ttlPrincipal
+	}
+
+	image := change.NewImage
+	if record.EventName == streamtypes.OperationTypeRemove {
+		image = change.OldImage
+	}
+	if image == nil {
+		event.Err = fmt.Errorf("stream record of session %s holds no image", redactID(key))
+		return event, true
+	}
+
+	item := make(map[string]types.AttributeValue, len(image))
+	for k, v := range image {
+		item[k] = streamValue(v)
+	}
+
+	session := sessions.NewSession(store, name)
+	session.Options = store.newOptions()
+	if _, err := store.decodeItem(ctx, itemKey, item, session, false); err != nil {
+		event.Err = err
+		return event, true
+	}
+
+	session.ID = key
+	session.Values[store.primaryKey] = key
+	event.Session = session
+
+	return event, true
+}
+
+// sessionKey strips the namespace of the store from an item key, reporting false
+// for keys of other namespaces and of items that aren't sessions
+func (store *Store) sessionKey(itemKey string) (string, bool) {
+	key := itemKey
+	if store.namespace != "" {
+		var ok bool
+		if key, ok = strings.CutPrefix(itemKey, store.namespace+namespaceSeparator); !ok {
+			return "", false
+		}
+	}
+
+	if key == "" || reservedID(key) || key == pingKey {
+		return "", false
+	}
+
+	return key, true
+}
+
+// streamValue converts an attribute value of a stream record into its dynamodb
+// counterpart
+func streamValue(value streamtypes.AttributeValue) types.AttributeValue {
+	switch v := value.(type) {
+	case *streamtypes.AttributeValueMemberS:
+		return &types.AttributeValueMemberS{Value: v.Value}
+	case *streamtypes.AttributeValueMemberN:
+		return &types.AttributeValueMemberN{Value: v.Value}
+	case *streamtypes.AttributeValueMemberB:
+		return &types.AttributeValueMemberB{Value: v.Value}
+	case *streamtypes.AttributeValueMemberBOOL:
+		return &types.AttributeValueMemberBOOL{Value: v.Value}
+	case *streamtypes.AttributeValueMemberNULL:
+		return &types.AttributeValueMemberNULL{Value: v.Value}
+	case *streamtypes.AttributeValueMemberSS:
+		return &types.AttributeValueMemberSS{Value: v.Value}
+	case *streamtypes.AttributeValueMemberNS:
+		return &types.AttributeValueMemberNS{Value: v.Value}
+	case *streamtypes.AttributeValueMemberBS:
+		return &types.AttributeValueMemberBS{Value: v.Value}
+	case *streamtypes.AttributeValueMemberL:
+		list := make([]types.AttributeValue, len(v.Value))
+		for i, element := range v.Value {
+			list[i] = streamValue(element)
+		}
+		return &types.AttributeValueMemberL{Value: list}
+	case *streamtypes.AttributeValueMemberM:
+		m := make(map[string]types.AttributeValue, len(v.Value))
+		for k, element := range v.Value {
+			m[k] = streamValue(element)
+		}
+		return &types.AttributeValueMemberM{Value: m}
+	default:
+		return nil
+	}
+}
+
+// ConsumeStream reads the stream identified by streamARN, which must be enabled on
+// the table with a view type including images, and calls fn with the change of
+// every session, shard by shard, parents before children, until ctx is done or fn
+// fails. from selects where new shards are read from, TRIM_HORIZON to replay the
+// last 24 hours or LATEST for new changes only. Positions aren't checkpointed:
+// records are delivered again by the next call.
+func (store *Store) ConsumeStream(ctx context.Context, client StreamsClient, streamARN, name string, from streamtypes.ShardIteratorType, fn func(context.Context, StreamEvent) error) error {
+	iterators := map[string]*string{}
+	finished := map[string]bool{}
+
+	for discover := true; ; {
+		if discover {
+			if err := discoverShards(ctx, client, streamARN, from, iterators, finished); err != nil {
+				return err
+			}
+			discover = false
+		}
+
+		idle := true
+		for shard, iterator := range iterators {
+			out, err := client.GetRecords(ctx, &dynamodbstreams.GetRecordsInput{ShardIterator: iterator})
+			var expired *streamtypes.ExpiredIteratorException
+			if errors.As(err, &expired) {
+				// reopened by the next discovery
+				delete(iterators, shard)
+				discover = true
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("failed to read shard %s: %w", shard, err)
+			}
+
+			for _, record := range out.Records {
+				if event, ok := store.DecodeStreamRecord(ctx, name, record); ok {
+					if err := fn(ctx, event); err != nil {
+						return err
+					}
+				}
+			}
+
+			if len(out.Records) > 0 {
+				idle = false
+			}
+			if out.NextShardIterator == nil {
+				delete(iterators, shard)
+				finished[shard] = true
+				discover = true
+				continue
+			}
+			iterators[shard] = out.NextShardIterator
+		}
+
+		if idle {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(streamPollInterval):
+			}
+			discover = true
+		}
+	}
+}
+
+// discoverShards opens an iterator on the shards of the stream not read yet whose
+// parent, if still in the stream, has been read to its end
+func discoverShards(ctx context.Context, client StreamsClient, streamARN string, from streamtypes.ShardIteratorType, iterators map[string]*string, finished map[string]bool) error {
+	var shards []streamtypes.Shard
+	for start := (*string)(nil); ; {
+		out, err := client.DescribeStream(ctx, &dynamodbstreams.DescribeStreamInput{
+			StreamArn:             aws.String(streamARN),
+			ExclusiveStartShardId: start,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to describe stream: %w", err)
+		}
+
+		shards = append(shards, out.StreamDescription.Shards...)
+		if start = out.StreamDescription.LastEvaluatedShardId; start == nil {
+			break
+		}
+	}
+
+	listed := make(map[string]bool, len(shards))
+	for _, shard := range shards {
+		listed[aws.ToString(shard.ShardId)] = true
+	}
+
+	for _, shard := range shards {
+		id := aws.ToString(shard.ShardId)
+		if _, open := iterators[id]; open || finished[id] {
+			continue
+		}
+		if parent := aws.ToString(shard.ParentShardId); parent != "" && listed[parent] && !finished[parent] {
+			continue
+		}
+
+		out, err := client.GetShardIterator(ctx, &dynamodbstreams.GetShardIteratorInput{
+			StreamArn:         aws.String(streamARN),
+			ShardId:           shard.ShardId,
+			ShardIteratorType: from,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to open shard %s: %w", id, err)
+		}
+		iterators[id] = out.ShardIterator
+	}
+
+	return nil
+}
@@ -0,0 +1,150 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodbstreams"
+	streamtypes "github.com/aws/aws-sdk-go-v2/service/dynamodbstreams/types"
+)
+
+// fakeStreams serves the records of each shard in a single page
+type fakeStreams struct {
+	shards  []streamtypes.Shard
+	records map[string][]streamtypes.Record
+	read    []string
+}
+
+func (f *fakeStreams) DescribeStream(ctx context.Context, params *dynamodbstreams.DescribeStreamInput, optFns ...func(*dynamodbstreams.Options)) (*dynamodbstreams.DescribeStreamOutput, error) {
+	return &dynamodbstreams.DescribeStreamOutput{StreamDescription: &streamtypes.StreamDescription{Shards: f.shards}}, nil
+}
+
+func (f *fakeStreams) GetShardIterator(ctx context.Context, params *dynamodbstreams.GetShardIteratorInput, optFns ...func(*dynamodbstreams.Options)) (*dynamodbstreams.GetShardIteratorOutput, error) {
+	return &dynamodbstreams.GetShardIteratorOutput{ShardIterator: params.ShardId}, nil
+}
+
+func (f *fakeStreams) GetRecords(ctx context.Context, params *dynamodbstreams.GetRecordsInput, optFns ...func(*dynamodbstreams.Options)) (*dynamodbstreams.GetRecordsOutput, error) {
+	shard := aws.ToString(params.ShardIterator)
+	f.read = append(f.read, shard)
+	return &dynamodbstreams.GetRecordsOutput{Records: f.records[shard]}, nil
+}
+
+func toStreamValue(value types.AttributeValue) streamtypes.AttributeValue {
+	switch v := value.(type) {
+	case *types.AttributeValueMemberS:
+		return &streamtypes.AttributeValueMemberS{Value: v.Value}
+	case *types.AttributeValueMemberN:
+		return &streamtypes.AttributeValueMemberN{Value: v.Value}
+	case *types.AttributeValueMemberB:
+		return &streamtypes.AttributeValueMemberB{Value: v.Value}
+	case *types.AttributeValueMemberBOOL:
+		return &streamtypes.AttributeValueMemberBOOL{Value: v.Value}
+	case *types.AttributeValueMemberSS:
+		return &streamtypes.AttributeValueMemberSS{Value: v.Value}
+	case *types.AttributeValueMemberM:
+		m := map[string]streamtypes.AttributeValue{}
+		for k, element := range v.Value {
+			m[k] = toStreamValue(element)
+		}
+		return &streamtypes.AttributeValueMemberM{Value: m}
+	default:
+		panic(fmt.Sprintf("unsupported attribute value %T", value))
+	}
+}
+
+func streamRecord(operation streamtypes.OperationType, item map[string]types.AttributeValue, expired bool) streamtypes.Record {
+	image := map[string]streamtypes.AttributeValue{}
+	for k, v := range item {
+		image[k] = toStreamValue(v)
+	}
+
+	record := streamtypes.Record{
+		EventName: operation,
+		Dynamodb: &streamtypes.StreamRecord{
+			Keys: map[string]streamtypes.AttributeValue{DefaultPrimaryKey: image[DefaultPrimaryKey]},
+		},
+	}
+	if operation == streamtypes.OperationTypeRemove {
+		record.Dynamodb.OldImage = image
+	} else {
+		record.Dynamodb.NewImage = image
+	}
+	if expired {
+		record.UserIdentity = &streamtypes.Identity{Type: aws.String("Service"), PrincipalId: aws.String(ttlPrincipal)}
+	}
+
+	return record
+}
+
+func TestConsumeStream(t *testing.T) {
+	ddb := newFakeDynamoDB()
+	store, _ := New(ddb, MaxAge(3600), WithUserIndex("user-index", "user_id"))
+	persistUserSessions(t, store, "bob", 2)
+
+	streams := &fakeStreams{
+		shards: []streamtypes.Shard{
+			{ShardId: aws.String("child"), ParentShardId: aws.String("parent")},
+			{ShardId: aws.String("parent")},
+		},
+		records: map[string][]streamtypes.Record{
+			"parent": {
+				streamRecord(streamtypes.OperationTypeInsert, ddb.items["bob-0"], false),
+				streamRecord(streamtypes.OperationTypeInsert, map[string]types.AttributeValue{
+					DefaultPrimaryKey: &types.AttributeValueMemberS{Value: userRegistryPrefix + "bob"},
+				}, false),
+			},
+			"child": {
+				streamRecord(streamtypes.OperationTypeRemove, ddb.items["bob-0"], false),
+				streamRecord(streamtypes.OperationTypeRemove, ddb.items["bob-1"], true),
+			},
+		},
+	}
+
+	done := fmt.Errorf("done")
+	var events []StreamEvent
+	err := store.ConsumeStream(context.TODO(), streams, "arn", "session", streamtypes.ShardIteratorTypeTrimHorizon, func(ctx context.Context, event StreamEvent) error {
+		events = append(events, event)
+		if len(events) == 3 {
+			return done
+		}
+		return nil
+	})
+	if err != done {
+		t.Fatalf("expected consumption to stop with the error of fn; got %v", err)
+	}
+
+	if streams.read[0] != "parent" {
+		t.Errorf("expected the parent shard to be read first; got %v", streams.read)
+	}
+
+	for i, event := range events {
+		if event.Err != nil || event.Session == nil || event.Session.Values["user_id"] != "bob" {
+			t.Fatalf("expected event %v to carry the session; got %+v", i, event)
+		}
+	}
+	if events[0].Operation != streamtypes.OperationTypeInsert || events[0].Session.ID != "bob-0" {
+		t.Errorf("expected the insert of bob-0; got %+v", events[0])
+	}
+	if events[1].Operation != streamtypes.OperationTypeRemove || events[1].Expired {
+		t.Errorf("expected an explicit removal; got %+v", events[1])
+	}
+	if !events[2].Expired || events[2].Session.ID != "bob-1" {
+		t.Errorf("expected bob-1 to expire; got %+v", events[2])
+	}
+}
@@ -0,0 +1,75 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrInvalidOptions is wrapped by the error NewWithOptions returns for options
+// that can't work together
+var ErrInvalidOptions = fmt.Errorf("invalid store options")
+
+// checkOptions returns the problems with the options applied to the store,
+// joined, or nil
+func (store *Store) checkOptions() error {
+	var errs []error
+	check := func(failed bool, format string, args ...any) {
+		if failed {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(store.ddb == nil && !store.inMemory, "no dynamodb client, pass one to New or use WithInMemory")
+	check(store.tableName == "", "table name is empty")
+	check(store.primaryKey == "", "primary key is empty")
+
+	check(store.enableTTL && store.options.MaxAge <= 0 && store.serverTTL <= 0 && store.minTTL <= 0,
+		"TTLEnabled with a MaxAge of %d expires sessions as soon as they are written, set MaxAge or WithServerTTL", store.options.MaxAge)
+	check(store.serverTTL < 0 || store.ttlGrace < 0 || store.ttlJitter < 0 || store.minTTL < 0 || store.maxTTL < 0,
+		"ttl durations must not be negative")
+	check(store.minTTL > 0 && store.maxTTL > 0 && store.minTTL > store.maxTTL,
+		"WithMinTTL of %v exceeds WithMaxTTL of %v", store.minTTL, store.maxTTL)
+
+	check(len(store.encryptedFields) > 0 && len(store.encryptionKey) == 0 && store.kms == nil && store.keys == nil,
+		"WithEncryptedFields requires WithEncryption, WithKMSEncryption or WithKeyProvider")
+
+	check(store.userIndex != "" && store.userKey == "", "WithUserIndex requires a user key")
+	check(store.maxSessions > 0 && store.userIndex == "", "WithMaxSessionsPerUser requires WithUserIndex")
+
+	check(store.revocationTable != "" && store.revocationTable == store.tableName,
+		"WithRevocationTable must name a table other than the sessions table %s", store.tableName)
+	check(store.audit != nil && store.audit.table == store.tableName,
+		"WithAuditLog must name a table other than the sessions table %s", store.tableName)
+
+	if store.readOnly {
+		check(store.writeBehind != nil, "WithWriteBehind has no effect on a read only store")
+		check(store.shadow != nil, "WithShadowWrites has no effect on a read only store")
+		check(store.touches != nil, "WithTouchCoalescing has no effect on a read only store")
+		check(store.autoEnableTTL, "WithAutoEnableTTL has no effect on a read only store")
+	}
+	if store.inMemory {
+		check(store.failover != nil, "WithFailover can't be combined with WithInMemory")
+		check(store.regionPinning != nil, "WithRegionPinning can't be combined with WithInMemory")
+	}
+	check(store.lastWriterWins != nil && store.writeBehind != nil,
+		"WithLastWriterWins writes synchronously, so WithWriteBehind would never be used")
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%w: %w", ErrInvalidOptions, errors.Join(errs...))
+	}
+
+	return nil
+}
@@ -0,0 +1,69 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewWithOptions(t *testing.T) {
+	testCases := map[string]struct {
+		client  DynamoDBClient
+		opts    []Option
+		problem string
+	}{
+		"valid":            {client: newFakeDynamoDB(), opts: []Option{TTLEnabled(), MaxAge(3600)}},
+		"in memory":        {opts: []Option{WithInMemory()}},
+		"no client":        {problem: "no dynamodb client"},
+		"table name":       {client: newFakeDynamoDB(), opts: []Option{TableName("")}, problem: "table name is empty"},
+		"ttl":              {client: newFakeDynamoDB(), opts: []Option{TTLEnabled()}, problem: "expires sessions as soon as they are written"},
+		"server ttl":       {client: newFakeDynamoDB(), opts: []Option{TTLEnabled(), WithServerTTL(time.Hour)}},
+		"ttl bounds":       {client: newFakeDynamoDB(), opts: []Option{WithMinTTL(time.Hour), WithMaxTTL(time.Minute)}, problem: "exceeds WithMaxTTL"},
+		"encrypted fields": {client: newFakeDynamoDB(), opts: []Option{WithEncryptedFields("email")}, problem: "WithEncryptedFields requires"},
+		"user limit":       {client: newFakeDynamoDB(), opts: []Option{WithMaxSessionsPerUser(3, RejectNewSessions)}, problem: "requires WithUserIndex"},
+		"audit table":      {client: newFakeDynamoDB(), opts: []Option{WithAuditLog(DefaultTableName, time.Hour)}, problem: "other than the sessions table"},
+		"read only":        {client: newFakeDynamoDB(), opts: []Option{WithReadOnly(), WithWriteBehind(10, 1, time.Second, nil)}, problem: "no effect on a read only store"},
+		"lww":              {client: newFakeDynamoDB(), opts: []Option{WithLastWriterWins(nil), WithWriteBehind(10, 1, time.Second, nil)}, problem: "would never be used"},
+	}
+
+	for label, tc := range testCases {
+		t.Run(label, func(t *testing.T) {
+			store, err := NewWithOptions(tc.client, tc.opts...)
+			if tc.problem == "" {
+				if err != nil {
+					t.Fatalf("expected valid options; got %v", err)
+				}
+				store.Close(context.TODO())
+				return
+			}
+
+			if !errors.Is(err, ErrInvalidOptions) || !strings.Contains(err.Error(), tc.problem) {
+				t.Errorf("expected %q; got %v", tc.problem, err)
+			}
+		})
+	}
+
+	_, err := NewWithOptions(nil, TableName(""), TTLEnabled())
+	if got := strings.Count(err.Error(), "\n"); got != 2 {
+		t.Errorf("expected all 3 problems to be reported; got %v", err)
+	}
+
+	if _, err := New(newFakeDynamoDB(), TTLEnabled()); err != nil {
+		t.Errorf("expected New to keep accepting the options; got %v", err)
+	}
+}
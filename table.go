@@ -0,0 +1,267 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// EnsureTTL enables dynamodb time to live on the ttl attribute of the table if it
+// isn't already. An error is returned if ttl is enabled on a different attribute.
+func (store *Store) EnsureTTL(ctx context.Context) error {
+	store = store.scoped(ctx)
+
+	result, err := store.ddb.DescribeTimeToLive(ctx, &dynamodb.DescribeTimeToLiveInput{
+		TableName: aws.String(store.tableName),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to describe ttl of table %s: %w", store.tableName, err)
+	}
+
+	if desc := result.TimeToLiveDescription; desc != nil {
+		switch desc.TimeToLiveStatus {
+		case types.TimeToLiveStatusEnabled, types.TimeToLiveStatusEnabling:
+			if attr := aws.ToString(desc.AttributeName); attr != DefaultTTLField {
+				return fmt.Errorf("ttl on table %s is enabled on attribute %s, expected %s", store.tableName, attr, DefaultTTLField)
+			}
+			return nil
+		case types.TimeToLiveStatusDisabling:
+			return fmt.Errorf("ttl on table %s is being disabled, retry once it has completed", store.tableName)
+		}
+	}
+
+	_, err = store.ddb.UpdateTimeToLive(ctx, &dynamodb.UpdateTimeToLiveInput{
+		TableName: aws.String(store.tableName),
+		TimeToLiveSpecification: &types.TimeToLiveSpecification{
+			AttributeName: aws.String(DefaultTTLField),
+			Enabled:       aws.Bool(true),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to enable ttl on table %s: %w", store.tableName, err)
+	}
+
+	return nil
+}
+
+// Validate checks that the table exists and matches the store configuration: the
+// primary key name and type, the ttl attribute when TTLEnabled is set, and the
+// user index when WithUserIndex is set. All problems found are returned together.
+func (store *Store) Validate(ctx context.Context) error {
+	store = store.scoped(ctx)
+
+	result, err := store.ddb.DescribeTable(ctx, &dynamodb.DescribeTableInput{
+		TableName: aws.String(store.tableName),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to describe table %s: %w", store.tableName, err)
+	}
+
+	table := result.Table
+	attributeTypes := make(map[string]types.ScalarAttributeType, len(table.AttributeDefinitions))
+	for _, def := range table.AttributeDefinitions {
+		attributeTypes[aws.ToString(def.AttributeName)] = def.AttributeType
+	}
+
+	var errs []error
+
+	errs = append(errs, validateKeySchema(
+		fmt.Sprintf("table %s", store.tableName), table.KeySchema, attributeTypes, store.primaryKey,
+	)...)
+
+	if store.userIndex != "" {
+		var found bool
+		for _, gsi := range table.GlobalSecondaryIndexes {
+			if aws.ToString(gsi.IndexName) != store.userIndex {
+				continue
+			}
+
+			found = true
+			errs = append(errs, validateKeySchema(
+				fmt.Sprintf("index %s", store.userIndex), gsi.KeySchema, attributeTypes, store.userKey,
+			)...)
+			if gsi.IndexStatus != types.IndexStatusActive {
+				errs = append(errs, fmt.Errorf("index %s is %s, expected %s", store.userIndex, gsi.IndexStatus, types.IndexStatusActive))
+			}
+		}
+
+		if !found {
+			errs = append(errs, fmt.Errorf("table %s has no global secondary index named %s", store.tableName, store.userIndex))
+		}
+	}
+
+	if store.enableTTL {
+		ttl, err := store.ddb.DescribeTimeToLive(ctx, &dynamodb.DescribeTimeToLiveInput{
+			TableName: aws.String(store.tableName),
+		})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to describe ttl of table %s: %w", store.tableName, err))
+		} else if desc := ttl.TimeToLiveDescription; desc == nil || desc.TimeToLiveStatus != types.TimeToLiveStatusEnabled {
+			errs = append(errs, fmt.Errorf("ttl is not enabled on table %s, enable it on attribute %s or use WithAutoEnableTTL", store.tableName, DefaultTTLField))
+		} else if attr := aws.ToString(desc.AttributeName); attr != DefaultTTLField {
+			errs = append(errs, fmt.Errorf("ttl on table %s is enabled on attribute %s, expected %s", store.tableName, attr, DefaultTTLField))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// validateKeySchema verifies that schema consists solely of a string partition key named key
+func validateKeySchema(label string, schema []types.KeySchemaElement, attributeTypes map[string]types.ScalarAttributeType, key string) []error {
+	var errs []error
+	for _, element := range schema {
+		name := aws.ToString(element.AttributeName)
+		switch element.KeyType {
+		case types.KeyTypeHash:
+			if name != key {
+				errs = append(errs, fmt.Errorf("%s has partition key %s, expected %s", label, name, key))
+			} else if attributeTypes[name] != types.ScalarAttributeTypeS {
+				errs = append(errs, fmt.Errorf("%s partition key %s has type %s, expected %s", label, name, attributeTypes[name], types.ScalarAttributeTypeS))
+			}
+		case types.KeyTypeRange:
+			errs = append(errs, fmt.Errorf("%s has sort key %s, which is not supported", label, name))
+		}
+	}
+
+	return errs
+}
+
+// defaultTableWait bounds how long EnsureTable waits for a new table to become
+// active when TableOptions.MaxWait is zero
+const defaultTableWait = 5 * time.Minute
+
+// TableOptions configures the table created by EnsureTable
+type TableOptions struct {
+	// Tags are applied to the table when it is created
+	Tags map[string]string
+
+	// DeletionProtection prevents the table from being deleted until disabled
+	DeletionProtection bool
+
+	// MaxWait bounds how long EnsureTable waits for the table to become active,
+	// five minutes by default
+	MaxWait time.Duration
+}
+
+// EnsureTable creates the table of the store if it does not exist, with on demand
+// billing, the primary key of the store, the user index when WithUserIndex is set
+// and ttl enabled, and waits for it to become active, so development and staging
+// environments can provision themselves. An existing table is left untouched;
+// use Validate to check it.
+func (store *Store) EnsureTable(ctx context.Context, opts TableOptions) error {
+	store = store.scoped(ctx)
+
+	if store.readOnly {
+		return fmt.Errorf("refusing to create table %s from a read only store", store.tableName)
+	}
+
+	_, err := store.ddb.DescribeTable(ctx, &dynamodb.DescribeTableInput{
+		TableName: aws.String(store.tableName),
+	})
+	if err == nil {
+		return nil
+	}
+	var notFound *types.ResourceNotFoundException
+	if !errors.As(err, &notFound) {
+		return fmt.Errorf("failed to describe table %s: %w", store.tableName, err)
+	}
+
+	input := store.CreateTableInput()
+	input.DeletionProtectionEnabled = aws.Bool(opts.DeletionProtection)
+	for key, value := range opts.Tags {
+		input.Tags = append(input.Tags, types.Tag{Key: aws.String(key), Value: aws.String(value)})
+	}
+
+	var inUse *types.ResourceInUseException
+	if _, err := store.ddb.CreateTable(ctx, input); errors.As(err, &inUse) {
+		// created concurrently, by another instance starting up
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to create table %s: %w", store.tableName, err)
+	}
+
+	maxWait := opts.MaxWait
+	if maxWait <= 0 {
+		maxWait = defaultTableWait
+	}
+
+	waiter := dynamodb.NewTableExistsWaiter(store.ddb, func(o *dynamodb.TableExistsWaiterOptions) {
+		o.MinDelay = time.Second
+		o.MaxDelay = 10 * time.Second
+	})
+	if err := waiter.Wait(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(store.tableName)}, maxWait); err != nil {
+		return fmt.Errorf("failed waiting for table %s to become active: %w", store.tableName, err)
+	}
+
+	return store.EnsureTTL(ctx)
+}
+
+// CreateTableInput returns the input creating a table that matches the store
+// configuration: the table name, primary key and user index, with on demand
+// billing. Ttl is enabled separately, on the DefaultTTLField attribute.
+func (store *Store) CreateTableInput() *dynamodb.CreateTableInput {
+	input := &dynamodb.CreateTableInput{
+		TableName:   aws.String(store.tableName),
+		BillingMode: types.BillingModePayPerRequest,
+		AttributeDefinitions: []types.AttributeDefinition{
+			{AttributeName: aws.String(store.primaryKey), AttributeType: types.ScalarAttributeTypeS},
+		},
+		KeySchema: []types.KeySchemaElement{
+			{AttributeName: aws.String(store.primaryKey), KeyType: types.KeyTypeHash},
+		},
+	}
+
+	if store.userIndex != "" {
+		input.AttributeDefinitions = append(input.AttributeDefinitions, types.AttributeDefinition{
+			AttributeName: aws.String(store.userKey),
+			AttributeType: types.ScalarAttributeTypeS,
+		})
+		input.GlobalSecondaryIndexes = []types.GlobalSecondaryIndex{{
+			IndexName: aws.String(store.userIndex),
+			KeySchema: []types.KeySchemaElement{
+				{AttributeName: aws.String(store.userKey), KeyType: types.KeyTypeHash},
+			},
+			Projection: &types.Projection{ProjectionType: types.ProjectionTypeAll},
+		}}
+	}
+
+	return input
+}
+
+// pingKey is the key of the item Ping reads. It is never written.
+const pingKey = "ping#"
+
+// Ping reports whether the table can be read, for readiness probes and health
+// endpoints. It reads a sentinel item that never exists, so it costs half a read
+// capacity unit and exercises the same permissions and endpoint as Load.
+func (store *Store) Ping(ctx context.Context) error {
+	store = store.scoped(ctx)
+
+	_, err := store.ddb.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(store.tableName),
+		Key:       store.rawKey(pingKey),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to reach table %s: %w", store.tableName, err)
+	}
+
+	return nil
+}
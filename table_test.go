@@ -0,0 +1,120 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func TestEnsureTTL(t *testing.T) {
+	ddb := newFakeDynamoDB()
+
+	if _, err := New(ddb, TTLEnabled(), WithAutoEnableTTL()); err != nil {
+		t.Fatalf("expected nil; got %v", err)
+	}
+	if ddb.ttl == nil || ddb.ttl.TimeToLiveStatus != types.TimeToLiveStatusEnabled {
+		t.Fatalf("expected ttl to be enabled; got %#v", ddb.ttl)
+	}
+
+	ddb.ttl.AttributeName = aws.String("expires")
+	store, _ := New(ddb)
+	if err := store.EnsureTTL(context.TODO()); err == nil {
+		t.Error("expected an error when ttl is enabled on another attribute")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	ddb := newFakeDynamoDB()
+	ddb.table = &types.TableDescription{
+		TableName: aws.String(DefaultTableName),
+		AttributeDefinitions: []types.AttributeDefinition{
+			{AttributeName: aws.String(DefaultPrimaryKey), AttributeType: types.ScalarAttributeTypeS},
+			{AttributeName: aws.String("user_id"), AttributeType: types.ScalarAttributeTypeN},
+		},
+		KeySchema: []types.KeySchemaElement{
+			{AttributeName: aws.String(DefaultPrimaryKey), KeyType: types.KeyTypeHash},
+		},
+		GlobalSecondaryIndexes: []types.GlobalSecondaryIndexDescription{
+			{
+				IndexName:   aws.String("user-index"),
+				IndexStatus: types.IndexStatusActive,
+				KeySchema: []types.KeySchemaElement{
+					{AttributeName: aws.String("user_id"), KeyType: types.KeyTypeHash},
+				},
+			},
+		},
+	}
+
+	store, _ := New(ddb)
+	if err := store.Validate(context.TODO()); err != nil {
+		t.Errorf("expected nil; got %v", err)
+	}
+
+	store, _ = New(ddb, TTLEnabled(), WithUserIndex("user-index", "user_id"))
+	if err := store.Validate(context.TODO()); err == nil {
+		t.Error("expected errors for a disabled ttl and a numeric user index key")
+	}
+}
+
+type unreachableDynamoDB struct {
+	*fakeDynamoDB
+}
+
+func (unreachableDynamoDB) GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	return nil, fmt.Errorf("connection refused")
+}
+
+func TestPing(t *testing.T) {
+	store, _ := New(newFakeDynamoDB())
+	if err := store.Ping(context.TODO()); err != nil {
+		t.Errorf("expected nil; got %v", err)
+	}
+
+	store, _ = New(unreachableDynamoDB{newFakeDynamoDB()})
+	if err := store.Ping(context.TODO()); err == nil {
+		t.Error("expected an error when dynamodb can't be reached")
+	}
+}
+
+func TestEnsureTable(t *testing.T) {
+	ctx := context.TODO()
+
+	ddb := newFakeDynamoDB()
+	store, _ := New(ddb, TTLEnabled(), WithUserIndex("user-index", "user_id"))
+
+	if err := store.EnsureTable(ctx, TableOptions{Tags: map[string]string{"env": "dev"}}); err != nil {
+		t.Fatalf("expected the table to be created; got %v", err)
+	}
+	if err := store.Validate(ctx); err != nil {
+		t.Errorf("expected the created table to match the store; got %v", err)
+	}
+	if mode := ddb.table.BillingModeSummary.BillingMode; mode != types.BillingModePayPerRequest {
+		t.Errorf("expected on demand billing; got %v", mode)
+	}
+
+	if err := store.EnsureTable(ctx, TableOptions{}); err != nil {
+		t.Errorf("expected an existing table to be left alone; got %v", err)
+	}
+
+	store, _ = New(newFakeDynamoDB(), WithReadOnly())
+	if err := store.EnsureTable(ctx, TableOptions{}); err == nil {
+		t.Error("expected a read only store not to create tables")
+	}
+}
@@ -0,0 +1,189 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// maxTransactItems is the maximum number of actions accepted by TransactWriteItems
+const maxTransactItems = 100
+
+// touchCoalescer collects the sessions passed to Touch and refreshes their ttl
+// once per interval, in transactions of up to maxTransactItems updates
+type touchCoalescer struct {
+	interval time.Duration
+	onError  func(error)
+
+	mu      sync.Mutex
+	pending map[string]pendingTouch
+	closed  bool
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// pendingTouch is a session to refresh, with the store it was touched through
+type pendingTouch struct {
+	store *Store
+	id    string
+}
+
+func (c *touchCoalescer) start() {
+	c.pending = make(map[string]pendingTouch)
+	c.stop = make(chan struct{})
+	c.done = make(chan struct{})
+
+	go func() {
+		defer close(c.done)
+
+		ticker := time.NewTicker(c.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				c.report(c.flush(context.Background()))
+			case <-c.stop:
+				c.report(c.flush(context.Background()))
+				return
+			}
+		}
+	}()
+}
+
+func (c *touchCoalescer) report(err error) {
+	if err != nil && c.onError != nil {
+		c.onError(err)
+	}
+}
+
+// add queues the session identified by id, unless already queued. Once closed,
+// the session is refreshed right away instead.
+func (c *touchCoalescer) add(store *Store, id string) {
+	c.mu.Lock()
+	if !c.closed {
+		c.pending[bufferKey(store.tableName, store.itemKey(id))] = pendingTouch{store: store, id: id}
+		c.mu.Unlock()
+		return
+	}
+	c.mu.Unlock()
+
+	if err := store.touch(context.Background(), id); err != nil && !errors.Is(err, ErrSessionNotFound) {
+		c.report(err)
+	}
+}
+
+// flush refreshes the queued sessions
+func (c *touchCoalescer) flush(ctx context.Context) error {
+	c.mu.Lock()
+	pending := c.pending
+	c.pending = make(map[string]pendingTouch)
+	c.mu.Unlock()
+
+	batch := make([]pendingTouch, 0, min(len(pending), maxTransactItems))
+	var errs []error
+	for _, touch := range pending {
+		batch = append(batch, touch)
+		if len(batch) == maxTransactItems {
+			errs = append(errs, c.write(ctx, batch))
+			batch = batch[:0]
+		}
+	}
+	if len(batch) > 0 {
+		errs = append(errs, c.write(ctx, batch))
+	}
+
+	return errors.Join(errs...)
+}
+
+// write refreshes touches in a single transaction. Its updates only apply to
+// sessions using the default MaxAge; the others, and sessions deleted since,
+// cancel the transaction and are refreshed one by one with Touch before the
+// rest is retried.
+func (c *touchCoalescer) write(ctx context.Context, touches []pendingTouch) error {
+	items := make([]types.TransactWriteItem, 0, len(touches))
+	for _, touch := range touches {
+		update, names, values := touch.store.ttlUpdate(touch.store.expiry(touch.store.options.MaxAge))
+		names["#maxAge"] = MaxAgeField
+
+		items = append(items, types.TransactWriteItem{
+			Update: &types.Update{
+				TableName:                 aws.String(touch.store.tableName),
+				Key:                       touch.store.key(touch.id),
+				ConditionExpression:       aws.String("attribute_exists(#pk) AND attribute_not_exists(#maxAge)"),
+				UpdateExpression:          aws.String(update),
+				ExpressionAttributeNames:  names,
+				ExpressionAttributeValues: values,
+			},
+		})
+	}
+
+	_, err := touches[0].store.ddb.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{TransactItems: items})
+
+	var canceled *types.TransactionCanceledException
+	if !errors.As(err, &canceled) || len(canceled.CancellationReasons) != len(touches) {
+		if err != nil {
+			return fmt.Errorf("failed to refresh the ttl of %d sessions: %w", len(touches), err)
+		}
+		return nil
+	}
+
+	var retry []pendingTouch
+	var errs []error
+	for i, reason := range canceled.CancellationReasons {
+		if aws.ToString(reason.Code) != "ConditionalCheckFailed" {
+			retry = append(retry, touches[i])
+			continue
+		}
+
+		if err := touches[i].store.touch(ctx, touches[i].id); err != nil && !errors.Is(err, ErrSessionNotFound) {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(retry) == len(touches) {
+		return fmt.Errorf("failed to refresh the ttl of %d sessions: %w", len(touches), err)
+	}
+	if len(retry) > 0 {
+		errs = append(errs, c.write(ctx, retry))
+	}
+
+	return errors.Join(errs...)
+}
+
+// close stops queueing touches and waits for the queued ones to be written, or
+// for ctx to be done
+func (c *touchCoalescer) close(ctx context.Context) error {
+	c.mu.Lock()
+	if !c.closed {
+		c.closed = true
+		close(c.stop)
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-c.done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("touches still pending: %w", ctx.Err())
+	}
+}
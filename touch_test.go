@@ -0,0 +1,82 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+)
+
+func TestTouchCoalescing(t *testing.T) {
+	ctx := context.TODO()
+
+	now := time.Unix(1700000000, 0)
+	ddb := newFakeDynamoDB()
+	store, _ := New(ddb, TTLEnabled(), MaxAge(60), WithClock(func() time.Time { return now }),
+		WithTouchCoalescing(time.Hour, func(err error) {
+			t.Errorf("unexpected touch error: %v", err)
+		}))
+
+	for _, id := range []string{"a", "b", "custom", "deleted"} {
+		session := sessions.NewSession(store, "session")
+		session.ID = id
+		session.Options = store.newOptions()
+		if id == "custom" {
+			session.Options.MaxAge = 600
+		}
+		if err := store.Persist(ctx, "session", session); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	now = now.Add(30 * time.Second)
+	for range 10 {
+		for _, id := range []string{"a", "b", "custom", "deleted"} {
+			if err := store.Touch(ctx, id); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := store.Delete(ctx, "deleted"); err != nil {
+		t.Fatal(err)
+	}
+	if ddb.updates != 0 || ddb.transactions != 0 {
+		t.Fatalf("expected touches to be queued; got %v updates", ddb.updates)
+	}
+
+	if err := store.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	ttl := func(id string) int64 {
+		n, _ := strconv.ParseInt(attributeNumber(ddb.items[id][DefaultTTLField]), 10, 64)
+		return n - now.Unix()
+	}
+	if ttl("a") < 60 || ttl("b") < 60 {
+		t.Errorf("expected the ttl of queued sessions to be refreshed; got %v, %v", ttl("a"), ttl("b"))
+	}
+	if ttl("custom") < 600 {
+		t.Errorf("expected the MaxAge of the session to be honoured; got %v", ttl("custom"))
+	}
+	if ddb.transactions != 2 || ddb.updates != 1 {
+		t.Errorf("expected one transaction to be retried and one session refreshed alone; got %v transactions, %v updates", ddb.transactions, ddb.updates)
+	}
+	if _, ok := ddb.items["deleted"]; ok {
+		t.Error("expected deleted session not to be brought back")
+	}
+}
@@ -0,0 +1,253 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Tracer starts the spans of store operations. Its shape follows the
+// OpenTelemetry tracer so an adapter takes a few lines, without the store
+// depending on the OpenTelemetry module:
+//
+//	type otelTracer struct{ trace.Tracer }
+//
+//	func (t otelTracer) Start(ctx context.Context, name string) (context.Context, dynastore.Span) {
+//		ctx, span := t.Tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient))
+//		return ctx, otelSpan{span}
+//	}
+//
+// where otelSpan implements Span by converting attributes with attribute.String
+// and friends.
+type Tracer interface {
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is a span started by a Tracer
+type Span interface {
+	SetAttributes(attributes ...Attribute)
+	RecordError(err error)
+	End()
+}
+
+// Attribute is a span attribute. Value is a string, int64, float64 or []string.
+type Attribute struct {
+	Key   string
+	Value any
+}
+
+// Span attributes set by the store, after the OpenTelemetry semantic conventions
+// for dynamodb where there is one
+const (
+	AttributeDBSystem         = "db.system.name"
+	AttributeOperation        = "db.operation.name"
+	AttributeTableNames       = "aws.dynamodb.table_names"
+	AttributeConsumedCapacity = "aws.dynamodb.consumed_capacity"
+	AttributeItemSize         = "dynastore.item_size"
+)
+
+type spanContextKey struct{}
+
+// span follows a store operation for the tracer set with WithTracer, the recorder
+// set with WithMetrics and the logger set with WithLogger, if any. Its methods
+// are safe to call on a nil span, which is what startSpan returns with none.
+type span struct {
+	Span
+	ctx       context.Context
+	logger    *slog.Logger
+	metrics   MetricsRecorder
+	operation string
+	table     string
+	started   time.Time
+	now       func() time.Time
+	size      int
+	capacity  float64
+}
+
+// startSpan starts following operation when WithTracer, WithMetrics or
+// WithLogger is set
+func (store *Store) startSpan(ctx context.Context, operation string) (context.Context, *span) {
+	if store.tracer == nil && store.metrics == nil && store.logger == nil {
+		return ctx, nil
+	}
+
+	sp := &span{
+		ctx:       ctx,
+		logger:    store.logger,
+		metrics:   store.metrics,
+		operation: operation,
+		table:     store.scoped(ctx).tableName,
+		started:   store.now(),
+		now:       store.now,
+	}
+
+	if store.tracer != nil {
+		ctx, sp.Span = store.tracer.Start(ctx, "dynastore."+operation)
+		sp.SetAttributes(
+			Attribute{Key: AttributeDBSystem, Value: "aws.dynamodb"},
+			Attribute{Key: AttributeOperation, Value: operation},
+			Attribute{Key: AttributeTableNames, Value: []string{sp.table}},
+		)
+	}
+
+	return context.WithValue(ctx, spanContextKey{}, sp), sp
+}
+
+// spanFromContext returns the span of the store operation ctx belongs to, if any
+func spanFromContext(ctx context.Context) *span {
+	sp, _ := ctx.Value(spanContextKey{}).(*span)
+	return sp
+}
+
+// consumed adds the capacity consumed by a call to the span
+func (s *span) consumed(capacity *types.ConsumedCapacity) {
+	if s == nil || capacity == nil {
+		return
+	}
+
+	s.capacity += aws.ToFloat64(capacity.CapacityUnits)
+}
+
+// itemSize records the size of the item read or written by the operation
+func (s *span) itemSize(item map[string]types.AttributeValue) {
+	if s == nil || item == nil {
+		return
+	}
+
+	s.size = itemSize(item)
+}
+
+// end records err, unless nil, and ends the span
+func (s *span) end(err error) {
+	if s == nil {
+		return
+	}
+
+	if s.Span != nil {
+		if s.size > 0 {
+			s.SetAttributes(Attribute{Key: AttributeItemSize, Value: int64(s.size)})
+		}
+		if s.capacity > 0 {
+			s.SetAttributes(Attribute{Key: AttributeConsumedCapacity, Value: s.capacity})
+		}
+		if err != nil {
+			s.RecordError(err)
+		}
+		s.End()
+	}
+
+	if s.logger != nil {
+		s.logger.LogAttrs(s.ctx, slog.LevelDebug, "dynastore "+s.operation,
+			slog.String("table", s.table),
+			slog.Duration("duration", s.now().Sub(s.started)),
+			slog.Int("item_size", s.size),
+			slog.Float64("consumed_capacity", s.capacity),
+			slog.Any("error", err),
+		)
+	}
+
+	if s.metrics != nil {
+		s.metrics.ObserveOperation(OperationMetrics{
+			Operation:        s.operation,
+			Table:            s.table,
+			Duration:         s.now().Sub(s.started),
+			Err:              err,
+			ErrorClass:       ClassifyError(err),
+			ItemSize:         s.size,
+			ConsumedCapacity: s.capacity,
+		})
+	}
+}
+
+// tracingClient asks dynamodb for the capacity consumed by the calls made during
+// a followed operation and adds it, with the size of the session item, to the span
+type tracingClient struct {
+	DynamoDBClient
+}
+
+func (c tracingClient) GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	sp := spanFromContext(ctx)
+	if sp == nil {
+		return c.DynamoDBClient.GetItem(ctx, params, optFns...)
+	}
+
+	input := *params
+	input.ReturnConsumedCapacity = types.ReturnConsumedCapacityTotal
+
+	out, err := c.DynamoDBClient.GetItem(ctx, &input, optFns...)
+	if err == nil {
+		sp.consumed(out.ConsumedCapacity)
+		sp.itemSize(out.Item)
+	}
+
+	return out, err
+}
+
+func (c tracingClient) PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	sp := spanFromContext(ctx)
+	if sp == nil {
+		return c.DynamoDBClient.PutItem(ctx, params, optFns...)
+	}
+
+	input := *params
+	input.ReturnConsumedCapacity = types.ReturnConsumedCapacityTotal
+
+	out, err := c.DynamoDBClient.PutItem(ctx, &input, optFns...)
+	if err == nil {
+		sp.consumed(out.ConsumedCapacity)
+		sp.itemSize(params.Item)
+	}
+
+	return out, err
+}
+
+func (c tracingClient) UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error) {
+	sp := spanFromContext(ctx)
+	if sp == nil {
+		return c.DynamoDBClient.UpdateItem(ctx, params, optFns...)
+	}
+
+	input := *params
+	input.ReturnConsumedCapacity = types.ReturnConsumedCapacityTotal
+
+	out, err := c.DynamoDBClient.UpdateItem(ctx, &input, optFns...)
+	if err == nil {
+		sp.consumed(out.ConsumedCapacity)
+	}
+
+	return out, err
+}
+
+func (c tracingClient) DeleteItem(ctx context.Context, params *dynamodb.DeleteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error) {
+	sp := spanFromContext(ctx)
+	if sp == nil {
+		return c.DynamoDBClient.DeleteItem(ctx, params, optFns...)
+	}
+
+	input := *params
+	input.ReturnConsumedCapacity = types.ReturnConsumedCapacityTotal
+
+	out, err := c.DynamoDBClient.DeleteItem(ctx, &input, optFns...)
+	if err == nil {
+		sp.consumed(out.ConsumedCapacity)
+	}
+
+	return out, err
+}
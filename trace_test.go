@@ -0,0 +1,90 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/gorilla/sessions"
+)
+
+type recordedSpan struct {
+	name       string
+	attributes map[string]any
+	err        error
+	ended      bool
+}
+
+func (s *recordedSpan) SetAttributes(attributes ...Attribute) {
+	for _, a := range attributes {
+		s.attributes[a.Key] = a.Value
+	}
+}
+
+func (s *recordedSpan) RecordError(err error) { s.err = err }
+
+func (s *recordedSpan) End() { s.ended = true }
+
+type recordingTracer struct {
+	mu    sync.Mutex
+	spans []*recordedSpan
+}
+
+func (t *recordingTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s := &recordedSpan{name: name, attributes: map[string]any{}}
+	t.spans = append(t.spans, s)
+	return ctx, s
+}
+
+func TestTracer(t *testing.T) {
+	ctx := context.TODO()
+
+	tracer := &recordingTracer{}
+	store, _ := New(newFakeDynamoDB(), MaxAge(3600), WithTracer(tracer))
+
+	session := sessions.NewSession(store, "session")
+	session.ID = "abc"
+	session.Options = store.newOptions()
+	session.Values["name"] = "bob"
+	if err := store.Persist(ctx, "session", session); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Load(ctx, "missing", sessions.NewSession(store, "session")); err == nil {
+		t.Fatal("expected missing session not to load")
+	}
+
+	if len(tracer.spans) != 2 {
+		t.Fatalf("expected 2 spans; got %v", len(tracer.spans))
+	}
+
+	persist := tracer.spans[0]
+	if persist.name != "dynastore.Persist" || !persist.ended || persist.err != nil {
+		t.Errorf("expected a successful Persist span; got %+v", persist)
+	}
+	if tables, _ := persist.attributes[AttributeTableNames].([]string); len(tables) != 1 || tables[0] != DefaultTableName {
+		t.Errorf("expected the table to be recorded; got %v", persist.attributes)
+	}
+	if size, _ := persist.attributes[AttributeItemSize].(int64); size == 0 {
+		t.Errorf("expected the item size to be recorded; got %v", persist.attributes)
+	}
+
+	if load := tracer.spans[1]; load.name != "dynastore.Load" || load.err == nil {
+		t.Errorf("expected the Load error to be recorded; got %+v", load)
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+
+	av "github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/gorilla/sessions"
+)
+
+// TypedStore gives the sessions of a Store a strongly typed payload. T is
+// converted to and from session values with attributevalue, so its fields are
+// named by their dynamodbav struct tags.
+type TypedStore[T any] struct {
+	store *Store
+	name  string
+}
+
+// TypedSession is a session whose values are held by Data
+type TypedSession[T any] struct {
+	Data T
+
+	// Session is the underlying session, for its id, options and flashes
+	Session *sessions.Session
+}
+
+// NewTypedStore returns a typed view of the sessions of store named name
+func NewTypedStore[T any](store *Store, name string) *TypedStore[T] {
+	return &TypedStore[T]{store: store, name: name}
+}
+
+// Get returns the session of the request, decoding its values into Data. As with
+// Store.Get, a new session is returned alongside any error loading it.
+func (ts *TypedStore[T]) Get(req *http.Request) (*TypedSession[T], error) {
+	session, err := ts.store.Get(req, ts.name)
+	if session == nil {
+		return nil, err
+	}
+
+	typed := &TypedSession[T]{Session: session}
+
+	values := convertToMapStringAny(session.Values)
+	delete(values, ts.store.primaryKey)
+
+	item, merr := av.MarshalMap(values)
+	if merr != nil {
+		return typed, fmt.Errorf("failed to marshal session values: %w", merr)
+	}
+	if merr := av.UnmarshalMap(item, &typed.Data); merr != nil {
+		return typed, fmt.Errorf("failed to decode session values: %w", merr)
+	}
+
+	return typed, err
+}
+
+// Save writes Data into the values of the session and saves it. Values that are
+// not fields of T, such as the CSRF token, are left as they are.
+func (ts *TypedStore[T]) Save(req *http.Request, w http.ResponseWriter, typed *TypedSession[T]) error {
+	item, err := av.MarshalMap(typed.Data)
+	if err != nil {
+		return fmt.Errorf("failed to encode session values: %w", err)
+	}
+
+	values := map[string]any{}
+	if err := av.UnmarshalMap(item, &values); err != nil {
+		return fmt.Errorf("failed to unmarshal session values: %w", err)
+	}
+
+	session := typed.Session
+	for _, name := range payloadFields(reflect.TypeFor[T]()) {
+		delete(session.Values, name)
+	}
+	setValues(session, values)
+
+	return ts.store.Save(req, w, session)
+}
+
+// payloadFields returns the attribute names of the fields of struct type t, so
+// fields omitted from its encoding can be removed from the session values
+func payloadFields(t reflect.Type) []string {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+
+	var names []string
+	for _, field := range reflect.VisibleFields(t) {
+		if !field.IsExported() || len(field.Index) > 1 {
+			continue
+		}
+
+		name, _, _ := strings.Cut(field.Tag.Get("dynamodbav"), ",")
+		switch {
+		case name == "-":
+			continue
+		case field.Anonymous && name == "":
+			names = append(names, payloadFields(field.Type)...)
+			continue
+		case name == "":
+			name = field.Name
+		}
+		names = append(names, name)
+	}
+
+	return names
+}
@@ -0,0 +1,72 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type profile struct {
+	UserID string   `dynamodbav:"user_id"`
+	Visits int      `dynamodbav:"visits"`
+	Roles  []string `dynamodbav:"roles,omitempty"`
+}
+
+func TestTypedStore(t *testing.T) {
+	store, _ := New(newFakeDynamoDB(), MaxAge(3600))
+	typed := NewTypedStore[profile](store, "session")
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	session, err := typed.Get(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	session.Data = profile{UserID: "bob", Visits: 1, Roles: []string{"admin"}}
+	session.Session.Values["theme"] = "dark"
+
+	w := httptest.NewRecorder()
+	if err := typed.Save(req, w, session); err != nil {
+		t.Fatal(err)
+	}
+
+	next := httptest.NewRequest(http.MethodGet, "/", nil)
+	for _, cookie := range w.Result().Cookies() {
+		next.AddCookie(cookie)
+	}
+
+	loaded, err := typed.Get(next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Session.IsNew {
+		t.Fatal("expected the session to be loaded")
+	}
+	if loaded.Data.UserID != "bob" || loaded.Data.Visits != 1 || len(loaded.Data.Roles) != 1 || loaded.Data.Roles[0] != "admin" {
+		t.Errorf("expected the payload to round trip; got %+v", loaded.Data)
+	}
+	if loaded.Session.Values["theme"] != "dark" {
+		t.Error("expected values outside of the payload to be kept")
+	}
+
+	loaded.Data.Roles = nil
+	w = httptest.NewRecorder()
+	if err := typed.Save(next, w, loaded); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := loaded.Session.Values["roles"]; ok {
+		t.Error("expected an omitted field to be removed from the values")
+	}
+}
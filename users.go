@@ -0,0 +1,360 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	av "github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// maxBatchWriteItems is the maximum number of requests accepted by BatchWriteItem
+const maxBatchWriteItems = 25
+
+// maxBatchWriteAttempts bounds the retries of unprocessed batch write requests
+const maxBatchWriteAttempts = 5
+
+// DeleteAllForUser deletes every session belonging to userID, e.g. after a
+// password reset, on account compromise or on account deletion, and ends the
+// remember-me series of the user with ForgetUser. It requires WithUserIndex.
+func (store *Store) DeleteAllForUser(ctx context.Context, userID string) error {
+	if err := store.DeleteAllForUserExcept(ctx, userID, ""); err != nil {
+		return err
+	}
+
+	return store.ForgetUser(ctx, userID)
+}
+
+// DeleteAllForUserExcept deletes every session belonging to userID other than
+// keepSessionID, powering "sign out everywhere else". It requires WithUserIndex.
+func (store *Store) DeleteAllForUserExcept(ctx context.Context, userID, keepSessionID string) error {
+	store = store.scoped(ctx)
+
+	sessions, err := store.userSessions(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	keep := ""
+	if keepSessionID != "" {
+		keep = store.itemKey(keepSessionID)
+	}
+
+	var (
+		requests []types.WriteRequest
+		deleted  []string
+	)
+	for _, s := range sessions {
+		if s.key == keep {
+			continue
+		}
+
+		deleted = append(deleted, s.key)
+		requests = append(requests, types.WriteRequest{
+			DeleteRequest: &types.DeleteRequest{
+				Key: map[string]types.AttributeValue{
+					store.primaryKey: &types.AttributeValueMemberS{Value: s.key},
+				},
+			},
+		})
+	}
+
+	if err := store.batchWrite(ctx, requests); err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, key := range deleted {
+		errs = append(errs, store.deleted(ctx, store.keyID(key), userID))
+	}
+
+	if store.maxSessions > 0 {
+		errs = append(errs, store.resetRegistry(ctx, userID, keep))
+	}
+
+	return errors.Join(errs...)
+}
+
+// resetRegistry replaces the sessions listed in the registry of user with keep,
+// or removes the registry entirely if keep is empty
+func (store *Store) resetRegistry(ctx context.Context, user, keep string) error {
+	if keep == "" {
+		_, err := store.ddb.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+			TableName: aws.String(store.tableName),
+			Key:       store.registryKey(user),
+		})
+		return err
+	}
+
+	_, err := store.ddb.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:                 aws.String(store.tableName),
+		Key:                       store.registryKey(user),
+		UpdateExpression:          aws.String("SET #sessions = :keys"),
+		ExpressionAttributeNames:  map[string]string{"#sessions": SessionsField},
+		ExpressionAttributeValues: map[string]types.AttributeValue{":keys": &types.AttributeValueMemberSS{Value: []string{keep}}},
+	})
+
+	return err
+}
+
+// batchWrite issues requests in batches of 25, retrying unprocessed items with a
+// short exponential backoff
+func (store *Store) batchWrite(ctx context.Context, requests []types.WriteRequest) error {
+	for _, request := range requests {
+		if request.DeleteRequest != nil {
+			store.discardPending(ctx, itemID(store, request.DeleteRequest.Key))
+		}
+	}
+
+	for len(requests) > 0 {
+		n := min(len(requests), maxBatchWriteItems)
+		batch := requests[:n]
+		requests = requests[n:]
+
+		for attempt := 0; len(batch) > 0; attempt++ {
+			if attempt == maxBatchWriteAttempts {
+				return fmt.Errorf("failed to write %d items after %d attempts", len(batch), attempt)
+			}
+
+			if attempt > 0 {
+				store.debug(ctx, "retrying unprocessed batch writes", slog.Int("attempt", attempt), slog.Int("items", len(batch)))
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case <-time.After(time.Duration(1<<attempt) * 25 * time.Millisecond):
+				}
+			}
+
+			result, err := store.ddb.BatchWriteItem(ctx, &dynamodb.BatchWriteItemInput{
+				RequestItems: map[string][]types.WriteRequest{store.tableName: batch},
+			})
+			if err != nil {
+				return fmt.Errorf("failed to batch write items: %w", err)
+			}
+
+			batch = result.UnprocessedItems[store.tableName]
+		}
+	}
+
+	return nil
+}
+
+// listPageSize is the number of sessions ListSessions reads per page
+const listPageSize = 25
+
+// SessionSummary describes one of a user's sessions as returned by ListSessions
+type SessionSummary struct {
+	// ID is the key of the session item: the session id, or its digest when
+	// WithHashedIDs is set. Session ids are bearer credentials, so it must not be
+	// shown to users as is.
+	ID string
+
+	// CreatedAt is when the session was first saved
+	CreatedAt time.Time
+
+	// LastSeen is when the session was last saved
+	LastSeen time.Time
+
+	// ExpiresAt is when the session expires, if TTLEnabled
+	ExpiresAt time.Time
+
+	// Device is the device fingerprint recorded by WithDeviceBinding, if any
+	Device string
+
+	// IP is the address of the client that created the session
+	IP string
+
+	// UserAgent is the user agent of the client that created the session
+	UserAgent string
+}
+
+// ListSessions returns a page of the live sessions of userID, for instance to
+// let users review where they are signed in. cursor is empty for the first page;
+// the returned cursor is passed back to fetch the next one and is empty after
+// the last. The user index must project the metadata attributes (or ALL) for the
+// summaries to be filled in. It requires WithUserIndex.
+func (store *Store) ListSessions(ctx context.Context, userID, cursor string) ([]SessionSummary, string, error) {
+	store = store.scoped(ctx)
+
+	if store.userIndex == "" {
+		return nil, "", fmt.Errorf("a user index must be configured with WithUserIndex")
+	}
+
+	startKey, err := decodeCursor(cursor)
+	if err != nil {
+		return nil, "", err
+	}
+
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(store.tableName),
+		IndexName:              aws.String(store.userIndex),
+		KeyConditionExpression: aws.String("#user = :user"),
+		ExclusiveStartKey:      startKey,
+		Limit:                  aws.Int32(listPageSize),
+	}
+	input.FilterExpression, input.ExpressionAttributeNames, input.ExpressionAttributeValues = store.namespaceFilter(nil,
+		map[string]string{"#user": store.userKey},
+		map[string]types.AttributeValue{":user": &types.AttributeValueMemberS{Value: userID}},
+	)
+
+	result, err := store.ddb.Query(ctx, input)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to query sessions of user: %w", err)
+	}
+
+	now := store.now()
+
+	summaries := make([]SessionSummary, 0, len(result.Items))
+	for _, item := range result.Items {
+		if _, revoked := item[RevokedField]; revoked {
+			continue
+		}
+
+		summary := store.sessionItem(item).SessionSummary
+		if !summary.ExpiresAt.IsZero() && !now.Before(summary.ExpiresAt) {
+			continue
+		}
+
+		summaries = append(summaries, summary)
+	}
+
+	next, err := encodeCursor(result.LastEvaluatedKey)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return summaries, next, nil
+}
+
+// encodeCursor converts the LastEvaluatedKey of a query into an opaque cursor
+func encodeCursor(key map[string]types.AttributeValue) (string, error) {
+	if len(key) == 0 {
+		return "", nil
+	}
+
+	var values map[string]any
+	if err := av.UnmarshalMap(key, &values); err != nil {
+		return "", fmt.Errorf("failed to encode cursor: %w", err)
+	}
+
+	data, err := json.Marshal(values)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode cursor: %w", err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// decodeCursor reverses encodeCursor
+func decodeCursor(cursor string) (map[string]types.AttributeValue, error) {
+	if cursor == "" {
+		return nil, nil
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor: %w", err)
+	}
+
+	var values map[string]any
+	if err := json.Unmarshal(data, &values); err != nil {
+		return nil, fmt.Errorf("invalid cursor: %w", err)
+	}
+
+	key, err := av.MarshalMap(values)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor: %w", err)
+	}
+
+	return key, nil
+}
+
+// attributeString returns the value of a string attribute, or "" if v isn't one
+func attributeString(v types.AttributeValue) string {
+	if s, ok := v.(*types.AttributeValueMemberS); ok {
+		return s.Value
+	}
+
+	return ""
+}
+
+// attributeTime returns the time held by a number attribute in epoch seconds,
+// or the zero time if v isn't one
+func attributeTime(v types.AttributeValue) time.Time {
+	n, ok := v.(*types.AttributeValueMemberN)
+	if !ok {
+		return time.Time{}
+	}
+
+	seconds, err := strconv.ParseInt(n.Value, 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+
+	return time.Unix(seconds, 0)
+}
+
+// ActiveSessionCount returns the number of sessions of userID that have neither
+// expired nor been revoked, e.g. to warn users signed in on many devices. Only
+// counts are returned by dynamodb, but read capacity is consumed for every
+// session of the user. It requires WithUserIndex.
+func (store *Store) ActiveSessionCount(ctx context.Context, userID string) (int, error) {
+	store = store.scoped(ctx)
+
+	if store.userIndex == "" {
+		return 0, fmt.Errorf("a user index must be configured with WithUserIndex")
+	}
+
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(store.tableName),
+		IndexName:              aws.String(store.userIndex),
+		Select:                 types.SelectCount,
+		KeyConditionExpression: aws.String("#user = :user"),
+	}
+	input.FilterExpression, input.ExpressionAttributeNames, input.ExpressionAttributeValues = store.namespaceFilter(
+		aws.String("attribute_not_exists(#ttl) AND attribute_not_exists(#revoked) OR #ttl > :now AND attribute_not_exists(#revoked)"),
+		map[string]string{
+			"#user":    store.userKey,
+			"#ttl":     DefaultTTLField,
+			"#revoked": RevokedField,
+		},
+		map[string]types.AttributeValue{
+			":user": &types.AttributeValueMemberS{Value: userID},
+			":now":  &types.AttributeValueMemberN{Value: strconv.FormatInt(store.now().Unix(), 10)},
+		},
+	)
+
+	paginator := dynamodb.NewQueryPaginator(store.ddb, input)
+
+	count := 0
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return 0, fmt.Errorf("failed to count sessions of user: %w", err)
+		}
+		count += int(page.Count)
+	}
+
+	return count, nil
+}
@@ -0,0 +1,186 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+)
+
+// persistUserSessions saves n sessions for user, returning their ids
+func persistUserSessions(t *testing.T, store *Store, user string, n int) []string {
+	t.Helper()
+
+	var ids []string
+	for i := 0; i < n; i++ {
+		session := sessions.NewSession(store, "session")
+		session.ID = fmt.Sprintf("%s-%d", user, i)
+		session.Options = store.newOptions()
+		session.Values["user_id"] = user
+		if err := store.Persist(context.TODO(), session.Name(), session); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, session.ID)
+	}
+
+	return ids
+}
+
+func TestDeleteAllForUserExcept(t *testing.T) {
+	ddb := newFakeDynamoDB()
+	store, _ := New(ddb, WithUserIndex("user-index", "user_id"))
+
+	bob := persistUserSessions(t, store, "bob", 30)
+	alice := persistUserSessions(t, store, "alice", 1)
+
+	if err := store.DeleteAllForUserExcept(context.TODO(), "bob", bob[3]); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ddb.items) != 2 {
+		t.Errorf("expected 2 sessions to remain; got %v", len(ddb.items))
+	}
+	if _, ok := ddb.items[bob[3]]; !ok {
+		t.Error("expected the kept session to remain")
+	}
+	if _, ok := ddb.items[alice[0]]; !ok {
+		t.Error("expected other users' sessions to remain")
+	}
+}
+
+func TestDeleteAllForUserExceptHooks(t *testing.T) {
+	var deleted []string
+	ddb := newFakeDynamoDB()
+	store, _ := New(ddb,
+		WithUserIndex("user-index", "user_id"),
+		WithNamespace("tenant"),
+		WithOnDelete(func(ctx context.Context, id string) error {
+			deleted = append(deleted, id)
+			return nil
+		}),
+	)
+
+	bob := persistUserSessions(t, store, "bob", 3)
+	if err := store.DeleteAllForUserExcept(context.TODO(), "bob", bob[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	if slices.Sort(deleted); !slices.Equal(deleted, bob[1:]) {
+		t.Errorf("expected the on delete hooks to run with the ids of the deleted sessions; got %v", deleted)
+	}
+}
+
+func TestDeleteAllForUser(t *testing.T) {
+	ddb := newFakeDynamoDB()
+	store, _ := New(ddb, WithUserIndex("user-index", "user_id"), WithMaxSessionsPerUser(50, RejectNewSessions))
+
+	persistUserSessions(t, store, "bob", 30)
+	alice := persistUserSessions(t, store, "alice", 1)
+
+	if err := store.DeleteAllForUser(context.TODO(), "bob"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := ddb.items[userRegistryPrefix+"bob"]; ok {
+		t.Error("expected the session registry to be removed")
+	}
+	if _, ok := ddb.items[alice[0]]; !ok {
+		t.Error("expected other users' sessions to remain")
+	}
+	if len(ddb.items) != 2 {
+		t.Errorf("expected only alice's session and registry to remain; got %v", len(ddb.items))
+	}
+}
+
+func TestListSessions(t *testing.T) {
+	ddb := newFakeDynamoDB()
+	now := time.Unix(1700000000, 0)
+	store, _ := New(ddb, WithUserIndex("user-index", "user_id"), WithClock(func() time.Time { return now }))
+
+	bob := persistUserSessions(t, store, "bob", 30)
+	persistUserSessions(t, store, "alice", 1)
+	if err := store.Revoke(context.TODO(), bob[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	var listed []SessionSummary
+	var pages int
+	cursor := ""
+	for {
+		page, next, err := store.ListSessions(context.TODO(), "bob", cursor)
+		if err != nil {
+			t.Fatal(err)
+		}
+		listed = append(listed, page...)
+		pages++
+
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+
+	if pages != 2 {
+		t.Errorf("expected 2 pages; got %v", pages)
+	}
+	if len(listed) != 29 {
+		t.Fatalf("expected 29 live sessions; got %v", len(listed))
+	}
+	for _, summary := range listed {
+		if summary.ID == bob[0] {
+			t.Error("expected the revoked session to be skipped")
+		}
+		if !summary.CreatedAt.Equal(now) || !summary.LastSeen.Equal(now) {
+			t.Errorf("expected metadata to be filled in; got %+v", summary)
+		}
+	}
+
+	if _, _, err := store.ListSessions(context.TODO(), "bob", "!"); err == nil {
+		t.Error("expected an invalid cursor to be rejected")
+	}
+}
+
+func TestActiveSessionCount(t *testing.T) {
+	ddb := newFakeDynamoDB()
+	now := time.Unix(1700000000, 0)
+	store, _ := New(ddb, WithUserIndex("user-index", "user_id"), TTLEnabled(), MaxAge(3600), WithClock(func() time.Time { return now }))
+
+	bob := persistUserSessions(t, store, "bob", 5)
+	persistUserSessions(t, store, "alice", 2)
+	if err := store.Revoke(context.TODO(), bob[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	count, err := store.ActiveSessionCount(context.TODO(), "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 4 {
+		t.Errorf("expected 4 active sessions; got %v", count)
+	}
+
+	now = now.Add(2 * time.Hour)
+	persistUserSessions(t, store, "carol", 1)
+	if count, _ := store.ActiveSessionCount(context.TODO(), "bob"); count != 0 {
+		t.Errorf("expected expired sessions not to count; got %v", count)
+	}
+	if count, _ := store.ActiveSessionCount(context.TODO(), "carol"); count != 1 {
+		t.Errorf("expected 1 active session; got %v", count)
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"time"
+
+	"github.com/gorilla/sessions"
+)
+
+// ValueExpiryField contains the name of the attribute holding per-value deadlines
+const ValueExpiryField = "value_expiry"
+
+// valueExpiryKey is the session.Values key under which per-value deadlines are
+// tracked between Load and Persist. It is not a string, so it is never written
+// to dynamodb as a regular value.
+type valueExpiryKey struct{}
+
+// SetValueExpiry attaches a deadline to the value stored under key. Once the
+// deadline has passed the value is stripped from the session on Load, even
+// though the session itself lives on.
+func SetValueExpiry(session *sessions.Session, key string, deadline time.Time) {
+	valueExpiries(session)[key] = deadline
+}
+
+// ValueExpiry returns the deadline attached to the value stored under key, if any
+func ValueExpiry(session *sessions.Session, key string) (time.Time, bool) {
+	deadlines, ok := session.Values[valueExpiryKey{}].(map[string]time.Time)
+	if !ok {
+		return time.Time{}, false
+	}
+
+	deadline, ok := deadlines[key]
+	return deadline, ok
+}
+
+func valueExpiries(session *sessions.Session) map[string]time.Time {
+	deadlines, ok := session.Values[valueExpiryKey{}].(map[string]time.Time)
+	if !ok {
+		deadlines = make(map[string]time.Time)
+		session.Values[valueExpiryKey{}] = deadlines
+	}
+
+	return deadlines
+}
+
+// expireValues removes values whose deadline has passed from the session and
+// returns the remaining deadlines as epoch seconds, ready to be written to
+// ValueExpiryField.
+func expireValues(session *sessions.Session, now time.Time) map[string]int64 {
+	deadlines, ok := session.Values[valueExpiryKey{}].(map[string]time.Time)
+	if !ok {
+		return nil
+	}
+
+	out := make(map[string]int64, len(deadlines))
+	for key, deadline := range deadlines {
+		if !now.Before(deadline) {
+			delete(session.Values, key)
+			delete(deadlines, key)
+			continue
+		}
+
+		out[key] = deadline.Unix()
+	}
+
+	return out
+}
+
+// loadValueExpiry extracts ValueExpiryField from an item read from dynamodb,
+// strips any values whose deadline has passed and returns the deadlines that
+// are still pending.
+func loadValueExpiry(item map[string]any, now time.Time) map[string]time.Time {
+	raw, ok := item[ValueExpiryField].(map[string]any)
+	delete(item, ValueExpiryField)
+	if !ok {
+		return nil
+	}
+
+	deadlines := make(map[string]time.Time, len(raw))
+	for key, v := range raw {
+		deadline, ok := parseTTL(v)
+		if !ok {
+			continue
+		}
+
+		if !now.Before(deadline) {
+			delete(item, key)
+			continue
+		}
+
+		deadlines[key] = deadline
+	}
+
+	return deadlines
+}
@@ -0,0 +1,62 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+)
+
+func TestValueExpiry(t *testing.T) {
+	now := time.Now()
+
+	session := sessions.NewSession(nil, "session")
+	session.Values["mfa"] = true
+	session.Values["impersonating"] = "admin"
+	session.Values["user"] = "bob"
+	SetValueExpiry(session, "mfa", now.Add(time.Minute))
+	SetValueExpiry(session, "impersonating", now.Add(-time.Minute))
+
+	deadlines := expireValues(session, now)
+	if _, ok := session.Values["impersonating"]; ok {
+		t.Error("expected expired value to be removed on persist")
+	}
+	if v := deadlines["mfa"]; v != now.Add(time.Minute).Unix() {
+		t.Errorf("expected mfa deadline %v; got %v", now.Add(time.Minute).Unix(), v)
+	}
+
+	item := map[string]any{
+		"mfa":  true,
+		"user": "bob",
+		ValueExpiryField: map[string]any{
+			"mfa": float64(deadlines["mfa"]),
+		},
+	}
+
+	loaded := loadValueExpiry(item, now.Add(2*time.Minute))
+	if _, ok := item["mfa"]; ok {
+		t.Error("expected expired value to be stripped on load")
+	}
+	if _, ok := item[ValueExpiryField]; ok {
+		t.Error("expected expiry attribute to be removed from values")
+	}
+	if item["user"] != "bob" {
+		t.Error("expected values without a deadline to be kept")
+	}
+	if len(loaded) != 0 {
+		t.Errorf("expected no pending deadlines; got %v", loaded)
+	}
+}
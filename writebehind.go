@@ -0,0 +1,347 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// defaultWriteBehindInterval is how often buffered sessions are written when
+// WithWriteBehind is given no interval
+const defaultWriteBehindInterval = 100 * time.Millisecond
+
+// writeBehind buffers the items of Persist in memory and writes them to the
+// table in batches from background workers. Items are kept in the buffer until
+// written so Load reads them back in the meantime.
+type writeBehind struct {
+	size     int
+	workers  int
+	interval time.Duration
+	onError  func(error)
+
+	mu       sync.Mutex
+	pending  map[string]*bufferedWrite
+	order    []string
+	flushing map[string][]*flushBatch
+	seq      uint64
+	closed   bool
+	ready    chan struct{}
+	stop     chan struct{}
+	wg       sync.WaitGroup
+}
+
+// bufferedWrite is the latest item written for a key, with the store it was
+// written through, which carries the table
+type bufferedWrite struct {
+	store  *Store
+	item   map[string]types.AttributeValue
+	seq    uint64
+	queued bool
+}
+
+// flushBatch is a batch taken from the buffer by a worker. Keys discarded while
+// the batch is in flight are tombstoned so they aren't written, and done is
+// closed once the batch has been written, or failed to be.
+type flushBatch struct {
+	entries   []*bufferedWrite
+	seqs      []uint64
+	keys      []string
+	discarded map[string]bool
+	done      chan struct{}
+}
+
+// bufferKey identifies an item across the tables a store may be scoped to
+func bufferKey(table, key string) string {
+	return table + "\x00" + key
+}
+
+func (w *writeBehind) start() {
+	w.pending = make(map[string]*bufferedWrite)
+	w.flushing = make(map[string][]*flushBatch)
+	w.ready = make(chan struct{}, 1)
+	w.stop = make(chan struct{})
+
+	for range max(w.workers, 1) {
+		w.wg.Add(1)
+		go w.run()
+	}
+}
+
+func (w *writeBehind) run() {
+	defer w.wg.Done()
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.ready:
+		case <-ticker.C:
+		case <-w.stop:
+			w.report(w.flush(context.Background()))
+			return
+		}
+
+		w.report(w.flush(context.Background()))
+	}
+}
+
+func (w *writeBehind) report(err error) {
+	if err != nil && w.onError != nil {
+		w.onError(err)
+	}
+}
+
+// enqueue buffers item, replacing any write of the same key still pending. It
+// returns false when the buffer is full or closed, in which case the caller
+// writes the item itself.
+func (w *writeBehind) enqueue(store *Store, item map[string]types.AttributeValue) bool {
+	key := bufferKey(store.tableName, itemID(store, item))
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	entry, ok := w.pending[key]
+	if w.closed || (!ok && len(w.pending) >= w.size) {
+		return false
+	}
+
+	if !ok {
+		entry = &bufferedWrite{}
+		w.pending[key] = entry
+	}
+
+	w.seq++
+	entry.store, entry.item, entry.seq = store, item, w.seq
+	if !entry.queued {
+		entry.queued = true
+		w.order = append(w.order, key)
+	}
+
+	if len(w.order) >= maxBatchWriteItems {
+		select {
+		case w.ready <- struct{}{}:
+		default:
+		}
+	}
+
+	return true
+}
+
+// lookup returns the pending item stored under key in table, if any
+func (w *writeBehind) lookup(table, key string) map[string]types.AttributeValue {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if entry, ok := w.pending[bufferKey(table, key)]; ok {
+		return entry.item
+	}
+
+	return nil
+}
+
+// discard drops the pending write of key in table so a flush can't bring a
+// deleted item back. If batches holding the key are already being flushed, the
+// key is tombstoned so it is left out of them, and discard waits for them to be
+// written so the caller's delete lands after them.
+func (w *writeBehind) discard(table, key string) {
+	key = bufferKey(table, key)
+
+	w.mu.Lock()
+	delete(w.pending, key)
+	batches := w.flushing[key]
+	for _, batch := range batches {
+		batch.discarded[key] = true
+	}
+	w.mu.Unlock()
+
+	for _, batch := range batches {
+		<-batch.done
+	}
+}
+
+// take removes up to one batch from the queue. The items stay readable until
+// done is called with the result of writing them.
+func (w *writeBehind) take() *flushBatch {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	batch := &flushBatch{discarded: map[string]bool{}, done: make(chan struct{})}
+	for len(w.order) > 0 && len(batch.entries) < maxBatchWriteItems {
+		key := w.order[0]
+		w.order = w.order[1:]
+
+		entry, ok := w.pending[key]
+		if !ok || !entry.queued {
+			continue
+		}
+
+		entry.queued = false
+		batch.entries = append(batch.entries, &bufferedWrite{store: entry.store, item: entry.item})
+		batch.seqs = append(batch.seqs, entry.seq)
+		batch.keys = append(batch.keys, key)
+		w.flushing[key] = append(w.flushing[key], batch)
+	}
+
+	return batch
+}
+
+// live returns the entries of batch whose key hasn't been discarded since the
+// batch was taken, grouped by table, with their index in the batch
+func (w *writeBehind) live(batch *flushBatch) map[string][]int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	byTable := map[string][]int{}
+	for i, entry := range batch.entries {
+		if batch.discarded[batch.keys[i]] {
+			continue
+		}
+		byTable[entry.store.tableName] = append(byTable[entry.store.tableName], i)
+	}
+
+	return byTable
+}
+
+// done drops the written items of batch from the buffer, unless they were
+// written again since they were taken, and releases the discards waiting on it.
+// Items that failed to be written stay in the buffer for requeue.
+func (w *writeBehind) done(batch *flushBatch, failed map[int]bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for i, key := range batch.keys {
+		w.flushing[key] = slices.DeleteFunc(w.flushing[key], func(b *flushBatch) bool { return b == batch })
+		if len(w.flushing[key]) == 0 {
+			delete(w.flushing, key)
+		}
+		if failed[i] {
+			continue
+		}
+		if entry, ok := w.pending[key]; ok && entry.seq == batch.seqs[i] {
+			delete(w.pending, key)
+		}
+	}
+
+	close(batch.done)
+}
+
+// requeue queues again the items that failed to be written, unless they were
+// written again or discarded since
+func (w *writeBehind) requeue(seqs []uint64, keys []string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for i, key := range keys {
+		if entry, ok := w.pending[key]; ok && entry.seq == seqs[i] && !entry.queued {
+			entry.queued = true
+			w.order = append(w.order, key)
+		}
+	}
+}
+
+// flush writes every pending item, one batch at a time. Workers flush
+// concurrently, each taking its own batches. Items that fail to be written are
+// queued again for the next flush.
+func (w *writeBehind) flush(ctx context.Context) error {
+	var (
+		errs       []error
+		failedSeqs []uint64
+		failedKeys []string
+	)
+	for {
+		batch := w.take()
+		if len(batch.entries) == 0 {
+			break
+		}
+
+		failed := map[int]bool{}
+		for table, indexes := range w.live(batch) {
+			items := make([]map[string]types.AttributeValue, 0, len(indexes))
+			for _, i := range indexes {
+				items = append(items, batch.entries[i].item)
+			}
+
+			store := batch.entries[indexes[0]].store
+			revoked, err := store.putNotRevoked(ctx, items)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("failed to flush %d buffered sessions to table %s: %w", len(items), table, err))
+				for _, i := range indexes {
+					failed[i] = true
+					failedSeqs = append(failedSeqs, batch.seqs[i])
+					failedKeys = append(failedKeys, batch.keys[i])
+				}
+			}
+			if len(revoked) > 0 {
+				errs = append(errs, fmt.Errorf("dropped %d buffered sessions of table %s: %w", len(revoked), table, ErrSessionRevoked))
+			}
+		}
+
+		w.done(batch, failed)
+	}
+
+	w.requeue(failedSeqs, failedKeys)
+
+	return errors.Join(errs...)
+}
+
+// close stops accepting writes and waits for the workers to flush the buffer, or
+// for ctx to be done
+func (w *writeBehind) close(ctx context.Context) error {
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.stop)
+	}
+	w.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		w.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("buffered sessions still pending: %w", ctx.Err())
+	}
+}
+
+// itemID returns the item key of item
+func itemID(store *Store, item map[string]types.AttributeValue) string {
+	if v, ok := item[store.primaryKey].(*types.AttributeValueMemberS); ok {
+		return v.Value
+	}
+
+	return ""
+}
+
+// Flush writes the sessions buffered by WithWriteBehind to the table without
+// waiting for the background workers. It does nothing without WithWriteBehind.
+func (store *Store) Flush(ctx context.Context) error {
+	if store.writeBehind == nil {
+		return nil
+	}
+
+	return store.writeBehind.flush(ctx)
+}
@@ -0,0 +1,175 @@
+// Copyright 2017 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dynastore
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/gorilla/sessions"
+)
+
+func TestWriteBehind(t *testing.T) {
+	ctx := context.TODO()
+
+	ddb := newFakeDynamoDB()
+	store, _ := New(ddb, MaxAge(3600), WithWriteBehind(3, 2, time.Hour, func(err error) {
+		t.Errorf("unexpected flush error: %v", err)
+	}))
+
+	for _, id := range []string{"a", "b", "a"} {
+		session := sessions.NewSession(store, "session")
+		session.ID = id
+		session.Options = store.newOptions()
+		session.Values["name"] = id
+		if err := store.Persist(ctx, "session", session); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if ddb.puts != 0 || len(ddb.items) != 0 {
+		t.Fatalf("expected sessions to be buffered; got %v", ddb.items)
+	}
+
+	loaded := sessions.NewSession(store, "session")
+	if err := store.Load(ctx, "a", loaded); err != nil || loaded.Values["name"] != "a" {
+		t.Errorf("expected buffered session to be loaded; got %v, %v", loaded.Values, err)
+	}
+
+	if err := store.Delete(ctx, "b"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := ddb.items["b"]; ok || len(ddb.items) != 1 || ddb.transactions != 1 {
+		t.Errorf("expected the buffer to be written in one batch without the deleted session; got %v", ddb.items)
+	}
+}
+
+func TestWriteBehindFull(t *testing.T) {
+	ctx := context.TODO()
+
+	ddb := newFakeDynamoDB()
+	store, _ := New(ddb, MaxAge(3600), WithWriteBehind(1, 1, time.Hour, nil))
+
+	persistUserSessions(t, store, "bob", 3)
+	if ddb.puts != 2 {
+		t.Errorf("expected writes past the buffer size to be synchronous; got %v puts", ddb.puts)
+	}
+
+	if err := store.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(ddb.items) != 3 {
+		t.Errorf("expected Close to flush the buffer; got %v", ddb.items)
+	}
+}
+
+// stalledDynamoDB holds transactions until release is closed, after signalling
+// entered, and fails them with err while set
+type stalledDynamoDB struct {
+	*fakeDynamoDB
+	entered chan struct{}
+	release chan struct{}
+	err     error
+}
+
+func (d *stalledDynamoDB) TransactWriteItems(ctx context.Context, params *dynamodb.TransactWriteItemsInput, optFns ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error) {
+	if d.entered != nil {
+		d.entered <- struct{}{}
+		<-d.release
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	return d.fakeDynamoDB.TransactWriteItems(ctx, params, optFns...)
+}
+
+func TestWriteBehindDeleteInFlight(t *testing.T) {
+	ctx := context.TODO()
+
+	ddb := &stalledDynamoDB{
+		fakeDynamoDB: newFakeDynamoDB(),
+		entered:      make(chan struct{}, 1),
+		release:      make(chan struct{}),
+	}
+	store, _ := New(ddb, MaxAge(3600), WithWriteBehind(10, 1, time.Hour, nil))
+
+	session := sessions.NewSession(store, "session")
+	session.ID = "a"
+	session.Options = store.newOptions()
+	if err := store.Persist(ctx, "session", session); err != nil {
+		t.Fatal(err)
+	}
+
+	flushed := make(chan error, 1)
+	go func() { flushed <- store.Flush(ctx) }()
+	<-ddb.entered
+
+	deleted := make(chan error, 1)
+	go func() { deleted <- store.Delete(ctx, "a") }()
+
+	select {
+	case err := <-deleted:
+		t.Fatalf("expected the delete to wait for the batch in flight; got %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(ddb.release)
+	if err := <-flushed; err != nil {
+		t.Fatal(err)
+	}
+	if err := <-deleted; err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := ddb.items["a"]; ok {
+		t.Errorf("expected the deleted session not to be brought back by the flush; got %v", ddb.items)
+	}
+}
+
+func TestWriteBehindRequeue(t *testing.T) {
+	ctx := context.TODO()
+
+	ddb := &stalledDynamoDB{fakeDynamoDB: newFakeDynamoDB(), err: fmt.Errorf("throttled")}
+	store, _ := New(ddb, MaxAge(3600), WithWriteBehind(10, 1, time.Hour, nil))
+
+	session := sessions.NewSession(store, "session")
+	session.ID = "a"
+	session.Options = store.newOptions()
+	session.Values["name"] = "a"
+	if err := store.Persist(ctx, "session", session); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Flush(ctx); err == nil {
+		t.Fatal("expected the flush to fail")
+	}
+	loaded := sessions.NewSession(store, "session")
+	if err := store.Load(ctx, "a", loaded); err != nil || loaded.Values["name"] != "a" {
+		t.Errorf("expected the session to stay buffered after a failed flush; got %v, %v", loaded.Values, err)
+	}
+
+	ddb.err = nil
+	if err := store.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := ddb.items["a"]; !ok {
+		t.Errorf("expected the session to be written by the next flush; got %v", ddb.items)
+	}
+}